@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/scalecraft/abt/internal"
+)
+
+// Client is a thin wrapper around the abt REST API.
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// APIError is a non-2xx response from the server.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(data))
+		}
+		return &APIError{Status: resp.StatusCode, Message: apiErr.Error}
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// listAll follows nextCursor until every page of a list endpoint is read.
+func listAll[T any](c *Client, path string, query url.Values) ([]T, error) {
+	items := []T{}
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("limit", "200")
+	for {
+		var page internal.Page[T]
+		if err := c.do(http.MethodGet, path+"?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+		if page.NextCursor == "" {
+			return items, nil
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+}
+
+// ListAgents returns every agent matching the optional list filters, e.g.
+// tag, owner or folder.
+func (c *Client) ListAgents(filters url.Values) ([]internal.Agent, error) {
+	return listAll[internal.Agent](c, "/agents/", filters)
+}
+
+func (c *Client) ListWorkflows(filters url.Values) ([]internal.Workflow, error) {
+	return listAll[internal.Workflow](c, "/workflows/", filters)
+}
+
+// ListExecutions returns the most recent page of executions.
+func (c *Client) ListExecutions(workflowID string) ([]internal.TaskDefinition, error) {
+	query := url.Values{}
+	if workflowID != "" {
+		query.Set("workflow_id", workflowID)
+	}
+	var page internal.Page[internal.TaskDefinition]
+	err := c.do(http.MethodGet, "/executions?"+query.Encode(), nil, &page)
+	return page.Items, err
+}
+
+func (c *Client) GetExecution(id string) (*internal.TaskDefinition, error) {
+	var execution internal.TaskDefinition
+	if err := c.do(http.MethodGet, "/executions/"+url.PathEscape(id), nil, &execution); err != nil {
+		return nil, err
+	}
+	return &execution, nil
+}
+
+func (c *Client) RunWorkflow(id string, params map[string]string) (string, error) {
+	var resp struct {
+		TaskID string `json:"taskId"`
+	}
+	err := c.do(http.MethodPost, "/workflows/"+url.PathEscape(id)+"/run", map[string]interface{}{"params": params}, &resp)
+	return resp.TaskID, err
+}
+
+func (c *Client) CreateConversation(agentID string) (*internal.Conversation, error) {
+	var conv internal.Conversation
+	err := c.do(http.MethodPost, "/agents/"+url.PathEscape(agentID)+"/conversations", map[string]string{}, &conv)
+	return &conv, err
+}
+
+func (c *Client) GetConversation(agentID, id string) (*internal.Conversation, error) {
+	var conv internal.Conversation
+	err := c.do(http.MethodGet, "/agents/"+url.PathEscape(agentID)+"/conversations/"+url.PathEscape(id), nil, &conv)
+	return &conv, err
+}
+
+func (c *Client) SendMessage(agentID, conversationID, message string) (string, error) {
+	var resp struct {
+		Response string `json:"response"`
+	}
+	path := "/agents/" + url.PathEscape(agentID) + "/conversations/" + url.PathEscape(conversationID) + "/messages"
+	err := c.do(http.MethodPost, path, map[string]string{"message": message}, &resp)
+	return resp.Response, err
+}
+
+func (c *Client) ApplyDefinitions(bundle *internal.DefinitionBundle, plan bool) (*internal.ApplyResult, error) {
+	path := "/definitions/apply"
+	if plan {
+		path = "/definitions/plan"
+	}
+	var result internal.ApplyResult
+	if err := c.do(http.MethodPost, path, bundle, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// FindWorkflow resolves a workflow by ID, slug or exact name.
+func (c *Client) FindWorkflow(ref string) (*internal.Workflow, error) {
+	workflows, err := c.ListWorkflows(nil)
+	if err != nil {
+		return nil, err
+	}
+	for i, w := range workflows {
+		if w.ID == ref || w.Slug == ref || w.Name == ref {
+			return &workflows[i], nil
+		}
+	}
+	return nil, fmt.Errorf("workflow %q not found", ref)
+}
+
+// FindAgent resolves an agent by ID, slug or exact name.
+func (c *Client) FindAgent(ref string) (*internal.Agent, error) {
+	agents, err := c.ListAgents(nil)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range agents {
+		if a.ID == ref || a.Slug == ref || a.Name == ref {
+			return &agents[i], nil
+		}
+	}
+	return nil, fmt.Errorf("agent %q not found", ref)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/scalecraft/abt/internal"
+)
+
+// paramFlag collects repeated --param k=v flags.
+type paramFlag map[string]string
+
+func (p paramFlag) String() string {
+	pairs := make([]string, 0, len(p))
+	for k, v := range p {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p paramFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	p[key] = val
+	return nil
+}
+
+func runApply(c *Client, out *Printer, args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	path := fs.String("f", "", "definitions file or directory")
+	plan := fs.Bool("plan", false, "show what would change without applying")
+	if _, err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("apply: -f is required")
+	}
+
+	bundle, err := internal.LoadDefinitions(*path)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.ApplyDefinitions(bundle, *plan)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, len(result.Changes))
+	for i, change := range result.Changes {
+		rows[i] = []string{change.Kind, change.Slug, string(change.Action), strings.Join(change.Fields, ",")}
+	}
+	return out.Print(result, []string{"KIND", "SLUG", "ACTION", "FIELDS"}, rows)
+}
+
+func runGet(c *Client, out *Printer, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	workflow := fs.String("workflow", "", "only list executions of this workflow")
+	tag := fs.String("tag", "", "only list agents or workflows with these comma-separated tags")
+	owner := fs.String("owner", "", "only list agents or workflows with this owner")
+	folder := fs.String("folder", "", "only list agents or workflows in this folder")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+
+	filters := url.Values{}
+	for key, value := range map[string]string{"tag": *tag, "owner": *owner, "folder": *folder} {
+		if value != "" {
+			filters.Set(key, value)
+		}
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("get: expected one of agents, workflows or executions")
+	}
+
+	switch positional[0] {
+	case "agents", "agent":
+		agents, err := c.ListAgents(filters)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, len(agents))
+		for i, a := range agents {
+			rows[i] = []string{a.ID, a.Slug, a.Name, string(a.Type), a.Owner, strings.Join(a.Tags, ","), truncate(a.Description, 50)}
+		}
+		return out.Print(agents, []string{"ID", "SLUG", "NAME", "TYPE", "OWNER", "TAGS", "DESCRIPTION"}, rows)
+
+	case "workflows", "workflow":
+		workflows, err := c.ListWorkflows(filters)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, len(workflows))
+		for i, w := range workflows {
+			rows[i] = []string{w.ID, w.Slug, w.Name, string(w.Status), fmt.Sprint(w.Version), fmt.Sprint(len(w.Dag.Nodes)), w.Owner, strings.Join(w.Tags, ",")}
+		}
+		return out.Print(workflows, []string{"ID", "SLUG", "NAME", "STATUS", "VERSION", "NODES", "OWNER", "TAGS"}, rows)
+
+	case "executions", "execution":
+		workflowID := ""
+		if *workflow != "" {
+			w, err := c.FindWorkflow(*workflow)
+			if err != nil {
+				return err
+			}
+			workflowID = w.ID
+		}
+		executions, err := c.ListExecutions(workflowID)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, len(executions))
+		for i, e := range executions {
+			rows[i] = []string{e.ID, e.WorkflowID, e.Status, progress(&e), e.CreatedAt.Local().Format(time.DateTime)}
+		}
+		return out.Print(executions, []string{"ID", "WORKFLOW", "STATUS", "NODES", "CREATED"}, rows)
+
+	default:
+		return fmt.Errorf("get: unknown resource %q", positional[0])
+	}
+}
+
+func runRun(c *Client, out *Printer, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	params := paramFlag{}
+	fs.Var(params, "param", "run parameter as key=value (repeatable)")
+	wait := fs.Bool("wait", false, "wait for the execution to finish")
+	interval := fs.Duration("interval", 2*time.Second, "polling interval with --wait")
+	local := fs.Bool("local", false, "run a definitions file in-process instead of on the server")
+	slug := fs.String("workflow", "", "workflow to run with --local when the file defines several")
+	script := fs.String("script", "", "scripted LLM responses for --local")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("run: expected a workflow")
+	}
+
+	if *local {
+		return runLocal(out, positional[0], *slug, *script, params)
+	}
+
+	workflow, err := c.FindWorkflow(positional[0])
+	if err != nil {
+		return err
+	}
+
+	executionID, err := c.RunWorkflow(workflow.ID, params)
+	if err != nil {
+		return err
+	}
+
+	if !*wait {
+		if out.Structured() {
+			return out.Print(map[string]string{"executionId": executionID}, nil, nil)
+		}
+		fmt.Println(executionID)
+		return nil
+	}
+
+	if !out.Structured() {
+		fmt.Fprintf(os.Stderr, "Started execution %s of %s\n", executionID, workflow.Slug)
+	}
+	execution, err := followExecution(c, executionID, *interval, !out.Structured())
+	if err != nil {
+		return err
+	}
+	if out.Structured() {
+		if err := out.Print(execution, nil, nil); err != nil {
+			return err
+		}
+	}
+	if execution.Status == "failed" {
+		return fmt.Errorf("execution %s failed", execution.ID)
+	}
+	return nil
+}
+
+// runLocal executes a workflow from a definitions file with an in-memory
+// store and the scripted LLM client, so it needs no server, database or
+// API keys.
+func runLocal(out *Printer, path, slug, scriptPath string, params map[string]string) error {
+	bundle, err := internal.LoadDefinitions(path)
+	if err != nil {
+		return err
+	}
+
+	var script internal.LLMScript
+	if scriptPath != "" {
+		if script, err = internal.LoadLLMScript(scriptPath); err != nil {
+			return err
+		}
+	}
+	llmClient, err := internal.NewScriptedLLMClient(script)
+	if err != nil {
+		return err
+	}
+
+	execution, err := internal.RunLocal(bundle, slug, params, llmClient)
+	if err != nil {
+		return err
+	}
+
+	if out.Structured() {
+		if err := out.Print(execution, nil, nil); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range execution.Results {
+			printResult(execution, r)
+		}
+		fmt.Printf("status: %s (%s)\n", execution.Status, progress(execution))
+	}
+	if execution.Status == "failed" {
+		return fmt.Errorf("local run failed")
+	}
+	return nil
+}
+
+func runLogs(c *Client, out *Printer, args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := fs.Bool("follow", false, "keep printing until the execution finishes")
+	fs.BoolVar(follow, "f", false, "shorthand for --follow")
+	interval := fs.Duration("interval", 2*time.Second, "polling interval with --follow")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("logs: expected an execution ID")
+	}
+
+	if !*follow {
+		execution, err := c.GetExecution(positional[0])
+		if err != nil {
+			return err
+		}
+		if out.Structured() {
+			return out.Print(execution, nil, nil)
+		}
+		for _, r := range execution.Results {
+			printResult(execution, r)
+		}
+		fmt.Printf("status: %s (%s)\n", execution.Status, progress(execution))
+		return nil
+	}
+
+	_, err = followExecution(c, positional[0], *interval, true)
+	return err
+}
+
+// followExecution polls an execution, printing each new node result, until it
+// is no longer in progress.
+func followExecution(c *Client, id string, interval time.Duration, print bool) (*internal.TaskDefinition, error) {
+	seen := 0
+	for {
+		execution, err := c.GetExecution(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if print {
+			for _, r := range execution.Results[min(seen, len(execution.Results)):] {
+				printResult(execution, r)
+			}
+		}
+		seen = len(execution.Results)
+
+		if execution.Status != "in_progress" && execution.Status != "pending" {
+			if print {
+				fmt.Printf("status: %s (%s)\n", execution.Status, progress(execution))
+			}
+			return execution, nil
+		}
+		time.Sleep(interval)
+	}
+}
+
+func printResult(execution *internal.TaskDefinition, r internal.Result) {
+	status := ""
+	for _, node := range execution.Nodes {
+		if node.ID != r.NodeID {
+			continue
+		}
+		status = node.Status
+		if node.Cached {
+			status += ", cached"
+		}
+		if node.Error != "" {
+			status += ": " + node.Error
+		}
+	}
+	fmt.Printf("[%s] %s (%s)\n", r.Timestamp.Local().Format(time.TimeOnly), r.NodeID, status)
+	if r.Output != "" {
+		for _, line := range strings.Split(strings.TrimRight(r.Output, "\n"), "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+}
+
+func progress(execution *internal.TaskDefinition) string {
+	done := 0
+	for _, node := range execution.Nodes {
+		if node.Status == "completed" {
+			done++
+		}
+	}
+	return fmt.Sprintf("%d/%d", done, len(execution.Nodes))
+}
+
+func runChat(c *Client, out *Printer, args []string) error {
+	fs := flag.NewFlagSet("chat", flag.ContinueOnError)
+	conversationID := fs.String("conversation", "", "continue this conversation instead of starting a new one")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("chat: expected an agent")
+	}
+
+	agent, err := c.FindAgent(positional[0])
+	if err != nil {
+		return err
+	}
+
+	var conv *internal.Conversation
+	if *conversationID != "" {
+		conv, err = c.GetConversation(agent.ID, *conversationID)
+	} else {
+		conv, err = c.CreateConversation(agent.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Chatting with %s in conversation %s. Type /exit or press Ctrl-D to quit.\n", agent.Name, conv.ID)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Fprint(os.Stderr, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(os.Stderr)
+			return scanner.Err()
+		}
+
+		message := strings.TrimSpace(scanner.Text())
+		if message == "" {
+			continue
+		}
+		if message == "/exit" || message == "/quit" {
+			return nil
+		}
+
+		response, err := c.SendMessage(agent.ID, conv.ID, message)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			continue
+		}
+		if out.Structured() {
+			if err := out.Print(map[string]string{"response": response}, nil, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println(response)
+	}
+}
@@ -0,0 +1,95 @@
+// Command abt is a command-line client for the abt REST API.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `abt is a command-line client for the abt server.
+
+Usage:
+  abt apply -f <path> [--plan]            Apply agent and workflow definitions
+  abt get agents|workflows|executions     List resources
+  abt run <workflow> [--param k=v] [--wait]
+                                          Run a workflow by slug, name or ID
+  abt run --local <file> [--workflow slug] [--script llm.yaml] [--param k=v]
+                                          Run a definitions file in-process with
+                                          a scripted LLM and no server
+  abt logs <execution> [--follow]         Show the progress of an execution
+  abt chat <agent> [--conversation id]    Chat with an agent interactively in a
+                                          new or existing conversation
+
+Global flags:
+  --server   API base URL (default $ABT_SERVER or http://localhost:8080/api/v1)
+  --token    API token sent with each request (default $ABT_TOKEN)
+  -o         output format: table, json or yaml (default table)
+`
+
+type command func(c *Client, out *Printer, args []string) error
+
+var commands = map[string]command{
+	"apply": runApply,
+	"get":   runGet,
+	"run":   runRun,
+	"logs":  runLogs,
+	"chat":  runChat,
+}
+
+func main() {
+	global := flag.NewFlagSet("abt", flag.ExitOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	server := global.String("server", envOr("ABT_SERVER", "http://localhost:8080/api/v1"), "API base URL")
+	token := global.String("token", os.Getenv("ABT_TOKEN"), "API token")
+	format := global.String("o", "table", "output format: table, json or yaml")
+	global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	name := global.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "abt: unknown command %q\n\n", name)
+		global.Usage()
+		os.Exit(2)
+	}
+
+	out, err := NewPrinter(*format, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "abt:", err)
+		os.Exit(2)
+	}
+
+	client := NewClient(*server, *token)
+	if err := cmd(client, out, global.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "abt:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseInterspersed parses flags that may appear before or after positional
+// arguments, e.g. "run triage --wait", and returns the positional ones.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Printer renders resources as a table, JSON or YAML.
+type Printer struct {
+	format string
+	w      io.Writer
+}
+
+func NewPrinter(format string, w io.Writer) (*Printer, error) {
+	switch format {
+	case "table", "json", "yaml":
+		return &Printer{format: format, w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q (want table, json or yaml)", format)
+	}
+}
+
+// Print writes v as JSON or YAML, or the given rows as a table.
+func (p *Printer) Print(v interface{}, headers []string, rows [][]string) error {
+	switch p.format {
+	case "json":
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		// Go through JSON so field names match the API.
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(p.w)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(generic)
+	default:
+		tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(headers, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// Structured reports whether output is machine-readable, in which case
+// progress messages should be kept off stdout.
+func (p *Printer) Structured() bool {
+	return p.format != "table"
+}
+
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -3,6 +3,7 @@ module github.com/scalecraft/abt
 go 1.23.1
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/aws/aws-sdk-go-v2 v1.36.2
 	github.com/aws/aws-sdk-go-v2/config v1.27.12
 	github.com/aws/aws-sdk-go-v2/service/bedrock v1.26.7
@@ -16,6 +17,7 @@ require (
 	github.com/tmc/langchaingo v0.1.12
 	golang.org/x/oauth2 v0.20.0
 	google.golang.org/api v0.180.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -126,5 +128,4 @@ require (
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240513163218-0867130af1f8 // indirect
 	google.golang.org/grpc v1.64.1 // indirect
 	google.golang.org/protobuf v1.36.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
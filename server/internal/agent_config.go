@@ -0,0 +1,617 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AgentConfig is the typed form of an agent's config column. Unset model
+// settings fall back to the LLM client's defaults when the agent runs.
+type AgentConfig struct {
+	Model          string       `json:"model,omitempty"`
+	Temperature    *float64     `json:"temperature,omitempty"`
+	MaxTokens      int          `json:"max_tokens,omitempty"`
+	UseRAG         bool         `json:"use_rag"`
+	UseDirectQuery bool         `json:"use_direct_query"`
+	UseMemory      bool         `json:"use_memory,omitempty"`
+	Avatar         *AgentAvatar `json:"avatar,omitempty"`
+	RAG            *RAGSettings `json:"rag,omitempty"`
+	// ContextBudget caps the estimated tokens of chat history sent per turn.
+	ContextBudget int `json:"context_budget,omitempty"`
+	// Lookup is the query chat runs when UseDirectQuery is set.
+	Lookup *LookupSettings `json:"lookup,omitempty"`
+	// Variables declares the variables of the narrative template.
+	Variables []NarrativeVariable `json:"variables,omitempty"`
+	// Guardrails check the prompt and reply of every model call.
+	Guardrails []GuardrailRule `json:"guardrails,omitempty"`
+
+	// Tools lists the tools an LLM agent may call.
+	Tools        []string `json:"tools,omitempty"`
+	MaxToolSteps int      `json:"max_tool_steps,omitempty"`
+
+	// Function agents call a registered Go function or an HTTP endpoint.
+	Function     string                 `json:"function,omitempty"`
+	Endpoint     *FunctionEndpoint      `json:"endpoint,omitempty"`
+	InputSchema  map[string]interface{} `json:"input_schema,omitempty"`
+	OutputSchema map[string]interface{} `json:"output_schema,omitempty"`
+
+	// Custom agents run their steps in order.
+	Steps []PipelineStep `json:"steps,omitempty"`
+}
+
+type AgentAvatar struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// modelParams resolves the agent's model settings over defaults.
+func (cfg AgentConfig) modelParams(defaults ModelParams) ModelParams {
+	params := defaults
+	if cfg.Model != "" {
+		params.Model = cfg.Model
+	}
+	if cfg.Temperature != nil {
+		params.Temperature = *cfg.Temperature
+	}
+	if cfg.MaxTokens > 0 {
+		params.MaxTokens = cfg.MaxTokens
+	}
+	return params
+}
+
+// toMap returns the config in its canonical JSON shape.
+func (cfg AgentConfig) toMap() map[string]interface{} {
+	data, _ := json.Marshal(cfg)
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+// FieldErrors maps a field path such as "config.temperature" to what is
+// wrong with it, so forms can show the error next to the input.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	paths := make([]string, 0, len(e))
+	for path := range e {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	parts := make([]string, len(paths))
+	for i, path := range paths {
+		parts[i] = path + ": " + e[path]
+	}
+	return strings.Join(parts, "; ")
+}
+
+func respondFieldErrors(c *gin.Context, kind string, errs FieldErrors) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + kind, "fields": errs})
+}
+
+// configField describes one config key. The same table drives validation
+// and the JSON Schema served to the UI.
+type configField struct {
+	name        string
+	kind        string // JSON Schema type
+	description string
+	min, max    *float64
+	enum        []string
+	properties  []configField
+	items       *configField // element of an array
+	freeform    bool         // an object with any keys
+}
+
+func bound(v float64) *float64 { return &v }
+
+var (
+	modelField       = configField{name: "model", kind: "string", description: "Model ID; defaults to the server's configured model"}
+	temperatureField = configField{name: "temperature", kind: "number", description: "Sampling temperature", min: bound(0), max: bound(2)}
+	maxTokensField   = configField{name: "max_tokens", kind: "integer", description: "Maximum tokens in a response", min: bound(1), max: bound(200000)}
+	avatarField      = configField{name: "avatar", kind: "object", description: "How the agent is shown in the UI", properties: []configField{
+		{name: "type", kind: "string", enum: []string{"emoji", "image"}},
+		{name: "value", kind: "string", description: "Emoji character or image URL"},
+	}}
+	outputSchemaField = configField{name: "output_schema", kind: "object", description: "JSON Schema the output must satisfy", freeform: true}
+	endpointField     = configField{name: "endpoint", kind: "object", description: "HTTP endpoint that receives the input as its JSON body", properties: []configField{
+		{name: "url", kind: "string", description: "http or https URL"},
+		{name: "method", kind: "string", enum: []string{"POST", "GET"}},
+		{name: "headers", kind: "object", description: "Request headers; ${VAR} expands from environment variables listed in FUNCTION_HEADER_ENV", freeform: true},
+		{name: "timeout_seconds", kind: "integer", min: bound(1), max: bound(maxFunctionTimeout.Seconds())},
+	}}
+	variablesField = configField{name: "variables", kind: "array", description: "Variables of the narrative template", items: &configField{kind: "object", properties: []configField{
+		{name: "name", kind: "string", description: "Written {{name}} in the narrative"},
+		{name: "description", kind: "string"},
+		{name: "required", kind: "boolean", description: "Refuse to run the agent without a value"},
+		{name: "default", kind: "string", description: "Used when no value is given"},
+	}}}
+	guardrailsField = configField{name: "guardrails", kind: "array", description: "Checks on the prompt and reply of every model call", items: &configField{kind: "object", properties: []configField{
+		{name: "detector", kind: "string", description: "What to find: name, dob, mrn, ssn, email, phone or a registered detector"},
+		{name: "action", kind: "string", enum: []string{GuardrailRedact, GuardrailPseudonymize, GuardrailBlock, GuardrailLog}},
+	}}}
+)
+
+// agentConfigFields lists the config keys each agent type accepts.
+var agentConfigFields = map[AgentType][]configField{
+	TypeLLM: {
+		modelField, temperatureField, maxTokensField,
+		{name: "use_rag", kind: "boolean", description: "Retrieve documents to ground responses"},
+		{name: "rag", kind: "object", description: "Where and how much to retrieve when use_rag is set", properties: []configField{
+			{name: "retriever", kind: "string", enum: []string{RetrieverPostgres, RetrieverSnowflake}},
+			{name: "top_k", kind: "integer", description: "Documents to retrieve", min: bound(1), max: bound(maxRAGTopK)},
+			{name: "threshold", kind: "number", description: "Minimum cosine similarity of a retrieved document", min: bound(0), max: bound(1)},
+			{name: "table", kind: "string", description: "Snowflake table searched by the snowflake retriever"},
+		}},
+		{name: "use_direct_query", kind: "boolean", description: "Answer chat from the lookup query"},
+		{name: "lookup", kind: "object", description: "Fields to extract from chat messages and the query they parameterize", properties: []configField{
+			{name: "integration", kind: "string", description: "Where the query runs", enum: []string{"snowflake"}},
+			{name: "fields", kind: "array", items: &configField{kind: "object", properties: []configField{
+				{name: "name", kind: "string", description: "Bound to :name in the query"},
+				{name: "type", kind: "string", enum: []string{LookupString, LookupInteger, LookupNumber, LookupBoolean, LookupDate}},
+				{name: "required", kind: "boolean", description: "Ask the user for it instead of querying without it"},
+				{name: "description", kind: "string", description: "Tells the model what to extract"},
+			}}},
+			{name: "query", kind: "string", description: "Read-only SQL with :name parameters"},
+			{name: "max_rows", kind: "integer", description: "Rows passed to the model", min: bound(1), max: bound(maxLookupRows)},
+		}},
+		{name: "use_memory", kind: "boolean", description: "Remember facts about each user across conversations"},
+		{name: "context_budget", kind: "integer", description: "Estimated tokens of chat history sent per turn; older turns are summarized", min: bound(minContextBudget), max: bound(maxContextBudget)},
+		{name: "tools", kind: "array", description: "Tools the agent may call", items: &configField{kind: "string"}},
+		{name: "max_tool_steps", kind: "integer", description: "Model turns that may request tools before the agent must answer", min: bound(1), max: bound(maxToolSteps)},
+		outputSchemaField,
+		variablesField,
+		guardrailsField,
+		avatarField,
+	},
+	TypeFunction: {
+		{name: "function", kind: "string", description: "Name of a registered function"},
+		endpointField,
+		{name: "input_schema", kind: "object", description: "JSON Schema the input must satisfy", freeform: true},
+		outputSchemaField,
+		avatarField,
+	},
+	TypeCustom: {
+		modelField, temperatureField, maxTokensField,
+		{name: "steps", kind: "array", description: "Pipeline run in order, each step's output feeding the next", items: &configField{
+			kind: "object", properties: []configField{
+				{name: "type", kind: "string", enum: []string{StepPrompt, StepFunction}},
+				{name: "prompt", kind: "string", description: "Prompt template; {{input}} is the previous output, {{original}} the agent input"},
+				{name: "function", kind: "string", description: "Name of a registered function or built-in tool"},
+				endpointField,
+			},
+		}},
+		outputSchemaField,
+		variablesField,
+		guardrailsField,
+		avatarField,
+	},
+}
+
+// checkAgentConfig reports settings that are well-formed on their own but
+// do not make a runnable agent together.
+func checkAgentConfig(agentType AgentType, cfg AgentConfig, errs FieldErrors) {
+	checkVariables(cfg.Variables, errs)
+	checkGuardrails(cfg.Guardrails, errs)
+	for path, schema := range map[string]map[string]interface{}{
+		"config.input_schema":  cfg.InputSchema,
+		"config.output_schema": cfg.OutputSchema,
+	} {
+		if schema == nil {
+			continue
+		}
+		if err := checkJSONSchema(schema); err != nil {
+			errs[path] = err.Error()
+		}
+	}
+	switch agentType {
+	case TypeLLM:
+		if cfg.RAG != nil && cfg.RAG.Retriever == RetrieverSnowflake && !snowflakeTablePattern.MatchString(cfg.RAG.Table) {
+			errs["config.rag.table"] = "must name a Snowflake table"
+		}
+		if cfg.Lookup != nil {
+			cfg.Lookup.check("config.lookup", errs)
+		} else if cfg.UseDirectQuery {
+			errs["config.lookup"] = "is required when use_direct_query is set"
+		}
+		for i, name := range cfg.Tools {
+			if !knownTool(name) {
+				errs[fmt.Sprintf("config.tools[%d]", i)] = fmt.Sprintf("unknown tool %q", name)
+			}
+		}
+	case TypeFunction:
+		checkFunctionTarget("config", cfg.Function, cfg.Endpoint, errs)
+	case TypeCustom:
+		for i, step := range cfg.Steps {
+			path := fmt.Sprintf("config.steps[%d]", i)
+			switch step.Type {
+			case StepPrompt:
+			case StepFunction:
+				// Named steps run through the tool registry, so built-in
+				// tools are allowed as well as registered functions.
+				if step.Function != "" && step.Endpoint == nil && knownTool(step.Function) {
+					break
+				}
+				checkFunctionTarget(path, step.Function, step.Endpoint, errs)
+			default:
+				errs[path+".type"] = "is required"
+			}
+		}
+	}
+}
+
+func checkFunctionTarget(path, function string, endpoint *FunctionEndpoint, errs FieldErrors) {
+	switch {
+	case function == "" && endpoint == nil:
+		errs[path+".function"] = "set function or endpoint"
+	case function != "" && endpoint != nil:
+		errs[path+".function"] = "set only one of function or endpoint"
+	case function != "":
+		if _, ok := lookupAgentFunction(function); !ok {
+			errs[path+".function"] = fmt.Sprintf("unknown function %q", function)
+		}
+	default:
+		if msg := checkEndpointURL(endpoint.URL); msg != "" {
+			errs[path+".endpoint.url"] = msg
+		}
+		allowed := headerEnv()
+		for key, value := range endpoint.Headers {
+			if _, err := expandHeader(value, allowed); err != nil {
+				errs[path+".endpoint.headers."+key] = err.Error()
+			}
+		}
+	}
+}
+
+func validAgentType(t AgentType) bool {
+	_, ok := agentConfigFields[t]
+	return ok
+}
+
+// parseAgentConfig validates a raw config object against the fields of
+// agentType.
+func parseAgentConfig(agentType AgentType, raw map[string]interface{}) (AgentConfig, FieldErrors) {
+	return decodeAgentConfig(agentType, raw, false)
+}
+
+// decodeAgentConfig keeps every valid key of raw and reports the rest. In
+// lenient mode numbers and booleans written as strings are accepted.
+func decodeAgentConfig(agentType AgentType, raw map[string]interface{}, lenient bool) (AgentConfig, FieldErrors) {
+	var cfg AgentConfig
+	fields, ok := agentConfigFields[agentType]
+	if !ok {
+		return cfg, FieldErrors{"type": fmt.Sprintf("unknown agent type %q", agentType)}
+	}
+
+	errs := FieldErrors{}
+	clean := checkConfigObject("config", fields, raw, lenient, errs)
+	data, err := json.Marshal(clean)
+	if err == nil {
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		errs["config"] = err.Error()
+	}
+	if !lenient && len(errs) == 0 {
+		checkAgentConfig(agentType, cfg, errs)
+	}
+	if len(errs) == 0 {
+		return cfg, nil
+	}
+	return cfg, errs
+}
+
+func checkConfigObject(path string, fields []configField, raw map[string]interface{}, lenient bool, errs FieldErrors) map[string]interface{} {
+	known := make(map[string]configField, len(fields))
+	for _, f := range fields {
+		known[f.name] = f
+	}
+
+	clean := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		f, ok := known[key]
+		if !ok {
+			errs[path+"."+key] = "unknown field"
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		if v, msg := checkConfigValue(path+"."+key, f, value, lenient, errs); msg != "" {
+			errs[path+"."+key] = msg
+		} else {
+			clean[key] = v
+		}
+	}
+	return clean
+}
+
+func checkConfigValue(path string, f configField, value interface{}, lenient bool, errs FieldErrors) (interface{}, string) {
+	if s, ok := value.(string); ok && lenient {
+		switch f.kind {
+		case "number", "integer":
+			if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				value = n
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+				value = b
+			}
+		}
+	}
+
+	switch f.kind {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, "must be a string"
+		}
+		if len(f.enum) > 0 && !containsString(f.enum, s) {
+			return nil, "must be one of " + strings.Join(f.enum, ", ")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return nil, "must be true or false"
+		}
+	case "number", "integer":
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case int: // YAML decodes whole numbers as int
+			n = float64(v)
+		default:
+			return nil, "must be a number"
+		}
+		value = n
+		if f.kind == "integer" && n != math.Trunc(n) {
+			return nil, "must be a whole number"
+		}
+		if f.min != nil && n < *f.min {
+			return nil, fmt.Sprintf("must be at least %g", *f.min)
+		}
+		if f.max != nil && n > *f.max {
+			return nil, fmt.Sprintf("must be at most %g", *f.max)
+		}
+	case "object":
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, "must be an object"
+		}
+		if f.freeform {
+			return m, ""
+		}
+		return checkConfigObject(path, f.properties, m, lenient, errs), ""
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, "must be a list"
+		}
+		clean := make([]interface{}, 0, len(list))
+		for i, item := range list {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if v, msg := checkConfigValue(itemPath, *f.items, item, lenient, errs); msg != "" {
+				errs[itemPath] = msg
+			} else {
+				clean = append(clean, v)
+			}
+		}
+		return clean, ""
+	}
+	return value, ""
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// agentConfigSchema renders the config fields of agentType as a JSON Schema,
+// with the model defaults the server would use.
+func agentConfigSchema(agentType AgentType, defaults ModelParams) map[string]interface{} {
+	schema := objectSchema(agentConfigFields[agentType])
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = fmt.Sprintf("%s agent config", agentType)
+
+	properties := schema["properties"].(map[string]interface{})
+	fieldDefaults := map[string]interface{}{
+		"model":            defaults.Model,
+		"temperature":      defaults.Temperature,
+		"max_tokens":       defaults.MaxTokens,
+		"use_rag":          false,
+		"use_direct_query": false,
+	}
+	for name, value := range fieldDefaults {
+		if property, ok := properties[name].(map[string]interface{}); ok {
+			property["default"] = value
+		}
+	}
+	return schema
+}
+
+func objectSchema(fields []configField) map[string]interface{} {
+	properties := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		properties[f.name] = fieldSchema(f)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func fieldSchema(f configField) map[string]interface{} {
+	property := map[string]interface{}{"type": f.kind}
+	if f.description != "" {
+		property["description"] = f.description
+	}
+	if f.min != nil {
+		property["minimum"] = *f.min
+	}
+	if f.max != nil {
+		property["maximum"] = *f.max
+	}
+	if len(f.enum) > 0 {
+		property["enum"] = f.enum
+	}
+	if f.kind == "object" && !f.freeform {
+		for k, v := range objectSchema(f.properties) {
+			property[k] = v
+		}
+	}
+	if f.items != nil {
+		property["items"] = fieldSchema(*f.items)
+	}
+	return property
+}
+
+// GetAgentConfigSchema serves the JSON Schema of agent configs, keyed by
+// agent type, or the schema of a single type with ?type=.
+func GetAgentConfigSchema(llmClient LLMClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defaults := llmClient.Defaults()
+		if t := AgentType(c.Query("type")); t != "" {
+			if !validAgentType(t) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown agent type %q", t)})
+				return
+			}
+			c.JSON(http.StatusOK, agentConfigSchema(t, defaults))
+			return
+		}
+
+		schemas := make(map[AgentType]interface{}, len(agentConfigFields))
+		for t := range agentConfigFields {
+			schemas[t] = agentConfigSchema(t, defaults)
+		}
+		c.JSON(http.StatusOK, schemas)
+	}
+}
+
+// CurrentAgentConfigVersion is the config shape agents are written in. Bump
+// it, and the column default, when stored configs need upgrading again.
+const CurrentAgentConfigVersion = 1
+
+// agentConfigUpgrade is a stored agent whose config needs rewriting.
+type agentConfigUpgrade struct {
+	agent      Agent
+	configJSON []byte
+}
+
+// MigrateAgentConfigs rewrites the configs of agents stored before
+// CurrentAgentConfigVersion into the typed shape, once: numbers and booleans
+// stored as strings are converted, and an unknown agent type becomes llm.
+// Each rewrite bumps the agent's version and records it, so the previous
+// version keeps the original config, including any unknown or invalid keys
+// the rewrite drops. Dropped keys are logged.
+func MigrateAgentConfigs(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT id, name, type, description, narrative, config, version FROM agents
+		WHERE config_version < $1`,
+		CurrentAgentConfigVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to fetch agents: %v", err)
+	}
+
+	var upgrades []agentConfigUpgrade
+	for rows.Next() {
+		var agent Agent
+		var stored []byte
+		if err := rows.Scan(&agent.ID, &agent.Name, &agent.Type, &agent.Description, &agent.Narrative, &stored, &agent.Version); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan agent: %v", err)
+		}
+
+		var raw map[string]interface{}
+		if err := json.Unmarshal(stored, &raw); err != nil {
+			log.Printf("Agent %s: replacing unreadable config: %v", agent.ID, err)
+		}
+		retyped := !validAgentType(agent.Type)
+		if retyped {
+			log.Printf("Agent %s: changing unknown type %q to %q", agent.ID, agent.Type, TypeLLM)
+			agent.Type = TypeLLM
+		}
+		cfg, errs := decodeAgentConfig(agent.Type, raw, true)
+		for path, msg := range errs {
+			log.Printf("Agent %s: dropping %s (%s); version %d keeps it", agent.ID, path, msg, agent.Version)
+		}
+
+		configJSON, err := json.Marshal(cfg)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("agent %s: failed to encode config: %v", agent.ID, err)
+		}
+		if retyped || !sameJSON(stored, configJSON) {
+			agent.Config = cfg
+			upgrades = append(upgrades, agentConfigUpgrade{agent: agent, configJSON: configJSON})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, upgrade := range upgrades {
+		if err := upgradeAgentConfig(db, upgrade); err != nil {
+			return fmt.Errorf("failed to migrate agent %s: %v", upgrade.agent.ID, err)
+		}
+	}
+	// The rest were already in the current shape.
+	if _, err := db.Exec(`UPDATE agents SET config_version = $1 WHERE config_version < $1`, CurrentAgentConfigVersion); err != nil {
+		return fmt.Errorf("failed to mark agent configs: %v", err)
+	}
+	if len(upgrades) > 0 {
+		log.Printf("Migrated %d agent configs to version %d", len(upgrades), CurrentAgentConfigVersion)
+	}
+	return nil
+}
+
+// upgradeAgentConfig writes one rewritten config as a new agent version.
+func upgradeAgentConfig(db *sql.DB, upgrade agentConfigUpgrade) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	agent := upgrade.agent
+	err = tx.QueryRow(`
+		UPDATE agents
+		SET type = $1, config = $2, config_version = $3, version = version + 1
+		WHERE id = $4 AND config_version < $3
+		RETURNING version`,
+		agent.Type, upgrade.configJSON, CurrentAgentConfigVersion, agent.ID,
+	).Scan(&agent.Version)
+	if err == sql.ErrNoRows {
+		// Upgraded concurrently by another server.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := insertAgentVersion(tx, &agent, upgrade.configJSON, "system"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func sameJSON(a, b []byte) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
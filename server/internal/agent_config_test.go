@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseAgentConfigFieldErrors(t *testing.T) {
+	_, errs := parseAgentConfig(TypeLLM, map[string]interface{}{
+		"model":       42,
+		"temperature": 3.5,
+		"max_tokens":  10.5,
+		"use_rag":     "yes",
+		"colour":      "red",
+		"avatar":      map[string]interface{}{"type": "sticker"},
+		"tools":       []interface{}{"no_such_tool"},
+	})
+	want := FieldErrors{
+		"config.model":       "must be a string",
+		"config.temperature": "must be at most 2",
+		"config.max_tokens":  "must be a whole number",
+		"config.use_rag":     "must be true or false",
+		"config.colour":      "unknown field",
+		"config.avatar.type": "must be one of emoji, image",
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("errors = %v, want %v", errs, want)
+	}
+
+	// Semantic checks only run once every field has the right shape.
+	_, errs = parseAgentConfig(TypeLLM, map[string]interface{}{"tools": []interface{}{"no_such_tool"}})
+	if errs["config.tools[0]"] == "" {
+		t.Errorf("errors = %v, want the unknown tool reported", errs)
+	}
+}
+
+func TestParseAgentConfigByType(t *testing.T) {
+	if _, errs := parseAgentConfig(AgentType("robot"), nil); errs["type"] == "" {
+		t.Errorf("errors = %v, want the unknown type reported", errs)
+	}
+	if _, errs := parseAgentConfig(TypeFunction, map[string]interface{}{}); errs == nil {
+		t.Error("a function agent without a function or endpoint was accepted")
+	}
+	if _, errs := parseAgentConfig(TypeFunction, map[string]interface{}{"use_rag": true}); errs["config.use_rag"] != "unknown field" {
+		t.Errorf("errors = %v, want LLM settings rejected on function agents", errs)
+	}
+}
+
+func TestDecodeAgentConfigLenient(t *testing.T) {
+	cfg, errs := decodeAgentConfig(TypeLLM, map[string]interface{}{
+		"temperature": "0.5",
+		"max_tokens":  "512",
+		"use_rag":     "true",
+		"legacy":      "x",
+	}, true)
+	if cfg.Temperature == nil || *cfg.Temperature != 0.5 || cfg.MaxTokens != 512 || !cfg.UseRAG {
+		t.Errorf("config = %+v, want strings coerced", cfg)
+	}
+	if len(errs) != 1 || errs["config.legacy"] == "" {
+		t.Errorf("errors = %v, want only the unknown field dropped", errs)
+	}
+}
+
+func TestModelParams(t *testing.T) {
+	defaults := ModelParams{Model: "default", Temperature: 0.7, MaxTokens: 1024}
+	if got := (AgentConfig{}).modelParams(defaults); got != defaults {
+		t.Errorf("params = %+v, want the defaults", got)
+	}
+
+	zero := 0.0
+	got := AgentConfig{Model: "fast", Temperature: &zero, MaxTokens: 64}.modelParams(defaults)
+	if want := (ModelParams{Model: "fast", Temperature: 0, MaxTokens: 64}); got != want {
+		t.Errorf("params = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetAgentConfigSchema(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveTestRequest(http.MethodGet, "/schema", "/schema?type=llm", "", GetAgentConfigSchema(llm))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Type    string      `json:"type"`
+			Default interface{} `json:"default"`
+		} `json:"properties"`
+		AdditionalProperties bool `json:"additionalProperties"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &schema)
+	if p := schema.Properties["max_tokens"]; p.Type != "integer" || p.Default != 1024.0 {
+		t.Errorf("max_tokens = %+v, want an integer defaulting to the client's 1024", p)
+	}
+	if schema.AdditionalProperties {
+		t.Error("schema allows unknown fields")
+	}
+
+	if w := serveTestRequest(http.MethodGet, "/schema", "/schema?type=robot", "", GetAgentConfigSchema(llm)); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown type: status = %d, want 400", w.Code)
+	}
+}
+
+func TestMigrateAgentConfigs(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT id, name, type, description, narrative, config, version FROM agents`).
+		WithArgs(CurrentAgentConfigVersion).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "type", "description", "narrative", "config", "version"}).
+			AddRow("a1", "Current", TypeLLM, "", "", []byte(`{"use_rag": false, "use_direct_query": false}`), 2).
+			AddRow("a2", "Legacy", TypeLLM, "", "", []byte(`{"temperature": "0.3", "colour": "red"}`), 5))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE agents\s+SET type = \$1, config = \$2`).
+		WithArgs(TypeLLM, []byte(`{"temperature":0.3,"use_rag":false,"use_direct_query":false}`), CurrentAgentConfigVersion, "a2").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(6))
+	mock.ExpectExec(`INSERT INTO agent_versions`).
+		WithArgs("a2", 6, "Legacy", TypeLLM, "", "", sqlmock.AnyArg(), "system").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`UPDATE agents SET config_version = \$1 WHERE config_version < \$1`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := MigrateAgentConfigs(db); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,333 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AgentVersion is an immutable snapshot of an agent, written on every save.
+type AgentVersion struct {
+	AgentID     string      `json:"agent_id"`
+	Version     int         `json:"version"`
+	Name        string      `json:"name"`
+	Type        AgentType   `json:"type"`
+	Description string      `json:"description"`
+	Narrative   string      `json:"narrative"`
+	Config      AgentConfig `json:"config"`
+	Author      string      `json:"author"`
+	CreatedAt   time.Time   `json:"created_at"`
+	// Changes is the diff from the previous version, set when listing.
+	Changes *AgentDiff `json:"changes,omitempty"`
+}
+
+type AgentDiff struct {
+	From          int            `json:"from"`
+	To            int            `json:"to"`
+	FieldsChanged []string       `json:"fields_changed"`
+	Narrative     []DiffLine     `json:"narrative"`
+	Config        []ConfigChange `json:"config"`
+}
+
+// DiffLine is one line of a line diff. Op is "+" for an added line, "-" for
+// a removed one and " " for one both sides share.
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// ConfigChange is a config key whose value differs. A missing Before or
+// After means the key was added or removed.
+type ConfigChange struct {
+	Key    string      `json:"key"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+func insertAgentVersion(tx *sql.Tx, agent *Agent, configJSON []byte, author string) error {
+	_, err := tx.Exec(`
+		INSERT INTO agent_versions (agent_id, version, name, type, description, narrative, config, author)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		agent.ID, agent.Version, agent.Name, agent.Type, agent.Description, agent.Narrative, configJSON, author,
+	)
+	return err
+}
+
+const agentVersionColumns = `agent_id, version, name, type, description, narrative, config, author, created_at`
+
+func scanAgentVersion(row rowScanner) (*AgentVersion, error) {
+	var v AgentVersion
+	var configJSON []byte
+	if err := row.Scan(&v.AgentID, &v.Version, &v.Name, &v.Type, &v.Description, &v.Narrative, &configJSON, &v.Author, &v.CreatedAt); err != nil {
+		return nil, err
+	}
+	// Snapshots keep the config as it was saved, so read it leniently like
+	// MigrateAgentConfigs does.
+	var raw map[string]interface{}
+	if err := json.Unmarshal(configJSON, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse agent config: %v", err)
+	}
+	v.Config, _ = decodeAgentConfig(v.Type, raw, true)
+	return &v, nil
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func fetchAgentVersion(db rowQueryer, agentID string, version int) (*AgentVersion, error) {
+	return scanAgentVersion(db.QueryRow(`
+		SELECT `+agentVersionColumns+`
+		FROM agent_versions
+		WHERE agent_id = $1 AND version = $2`,
+		agentID, version,
+	))
+}
+
+// fetchAgentRef loads the agent a workflow node references: the current
+// agent, or for "id@version" the agent as it was at that version. Fields
+// that are not versioned, like slug and tags, are always current.
+func fetchAgentRef(db rowQueryer, ref string) (*Agent, error) {
+	id, version, err := parseAgentRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	agent, err := scanAgent(db.QueryRow(`SELECT `+agentColumns+` FROM agents WHERE id = $1`, id))
+	if err != nil || version == 0 || version == agent.Version {
+		return agent, err
+	}
+
+	v, err := fetchAgentVersion(db, id, version)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("agent %s has no version %d", id, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pinned := *agent
+	pinned.Name = v.Name
+	pinned.Type = v.Type
+	pinned.Description = v.Description
+	pinned.Narrative = v.Narrative
+	pinned.Config = v.Config
+	pinned.Version = v.Version
+	return &pinned, nil
+}
+
+// parseAgentRef splits a node's agent reference. "id" follows the latest
+// version and "id@3" pins version 3, reported as version 0 and 3.
+func parseAgentRef(ref string) (id string, version int, err error) {
+	i := strings.LastIndex(ref, "@")
+	if i < 0 {
+		return ref, 0, nil
+	}
+	version, err = strconv.Atoi(ref[i+1:])
+	if err != nil || version < 1 {
+		return "", 0, fmt.Errorf("invalid agent reference %q: the version after @ must be a positive number", ref)
+	}
+	return ref[:i], version, nil
+}
+
+// agentRef is the inverse of parseAgentRef.
+func agentRef(id string, version int) string {
+	if version == 0 {
+		return id
+	}
+	return fmt.Sprintf("%s@%d", id, version)
+}
+
+// ListAgentVersions serves GET /agents/:id/versions, newest first. Each
+// version carries its diff from the one before it.
+func ListAgentVersions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rows, err := db.Query(`
+			SELECT `+agentVersionColumns+`
+			FROM agent_versions
+			WHERE agent_id = $1
+			ORDER BY version DESC`,
+			c.Param("id"),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent versions"})
+			return
+		}
+		defer rows.Close()
+
+		versions := []*AgentVersion{}
+		for rows.Next() {
+			v, err := scanAgentVersion(rows)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan agent version"})
+				return
+			}
+			versions = append(versions, v)
+		}
+		if err := rows.Err(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent versions"})
+			return
+		}
+
+		if len(versions) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+			return
+		}
+		for i := 0; i+1 < len(versions); i++ {
+			diff := diffAgentVersions(versions[i+1], versions[i])
+			versions[i].Changes = &diff
+		}
+		c.JSON(http.StatusOK, versions)
+	}
+}
+
+func GetAgentVersion(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+			return
+		}
+
+		v, err := fetchAgentVersion(db, c.Param("id"), version)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Agent version not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent version"})
+			return
+		}
+		c.JSON(http.StatusOK, v)
+	}
+}
+
+// DiffAgentVersions serves GET /agents/:id/diff?from=&to=.
+func DiffAgentVersions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		from, errFrom := strconv.Atoi(c.Query("from"))
+		to, errTo := strconv.Atoi(c.Query("to"))
+		if errFrom != nil || errTo != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameters 'from' and 'to' must be version numbers"})
+			return
+		}
+
+		before, err := fetchAgentVersion(db, id, from)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Agent version %d not found", from)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent version"})
+			return
+		}
+
+		after, err := fetchAgentVersion(db, id, to)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Agent version %d not found", to)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent version"})
+			return
+		}
+
+		c.JSON(http.StatusOK, diffAgentVersions(before, after))
+	}
+}
+
+func diffAgentVersions(before, after *AgentVersion) AgentDiff {
+	diff := AgentDiff{
+		From:          before.Version,
+		To:            after.Version,
+		FieldsChanged: []string{},
+		Narrative:     []DiffLine{},
+		Config:        []ConfigChange{},
+	}
+
+	if before.Name != after.Name {
+		diff.FieldsChanged = append(diff.FieldsChanged, "name")
+	}
+	if before.Type != after.Type {
+		diff.FieldsChanged = append(diff.FieldsChanged, "type")
+	}
+	if before.Description != after.Description {
+		diff.FieldsChanged = append(diff.FieldsChanged, "description")
+	}
+	if before.Narrative != after.Narrative {
+		diff.FieldsChanged = append(diff.FieldsChanged, "narrative")
+		diff.Narrative = diffLines(before.Narrative, after.Narrative)
+	}
+
+	var a, b map[string]interface{}
+	beforeJSON, _ := json.Marshal(before.Config)
+	afterJSON, _ := json.Marshal(after.Config)
+	json.Unmarshal(beforeJSON, &a)
+	json.Unmarshal(afterJSON, &b)
+	for _, key := range changedFields(before.Config, after.Config) {
+		diff.Config = append(diff.Config, ConfigChange{Key: key, Before: a[key], After: b[key]})
+	}
+	if len(diff.Config) > 0 {
+		diff.FieldsChanged = append(diff.FieldsChanged, "config")
+	}
+	return diff
+}
+
+// diffLines is a line diff of two texts, from their longest common
+// subsequence of lines. Empty text has no lines.
+func diffLines(before, after string) []DiffLine {
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// common[i][j] is the LCS length of a[i:] and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	lines := []DiffLine{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, DiffLine{Op: " ", Text: a[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, DiffLine{Op: "-", Text: a[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{Op: "+", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{Op: "-", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{Op: "+", Text: b[j]})
+	}
+	return lines
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          []DiffLine
+	}{
+		{
+			name:   "unchanged",
+			before: "a\nb",
+			after:  "a\nb",
+			want:   []DiffLine{{" ", "a"}, {" ", "b"}},
+		},
+		{
+			name:   "line changed",
+			before: "a\nb\nc",
+			after:  "a\nB\nc",
+			want:   []DiffLine{{" ", "a"}, {"-", "b"}, {"+", "B"}, {" ", "c"}},
+		},
+		{
+			name:   "lines added at the end",
+			before: "a",
+			after:  "a\nb\nc",
+			want:   []DiffLine{{" ", "a"}, {"+", "b"}, {"+", "c"}},
+		},
+		{
+			name:   "line removed from the start",
+			before: "a\nb\nc",
+			after:  "b\nc",
+			want:   []DiffLine{{"-", "a"}, {" ", "b"}, {" ", "c"}},
+		},
+		{
+			name:   "keeps the longest common run",
+			before: "x\na\nb\nc",
+			after:  "a\nb\nc\nx",
+			want:   []DiffLine{{"-", "x"}, {" ", "a"}, {" ", "b"}, {" ", "c"}, {"+", "x"}},
+		},
+		{
+			name:   "from empty",
+			before: "",
+			after:  "a",
+			want:   []DiffLine{{"+", "a"}},
+		},
+		{
+			name:   "to empty",
+			before: "a\nb",
+			after:  "",
+			want:   []DiffLine{{"-", "a"}, {"-", "b"}},
+		},
+		{
+			name:   "both empty",
+			before: "",
+			after:  "",
+			want:   []DiffLine{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines(%q, %q) = %v, want %v", tt.before, tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAgentRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		id      string
+		version int
+		err     bool
+	}{
+		{ref: "4f1c", id: "4f1c"},
+		{ref: "4f1c@3", id: "4f1c", version: 3},
+		{ref: "4f1c@0", err: true},
+		{ref: "4f1c@latest", err: true},
+	}
+	for _, tt := range tests {
+		id, version, err := parseAgentRef(tt.ref)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.ref)
+			}
+			continue
+		}
+		if err != nil || id != tt.id || version != tt.version {
+			t.Errorf("%q = (%q, %d, %v), want (%q, %d)", tt.ref, id, version, err, tt.id, tt.version)
+		}
+		if got := agentRef(id, version); got != tt.ref {
+			t.Errorf("agentRef(%q, %d) = %q, want %q", id, version, got, tt.ref)
+		}
+	}
+}
+
+func TestDiffAgentVersions(t *testing.T) {
+	temperature := 0.2
+	before := &AgentVersion{Version: 1, Name: "Triage", Narrative: "Be brief.", Config: AgentConfig{Model: "a"}}
+	after := &AgentVersion{Version: 2, Name: "Triage", Narrative: "Be brief.\nCite sources.", Config: AgentConfig{Model: "b", Temperature: &temperature}}
+
+	diff := diffAgentVersions(before, after)
+	if diff.From != 1 || diff.To != 2 {
+		t.Errorf("from %d to %d, want 1 to 2", diff.From, diff.To)
+	}
+	if want := []string{"narrative", "config"}; !reflect.DeepEqual(diff.FieldsChanged, want) {
+		t.Errorf("fields changed = %v, want %v", diff.FieldsChanged, want)
+	}
+	if want := []DiffLine{{" ", "Be brief."}, {"+", "Cite sources."}}; !reflect.DeepEqual(diff.Narrative, want) {
+		t.Errorf("narrative diff = %v, want %v", diff.Narrative, want)
+	}
+	keys := map[string]bool{}
+	for _, change := range diff.Config {
+		keys[change.Key] = true
+	}
+	if !keys["model"] || !keys["temperature"] || len(keys) != 2 {
+		t.Errorf("config changes = %+v, want model and temperature", diff.Config)
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 const (
@@ -19,64 +21,108 @@ const (
 
 type AgentType string
 type Agent struct {
-	ID          string                 `json:"id"`
-	Name        string                 `json:"name"`
-	Type        AgentType              `json:"type"`
-	Description string                 `json:"description"`
-	Narrative   string                 `json:"narrative"`
-	Config      map[string]interface{} `json:"config"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Slug        string      `json:"slug"`
+	Type        AgentType   `json:"type"`
+	Description string      `json:"description"`
+	Narrative   string      `json:"narrative"`
+	Config      AgentConfig `json:"config"`
+	Version     int         `json:"version"`
+	IsTemplate  bool        `json:"is_template"`
+	Metadata
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
 }
 
-// Add this struct to store chat history
-type ChatSession struct {
-	AgentID  string    `json:"agent_id"`
-	Messages []Message `json:"messages"`
+// agentInput is the request body of CreateAgent and UpdateAgent. Config is
+// kept raw so it can be validated field by field.
+type agentInput struct {
+	Agent
+	Config map[string]interface{} `json:"config"`
 }
 
-func ListAgents(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		rows, err := db.Query(`
-			SELECT id, name, type, description, narrative, config 
-			FROM agents`)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agents"})
-			return
-		}
-		defer rows.Close()
+// agent validates the input and returns the agent it describes.
+func (in *agentInput) agent() (*Agent, FieldErrors) {
+	agent := in.Agent
+	errs := FieldErrors{}
+	if strings.TrimSpace(agent.Name) == "" {
+		errs["name"] = "is required"
+	}
+	if agent.Slug != "" && !validSlug(agent.Slug) {
+		errs["slug"] = "use lowercase letters, digits and dashes"
+	}
+	if err := agent.Metadata.normalize(); err != nil {
+		errs["tags"] = err.Error()
+	}
+	checkNarrative(agent.Narrative, errs)
 
-		var agents []Agent
-		for rows.Next() {
-			var a Agent
-			var configJSON []byte
-			err := rows.Scan(&a.ID, &a.Name, &a.Type, &a.Description, &a.Narrative, &configJSON)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan agent"})
-				return
-			}
-			if err := json.Unmarshal(configJSON, &a.Config); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse config"})
-				return
-			}
-			agents = append(agents, a)
-		}
-		c.JSON(http.StatusOK, agents)
+	config, configErrs := parseAgentConfig(agent.Type, in.Config)
+	for path, msg := range configErrs {
+		errs[path] = msg
+	}
+	agent.Config = config
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &agent, nil
+}
+
+// agentColumns is the column list read by scanAgent.
+const agentColumns = `id, name, slug, type, description, narrative, config, version, is_template, tags, folder, owner, archived_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAgent(row rowScanner) (*Agent, error) {
+	var agent Agent
+	var configJSON []byte
+	if err := row.Scan(&agent.ID, &agent.Name, &agent.Slug, &agent.Type, &agent.Description, &agent.Narrative, &configJSON, &agent.Version, &agent.IsTemplate, pq.Array(&agent.Tags), &agent.Folder, &agent.Owner, &agent.ArchivedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(configJSON, &agent.Config); err != nil {
+		return nil, fmt.Errorf("failed to parse agent config: %v", err)
 	}
+	return &agent, nil
+}
+
+var agentListSpec = listSpec{
+	table:       "agents",
+	columns:     agentColumns,
+	sorts:       map[string]string{"name": "name", "created_at": "created_at", "updated_at": "updated_at"},
+	defaultSort: "name",
+	filters:     map[string]string{"type": "type", "template": "is_template::text", "owner": "owner"},
+	search:      []string{"name", "description"},
+	archivable:  true,
+	tagged:      true,
+}
+
+// ListAgents serves GET /agents with pagination, ?q= search and the ?type=,
+// ?template=, ?owner=, ?tag=, ?folder= and ?archived= filters.
+func ListAgents(db *sql.DB) gin.HandlerFunc {
+	return listHandler(db, agentListSpec, scanAgent, "agents")
 }
 
 func CreateAgent(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		log.Printf("Received CreateAgent request")
-		var agent Agent
-		if err := c.ShouldBindJSON(&agent); err != nil {
+		var in agentInput
+		if err := c.ShouldBindJSON(&in); err != nil {
 			log.Printf("Failed to bind JSON: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		// Ensure use_rag is present in config
-		if _, exists := agent.Config["use_rag"]; !exists {
-			agent.Config["use_rag"] = false
+		if in.Type == "" {
+			in.Type = TypeLLM
 		}
+		agent, errs := in.agent()
+		if errs != nil {
+			respondFieldErrors(c, "agent", errs)
+			return
+		}
+		agent.defaultOwner(c)
 
 		configJSON, err := json.Marshal(agent.Config)
 		if err != nil {
@@ -84,33 +130,53 @@ func CreateAgent(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		err = db.QueryRow(`
-			INSERT INTO agents (name, type, description, narrative, config)
-			VALUES ($1, $2, $3, $4, $5)
-			RETURNING id`,
-			agent.Name, agent.Type, agent.Description, agent.Narrative, configJSON,
-		).Scan(&agent.ID)
+		if agent.Slug == "" {
+			agent.Slug = slugify(agent.Name)
+		}
+		if !validSlug(agent.Slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug: use lowercase letters, digits and dashes"})
+			return
+		}
 
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		err = tx.QueryRow(`
+			INSERT INTO agents (name, slug, type, description, narrative, config, is_template, tags, folder, owner)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id, version`,
+			agent.Name, agent.Slug, agent.Type, agent.Description, agent.Narrative, configJSON, agent.IsTemplate,
+			pq.Array(agent.Tags), agent.Folder, agent.Owner,
+		).Scan(&agent.ID, &agent.Version)
+
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("An agent with slug %q already exists", agent.Slug)})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create agent"})
 			return
 		}
+		if err := insertAgentVersion(tx, agent, configJSON, currentUser(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record agent version"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		setETag(c, agent.Version)
 		c.JSON(http.StatusCreated, agent)
 	}
 }
 
 func GetAgent(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
-		var agent Agent
-		var configJSON []byte
-
-		err := db.QueryRow(`
-			SELECT id, name, type, description, narrative, config 
-			FROM agents WHERE id = $1`,
-			id,
-		).Scan(&agent.ID, &agent.Name, &agent.Type, &agent.Description, &agent.Narrative, &configJSON)
-
+		agent, err := fetchAgent(db, c.Param("id"))
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
 			return
@@ -119,201 +185,105 @@ func GetAgent(db *sql.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
 			return
 		}
-
-		if err := json.Unmarshal(configJSON, &agent.Config); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse config"})
-			return
-		}
+		setETag(c, agent.Version)
 		c.JSON(http.StatusOK, agent)
 	}
 }
 
+// fetchAgent loads a single agent by ID. It returns sql.ErrNoRows when the
+// agent does not exist.
+func fetchAgent(db *sql.DB, id string) (*Agent, error) {
+	return scanAgent(db.QueryRow(`SELECT `+agentColumns+` FROM agents WHERE id = $1`, id))
+}
+
 func UpdateAgent(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		var agent Agent
-		if err := c.ShouldBindJSON(&agent); err != nil {
+		var in agentInput
+		if err := c.ShouldBindJSON(&in); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-
-		configJSON, err := json.Marshal(agent.Config)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid config format"})
-			return
-		}
-
-		result, err := db.Exec(`
-			UPDATE agents 
-			SET name = $1, type = $2, description = $3, narrative = $4, config = $5
-			WHERE id = $6`,
-			agent.Name, agent.Type, agent.Description, agent.Narrative, configJSON, id,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update agent"})
-			return
-		}
-
-		rows, err := result.RowsAffected()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rows affected"})
-			return
+		if in.Type == "" {
+			// Validate the config against the type the agent already has.
+			err := db.QueryRow(`SELECT type FROM agents WHERE id = $1`, id).Scan(&in.Type)
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
+				return
+			}
 		}
-		if rows == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		agent, errs := in.agent()
+		if errs != nil {
+			respondFieldErrors(c, "agent", errs)
 			return
 		}
-		agent.ID = id
-		c.JSON(http.StatusOK, agent)
-	}
-}
 
-func DeleteAgent(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		id := c.Param("id")
-
-		result, err := db.Exec(`DELETE FROM agents WHERE id = $1`, id)
+		configJSON, err := json.Marshal(agent.Config)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete agent"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid config format"})
 			return
 		}
 
-		rows, err := result.RowsAffected()
+		expected, err := ifMatchVersion(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rows affected"})
-			return
-		}
-		if rows == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"message": "Agent deleted", "id": id})
-	}
-}
 
-func ChatWithAgent(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		id := c.Param("id")
-
-		// Get the agent
-		var agent Agent
-		var configJSON []byte
-		err := db.QueryRow(`SELECT id, name, type, description, narrative, config FROM agents WHERE id = $1`, id).
-			Scan(&agent.ID, &agent.Name, &agent.Type, &agent.Description, &agent.Narrative, &configJSON)
+		tx, err := db.Begin()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
 			return
 		}
-
-		// Parse config JSON
-		if err := json.Unmarshal(configJSON, &agent.Config); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse agent config: %v", err)})
+		defer tx.Rollback()
+
+		err = tx.QueryRow(`
+			UPDATE agents
+			SET name = $1, slug = COALESCE(NULLIF($2, ''), slug), type = $3, description = $4, narrative = $5, config = $6, is_template = $9,
+				tags = $10, folder = $11, owner = COALESCE(NULLIF($12, ''), owner), version = version + 1
+			WHERE id = $7 AND ($8::int IS NULL OR version = $8)
+			RETURNING slug, owner, version`,
+			agent.Name, agent.Slug, agent.Type, agent.Description, agent.Narrative, configJSON, id, expected, agent.IsTemplate,
+			pq.Array(agent.Tags), agent.Folder, agent.Owner,
+		).Scan(&agent.Slug, &agent.Owner, &agent.Version)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("An agent with slug %q already exists", agent.Slug)})
 			return
 		}
-
-		// Get chat request
-		var req struct {
-			Message string `json:"message" binding:"required"`
-		}
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		// Get or initialize chat history
-		var historyJSON []byte
-		err = db.QueryRow(`
-			SELECT messages FROM chat_history 
-			WHERE agent_id = $1 AND user_id = $2
-			ORDER BY created_at DESC LIMIT 1`,
-			id, "current_user",
-		).Scan(&historyJSON)
-
-		var history []Message
-		if err == nil {
-			if err := json.Unmarshal(historyJSON, &history); err != nil {
-				log.Printf("Failed to unmarshal chat history: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse chat history"})
+		if err == sql.ErrNoRows {
+			// Either the agent is gone or If-Match named a stale version.
+			current, err := fetchAgent(db, id)
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
 				return
 			}
-		} else if err != sql.ErrNoRows {
-			log.Printf("Failed to fetch chat history: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat history"})
-			return
-		}
-
-		// Add narrative and context to system message
-		var contextPrompt string
-		var userMessage *Message
-		if useDirectQuery, ok := agent.Config["use_direct_query"].(bool); ok && useDirectQuery {
-			patientInfo, err := extractPatientInfo(req.Message, llmClient)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse patient info: %v", err)})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
 				return
 			}
-
-			if patientInfo != nil {
-				queryResults, err := QueryPatientData(db, *patientInfo)
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to query data: %v", err)})
-					return
-				}
-				contextPrompt = fmt.Sprintf(`Here is the patient's medical data:
-
-%s
-
-Based on this data, please provide a clinical summary.`, queryResults)
-			} else {
-				userMessage = &Message{Role: "user", Content: req.Message}
-			}
-		}
-
-		systemMessage := Message{
-			Role:    "system",
-			Content: agent.Narrative + "\n\n" + contextPrompt,
-		}
-
-		allMessages := make([]Message, 0, len(history)+2)
-		allMessages = append(allMessages, history...)
-		allMessages = append(allMessages, systemMessage)
-
-		if userMessage != nil {
-			allMessages = append(allMessages, *userMessage)
+			respondVersionConflict(c, "Agent", current.Version, current)
+			return
 		}
-
-		// Get response using the context and history
-		model := agent.Config["model"].(string)
-		temperature := agent.Config["temperature"].(float64)
-		maxTokens := int(agent.Config["max_tokens"].(float64))
-
-		response, _, err := llmClient.Complete(allMessages, model, temperature, &maxTokens)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update agent"})
 			return
 		}
 
-		// Add assistant response to history
-		history = append(history, Message{Role: "assistant", Content: response})
-
-		// Store updated chat history
-		historyJSON, err = json.Marshal(history)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal chat history"})
+		agent.ID = id
+		if err := insertAgentVersion(tx, agent, configJSON, currentUser(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record agent version"})
 			return
 		}
-
-		_, err = db.Exec(`
-			INSERT INTO chat_history (agent_id, user_id, messages)
-			VALUES ($1, $2, $3)
-			ON CONFLICT (agent_id, user_id) 
-			DO UPDATE SET messages = $3`,
-			id, "current_user", historyJSON)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chat history"})
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
 			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{"response": response})
+		setETag(c, agent.Version)
+		c.JSON(http.StatusOK, agent)
 	}
 }
 
@@ -331,34 +301,3 @@ Context:
 
 Answer the question based on the context above.`, context)
 }
-
-func extractPatientInfo(message string, llmClient LLMClient) (*PatientQuery, error) {
-	maxTokens := 1024
-	extractPrompt := []Message{{
-		Role: "user",
-		Content: `Extract patient first name, last name, and date of birth (YYYY-MM-DD) from this message.
-				 You must respond with ONLY a JSON object in this exact format, with no other text:
-				 {"firstName": "value", "lastName": "value", "dob": "YYYY-MM-DD"}
-				 If any field cannot be found, use null for that field.
-				 Message: ` + message,
-	}}
-
-	response, _, err := llmClient.Complete(extractPrompt, "anthropic.claude-3-5-sonnet-20240620-v1:0", 0.1, &maxTokens)
-	if err != nil {
-		return nil, err
-	}
-
-	// Trim any potential whitespace or extra characters
-	response = strings.TrimSpace(response)
-
-	var patientInfo PatientQuery
-	if err := json.Unmarshal([]byte(response), &patientInfo); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response as JSON: %v\nResponse was: %s", err, response)
-	}
-
-	// Only return if we have all required fields
-	if patientInfo.FirstName != "" && patientInfo.LastName != "" && patientInfo.DOB != "" {
-		return &patientInfo, nil
-	}
-	return nil, nil
-}
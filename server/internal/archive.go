@@ -0,0 +1,399 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deleting a workflow or agent archives it: the row is hidden from lists but
+// executions, versions and references keep pointing at it. Archived rows can
+// be restored, or purged for good.
+
+// Purge policies for the executions of a workflow.
+const (
+	PurgeRestrict = "restrict" // refuse while executions exist
+	PurgeDetach   = "detach"   // keep executions, clear their workflow_id
+	PurgeDelete   = "delete"   // delete executions with the workflow
+)
+
+// WorkflowRef identifies a workflow that blocks an agent operation.
+type WorkflowRef struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Archived bool   `json:"archived"`
+	// Versions lists the past versions that reference the agent, when only
+	// the version history does.
+	Versions []int `json:"versions,omitempty"`
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// workflowsUsingAgent lists the workflows whose current DAG has a node for
+// the agent, following its latest version or pinning one.
+func workflowsUsingAgent(q queryer, agentID string, includeArchived bool) ([]WorkflowRef, error) {
+	query := `
+		SELECT id, name, slug, archived_at IS NOT NULL
+		FROM workflows
+		WHERE EXISTS (
+			SELECT 1 FROM jsonb_array_elements(dag->'nodes') node
+			WHERE split_part(node->>'agentId', '@', 1) = $1::text
+		)`
+	if !includeArchived {
+		query += ` AND archived_at IS NULL`
+	}
+	rows, err := q.Query(query+` ORDER BY name`, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []WorkflowRef{}
+	for rows.Next() {
+		var ref WorkflowRef
+		if err := rows.Scan(&ref.ID, &ref.Name, &ref.Slug, &ref.Archived); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// versionsUsingAgent lists the workflows with a past version whose DAG has a
+// node for the agent. Rolling back to such a version would need the agent.
+func versionsUsingAgent(q queryer, agentID string) ([]WorkflowRef, error) {
+	rows, err := q.Query(`
+		SELECT w.id, w.name, w.slug, w.archived_at IS NOT NULL, v.version
+		FROM workflow_versions v
+		JOIN workflows w ON w.id = v.workflow_id
+		WHERE EXISTS (
+			SELECT 1 FROM jsonb_array_elements(v.dag->'nodes') node
+			WHERE split_part(node->>'agentId', '@', 1) = $1::text
+		)
+		ORDER BY w.name, w.id, v.version`, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := []WorkflowRef{}
+	for rows.Next() {
+		var ref WorkflowRef
+		var version int
+		if err := rows.Scan(&ref.ID, &ref.Name, &ref.Slug, &ref.Archived, &version); err != nil {
+			return nil, err
+		}
+		if n := len(refs); n > 0 && refs[n-1].ID == ref.ID {
+			refs[n-1].Versions = append(refs[n-1].Versions, version)
+			continue
+		}
+		ref.Versions = []int{version}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// unavailableAgents lists the agent references of dag whose agent is
+// archived or purged.
+func unavailableAgents(db *sql.DB, dag Dag) ([]string, error) {
+	var unavailable []string
+	for _, node := range dag.Nodes {
+		if node.Type != NodeTypeAgent {
+			continue
+		}
+		agent, err := fetchAgentRef(db, node.AgentID)
+		if err == sql.ErrNoRows || (err == nil && agent.ArchivedAt != nil) {
+			unavailable = append(unavailable, node.AgentID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return unavailable, nil
+}
+
+// DeleteWorkflow archives a workflow. Archiving is idempotent.
+func DeleteWorkflow(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		var workflow Workflow
+		err := db.QueryRow(`
+			UPDATE workflows
+			SET archived_at = COALESCE(archived_at, CURRENT_TIMESTAMP)
+			WHERE id = $1
+			RETURNING id, archived_at`,
+			id,
+		).Scan(&workflow.ID, &workflow.ArchivedAt)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive workflow"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Workflow archived", "id": id, "archived_at": workflow.ArchivedAt})
+	}
+}
+
+// RestoreWorkflow un-archives a workflow, provided every agent it uses is
+// still available.
+func RestoreWorkflow(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workflow, err := fetchWorkflow(db, c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow"})
+			return
+		}
+
+		unavailable, err := unavailableAgents(db, workflow.Dag)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
+			return
+		}
+		if len(unavailable) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "Workflow uses agents that are archived or purged; restore them first",
+				"agents": unavailable,
+			})
+			return
+		}
+
+		_, err = db.Exec(`UPDATE workflows SET archived_at = NULL WHERE id = $1`, workflow.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore workflow"})
+			return
+		}
+		workflow.ArchivedAt = nil
+		c.JSON(http.StatusOK, workflow)
+	}
+}
+
+// PurgeWorkflow permanently deletes an archived workflow and its version
+// history. ?executions= picks what happens to its executions: restrict
+// (default), detach or delete.
+func PurgeWorkflow(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		policy := c.DefaultQuery("executions", PurgeRestrict)
+		if policy != PurgeRestrict && policy != PurgeDetach && policy != PurgeDelete {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "executions must be restrict, detach or delete"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var archived bool
+		err = tx.QueryRow(`SELECT archived_at IS NOT NULL FROM workflows WHERE id = $1 FOR UPDATE`, id).Scan(&archived)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow"})
+			return
+		}
+		if !archived {
+			c.JSON(http.StatusConflict, gin.H{"error": "Archive the workflow before purging it"})
+			return
+		}
+
+		var total, running int
+		err = tx.QueryRow(`
+			SELECT COUNT(*), COUNT(*) FILTER (WHERE status = 'in_progress')
+			FROM executions WHERE workflow_id = $1`,
+			id,
+		).Scan(&total, &running)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count executions"})
+			return
+		}
+		if running > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Workflow has %d running executions", running)})
+			return
+		}
+
+		switch policy {
+		case PurgeRestrict:
+			if total > 0 {
+				c.JSON(http.StatusConflict, gin.H{
+					"error":      fmt.Sprintf("Workflow has %d executions; purge with executions=detach or executions=delete", total),
+					"executions": total,
+				})
+				return
+			}
+		case PurgeDetach:
+			_, err = tx.Exec(`UPDATE executions SET workflow_id = NULL WHERE workflow_id = $1`, id)
+		case PurgeDelete:
+			_, err = tx.Exec(`DELETE FROM executions WHERE workflow_id = $1`, id)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + policy + " executions"})
+			return
+		}
+
+		if _, err := tx.Exec(`DELETE FROM workflows WHERE id = $1`, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge workflow"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Workflow purged",
+			"id":         id,
+			"executions": gin.H{"policy": policy, "affected": total},
+		})
+	}
+}
+
+// DeleteAgent archives an agent. Agents used by an active workflow can't be
+// archived.
+func DeleteAgent(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		refs, err := workflowsUsingAgent(db, id, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check agent references"})
+			return
+		}
+		if len(refs) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     fmt.Sprintf("Agent is used by %d workflows", len(refs)),
+				"workflows": refs,
+			})
+			return
+		}
+
+		var agent Agent
+		err = db.QueryRow(`
+			UPDATE agents
+			SET archived_at = COALESCE(archived_at, CURRENT_TIMESTAMP)
+			WHERE id = $1
+			RETURNING id, archived_at`,
+			id,
+		).Scan(&agent.ID, &agent.ArchivedAt)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive agent"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Agent archived", "id": id, "archived_at": agent.ArchivedAt})
+	}
+}
+
+func RestoreAgent(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent, err := scanAgent(db.QueryRow(`
+			UPDATE agents SET archived_at = NULL
+			WHERE id = $1
+			RETURNING `+agentColumns,
+			c.Param("id"),
+		))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore agent"})
+			return
+		}
+		c.JSON(http.StatusOK, agent)
+	}
+}
+
+// PurgeAgent permanently deletes an archived agent with its cached results
+// and chat history. It is refused while any workflow, archived or not,
+// still references the agent.
+func PurgeAgent(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var archived bool
+		err = tx.QueryRow(`SELECT archived_at IS NOT NULL FROM agents WHERE id = $1 FOR UPDATE`, id).Scan(&archived)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
+			return
+		}
+		if !archived {
+			c.JSON(http.StatusConflict, gin.H{"error": "Archive the agent before purging it"})
+			return
+		}
+
+		refs, err := workflowsUsingAgent(tx, id, true)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check agent references"})
+			return
+		}
+		if len(refs) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     fmt.Sprintf("Agent is still referenced by %d workflows", len(refs)),
+				"workflows": refs,
+			})
+			return
+		}
+
+		refs, err = versionsUsingAgent(tx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check agent references"})
+			return
+		}
+		if len(refs) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     fmt.Sprintf("Agent is still referenced by the version history of %d workflows", len(refs)),
+				"workflows": refs,
+			})
+			return
+		}
+
+		for _, query := range []string{
+			`DELETE FROM node_result_cache WHERE agent_id = $1`,
+			`DELETE FROM conversations WHERE agent_id = $1`,
+			`DELETE FROM agents WHERE id = $1`,
+		} {
+			if _, err := tx.Exec(query, id); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge agent"})
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Agent purged", "id": id})
+	}
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// serveTestRequest sends one request to handlers mounted at route.
+func serveTestRequest(method, route, target, body string, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, route, handlers...)
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, reader))
+	return w
+}
+
+func expectPurgeableWorkflow(mock sqlmock.Sqlmock, total, running int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT archived_at IS NOT NULL FROM workflows WHERE id = \$1 FOR UPDATE`).
+		WithArgs("w1").
+		WillReturnRows(sqlmock.NewRows([]string{"archived"}).AddRow(true))
+	mock.ExpectQuery(`FROM executions WHERE workflow_id = \$1`).
+		WithArgs("w1").
+		WillReturnRows(sqlmock.NewRows([]string{"total", "running"}).AddRow(total, running))
+}
+
+func TestPurgeWorkflowPolicies(t *testing.T) {
+	t.Run("restrict refuses while executions exist", func(t *testing.T) {
+		db, mock := newTestMock(t)
+		expectPurgeableWorkflow(mock, 2, 0)
+		mock.ExpectRollback()
+
+		w := serveTestRequest(http.MethodDelete, "/workflows/:id/purge", "/workflows/w1/purge", "", PurgeWorkflow(db))
+		if w.Code != http.StatusConflict {
+			t.Errorf("status = %d, want 409: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("running executions block every policy", func(t *testing.T) {
+		db, mock := newTestMock(t)
+		expectPurgeableWorkflow(mock, 2, 1)
+		mock.ExpectRollback()
+
+		w := serveTestRequest(http.MethodDelete, "/workflows/:id/purge", "/workflows/w1/purge?executions=delete", "", PurgeWorkflow(db))
+		if w.Code != http.StatusConflict {
+			t.Errorf("status = %d, want 409: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("detach keeps executions", func(t *testing.T) {
+		db, mock := newTestMock(t)
+		expectPurgeableWorkflow(mock, 2, 0)
+		mock.ExpectExec(`UPDATE executions SET workflow_id = NULL WHERE workflow_id = \$1`).
+			WithArgs("w1").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(`DELETE FROM workflows WHERE id = \$1`).
+			WithArgs("w1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		w := serveTestRequest(http.MethodDelete, "/workflows/:id/purge", "/workflows/w1/purge?executions=detach", "", PurgeWorkflow(db))
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("active workflows must be archived first", func(t *testing.T) {
+		db, mock := newTestMock(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT archived_at IS NOT NULL FROM workflows`).
+			WillReturnRows(sqlmock.NewRows([]string{"archived"}).AddRow(false))
+		mock.ExpectRollback()
+
+		w := serveTestRequest(http.MethodDelete, "/workflows/:id/purge", "/workflows/w1/purge", "", PurgeWorkflow(db))
+		if w.Code != http.StatusConflict {
+			t.Errorf("status = %d, want 409: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		db, _ := newTestMock(t)
+		w := serveTestRequest(http.MethodDelete, "/workflows/:id/purge", "/workflows/w1/purge?executions=cascade", "", PurgeWorkflow(db))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", w.Code)
+		}
+	})
+}
+
+func TestDeleteAgentRefusedWhileInUse(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`(?s)FROM workflows\s+WHERE EXISTS .* AND archived_at IS NULL ORDER BY name`).
+		WithArgs("a1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "slug", "archived"}).AddRow("w1", "Nightly", "nightly", false))
+
+	w := serveTestRequest(http.MethodDelete, "/agents/:id", "/agents/a1", "", DeleteAgent(db))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Workflows []WorkflowRef `json:"workflows"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if len(body.Workflows) != 1 || body.Workflows[0].Slug != "nightly" {
+		t.Errorf("workflows = %+v", body.Workflows)
+	}
+}
+
+func TestPurgeAgentRefusedByVersionHistory(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT archived_at IS NOT NULL FROM agents WHERE id = \$1 FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"archived"}).AddRow(true))
+	mock.ExpectQuery(`FROM workflows\s+WHERE EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "slug", "archived"}))
+	mock.ExpectQuery(`FROM workflow_versions v`).
+		WithArgs("a1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "slug", "archived", "version"}).
+			AddRow("w1", "Nightly", "nightly", false, 1).
+			AddRow("w1", "Nightly", "nightly", false, 3).
+			AddRow("w2", "Weekly", "weekly", true, 2))
+	mock.ExpectRollback()
+
+	w := serveTestRequest(http.MethodDelete, "/agents/:id/purge", "/agents/a1/purge", "", PurgeAgent(db))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Workflows []WorkflowRef `json:"workflows"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	want := []WorkflowRef{
+		{ID: "w1", Name: "Nightly", Slug: "nightly", Versions: []int{1, 3}},
+		{ID: "w2", Name: "Weekly", Slug: "weekly", Archived: true, Versions: []int{2}},
+	}
+	if !reflect.DeepEqual(body.Workflows, want) {
+		t.Errorf("workflows = %+v, want %+v", body.Workflows, want)
+	}
+}
+
+func TestPurgeAgentDeletesItsData(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT archived_at IS NOT NULL FROM agents`).
+		WillReturnRows(sqlmock.NewRows([]string{"archived"}).AddRow(true))
+	mock.ExpectQuery(`FROM workflows\s+WHERE EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "slug", "archived"}))
+	mock.ExpectQuery(`FROM workflow_versions v`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "slug", "archived", "version"}))
+	for _, table := range []string{"node_result_cache", "conversations", "agents"} {
+		mock.ExpectExec(`DELETE FROM ` + table).WithArgs("a1").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	w := serveTestRequest(http.MethodDelete, "/agents/:id/purge", "/agents/a1/purge", "", PurgeAgent(db))
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// NodeCachePolicy is the opt-in memoization setting of a workflow node.
+// It is read from the node configuration, e.g.
+//
+//	"cache": {"enabled": true, "ttl": "24h"}
+type NodeCachePolicy struct {
+	Enabled bool   `json:"enabled"`
+	TTL     string `json:"ttl"`
+}
+
+const defaultNodeCacheTTL = 24 * time.Hour
+
+// ModelParams are the model settings that influence an LLM completion.
+type ModelParams struct {
+	Model       string  `json:"model"`
+	Temperature float64 `json:"temperature"`
+	MaxTokens   int     `json:"max_tokens"`
+}
+
+func parseNodeCachePolicy(configuration map[string]interface{}) (*NodeCachePolicy, error) {
+	raw, ok := configuration["cache"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	enabled, _ := raw["enabled"].(bool)
+	if !enabled {
+		return nil, nil
+	}
+
+	policy := &NodeCachePolicy{Enabled: true, TTL: defaultNodeCacheTTL.String()}
+	if ttl, ok := raw["ttl"].(string); ok && ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache ttl %q: %v", ttl, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("cache ttl must be positive, got %q", ttl)
+		}
+		policy.TTL = d.String()
+	}
+	return policy, nil
+}
+
+func (p *NodeCachePolicy) ttl() time.Duration {
+	d, err := time.ParseDuration(p.TTL)
+	if err != nil || d <= 0 {
+		return defaultNodeCacheTTL
+	}
+	return d
+}
+
+// agentVersion fingerprints the parts of an agent that affect its output.
+func agentVersion(agent *Agent) (string, error) {
+	payload, err := json.Marshal(struct {
+		Type      AgentType   `json:"type"`
+		Narrative string      `json:"narrative"`
+		Config    AgentConfig `json:"config"`
+	}{agent.Type, agent.Narrative, agent.Config})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// nodeCacheKey content-addresses a node execution: identical agent version,
+// messages and model parameters always hash to the same key.
+func nodeCacheKey(agentID, version string, messages []Message, params ModelParams) (string, error) {
+	payload, err := json.Marshal(struct {
+		AgentID  string      `json:"agent_id"`
+		Version  string      `json:"version"`
+		Messages []Message   `json:"messages"`
+		Params   ModelParams `json:"params"`
+	}{agentID, version, messages, params})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func lookupNodeCache(db *sql.DB, key string) (string, bool, error) {
+	var output string
+	err := db.QueryRow(`
+		UPDATE node_result_cache
+		SET hits = hits + 1
+		WHERE cache_key = $1 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING output`,
+		key,
+	).Scan(&output)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return output, true, nil
+}
+
+func storeNodeCache(db *sql.DB, key, agentID, output string, ttl time.Duration) error {
+	_, err := db.Exec(`
+		INSERT INTO node_result_cache (cache_key, agent_id, output, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (cache_key)
+		DO UPDATE SET output = EXCLUDED.output, hits = 0, expires_at = EXCLUDED.expires_at`,
+		key, agentID, output, time.Now().Add(ttl),
+	)
+	return err
+}
+
+func purgeExpiredNodeCache(db *sql.DB) (int64, error) {
+	result, err := db.Exec(`DELETE FROM node_result_cache WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
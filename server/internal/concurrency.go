@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Agents, workflows and integrations carry a row version that is bumped on
+// every write. GET responses expose it as an ETag; a PUT with If-Match only
+// succeeds if the row is still at that version, otherwise it gets 409 with
+// the current resource so the client can merge.
+
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", etag(version))
+}
+
+// ifMatchVersion parses the If-Match header. It returns nil when the header
+// is absent or "*", meaning the write is unconditional.
+func ifMatchVersion(c *gin.Context) (*int, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	value := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return nil, fmt.Errorf("invalid If-Match header %q", header)
+	}
+	return &version, nil
+}
+
+// respondVersionConflict reports a stale write along with the current state
+// of the resource.
+func respondVersionConflict(c *gin.Context, kind string, version int, current interface{}) {
+	setETag(c, version)
+	c.JSON(http.StatusConflict, gin.H{
+		"error":          fmt.Sprintf("%s was modified by someone else; current version is %d", kind, version),
+		"currentVersion": version,
+		"current":        current,
+	})
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		header  string
+		want    int
+		wantErr bool
+	}{
+		{header: ""},
+		{header: "*"},
+		{header: `"3"`, want: 3},
+		{header: `W/"3"`, want: 3},
+		{header: "3", want: 3},
+		{header: `"0"`, wantErr: true},
+		{header: `"abc"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPut, "/", nil)
+		c.Request.Header.Set("If-Match", tt.header)
+
+		version, err := ifMatchVersion(c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("If-Match %q: err = %v", tt.header, err)
+			continue
+		}
+		got := 0
+		if version != nil {
+			got = *version
+		}
+		if got != tt.want {
+			t.Errorf("If-Match %q = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func putTestIntegration(t *testing.T, db *sql.DB, ifMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/integrations/:id", UpdateIntegration(db))
+
+	body := `{"name": "Drive", "provider": "snowflake", "type": "storage", "config": {"folder": "x"}}`
+	req := httptest.NewRequest(http.MethodPut, "/integrations/i1", strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateIntegrationKeepsProvider(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`UPDATE integrations`).
+		WithArgs("Drive", "storage", "", []byte(`{"folder":"x"}`), "i1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "provider", "version"}).AddRow("i1", "google-drive", 3))
+
+	w := putTestIntegration(t, db, `"2"`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got != `"3"` {
+		t.Errorf("ETag = %s, want \"3\"", got)
+	}
+	var got Integration
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if got.ID != "i1" || got.Provider != "google-drive" || got.Version != 3 {
+		t.Errorf("integration = %+v, want i1 from google-drive at version 3", got)
+	}
+}
+
+func TestUpdateIntegrationConflicts(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`UPDATE integrations`).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT .* FROM integrations WHERE id = \$1`).
+		WithArgs("i1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "provider", "type", "description", "config", "version"}).
+			AddRow("i1", "Drive", "google-drive", "storage", "", []byte(`{}`), 5))
+
+	w := putTestIntegration(t, db, `"2"`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("ETag"); got != `"5"` {
+		t.Errorf("ETag = %s, want the current version", got)
+	}
+	var body struct {
+		CurrentVersion int         `json:"currentVersion"`
+		Current        Integration `json:"current"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.CurrentVersion != 5 || body.Current.Provider != "google-drive" {
+		t.Errorf("body = %+v", body)
+	}
+}
+
+func TestUpdateIntegrationNotFound(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`UPDATE integrations`).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT .* FROM integrations WHERE id = \$1`).WillReturnError(sql.ErrNoRows)
+
+	if w := putTestIntegration(t, db, ""); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestUpdateIntegrationRejectsBadIfMatch(t *testing.T) {
+	db, _ := newTestMock(t)
+	if w := putTestIntegration(t, db, `"latest"`); w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
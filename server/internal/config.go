@@ -8,8 +8,14 @@ import (
 )
 
 type Config struct {
-	DB  DBConfig
-	LLM LLMConfig
+	DB   DBConfig
+	LLM  LLMConfig
+	Auth AuthConfig
+}
+
+// AuthConfig holds the secret API tokens are signed with.
+type AuthConfig struct {
+	Secret string
 }
 
 func LoadConfig() (*Config, error) {
@@ -35,6 +41,11 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("LLM_API_KEY environment variable is required")
 	}
 
+	authConfig := AuthConfig{Secret: getEnv("AUTH_SECRET", "")}
+	if authConfig.Secret == "" {
+		return nil, fmt.Errorf("AUTH_SECRET environment variable is required")
+	}
+
 	dbConfig := DBConfig{
 		Host:     getEnv("DB_HOST", "localhost"),
 		Port:     5432,
@@ -45,8 +56,9 @@ func LoadConfig() (*Config, error) {
 	}
 
 	return &Config{
-		DB:  dbConfig,
-		LLM: llmConfig,
+		DB:   dbConfig,
+		LLM:  llmConfig,
+		Auth: authConfig,
 	}, nil
 }
 
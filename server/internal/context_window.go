@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultContextBudget = 8000
+	minContextBudget     = 500
+	maxContextBudget     = 1000000
+	// charsPerToken is a rough average for English text, close enough to
+	// keep history within budget without a tokenizer per model.
+	charsPerToken = 4
+)
+
+func estimateTokens(text string) int {
+	return (len(text)+charsPerToken-1)/charsPerToken + 4 // per-message overhead
+}
+
+// fitHistory returns the most recent messages of history that fit the
+// agent's context budget alongside the conversation summary. Older messages
+// are folded into the summary, which is stored with the conversation.
+func fitHistory(db *sql.DB, llmClient LLMClient, agent *Agent, conv *Conversation, history []ConversationMessage) ([]ConversationMessage, error) {
+	budget := agent.Config.ContextBudget
+	if budget <= 0 {
+		budget = defaultContextBudget
+	}
+
+	used := estimateTokens(conv.Summary)
+	cut := len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		tokens := estimateTokens(history[i].Content)
+		if used+tokens > budget {
+			break
+		}
+		used += tokens
+		cut = i
+	}
+	// Recent history starts with a user turn, as the models require.
+	for cut < len(history) && history[cut].Role != "user" {
+		cut++
+	}
+	if cut == 0 {
+		return history, nil
+	}
+
+	older := history[:cut]
+	summary, err := summarizeHistory(llmClient, agent, conv.Summary, older, budget/4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize history: %v", err)
+	}
+	through := older[len(older)-1].ID
+	if _, err := db.Exec(`
+		UPDATE conversations SET summary = $2, summarized_through = $3
+		WHERE id = $1`,
+		conv.ID, summary, through); err != nil {
+		return nil, fmt.Errorf("failed to store summary: %v", err)
+	}
+	conv.Summary, conv.SummarizedThrough = summary, through
+	return history[cut:], nil
+}
+
+// summarizeHistory extends a rolling summary with older messages, keeping
+// it within maxTokens.
+func summarizeHistory(llmClient LLMClient, agent *Agent, summary string, older []ConversationMessage, maxTokens int) (string, error) {
+	var transcript strings.Builder
+	if summary != "" {
+		transcript.WriteString("Summary so far:\n" + summary + "\n\nLater messages:\n")
+	}
+	for _, msg := range older {
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+
+	words := maxTokens * 3 / 4
+	prompt := []Message{
+		{Role: "system", Content: fmt.Sprintf(`Summarize this conversation between a user and an assistant in at most %d words. Keep names, figures, decisions, open questions and anything the user asked to remember. Respond with the summary only.`, words)},
+		{Role: "user", Content: transcript.String()},
+	}
+	params := agent.Config.modelParams(llmClient.Defaults())
+	response, _, err := llmClient.Complete(prompt, params.Model, 0, &maxTokens)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response), nil
+}
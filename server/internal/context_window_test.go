@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func testHistory(contents ...string) []ConversationMessage {
+	history := make([]ConversationMessage, len(contents))
+	for i, content := range contents {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		history[i] = ConversationMessage{ID: int64(i + 1), Role: role, Content: content}
+	}
+	return history
+}
+
+func TestFitHistoryWithinBudget(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := testHistory("Hi", "Hello, how can I help?")
+
+	recent, err := fitHistory(nil, llm, &Agent{}, &Conversation{ID: "c1"}, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || len(llm.Calls()) != 0 {
+		t.Errorf("recent = %v after %d model calls, want the history unchanged", recent, len(llm.Calls()))
+	}
+}
+
+func TestFitHistorySummarizesOlderMessages(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{
+		{System: "Summarize this conversation", Response: " The user runs the Denver clinic. "},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 4*600)
+	// The budget holds everything but the first message. The reply to it is
+	// summarized too, so recent history starts with the user.
+	history := testHistory("I run the Denver clinic. "+long, "Noted.", "And the hours?", "Nine to five.")
+	agent := &Agent{Config: AgentConfig{ContextBudget: 500}}
+	conv := &Conversation{ID: "c1", Summary: "Earlier facts."}
+
+	db, mock := newTestMock(t)
+	mock.ExpectExec(`UPDATE conversations SET summary = \$2, summarized_through = \$3`).
+		WithArgs("c1", "The user runs the Denver clinic.", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	recent, err := fitHistory(db, llm, agent, conv, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].Role != "user" || recent[0].Content != "And the hours?" {
+		t.Errorf("recent = %+v, want the last exchange", recent)
+	}
+	if conv.Summary != "The user runs the Denver clinic." || conv.SummarizedThrough != 2 {
+		t.Errorf("conversation = %+v, want the new summary recorded", conv)
+	}
+	if transcript := llm.Calls()[0][1].Content; !strings.HasPrefix(transcript, "Summary so far:\nEarlier facts.") {
+		t.Errorf("summary prompt = %q, want the previous summary extended", transcript)
+	}
+}
+
+func TestAgentTurnSendsOneSystemPrompt(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	turn := agentTurn{
+		Summary: "The user runs the Denver clinic.",
+		History: []Message{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello"}},
+		Message: "What are the hours?",
+	}
+	if _, _, err := turn.reply(context.Background(), nil, llm, &Agent{Type: TypeLLM, Narrative: "You help clinics."}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := llm.Calls()[0]
+	var systems int
+	for _, m := range sent {
+		if m.Role == "system" {
+			systems++
+		}
+	}
+	if systems != 1 || len(sent) != 4 || sent[3].Content != "What are the hours?" {
+		t.Errorf("messages = %+v, want one system prompt, the history and the new message", sent)
+	}
+	if !strings.Contains(sent[0].Content, "Summary of the earlier conversation:\nThe user runs the Denver clinic.") {
+		t.Errorf("system prompt = %q, want the summary included", sent[0].Content)
+	}
+}
@@ -0,0 +1,547 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const maxConversationTitle = 80
+
+// Conversation is one chat thread between a user and an agent. Its messages
+// are stored row by row in conversation_messages.
+type Conversation struct {
+	ID      string `json:"id"`
+	AgentID string `json:"agent_id"`
+	Owner   string `json:"owner"`
+	Title   string `json:"title"`
+	// Variables fill the agent's narrative on every turn.
+	Variables map[string]interface{} `json:"variables"`
+	// Summary condenses the messages up to SummarizedThrough, which are no
+	// longer sent to the model verbatim.
+	Summary           string                `json:"summary,omitempty"`
+	SummarizedThrough int64                 `json:"-"`
+	CreatedAt         time.Time             `json:"created_at"`
+	UpdatedAt         time.Time             `json:"updated_at"`
+	Messages          []ConversationMessage `json:"messages,omitempty"`
+}
+
+type ConversationMessage struct {
+	ID      int64  `json:"id"`
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Sources are the IDs of the documents retrieved for a reply.
+	Sources   []string  `json:"sources,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const conversationColumns = `id, agent_id, owner, title, summary, summarized_through, variables, created_at, updated_at`
+
+func scanConversation(row rowScanner) (*Conversation, error) {
+	var conv Conversation
+	var variablesJSON []byte
+	if err := row.Scan(&conv.ID, &conv.AgentID, &conv.Owner, &conv.Title, &conv.Summary, &conv.SummarizedThrough, &variablesJSON, &conv.CreatedAt, &conv.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(variablesJSON, &conv.Variables); err != nil {
+		return nil, fmt.Errorf("failed to parse conversation variables: %v", err)
+	}
+	if conv.Variables == nil {
+		conv.Variables = map[string]interface{}{}
+	}
+	return &conv, nil
+}
+
+var conversationListSpec = listSpec{
+	table:       "conversations",
+	columns:     conversationColumns,
+	sorts:       map[string]string{"updated_at": "updated_at", "created_at": "created_at", "title": "title"},
+	defaultSort: "-updated_at",
+	filters:     map[string]string{"agent_id": "agent_id::text", "owner": "owner"},
+	search:      []string{"title"},
+}
+
+// fetchConversation loads a conversation of the agent owned by user. Other
+// users' conversations read as missing.
+func fetchConversation(db *sql.DB, agentID, id, user string) (*Conversation, error) {
+	return scanConversation(db.QueryRow(`
+		SELECT `+conversationColumns+` FROM conversations
+		WHERE id::text = $1 AND agent_id::text = $2 AND owner = $3`,
+		id, agentID, user))
+}
+
+// fetchConversationMessages returns the messages after message ID after,
+// oldest first.
+func fetchConversationMessages(db *sql.DB, id string, after int64) ([]ConversationMessage, error) {
+	rows, err := db.Query(`
+		SELECT id, role, content, sources, created_at FROM conversation_messages
+		WHERE conversation_id = $1 AND id > $2 ORDER BY id`, id, after)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ConversationMessage{}
+	for rows.Next() {
+		var msg ConversationMessage
+		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content, pq.Array(&msg.Sources), &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func createConversation(db *sql.DB, agentID, user, title string, variables map[string]interface{}) (*Conversation, error) {
+	if variables == nil {
+		variables = map[string]interface{}{}
+	}
+	variablesJSON, err := json.Marshal(variables)
+	if err != nil {
+		return nil, err
+	}
+	return scanConversation(db.QueryRow(`
+		INSERT INTO conversations (agent_id, owner, title, variables)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+conversationColumns,
+		agentID, user, title, variablesJSON))
+}
+
+// conversationTitle derives a title from the first message of a
+// conversation created without one, cut to maxConversationTitle characters.
+func conversationTitle(message string) string {
+	title := strings.Join(strings.Fields(message), " ")
+	runes := []rune(title)
+	if len(runes) <= maxConversationTitle {
+		return title
+	}
+	head := string(runes[:maxConversationTitle])
+	cut := strings.LastIndex(head, " ")
+	if cut <= 0 {
+		cut = len(head)
+	}
+	return head[:cut] + "..."
+}
+
+// requestAgent resolves the :id agent of an agent route. It
+// writes the error response and returns nil if the agent can't be loaded.
+func requestAgent(c *gin.Context, db *sql.DB) *Agent {
+	agent, err := fetchAgent(db, c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to fetch agent: %v", err)})
+		return nil
+	}
+	return agent
+}
+
+// ListConversations serves the requesting user's conversations with an
+// agent, most recently active first.
+func ListConversations(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		q, err := parseListQuery(c, conversationListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		q.filters["agent_id"] = []string{agent.ID}
+		q.filters["owner"] = []string{currentUser(c)}
+
+		page, err := queryPage(db, conversationListSpec, q, scanConversation)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func CreateConversation(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		var req struct {
+			Title     string                 `json:"title"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		conv, err := createConversation(db, agent.ID, currentUser(c), strings.TrimSpace(req.Title), req.Variables)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
+			return
+		}
+		c.JSON(http.StatusCreated, conv)
+	}
+}
+
+// GetConversation serves a conversation with its messages.
+func GetConversation(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conv, err := fetchConversation(db, c.Param("id"), c.Param("cid"), currentUser(c))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversation"})
+			return
+		}
+		if conv.Messages, err = fetchConversationMessages(db, conv.ID, 0); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+			return
+		}
+		c.JSON(http.StatusOK, conv)
+	}
+}
+
+// RenameConversation serves PUT with {"title": ...}.
+func RenameConversation(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Title string `json:"title" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		title := strings.TrimSpace(req.Title)
+		if title == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Title must not be blank"})
+			return
+		}
+
+		conv, err := scanConversation(db.QueryRow(`
+			UPDATE conversations SET title = $4, updated_at = CURRENT_TIMESTAMP
+			WHERE id::text = $1 AND agent_id::text = $2 AND owner = $3
+			RETURNING `+conversationColumns,
+			c.Param("cid"), c.Param("id"), currentUser(c), title))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename conversation"})
+			return
+		}
+		c.JSON(http.StatusOK, conv)
+	}
+}
+
+func DeleteConversation(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := db.Exec(`
+			DELETE FROM conversations
+			WHERE id::text = $1 AND agent_id::text = $2 AND owner = $3`,
+			c.Param("cid"), c.Param("id"), currentUser(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete conversation"})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Conversation deleted", "id": c.Param("cid")})
+	}
+}
+
+// PostConversationMessage sends a user message to the agent and stores both
+// the message and the reply in the conversation.
+func PostConversationMessage(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return conversationTurnHandler(db, llmClient, requestedConversation, respondConversationTurn)
+}
+
+// StreamConversationMessage is PostConversationMessage with the reply sent
+// as server-sent events.
+func StreamConversationMessage(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return conversationTurnHandler(db, llmClient, requestedConversation, streamConversationTurn)
+}
+
+// ChatWithAgent serves the single-thread chat endpoint. Messages go to the
+// user's most recently active conversation with the agent, which is created
+// on first use.
+func ChatWithAgent(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return conversationTurnHandler(db, llmClient, latestConversation, respondConversationTurn)
+}
+
+// StreamChatWithAgent is ChatWithAgent with the reply sent as server-sent
+// events.
+func StreamChatWithAgent(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return conversationTurnHandler(db, llmClient, latestConversation, streamConversationTurn)
+}
+
+type conversationResolver func(c *gin.Context, db *sql.DB, agent *Agent) (*Conversation, error)
+
+type turnResponder func(c *gin.Context, db *sql.DB, llmClient LLMClient, agent *Agent, conv *Conversation, message string)
+
+// conversationTurnHandler binds {"message": ..., "variables": {...}}, finds
+// the conversation, renders the agent's narrative and hands the turn to
+// respond. Variables are merged into the conversation's and stored with the
+// turn.
+func conversationTurnHandler(db *sql.DB, llmClient LLMClient, resolve conversationResolver, respond turnResponder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		var req struct {
+			Message   string                 `json:"message" binding:"required"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		conv, err := resolve(c, db, agent)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversation"})
+			return
+		}
+
+		for name, value := range req.Variables {
+			conv.Variables[name] = value
+		}
+		rendered, err := withNarrative(agent, conv.Variables, func() (map[string]interface{}, error) {
+			return integrationMetadata(db)
+		})
+		var missing MissingVariablesError
+		if errors.As(err, &missing) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "missing": missing})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		respond(c, db, guardLLM(llmClient, rendered), rendered, conv, req.Message)
+	}
+}
+
+func requestedConversation(c *gin.Context, db *sql.DB, agent *Agent) (*Conversation, error) {
+	return fetchConversation(db, agent.ID, c.Param("cid"), currentUser(c))
+}
+
+func latestConversation(c *gin.Context, db *sql.DB, agent *Agent) (*Conversation, error) {
+	user := currentUser(c)
+	conv, err := scanConversation(db.QueryRow(`
+		SELECT `+conversationColumns+` FROM conversations
+		WHERE agent_id = $1 AND owner = $2
+		ORDER BY updated_at DESC LIMIT 1`,
+		agent.ID, user))
+	if err == sql.ErrNoRows {
+		return createConversation(db, agent.ID, user, "", nil)
+	}
+	return conv, err
+}
+
+func respondConversationTurn(c *gin.Context, db *sql.DB, llmClient LLMClient, agent *Agent, conv *Conversation, message string) {
+	history, err := fetchConversationMessages(db, conv.ID, conv.SummarizedThrough)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat history"})
+		return
+	}
+
+	response, sources, err := agentReply(c.Request.Context(), db, llmClient, agent, conv, history, message, nil)
+	var blocked *GuardrailError
+	if errors.As(err, &blocked) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	reply, err := appendConversationTurn(db, conv, redactForStorage(agent, message), redactForStorage(agent, response), sources)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chat history"})
+		return
+	}
+	rememberTurn(db, llmClient, agent, conv, message, response)
+	c.JSON(http.StatusOK, gin.H{"response": response, "sources": sources, "conversation_id": conv.ID, "message": reply})
+}
+
+// streamConversationTurn sends the reply as "token" events carrying text
+// chunks, then a "done" event with the stored message, or an "error" event.
+// The turn is stored only once the reply is complete.
+func streamConversationTurn(c *gin.Context, db *sql.DB, llmClient LLMClient, agent *Agent, conv *Conversation, message string) {
+	history, err := fetchConversationMessages(db, conv.ID, conv.SummarizedThrough)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chat history"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	send := func(event string, data interface{}) {
+		c.SSEvent(event, data)
+		c.Writer.Flush()
+	}
+	send("start", gin.H{"conversation_id": conv.ID})
+
+	response, sources, err := agentReply(c.Request.Context(), db, llmClient, agent, conv, history, message, func(token string) error {
+		send("token", gin.H{"text": token})
+		return nil
+	})
+	if err != nil {
+		send("error", gin.H{"error": err.Error()})
+		return
+	}
+
+	reply, err := appendConversationTurn(db, conv, redactForStorage(agent, message), redactForStorage(agent, response), sources)
+	if err != nil {
+		send("error", gin.H{"error": "Failed to store chat history"})
+		return
+	}
+	rememberTurn(db, llmClient, agent, conv, message, response)
+	send("done", gin.H{"response": response, "sources": sources, "conversation_id": conv.ID, "message": reply})
+}
+
+// agentReply runs one chat turn: the agent's narrative, the conversation so
+// far within the agent's context budget and the new user message. History is
+// the messages after the conversation's summary. With onToken set the reply
+// is streamed. It also returns the IDs of any documents retrieved.
+func agentReply(ctx context.Context, db *sql.DB, llmClient LLMClient, agent *Agent, conv *Conversation, history []ConversationMessage, message string, onToken func(string) error) (string, []string, error) {
+	recent, err := fitHistory(db, llmClient, agent, conv, history)
+	if err != nil {
+		return "", nil, err
+	}
+	turn := agentTurn{Summary: conv.Summary, Message: message}
+	for _, msg := range recent {
+		turn.History = append(turn.History, Message{Role: msg.Role, Content: msg.Content})
+	}
+	if agent.Type == TypeLLM && agent.Config.UseMemory {
+		if turn.Memories, err = recallMemories(ctx, db, llmClient, agent.ID, conv.Owner, message); err != nil {
+			return "", nil, fmt.Errorf("failed to recall memories: %v", err)
+		}
+	}
+	return turn.reply(ctx, db, llmClient, agent, onToken)
+}
+
+// agentTurn is one message to an agent with the context it is sent in.
+type agentTurn struct {
+	Summary string
+	History []Message
+	Message string
+	// Documents, when set, ground the reply instead of retrieval.
+	Documents []Document
+	// Memories are facts remembered about the user.
+	Memories []string
+}
+
+func (t agentTurn) reply(ctx context.Context, db *sql.DB, llmClient LLMClient, agent *Agent, onToken func(string) error) (string, []string, error) {
+	var contextPrompts []string
+	sources := []string{}
+	if agent.Type == TypeLLM && agent.Config.UseRAG {
+		docs := t.Documents
+		if docs == nil {
+			var err error
+			if docs, err = retrieveForAgent(ctx, db, llmClient, agent, t.Message); err != nil {
+				return "", nil, err
+			}
+		}
+		if len(docs) > 0 {
+			contextPrompts = append(contextPrompts, buildRAGPrompt(t.Message, docs))
+			sources = documentIDs(docs)
+		}
+	}
+	if agent.Type == TypeLLM && agent.Config.UseDirectQuery {
+		prompt, err := lookupPrompt(ctx, db, llmClient, agent, t.Message)
+		if err != nil {
+			return "", nil, err
+		}
+		contextPrompts = append(contextPrompts, prompt)
+	}
+
+	if len(t.Memories) > 0 {
+		contextPrompts = append(contextPrompts, memoryPrompt(t.Memories))
+	}
+	if t.Summary != "" {
+		contextPrompts = append(contextPrompts, "Summary of the earlier conversation:\n"+t.Summary)
+	}
+	system := strings.TrimSpace(agent.Narrative + "\n\n" + strings.Join(contextPrompts, "\n\n"))
+	messages := make([]Message, 0, len(t.History)+2)
+	messages = append(messages, Message{Role: "system", Content: system})
+	messages = append(messages, t.History...)
+	messages = append(messages, Message{Role: "user", Content: t.Message})
+
+	invoker := NewInvoker(llmClient, NewToolRegistry(db, llmClient))
+	req := AgentRequest{Messages: messages, Input: t.Message}
+	var response string
+	var err error
+	if onToken != nil {
+		response, err = invoker.Stream(ctx, agent, req, onToken)
+	} else {
+		response, err = invoker.Invoke(agent, req)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return response, sources, nil
+}
+
+// appendConversationTurn stores a user message and the agent's reply with
+// the conversation's variables, and titles an untitled conversation after
+// its first message.
+func appendConversationTurn(db *sql.DB, conv *Conversation, message, response string, sources []string) (*ConversationMessage, error) {
+	variablesJSON, err := json.Marshal(conv.Variables)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insert := `
+		INSERT INTO conversation_messages (conversation_id, role, content, sources)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, role, content, sources, created_at`
+	var reply ConversationMessage
+	if _, err := tx.Exec(insert, conv.ID, "user", message, pq.Array([]string{})); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(insert, conv.ID, "assistant", response, pq.Array(sources)).Scan(&reply.ID, &reply.Role, &reply.Content, pq.Array(&reply.Sources), &reply.CreatedAt); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE conversations
+		SET updated_at = CURRENT_TIMESTAMP, title = CASE WHEN title = '' THEN $2 ELSE title END, variables = $3
+		WHERE id = $1`,
+		conv.ID, conversationTitle(message), variablesJSON); err != nil {
+		return nil, err
+	}
+	return &reply, tx.Commit()
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestConversationTitle(t *testing.T) {
+	if got := conversationTitle("  Where is\nmy   order? "); got != "Where is my order?" {
+		t.Errorf("title = %q, want whitespace collapsed", got)
+	}
+
+	long := strings.Repeat("word ", 30)
+	if got := conversationTitle(long); len(got) > maxConversationTitle+3 || !strings.HasSuffix(got, "word...") {
+		t.Errorf("title = %q, want it cut at a word boundary", got)
+	}
+
+	accented := strings.Repeat("é", maxConversationTitle+10)
+	got := conversationTitle(accented)
+	if !utf8.ValidString(got) || got != strings.Repeat("é", maxConversationTitle)+"..." {
+		t.Errorf("title = %q, want %d whole characters", got, maxConversationTitle)
+	}
+}
+
+func conversationRows() *sqlmock.Rows {
+	return sqlmock.NewRows(strings.Split(conversationColumns, ", "))
+}
+
+func TestConversationsAreScopedToOwner(t *testing.T) {
+	db, mock := newTestMock(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM conversations\s+WHERE id::text = \$1 AND agent_id::text = \$2 AND owner = \$3`).
+		WithArgs("c1", "a1", "bob").
+		WillReturnRows(conversationRows())
+	mock.ExpectQuery(`FROM conversations\s+WHERE id::text = \$1 AND agent_id::text = \$2 AND owner = \$3`).
+		WithArgs("c1", "a1", "alice").
+		WillReturnRows(conversationRows().AddRow("c1", "a1", "alice", "Orders", "", 0, []byte(`{}`), now, now))
+	mock.ExpectQuery(`FROM conversation_messages`).
+		WithArgs("c1", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role", "content", "sources", "created_at"}).
+			AddRow(1, "user", "Where is my order?", "{}", now))
+	mock.ExpectExec(`DELETE FROM conversations\s+WHERE id::text = \$1 AND agent_id::text = \$2 AND owner = \$3`).
+		WithArgs("c1", "a1", "bob").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	route := "/agents/:id/conversations/:cid"
+	if w := serveTestRequest(http.MethodGet, route, "/agents/a1/conversations/c1", "", asTestUser("bob"), GetConversation(db)); w.Code != http.StatusNotFound {
+		t.Errorf("another user's conversation: status = %d, want 404", w.Code)
+	}
+	w := serveTestRequest(http.MethodGet, route, "/agents/a1/conversations/c1", "", asTestUser("alice"), GetConversation(db))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Where is my order?") {
+		t.Errorf("owner: status = %d: %s", w.Code, w.Body)
+	}
+	if w := serveTestRequest(http.MethodDelete, route, "/agents/a1/conversations/c1", "", asTestUser("bob"), DeleteConversation(db)); w.Code != http.StatusNotFound {
+		t.Errorf("deleting another user's conversation: status = %d, want 404", w.Code)
+	}
+}
+
+func TestChatStartsConversationForCaller(t *testing.T) {
+	db, mock := newTestMock(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM conversations\s+WHERE agent_id = \$1 AND owner = \$2`).
+		WithArgs("a1", "carol").
+		WillReturnRows(conversationRows())
+	mock.ExpectQuery(`INSERT INTO conversations`).
+		WithArgs("a1", "carol", "", []byte(`{}`)).
+		WillReturnRows(conversationRows().AddRow("c2", "a1", "carol", "", "", 0, []byte(`{}`), now, now))
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(userContextKey, "carol")
+	conv, err := latestConversation(c, db, &Agent{ID: "a1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.ID != "c2" || conv.Owner != "carol" {
+		t.Errorf("conversation = %+v, want a new one owned by the caller", conv)
+	}
+}
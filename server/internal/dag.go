@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentDagSchemaVersion is the version of the workflow graph schema written
+// by this server. Stored graphs with an older version are upgraded on read.
+const CurrentDagSchemaVersion = 1
+
+const (
+	NodeTypeAgent NodeType = "agent"
+	NodeTypeHuman NodeType = "human"
+)
+
+type NodeType string
+
+type Dag struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Nodes         []DagNode `json:"nodes"`
+	Edges         []DagEdge `json:"edges"`
+}
+
+type Position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type DagNode struct {
+	ID       string   `json:"id"`
+	Type     NodeType `json:"type"`
+	Position Position `json:"position"`
+	Inputs   []string `json:"inputs"`
+	Outputs  []string `json:"outputs"`
+
+	// Agent nodes
+	AgentID       string                 `json:"agentId,omitempty"`
+	Configuration map[string]interface{} `json:"configuration,omitempty"`
+
+	// Human nodes
+	UserID       string `json:"userId,omitempty"`
+	Task         string `json:"task,omitempty"`
+	Instructions string `json:"instructions,omitempty"`
+	Status       string `json:"status,omitempty"`
+}
+
+type DagEdge struct {
+	ID           string         `json:"id"`
+	Source       string         `json:"source"`
+	Target       string         `json:"target"`
+	SourceHandle *string        `json:"sourceHandle"`
+	TargetHandle *string        `json:"targetHandle"`
+	Type         string         `json:"type,omitempty"`
+	Label        string         `json:"label,omitempty"`
+	Style        map[string]any `json:"style,omitempty"`
+	Animated     bool           `json:"animated,omitempty"`
+}
+
+// dagUpgrades maps a schema version to the function that rewrites a raw
+// graph of that version into the next one.
+var dagUpgrades = map[int]func(raw map[string]interface{}) error{
+	0: upgradeDagV0,
+}
+
+// decodeDag parses a stored or submitted graph, upgrading it to the current
+// schema version. The returned flag reports whether an upgrade happened.
+func decodeDag(data []byte) (Dag, bool, error) {
+	var dag Dag
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return dag, false, fmt.Errorf("invalid DAG: %v", err)
+	}
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+
+	version := 0
+	if v, ok := raw["schemaVersion"].(float64); ok {
+		version = int(v)
+	}
+	if version > CurrentDagSchemaVersion {
+		return dag, false, fmt.Errorf("unsupported DAG schema version %d", version)
+	}
+
+	upgraded := false
+	for version < CurrentDagSchemaVersion {
+		upgrade, ok := dagUpgrades[version]
+		if !ok {
+			return dag, false, fmt.Errorf("no upgrade path from DAG schema version %d", version)
+		}
+		if err := upgrade(raw); err != nil {
+			return dag, false, fmt.Errorf("failed to upgrade DAG from version %d: %v", version, err)
+		}
+		version++
+		raw["schemaVersion"] = version
+		upgraded = true
+	}
+
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return dag, false, err
+	}
+	if err := json.Unmarshal(normalized, (*dagFields)(&dag)); err != nil {
+		return dag, false, fmt.Errorf("invalid DAG: %v", err)
+	}
+	if dag.Nodes == nil {
+		dag.Nodes = []DagNode{}
+	}
+	if dag.Edges == nil {
+		dag.Edges = []DagEdge{}
+	}
+	return dag, upgraded, nil
+}
+
+// upgradeDagV0 handles graphs saved before the schema was versioned. Those
+// were opaque React Flow blobs: nodes could carry their fields under "data"
+// and agent nodes could omit the type.
+func upgradeDagV0(raw map[string]interface{}) error {
+	nodes, _ := raw["nodes"].([]interface{})
+	for _, n := range nodes {
+		node, ok := n.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("node is not an object")
+		}
+
+		if data, ok := node["data"].(map[string]interface{}); ok {
+			for key, value := range data {
+				if _, exists := node[key]; !exists || key == "type" {
+					node[key] = value
+				}
+			}
+			delete(node, "data")
+		}
+
+		if t, _ := node["type"].(string); t == "" {
+			node["type"] = string(NodeTypeAgent)
+		}
+	}
+	if nodes == nil {
+		raw["nodes"] = []interface{}{}
+	}
+	if _, ok := raw["edges"].([]interface{}); !ok {
+		raw["edges"] = []interface{}{}
+	}
+	return nil
+}
+
+// dagFields has the fields of Dag without its UnmarshalJSON method.
+type dagFields Dag
+
+// UnmarshalJSON lets request bodies carry graphs of any supported schema
+// version; they are upgraded on the way in.
+func (d *Dag) UnmarshalJSON(data []byte) error {
+	dag, _, err := decodeDag(data)
+	if err != nil {
+		return err
+	}
+	*d = dag
+	return nil
+}
+
+// Validate checks that the graph is well formed: unique node IDs, known node
+// types, agent nodes bound to an agent, edges between existing nodes and no
+// cycles.
+func (d *Dag) Validate() error {
+	ids := make([]string, len(d.Nodes))
+	seen := make(map[string]bool, len(d.Nodes))
+	for i, node := range d.Nodes {
+		if node.ID == "" {
+			return fmt.Errorf("node %d has no id", i)
+		}
+		if seen[node.ID] {
+			return fmt.Errorf("duplicate node id %q", node.ID)
+		}
+		seen[node.ID] = true
+		ids[i] = node.ID
+
+		switch node.Type {
+		case NodeTypeAgent:
+			if node.AgentID == "" {
+				return fmt.Errorf("agent node %q has no agentId", node.ID)
+			}
+			if _, _, err := parseAgentRef(node.AgentID); err != nil {
+				return fmt.Errorf("agent node %q: %v", node.ID, err)
+			}
+			if _, err := parseNodeOutputSchema(node.Configuration); err != nil {
+				return fmt.Errorf("agent node %q: %v", node.ID, err)
+			}
+		case NodeTypeHuman:
+		default:
+			return fmt.Errorf("node %q has unknown type %q", node.ID, node.Type)
+		}
+	}
+
+	_, err := topologicalOrder(ids, d.taskEdges())
+	return err
+}
+
+func (d *Dag) taskEdges() []TaskEdge {
+	edges := make([]TaskEdge, len(d.Edges))
+	for i, edge := range d.Edges {
+		edges[i] = TaskEdge{
+			Source: edge.Source,
+			Target: edge.Target,
+		}
+	}
+	return edges
+}
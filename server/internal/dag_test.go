@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeDagUpgradesUnversionedGraphs(t *testing.T) {
+	legacy := `{
+		"nodes": [
+			{"id": "a", "data": {"agentId": "writer", "configuration": {"prompt": "hi"}}},
+			{"id": "b", "type": "default", "data": {"type": "human", "task": "Review"}}
+		],
+		"edges": [{"id": "e1", "source": "a", "target": "b"}]
+	}`
+	dag, upgraded, err := decodeDag([]byte(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upgraded || dag.SchemaVersion != CurrentDagSchemaVersion {
+		t.Errorf("upgraded = %v to version %d, want true to %d", upgraded, dag.SchemaVersion, CurrentDagSchemaVersion)
+	}
+	a, b := dag.Nodes[0], dag.Nodes[1]
+	if a.Type != NodeTypeAgent || a.AgentID != "writer" || a.Configuration["prompt"] != "hi" {
+		t.Errorf("agent node = %+v", a)
+	}
+	if b.Type != NodeTypeHuman || b.Task != "Review" {
+		t.Errorf("human node = %+v", b)
+	}
+	if err := dag.Validate(); err != nil {
+		t.Errorf("upgraded graph is invalid: %v", err)
+	}
+
+	// A current graph decodes as is.
+	current, _ := json.Marshal(dag)
+	if _, upgraded, err := decodeDag(current); err != nil || upgraded {
+		t.Errorf("current graph: upgraded = %v, err = %v", upgraded, err)
+	}
+}
+
+func TestDecodeDagRejectsNewerVersions(t *testing.T) {
+	_, _, err := decodeDag([]byte(`{"schemaVersion": 99, "nodes": []}`))
+	if err == nil || !strings.Contains(err.Error(), "unsupported DAG schema version 99") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestDagValidate(t *testing.T) {
+	agent := func(id string) DagNode { return DagNode{ID: id, Type: NodeTypeAgent, AgentID: "writer"} }
+	tests := []struct {
+		name string
+		dag  Dag
+		want string // "" for valid
+	}{
+		{"valid", Dag{Nodes: []DagNode{agent("a"), {ID: "b", Type: NodeTypeHuman}}, Edges: testEdges("a", "b")}, ""},
+		{"pinned agent", Dag{Nodes: []DagNode{{ID: "a", Type: NodeTypeAgent, AgentID: "writer@2"}}}, ""},
+		{"missing id", Dag{Nodes: []DagNode{{Type: NodeTypeAgent, AgentID: "writer"}}}, "node 0 has no id"},
+		{"duplicate id", Dag{Nodes: []DagNode{agent("a"), agent("a")}}, `duplicate node id "a"`},
+		{"no agent", Dag{Nodes: []DagNode{{ID: "a", Type: NodeTypeAgent}}}, `agent node "a" has no agentId`},
+		{"bad pin", Dag{Nodes: []DagNode{{ID: "a", Type: NodeTypeAgent, AgentID: "writer@x"}}}, "invalid agent reference"},
+		{"unknown type", Dag{Nodes: []DagNode{{ID: "a", Type: "robot"}}}, `unknown type "robot"`},
+		{"cycle", Dag{Nodes: []DagNode{agent("a"), agent("b")}, Edges: testEdges("a", "b", "b", "a")}, "cycle"},
+		{"dangling edge", Dag{Nodes: []DagNode{agent("a")}, Edges: testEdges("a", "ghost")}, "ghost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.dag.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,757 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v3"
+)
+
+// DefinitionBundle is the declarative form of a set of agents and workflows.
+// Resources are matched to existing rows by slug, so applying the same bundle
+// twice is a no-op.
+type DefinitionBundle struct {
+	Agents    []AgentDefinition    `yaml:"agents,omitempty" json:"agents"`
+	Workflows []WorkflowDefinition `yaml:"workflows,omitempty" json:"workflows"`
+}
+
+type AgentDefinition struct {
+	Slug        string                 `yaml:"slug,omitempty" json:"slug,omitempty"`
+	Name        string                 `yaml:"name" json:"name"`
+	Description string                 `yaml:"description" json:"description"`
+	Narrative   string                 `yaml:"narrative" json:"narrative"`
+	Type        AgentType              `yaml:"type" json:"type"`
+	Config      map[string]interface{} `yaml:"config" json:"config"`
+	Template    bool                   `yaml:"template,omitempty" json:"template,omitempty"`
+	Metadata    `yaml:",inline" json:",inline"`
+}
+
+type WorkflowDefinition struct {
+	Slug        string         `yaml:"slug,omitempty" json:"slug,omitempty"`
+	Name        string         `yaml:"name" json:"name"`
+	Description string         `yaml:"description" json:"description"`
+	Status      WorkflowStatus `yaml:"status" json:"status"`
+	Schedule    string         `yaml:"schedule" json:"schedule"`
+	Dag         DagDefinition  `yaml:"dag" json:"dag"`
+	Template    bool           `yaml:"template,omitempty" json:"template,omitempty"`
+	// Parameters are default run parameters.
+	Parameters map[string]string `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	Metadata   `yaml:",inline" json:",inline"`
+}
+
+type DagDefinition struct {
+	Nodes []NodeDefinition `yaml:"nodes" json:"nodes"`
+	Edges []EdgeDefinition `yaml:"edges" json:"edges"`
+}
+
+// NodeDefinition is a DAG node that refers to its agent by slug, so the
+// definition is portable between servers. A raw agentId is accepted too.
+// Either may pin a version with an @version suffix.
+type NodeDefinition struct {
+	ID            string                 `yaml:"id" json:"id"`
+	Type          NodeType               `yaml:"type" json:"type"`
+	Agent         string                 `yaml:"agent,omitempty" json:"agent,omitempty"`
+	AgentID       string                 `yaml:"agentId,omitempty" json:"agentId,omitempty"`
+	Position      Position               `yaml:"position" json:"position"`
+	Configuration map[string]interface{} `yaml:"configuration,omitempty" json:"configuration,omitempty"`
+	Inputs        []string               `yaml:"inputs,omitempty" json:"inputs,omitempty"`
+	Outputs       []string               `yaml:"outputs,omitempty" json:"outputs,omitempty"`
+	UserID        string                 `yaml:"userId,omitempty" json:"userId,omitempty"`
+	Task          string                 `yaml:"task,omitempty" json:"task,omitempty"`
+	Instructions  string                 `yaml:"instructions,omitempty" json:"instructions,omitempty"`
+}
+
+type EdgeDefinition struct {
+	ID     string `yaml:"id,omitempty" json:"id,omitempty"`
+	Source string `yaml:"source" json:"source"`
+	Target string `yaml:"target" json:"target"`
+}
+
+const (
+	PlanCreate    PlanAction = "create"
+	PlanUpdate    PlanAction = "update"
+	PlanUnchanged PlanAction = "unchanged"
+)
+
+type PlanAction string
+
+type PlanChange struct {
+	Kind   string     `json:"kind"`
+	Slug   string     `json:"slug"`
+	Name   string     `json:"name"`
+	Action PlanAction `json:"action"`
+	ID     string     `json:"id,omitempty"`
+	Fields []string   `json:"fields,omitempty"`
+}
+
+type ApplyResult struct {
+	Applied bool         `json:"applied"`
+	Changes []PlanChange `json:"changes"`
+}
+
+var (
+	slugPattern    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	nonSlugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+func slugify(name string) string {
+	return strings.Trim(nonSlugPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func validSlug(slug string) bool {
+	return slugPattern.MatchString(slug)
+}
+
+// DefinitionError is a definition that cannot be applied as written, as
+// opposed to a failure to store it.
+type DefinitionError struct {
+	Err error
+}
+
+func (e *DefinitionError) Error() string { return e.Err.Error() }
+func (e *DefinitionError) Unwrap() error { return e.Err }
+
+func parseDefinitionBundle(data []byte) (*DefinitionBundle, error) {
+	var bundle DefinitionBundle
+	if err := yaml.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid definitions: %v", err)
+	}
+	return &bundle, nil
+}
+
+// LoadDefinitions reads a bundle from path. A file is parsed as a bundle; a
+// directory is read as agents/*.yaml and workflows/*.yaml with one
+// definition per file.
+func LoadDefinitions(path string) (*DefinitionBundle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parseDefinitionBundle(data)
+	}
+
+	bundle := &DefinitionBundle{}
+	agentFiles, err := definitionFiles(filepath.Join(path, "agents"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range agentFiles {
+		var def AgentDefinition
+		if err := readDefinitionFile(file, &def); err != nil {
+			return nil, err
+		}
+		bundle.Agents = append(bundle.Agents, def)
+	}
+
+	workflowFiles, err := definitionFiles(filepath.Join(path, "workflows"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range workflowFiles {
+		var def WorkflowDefinition
+		if err := readDefinitionFile(file, &def); err != nil {
+			return nil, err
+		}
+		bundle.Workflows = append(bundle.Workflows, def)
+	}
+	return bundle, nil
+}
+
+func definitionFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml")) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func readDefinitionFile(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// WriteDefinitions writes a bundle to dir in the layout read by
+// LoadDefinitions.
+func WriteDefinitions(dir string, bundle *DefinitionBundle) error {
+	for _, def := range bundle.Agents {
+		if err := writeDefinitionFile(filepath.Join(dir, "agents", def.Slug+".yaml"), def); err != nil {
+			return err
+		}
+	}
+	for _, def := range bundle.Workflows {
+		if err := writeDefinitionFile(filepath.Join(dir, "workflows", def.Slug+".yaml"), def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDefinitionFile(path string, def interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(def)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// ApplyDefinitions creates or updates the resources in bundle. With dryRun
+// the changes are computed inside a transaction that is rolled back, so the
+// plan reflects exactly what an apply would do.
+func ApplyDefinitions(db *sql.DB, bundle *DefinitionBundle, author string, dryRun bool) (*ApplyResult, error) {
+	if err := bundle.normalize(); err != nil {
+		return nil, &DefinitionError{err}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result := &ApplyResult{Changes: []PlanChange{}}
+	agentIDs := make(map[string]string, len(bundle.Agents))
+
+	for _, def := range bundle.Agents {
+		change, err := applyAgentDefinition(tx, def, author)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %w", def.Slug, err)
+		}
+		agentIDs[def.Slug] = change.ID
+		result.Changes = append(result.Changes, *change)
+	}
+
+	for _, def := range bundle.Workflows {
+		change, err := applyWorkflowDefinition(tx, def, agentIDs, author)
+		if err != nil {
+			return nil, fmt.Errorf("workflow %s: %w", def.Slug, err)
+		}
+		result.Changes = append(result.Changes, *change)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	result.Applied = true
+	return result, nil
+}
+
+func (b *DefinitionBundle) normalize() error {
+	seen := make(map[string]bool)
+	for i := range b.Agents {
+		def := &b.Agents[i]
+		if def.Name == "" {
+			return fmt.Errorf("agent %d has no name", i)
+		}
+		if def.Slug == "" {
+			def.Slug = slugify(def.Name)
+		}
+		if !validSlug(def.Slug) {
+			return fmt.Errorf("agent %q has an invalid slug %q", def.Name, def.Slug)
+		}
+		if seen["agent/"+def.Slug] {
+			return fmt.Errorf("duplicate agent slug %q", def.Slug)
+		}
+		seen["agent/"+def.Slug] = true
+
+		if def.Type == "" {
+			def.Type = TypeLLM
+		}
+		if !validAgentType(def.Type) {
+			return fmt.Errorf("agent %q has an unknown type %q", def.Slug, def.Type)
+		}
+		config, errs := parseAgentConfig(def.Type, def.Config)
+		if errs != nil {
+			return fmt.Errorf("agent %q: %v", def.Slug, errs)
+		}
+		if _, err := parseNarrative(def.Narrative); err != nil {
+			return fmt.Errorf("agent %q: narrative: %v", def.Slug, err)
+		}
+		// Keep the canonical form so plans compare like with like.
+		def.Config = config.toMap()
+		if err := def.Metadata.normalize(); err != nil {
+			return fmt.Errorf("agent %q: %v", def.Slug, err)
+		}
+	}
+
+	for i := range b.Workflows {
+		def := &b.Workflows[i]
+		if def.Name == "" {
+			return fmt.Errorf("workflow %d has no name", i)
+		}
+		if def.Slug == "" {
+			def.Slug = slugify(def.Name)
+		}
+		if !validSlug(def.Slug) {
+			return fmt.Errorf("workflow %q has an invalid slug %q", def.Name, def.Slug)
+		}
+		if seen["workflow/"+def.Slug] {
+			return fmt.Errorf("duplicate workflow slug %q", def.Slug)
+		}
+		seen["workflow/"+def.Slug] = true
+
+		if def.Status == "" {
+			def.Status = StatusInactive
+		}
+		if err := def.Metadata.normalize(); err != nil {
+			return fmt.Errorf("workflow %q: %v", def.Slug, err)
+		}
+	}
+	return nil
+}
+
+func applyAgentDefinition(tx *sql.Tx, def AgentDefinition, author string) (*PlanChange, error) {
+	change := &PlanChange{Kind: "agent", Slug: def.Slug, Name: def.Name}
+
+	configJSON, err := json.Marshal(def.Config)
+	if err != nil {
+		return nil, &DefinitionError{fmt.Errorf("invalid config: %v", err)}
+	}
+
+	var version int
+	existing, err := scanAgent(tx.QueryRow(`SELECT `+agentColumns+` FROM agents WHERE slug = $1 FOR UPDATE`, def.Slug))
+	if err == sql.ErrNoRows {
+		change.Action = PlanCreate
+		err = tx.QueryRow(`
+			INSERT INTO agents (name, slug, type, description, narrative, config, is_template, tags, folder, owner)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id, version`,
+			def.Name, def.Slug, def.Type, def.Description, def.Narrative, configJSON, def.Template,
+			pq.Array(def.Tags), def.Folder, def.Owner,
+		).Scan(&change.ID, &version)
+		if err != nil {
+			return nil, err
+		}
+		return change, insertAgentVersion(tx, def.snapshot(change.ID, version), configJSON, author)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	change.ID = existing.ID
+	change.Fields = changedFields(agentDefinitionOf(existing), def)
+	if existing.ArchivedAt != nil {
+		// Declaring an archived agent again restores it.
+		change.Fields = append(change.Fields, "archived")
+	}
+	if len(change.Fields) == 0 {
+		change.Action = PlanUnchanged
+		return change, nil
+	}
+
+	change.Action = PlanUpdate
+	err = tx.QueryRow(`
+		UPDATE agents
+		SET name = $1, type = $2, description = $3, narrative = $4, config = $5, is_template = $7,
+			tags = $8, folder = $9, owner = COALESCE(NULLIF($10, ''), owner), version = version + 1, archived_at = NULL
+		WHERE id = $6
+		RETURNING version`,
+		def.Name, def.Type, def.Description, def.Narrative, configJSON, existing.ID, def.Template,
+		pq.Array(def.Tags), def.Folder, def.Owner,
+	).Scan(&version)
+	if err != nil {
+		return nil, err
+	}
+	return change, insertAgentVersion(tx, def.snapshot(existing.ID, version), configJSON, author)
+}
+
+// snapshot is the agent the definition describes, for its version history.
+func (def AgentDefinition) snapshot(id string, version int) *Agent {
+	return &Agent{ID: id, Version: version, Name: def.Name, Type: def.Type, Description: def.Description, Narrative: def.Narrative}
+}
+
+func applyWorkflowDefinition(tx *sql.Tx, def WorkflowDefinition, agentIDs map[string]string, author string) (*PlanChange, error) {
+	change := &PlanChange{Kind: "workflow", Slug: def.Slug, Name: def.Name}
+
+	dag, err := resolveDagDefinition(tx, def.Dag, agentIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := dag.Validate(); err != nil {
+		return nil, &DefinitionError{fmt.Errorf("invalid DAG: %v", err)}
+	}
+	dagJSON, err := json.Marshal(dag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode DAG: %v", err)
+	}
+
+	workflow := Workflow{
+		Name:        def.Name,
+		Slug:        def.Slug,
+		Description: def.Description,
+		Status:      def.Status,
+		Dag:         dag,
+		Schedule:    def.Schedule,
+		IsTemplate:  def.Template,
+		Parameters:  def.Parameters,
+		Metadata:    def.Metadata,
+	}
+	if workflow.Parameters == nil {
+		workflow.Parameters = map[string]string{}
+	}
+	paramsJSON, err := json.Marshal(workflow.Parameters)
+	if err != nil {
+		return nil, &DefinitionError{fmt.Errorf("invalid parameters: %v", err)}
+	}
+
+	existing, err := scanWorkflow(tx.QueryRow(`SELECT `+workflowColumns+` FROM workflows WHERE slug = $1 FOR UPDATE`, def.Slug))
+	if err == sql.ErrNoRows {
+		change.Action = PlanCreate
+		err = tx.QueryRow(`
+			INSERT INTO workflows (name, slug, description, status, dag, schedule, is_template, parameters, tags, folder, owner)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id, version`,
+			workflow.Name, workflow.Slug, workflow.Description, workflow.Status, dagJSON, workflow.Schedule, workflow.IsTemplate, paramsJSON,
+			pq.Array(workflow.Tags), workflow.Folder, workflow.Owner,
+		).Scan(&workflow.ID, &workflow.Version)
+		if err != nil {
+			return nil, err
+		}
+		change.ID = workflow.ID
+		return change, insertWorkflowVersion(tx, &workflow, dagJSON, author)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	change.ID = existing.ID
+	change.Fields = changedFields(workflowComparable(existing), workflowComparable(&workflow))
+	if existing.ArchivedAt != nil {
+		change.Fields = append(change.Fields, "archived")
+	}
+	if len(change.Fields) == 0 {
+		change.Action = PlanUnchanged
+		return change, nil
+	}
+
+	change.Action = PlanUpdate
+	err = tx.QueryRow(`
+		UPDATE workflows
+		SET name = $1, description = $2, status = $3, dag = $4, schedule = $5, is_template = $7, parameters = $8,
+			tags = $9, folder = $10, owner = COALESCE(NULLIF($11, ''), owner), version = version + 1, archived_at = NULL
+		WHERE id = $6
+		RETURNING id, version`,
+		workflow.Name, workflow.Description, workflow.Status, dagJSON, workflow.Schedule, existing.ID, workflow.IsTemplate, paramsJSON,
+		pq.Array(workflow.Tags), workflow.Folder, workflow.Owner,
+	).Scan(&workflow.ID, &workflow.Version)
+	if err != nil {
+		return nil, err
+	}
+	return change, insertWorkflowVersion(tx, &workflow, dagJSON, author)
+}
+
+// resolveDagDefinition turns agent slugs into IDs, preferring agents from the
+// same bundle over ones already stored. With a nil tx only the bundle's own
+// agents can be referenced.
+func resolveDagDefinition(tx *sql.Tx, def DagDefinition, agentIDs map[string]string) (Dag, error) {
+	dag := Dag{
+		SchemaVersion: CurrentDagSchemaVersion,
+		Nodes:         make([]DagNode, len(def.Nodes)),
+		Edges:         make([]DagEdge, len(def.Edges)),
+	}
+
+	for i, n := range def.Nodes {
+		node := DagNode{
+			ID:            n.ID,
+			Type:          n.Type,
+			Position:      n.Position,
+			Inputs:        n.Inputs,
+			Outputs:       n.Outputs,
+			AgentID:       n.AgentID,
+			Configuration: n.Configuration,
+			UserID:        n.UserID,
+			Task:          n.Task,
+			Instructions:  n.Instructions,
+		}
+		if node.Type == "" {
+			node.Type = NodeTypeAgent
+		}
+		if node.Inputs == nil {
+			node.Inputs = []string{}
+		}
+		if node.Outputs == nil {
+			node.Outputs = []string{}
+		}
+
+		if n.Agent != "" {
+			// "slug@3" pins version 3 of the agent.
+			slug, version, err := parseAgentRef(n.Agent)
+			if err != nil {
+				return dag, &DefinitionError{fmt.Errorf("node %q: %v", n.ID, err)}
+			}
+			id, ok := agentIDs[slug]
+			if !ok && tx == nil {
+				return dag, &DefinitionError{fmt.Errorf("node %q references unknown agent %q", n.ID, slug)}
+			}
+			if !ok {
+				err := tx.QueryRow(`SELECT id FROM agents WHERE slug = $1 AND archived_at IS NULL`, slug).Scan(&id)
+				if err == sql.ErrNoRows {
+					return dag, &DefinitionError{fmt.Errorf("node %q references unknown agent %q", n.ID, slug)}
+				}
+				if err != nil {
+					return dag, err
+				}
+			}
+			node.AgentID = agentRef(id, version)
+		}
+		dag.Nodes[i] = node
+	}
+
+	for i, e := range def.Edges {
+		id := e.ID
+		if id == "" {
+			id = e.Source + "-" + e.Target
+		}
+		dag.Edges[i] = DagEdge{ID: id, Source: e.Source, Target: e.Target}
+	}
+	return dag, nil
+}
+
+func agentDefinitionOf(agent *Agent) AgentDefinition {
+	return AgentDefinition{
+		Slug:        agent.Slug,
+		Name:        agent.Name,
+		Description: agent.Description,
+		Narrative:   agent.Narrative,
+		Type:        agent.Type,
+		Config:      agent.Config.toMap(),
+		Template:    agent.IsTemplate,
+		Metadata:    agent.Metadata,
+	}
+}
+
+// workflowComparable keeps the fields an apply can change. Edge styling and
+// other editor-only state is ignored so a round trip through YAML does not
+// show up as a change.
+func workflowComparable(w *Workflow) interface{} {
+	type node struct {
+		ID            string                 `json:"id"`
+		Type          NodeType               `json:"type"`
+		AgentID       string                 `json:"agentId"`
+		Position      Position               `json:"position"`
+		Configuration map[string]interface{} `json:"configuration"`
+		UserID        string                 `json:"userId"`
+		Task          string                 `json:"task"`
+		Instructions  string                 `json:"instructions"`
+	}
+	type edge struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+	}
+
+	nodes := make([]node, len(w.Dag.Nodes))
+	for i, n := range w.Dag.Nodes {
+		nodes[i] = node{n.ID, n.Type, n.AgentID, n.Position, n.Configuration, n.UserID, n.Task, n.Instructions}
+	}
+	edges := make([]edge, len(w.Dag.Edges))
+	for i, e := range w.Dag.Edges {
+		edges[i] = edge{e.Source, e.Target}
+	}
+
+	// An empty map and no parameters at all are the same thing.
+	var params map[string]string
+	if len(w.Parameters) > 0 {
+		params = w.Parameters
+	}
+
+	return struct {
+		Name        string            `json:"name"`
+		Description string            `json:"description"`
+		Status      WorkflowStatus    `json:"status"`
+		Schedule    string            `json:"schedule"`
+		Template    bool              `json:"template"`
+		Parameters  map[string]string `json:"parameters"`
+		Metadata
+		Nodes []node `json:"nodes"`
+		Edges []edge `json:"edges"`
+	}{w.Name, w.Description, w.Status, w.Schedule, w.IsTemplate, params, w.Metadata, nodes, edges}
+}
+
+// ExportDefinitions reads every agent and workflow back into a bundle.
+func ExportDefinitions(db *sql.DB) (*DefinitionBundle, error) {
+	bundle := &DefinitionBundle{}
+	agentSlugs := make(map[string]string)
+
+	rows, err := db.Query(`SELECT ` + agentColumns + ` FROM agents WHERE archived_at IS NULL ORDER BY slug`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch agents: %v", err)
+	}
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		agentSlugs[agent.ID] = agent.Slug
+		bundle.Agents = append(bundle.Agents, agentDefinitionOf(agent))
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT ` + workflowColumns + ` FROM workflows WHERE archived_at IS NULL ORDER BY slug`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch workflows: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		w, err := scanWorkflow(rows)
+		if err != nil {
+			return nil, err
+		}
+		bundle.Workflows = append(bundle.Workflows, workflowDefinitionOf(w, agentSlugs))
+	}
+	return bundle, rows.Err()
+}
+
+func workflowDefinitionOf(w *Workflow, agentSlugs map[string]string) WorkflowDefinition {
+	def := WorkflowDefinition{
+		Slug:        w.Slug,
+		Name:        w.Name,
+		Description: w.Description,
+		Status:      w.Status,
+		Schedule:    w.Schedule,
+		Template:    w.IsTemplate,
+		Metadata:    w.Metadata,
+		Dag: DagDefinition{
+			Nodes: make([]NodeDefinition, len(w.Dag.Nodes)),
+			Edges: make([]EdgeDefinition, len(w.Dag.Edges)),
+		},
+	}
+
+	for i, n := range w.Dag.Nodes {
+		node := NodeDefinition{
+			ID:            n.ID,
+			Type:          n.Type,
+			Position:      n.Position,
+			Configuration: n.Configuration,
+			Inputs:        n.Inputs,
+			Outputs:       n.Outputs,
+			UserID:        n.UserID,
+			Task:          n.Task,
+			Instructions:  n.Instructions,
+		}
+		id, version, _ := parseAgentRef(n.AgentID)
+		if slug, ok := agentSlugs[id]; ok {
+			node.Agent = agentRef(slug, version)
+		} else {
+			node.AgentID = n.AgentID
+		}
+		def.Dag.Nodes[i] = node
+	}
+	for i, e := range w.Dag.Edges {
+		def.Dag.Edges[i] = EdgeDefinition{ID: e.ID, Source: e.Source, Target: e.Target}
+	}
+	if len(w.Parameters) > 0 {
+		def.Parameters = w.Parameters
+	}
+	return def
+}
+
+// String renders the result as one line per resource, e.g.
+// "+ agent it-support-agent".
+func (r *ApplyResult) String() string {
+	symbols := map[PlanAction]string{PlanCreate: "+", PlanUpdate: "~", PlanUnchanged: "="}
+
+	var b strings.Builder
+	for _, change := range r.Changes {
+		fmt.Fprintf(&b, "%s %s %s", symbols[change.Action], change.Kind, change.Slug)
+		if len(change.Fields) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(change.Fields, ", "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func PlanDefinitionsHandler(db *sql.DB) gin.HandlerFunc {
+	return applyDefinitionsHandler(db, true)
+}
+
+func ApplyDefinitionsHandler(db *sql.DB) gin.HandlerFunc {
+	return applyDefinitionsHandler(db, false)
+}
+
+func applyDefinitionsHandler(db *sql.DB, dryRun bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+
+		bundle, err := parseDefinitionBundle(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := ApplyDefinitions(db, bundle, currentUser(c), dryRun)
+		var invalid *DefinitionError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to apply definitions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply definitions"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func ExportDefinitionsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bundle, err := ExportDefinitions(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export definitions"})
+			return
+		}
+
+		if c.Query("format") == "json" {
+			c.JSON(http.StatusOK, bundle)
+			return
+		}
+		c.YAML(http.StatusOK, bundle)
+	}
+}
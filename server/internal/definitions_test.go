@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+const testBundleYAML = `
+agents:
+  - name: Ticket Triage
+    narrative: Sort incoming tickets.
+    tags: [Support]
+workflows:
+  - name: Nightly Triage
+    dag:
+      nodes:
+        - id: triage
+          agent: ticket-triage@2
+`
+
+func newTestMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+func parseTestBundle(t *testing.T, data string) *DefinitionBundle {
+	t.Helper()
+	bundle, err := parseDefinitionBundle([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func agentRows() *sqlmock.Rows {
+	return sqlmock.NewRows(strings.Split(agentColumns, ", "))
+}
+
+func workflowRows() *sqlmock.Rows {
+	return sqlmock.NewRows(strings.Split(workflowColumns, ", "))
+}
+
+func TestApplyDefinitionsRejectsInvalidBundles(t *testing.T) {
+	tests := map[string]string{
+		"missing name":   "agents:\n  - narrative: x\n",
+		"invalid slug":   "agents:\n  - name: A\n    slug: Not_A_Slug\n",
+		"duplicate slug": "agents:\n  - name: A\n  - name: a\n",
+		"unknown type":   "agents:\n  - name: A\n    type: robot\n",
+		"invalid tag":    "workflows:\n  - name: W\n    tags: ['has space']\n",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Validation fails before the database is touched.
+			_, err := ApplyDefinitions(nil, parseTestBundle(t, data), "alice", true)
+			var invalid *DefinitionError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("err = %v, want a DefinitionError", err)
+			}
+		})
+	}
+}
+
+func TestPlanDefinitionsCreatesNothing(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM agents WHERE slug = \$1 FOR UPDATE`).
+		WithArgs("ticket-triage").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO agents`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow("a1", 1))
+	mock.ExpectExec(`INSERT INTO agent_versions`).
+		WithArgs("a1", 1, "Ticket Triage", TypeLLM, "", "Sort incoming tickets.", sqlmock.AnyArg(), "alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT .* FROM workflows WHERE slug = \$1 FOR UPDATE`).
+		WithArgs("nightly-triage").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO workflows`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow("w1", 1))
+	mock.ExpectExec(`INSERT INTO workflow_versions`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	result, err := ApplyDefinitions(db, parseTestBundle(t, testBundleYAML), "alice", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Applied {
+		t.Error("a plan reported itself as applied")
+	}
+	if got, want := result.String(), "+ agent ticket-triage\n+ workflow nightly-triage\n"; got != want {
+		t.Errorf("plan = %q, want %q", got, want)
+	}
+}
+
+func TestApplyDefinitionsLeavesUnchangedResources(t *testing.T) {
+	bundle := parseTestBundle(t, testBundleYAML)
+	if err := bundle.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	configJSON, _ := json.Marshal(bundle.Agents[0].Config)
+	dagJSON, _ := json.Marshal(Dag{
+		SchemaVersion: CurrentDagSchemaVersion,
+		Nodes:         []DagNode{{ID: "triage", Type: NodeTypeAgent, AgentID: "a1@2"}},
+	})
+	now := time.Now()
+
+	db, mock := newTestMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM agents WHERE slug = \$1 FOR UPDATE`).
+		WillReturnRows(agentRows().AddRow("a1", "Ticket Triage", "ticket-triage", TypeLLM, "", "Sort incoming tickets.", configJSON, 2, false, "{support}", "", "", nil))
+	mock.ExpectQuery(`SELECT .* FROM workflows WHERE slug = \$1 FOR UPDATE`).
+		WillReturnRows(workflowRows().AddRow("w1", "Nightly Triage", "nightly-triage", "", StatusInactive, dagJSON, "", 4, now, now, false, []byte(`{}`), "{}", "", "", nil))
+	mock.ExpectCommit()
+
+	result, err := ApplyDefinitions(db, bundle, "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Applied {
+		t.Error("apply did not report itself as applied")
+	}
+	if got, want := result.String(), "= agent ticket-triage\n= workflow nightly-triage\n"; got != want {
+		t.Errorf("result = %q, want %q", got, want)
+	}
+}
+
+func TestApplyDefinitionsRestoresArchivedAgents(t *testing.T) {
+	bundle := parseTestBundle(t, "agents:\n  - name: Ticket Triage\n    narrative: Sort incoming tickets.\n")
+	if err := bundle.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	configJSON, _ := json.Marshal(bundle.Agents[0].Config)
+
+	db, mock := newTestMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM agents WHERE slug = \$1 FOR UPDATE`).
+		WillReturnRows(agentRows().AddRow("a1", "Ticket Triage", "ticket-triage", TypeLLM, "", "Sort tickets.", configJSON, 2, false, "{}", "", "", time.Now()))
+	mock.ExpectQuery(`UPDATE agents`).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectExec(`INSERT INTO agent_versions`).
+		WithArgs("a1", 3, "Ticket Triage", TypeLLM, "", "Sort incoming tickets.", sqlmock.AnyArg(), "alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := ApplyDefinitions(db, bundle, "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := result.String(), "~ agent ticket-triage (narrative, archived)\n"; got != want {
+		t.Errorf("result = %q, want %q", got, want)
+	}
+}
+
+func TestApplyDefinitionsRejectsUnknownAgents(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM agents WHERE slug = \$1`).
+		WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	bundle := parseTestBundle(t, "workflows:\n  - name: W\n    dag:\n      nodes:\n        - id: a\n          agent: missing\n")
+	_, err := ApplyDefinitions(db, bundle, "alice", false)
+	var invalid *DefinitionError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want a DefinitionError", err)
+	}
+}
+
+func TestExportDefinitions(t *testing.T) {
+	dagJSON, _ := json.Marshal(Dag{
+		Nodes: []DagNode{
+			{ID: "triage", Type: NodeTypeAgent, AgentID: "a1@2"},
+			{ID: "other", Type: NodeTypeAgent, AgentID: "gone"},
+		},
+		Edges: testEdges("triage", "other"),
+	})
+	now := time.Now()
+
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT .* FROM agents WHERE archived_at IS NULL ORDER BY slug`).
+		WillReturnRows(agentRows().AddRow("a1", "Ticket Triage", "ticket-triage", TypeLLM, "", "Sort.", []byte(`{}`), 2, false, "{support}", "ops", "", nil))
+	mock.ExpectQuery(`SELECT .* FROM workflows WHERE archived_at IS NULL ORDER BY slug`).
+		WillReturnRows(workflowRows().AddRow("w1", "Nightly", "nightly", "", StatusActive, dagJSON, "@daily", 1, now, now, false, []byte(`{"region":"eu"}`), "{}", "", "", nil))
+
+	bundle, err := ExportDefinitions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Agents) != 1 || bundle.Agents[0].Slug != "ticket-triage" || bundle.Agents[0].Folder != "ops" {
+		t.Errorf("agents = %+v", bundle.Agents)
+	}
+	if len(bundle.Workflows) != 1 {
+		t.Fatalf("workflows = %+v", bundle.Workflows)
+	}
+	w := bundle.Workflows[0]
+	if node := w.Dag.Nodes[0]; node.Agent != "ticket-triage@2" || node.AgentID != "" {
+		t.Errorf("node = %+v, want the agent referenced by slug", node)
+	}
+	if node := w.Dag.Nodes[1]; node.Agent != "" || node.AgentID != "gone" {
+		t.Errorf("node = %+v, want the unknown agent kept by ID", node)
+	}
+	if w.Parameters["region"] != "eu" {
+		t.Errorf("parameters = %v", w.Parameters)
+	}
+}
+
+func TestPlanDefinitionsHandlerRejectsInvalidBundles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/definitions/plan", PlanDefinitionsHandler(nil))
+
+	for body, want := range map[string]int{
+		"agents: [":                   http.StatusBadRequest,
+		"agents:\n  - narrative: x\n": http.StatusUnprocessableEntity,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/definitions/plan", strings.NewReader(body)))
+		if w.Code != want {
+			t.Errorf("POST %q = %d, want %d", body, w.Code, want)
+		}
+	}
+}
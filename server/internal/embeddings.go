@@ -2,39 +2,33 @@ package internal
 
 import (
 	"context"
+	"hash/fnv"
 	"math"
 	"strings"
+	"unicode"
 )
 
+// embeddingDim is the size of every local embedding. Vectors from separate
+// calls share one space, so stored documents and queries compare directly.
+const embeddingDim = 512
+
 type LocalEmbedder struct{}
 
 func NewLocalEmbedder() (*LocalEmbedder, error) {
 	return &LocalEmbedder{}, nil
 }
 
-// Simple term frequency based embeddings
+// CreateEmbeddings hashes each word into one of embeddingDim buckets and
+// returns unit-length term frequency vectors.
 func (e *LocalEmbedder) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
 	embeddings := make([][]float32, len(texts))
-	vocab := make(map[string]int)
-
-	// Build vocabulary
-	for _, text := range texts {
-		words := strings.Fields(strings.ToLower(text))
-		for _, word := range words {
-			vocab[word] = len(vocab)
-		}
-	}
-
-	// Create term frequency vectors
 	for i, text := range texts {
-		vector := make([]float32, len(vocab))
-		words := strings.Fields(strings.ToLower(text))
-		for _, word := range words {
-			if idx, ok := vocab[word]; ok {
-				vector[idx]++
-			}
+		vector := make([]float32, embeddingDim)
+		for _, word := range embeddingTokens(text) {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vector[h.Sum32()%embeddingDim]++
 		}
-		// Normalize vector
 		magnitude := float32(math.Sqrt(float64(dot(vector, vector))))
 		if magnitude > 0 {
 			for j := range vector {
@@ -43,14 +37,21 @@ func (e *LocalEmbedder) CreateEmbeddings(ctx context.Context, texts []string) ([
 		}
 		embeddings[i] = vector
 	}
-
 	return embeddings, nil
 }
 
+func embeddingTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
 func dot(a, b []float32) float32 {
 	var sum float32
 	for i := range a {
-		sum += a[i] * b[i]
+		if i < len(b) {
+			sum += a[i] * b[i]
+		}
 	}
 	return sum
 }
@@ -0,0 +1,715 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Assertion types a test case can check a reply with.
+const (
+	AssertContains   = "contains"    // Value appears in the reply
+	AssertRegex      = "regex"       // Pattern matches the reply
+	AssertJSONSchema = "json_schema" // the reply is JSON satisfying Schema
+	AssertSimilarity = "similarity"  // the reply's embedding is close to Expected's
+	AssertJudge      = "judge"       // the model grades the reply against Rubric
+)
+
+const (
+	defaultSimilarityThreshold = 0.8
+	defaultJudgeThreshold      = 0.7
+)
+
+// TestCase is a message to send an agent and what its reply must satisfy.
+type TestCase struct {
+	ID         string      `json:"id"`
+	AgentID    string      `json:"agent_id"`
+	Name       string      `json:"name"`
+	Input      string      `json:"input"`
+	Context    TestContext `json:"context"`
+	Assertions []Assertion `json:"assertions"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// TestContext mocks what the input is sent with.
+type TestContext struct {
+	// History is the conversation before the input.
+	History []Message `json:"history,omitempty"`
+	Summary string    `json:"summary,omitempty"`
+	// Documents replace retrieval for agents with use_rag set.
+	Documents []Document `json:"documents,omitempty"`
+	// Variables fill the agent's narrative.
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	// Memories stand in for the facts recalled for agents with use_memory set.
+	Memories []string `json:"memories,omitempty"`
+}
+
+type Assertion struct {
+	Type       string                 `json:"type"`
+	Value      string                 `json:"value,omitempty"`
+	IgnoreCase bool                   `json:"ignore_case,omitempty"`
+	Pattern    string                 `json:"pattern,omitempty"`
+	Schema     map[string]interface{} `json:"schema,omitempty"`
+	Expected   string                 `json:"expected,omitempty"`
+	Rubric     string                 `json:"rubric,omitempty"`
+	// Threshold is the least similarity or judge score, from 0 to 1, that
+	// passes.
+	Threshold *float64 `json:"threshold,omitempty"`
+}
+
+// EvalReport is the scored result of running an agent's test cases.
+type EvalReport struct {
+	ID           string `json:"id"`
+	AgentID      string `json:"agent_id"`
+	AgentVersion int    `json:"agent_version"`
+	// Proposed reports evaluated an unsaved narrative or config on top of
+	// AgentVersion.
+	Proposed   bool            `json:"proposed"`
+	Narrative  string          `json:"narrative,omitempty"`
+	Config     *AgentConfig    `json:"config,omitempty"`
+	Score      float64         `json:"score"`
+	Passed     int             `json:"passed"`
+	Total      int             `json:"total"`
+	Results    []CaseResult    `json:"results,omitempty"`
+	Comparison *EvalComparison `json:"comparison,omitempty"`
+	Author     string          `json:"author"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type CaseResult struct {
+	TestID     string            `json:"test_id"`
+	Name       string            `json:"name"`
+	Response   string            `json:"response"`
+	Error      string            `json:"error,omitempty"`
+	Passed     bool              `json:"passed"`
+	Score      float64           `json:"score"`
+	Assertions []AssertionResult `json:"assertions"`
+}
+
+type AssertionResult struct {
+	Type   string  `json:"type"`
+	Passed bool    `json:"passed"`
+	Score  float64 `json:"score"`
+	Detail string  `json:"detail,omitempty"`
+}
+
+// EvalComparison compares a report with an earlier one, case by case.
+type EvalComparison struct {
+	BaselineID      string       `json:"baseline_id"`
+	BaselineVersion int          `json:"baseline_version"`
+	ScoreDelta      float64      `json:"score_delta"`
+	Regressions     []CaseChange `json:"regressions"` // passed in the baseline, fail now
+	Fixed           []CaseChange `json:"fixed"`       // failed in the baseline, pass now
+}
+
+type CaseChange struct {
+	TestID string  `json:"test_id"`
+	Name   string  `json:"name"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+}
+
+// testCaseInput is the body of CreateTestCase and UpdateTestCase.
+type testCaseInput struct {
+	Name       string      `json:"name"`
+	Input      string      `json:"input"`
+	Context    TestContext `json:"context"`
+	Assertions []Assertion `json:"assertions"`
+}
+
+func (in *testCaseInput) check() FieldErrors {
+	errs := FieldErrors{}
+	if strings.TrimSpace(in.Input) == "" {
+		errs["input"] = "is required"
+	}
+	if in.Name == "" {
+		in.Name = conversationTitle(in.Input)
+	}
+	for i, msg := range in.Context.History {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			errs[fmt.Sprintf("context.history[%d].role", i)] = "must be user or assistant"
+		}
+	}
+	for i, doc := range in.Context.Documents {
+		if doc.ID == "" || doc.Content == "" {
+			errs[fmt.Sprintf("context.documents[%d]", i)] = "id and content are required"
+		}
+	}
+	if len(in.Assertions) == 0 {
+		errs["assertions"] = "is required"
+	}
+	for i, a := range in.Assertions {
+		a.check(fmt.Sprintf("assertions[%d]", i), errs)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check reports problems with the assertion under path.
+func (a *Assertion) check(path string, errs FieldErrors) {
+	required := func(field, value string) {
+		if strings.TrimSpace(value) == "" {
+			errs[path+"."+field] = "is required"
+		}
+	}
+	switch a.Type {
+	case AssertContains:
+		required("value", a.Value)
+	case AssertRegex:
+		required("pattern", a.Pattern)
+		if _, err := regexp.Compile(a.Pattern); err != nil {
+			errs[path+".pattern"] = err.Error()
+		}
+	case AssertJSONSchema:
+		if a.Schema == nil {
+			errs[path+".schema"] = "is required"
+		}
+	case AssertSimilarity:
+		required("expected", a.Expected)
+	case AssertJudge:
+		required("rubric", a.Rubric)
+	default:
+		errs[path+".type"] = "must be one of contains, regex, json_schema, similarity, judge"
+	}
+	if a.Threshold != nil && (*a.Threshold < 0 || *a.Threshold > 1) {
+		errs[path+".threshold"] = "must be between 0 and 1"
+	}
+}
+
+func (a *Assertion) threshold(fallback float64) float64 {
+	if a.Threshold != nil {
+		return *a.Threshold
+	}
+	return fallback
+}
+
+// evaluate checks reply against the assertion. Only similarity and judge
+// assertions score between 0 and 1; the rest pass or fail.
+func (a *Assertion) evaluate(ctx context.Context, llmClient LLMClient, input, reply string) AssertionResult {
+	result := AssertionResult{Type: a.Type}
+	pass := func(ok bool, detail string) AssertionResult {
+		result.Passed = ok
+		if ok {
+			result.Score = 1
+		} else {
+			result.Detail = detail
+		}
+		return result
+	}
+
+	switch a.Type {
+	case AssertContains:
+		if a.IgnoreCase {
+			return pass(strings.Contains(strings.ToLower(reply), strings.ToLower(a.Value)), fmt.Sprintf("reply does not contain %q", a.Value))
+		}
+		return pass(strings.Contains(reply, a.Value), fmt.Sprintf("reply does not contain %q", a.Value))
+
+	case AssertRegex:
+		re, err := regexp.Compile(a.Pattern)
+		if err != nil {
+			return pass(false, err.Error())
+		}
+		return pass(re.MatchString(reply), fmt.Sprintf("reply does not match %s", a.Pattern))
+
+	case AssertJSONSchema:
+		var value interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(reply)), &value); err != nil {
+			if err := json.Unmarshal([]byte(jsonValueIn(reply)), &value); err != nil {
+				return pass(false, "reply is not JSON")
+			}
+		}
+		if err := validateJSONSchema(a.Schema, value); err != nil {
+			return pass(false, err.Error())
+		}
+		return pass(true, "")
+
+	case AssertSimilarity:
+		embeddings, err := llmClient.CreateEmbeddings(ctx, []string{reply, a.Expected})
+		if err != nil {
+			return pass(false, fmt.Sprintf("failed to embed: %v", err))
+		}
+		// Embeddings are unit length, so the dot product is the cosine.
+		similarity := math.Max(0, float64(dot(embeddings[0], embeddings[1])))
+		result.Score = similarity
+		result.Passed = similarity >= a.threshold(defaultSimilarityThreshold)
+		result.Detail = fmt.Sprintf("similarity %.2f", similarity)
+		return result
+
+	case AssertJudge:
+		score, reason, err := judgeReply(llmClient, input, reply, a.Rubric)
+		if err != nil {
+			return pass(false, fmt.Sprintf("judge failed: %v", err))
+		}
+		result.Score = score
+		result.Passed = score >= a.threshold(defaultJudgeThreshold)
+		result.Detail = reason
+		return result
+	}
+	return pass(false, fmt.Sprintf("unknown assertion type %q", a.Type))
+}
+
+var gradeSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []interface{}{"score", "reason"},
+	"properties": map[string]interface{}{
+		"score":  map[string]interface{}{"type": "number", "minimum": 0, "maximum": 1},
+		"reason": map[string]interface{}{"type": "string"},
+	},
+}
+
+// judgeReply asks the model to grade reply against rubric, from 0 to 1.
+func judgeReply(llmClient LLMClient, input, reply, rubric string) (float64, string, error) {
+	prompt := []Message{
+		{Role: "system", Content: `You grade an assistant's reply to a user against a rubric, with a score from 0 to 1 and a one sentence reason.`},
+		{Role: "user", Content: fmt.Sprintf("Rubric:\n%s\n\nUser message:\n%s\n\nReply:\n%s", rubric, input, reply)},
+	}
+	params := llmClient.Defaults()
+	response, _, err := llmClient.CompleteJSON(prompt, gradeSchema, params.Model, 0, &params.MaxTokens)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var grade struct {
+		Score  float64 `json:"score"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.Unmarshal(response, &grade); err != nil {
+		return 0, "", fmt.Errorf("unreadable grade %s", response)
+	}
+	return grade.Score, grade.Reason, nil
+}
+
+// runTestCase sends the case's input to the agent and checks the reply. A
+// failed reply fails the case rather than the evaluation.
+func runTestCase(ctx context.Context, db *sql.DB, llmClient LLMClient, agent *Agent, tc *TestCase) CaseResult {
+	result := CaseResult{TestID: tc.ID, Name: tc.Name, Assertions: []AssertionResult{}}
+	agent, err := withNarrative(agent, tc.Context.Variables, func() (map[string]interface{}, error) {
+		return integrationMetadata(db)
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	turn := agentTurn{
+		Summary:   tc.Context.Summary,
+		History:   tc.Context.History,
+		Message:   tc.Input,
+		Documents: tc.Context.Documents,
+		Memories:  tc.Context.Memories,
+	}
+	reply, _, err := turn.reply(ctx, db, guardLLM(llmClient, agent), agent, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Response = reply
+
+	result.Passed = true
+	for _, a := range tc.Assertions {
+		checked := a.evaluate(ctx, llmClient, tc.Input, reply)
+		result.Assertions = append(result.Assertions, checked)
+		result.Score += checked.Score
+		result.Passed = result.Passed && checked.Passed
+	}
+	if len(tc.Assertions) > 0 {
+		result.Score /= float64(len(tc.Assertions))
+	}
+	return result
+}
+
+// compareReports matches the cases of report against baseline by test ID.
+func compareReports(baseline, report *EvalReport) *EvalComparison {
+	comparison := &EvalComparison{
+		BaselineID:      baseline.ID,
+		BaselineVersion: baseline.AgentVersion,
+		ScoreDelta:      report.Score - baseline.Score,
+		Regressions:     []CaseChange{},
+		Fixed:           []CaseChange{},
+	}
+	before := make(map[string]CaseResult, len(baseline.Results))
+	for _, r := range baseline.Results {
+		before[r.TestID] = r
+	}
+	for _, r := range report.Results {
+		old, ok := before[r.TestID]
+		if !ok {
+			continue
+		}
+		change := CaseChange{TestID: r.TestID, Name: r.Name, Before: old.Score, After: r.Score}
+		switch {
+		case old.Passed && !r.Passed:
+			comparison.Regressions = append(comparison.Regressions, change)
+		case !old.Passed && r.Passed:
+			comparison.Fixed = append(comparison.Fixed, change)
+		}
+	}
+	return comparison
+}
+
+const testCaseColumns = `id, agent_id, name, input, context, assertions, created_at, updated_at`
+
+func scanTestCase(row rowScanner) (*TestCase, error) {
+	var tc TestCase
+	var contextJSON, assertionsJSON []byte
+	if err := row.Scan(&tc.ID, &tc.AgentID, &tc.Name, &tc.Input, &contextJSON, &assertionsJSON, &tc.CreatedAt, &tc.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contextJSON, &tc.Context); err != nil {
+		return nil, fmt.Errorf("failed to parse test context: %v", err)
+	}
+	if err := json.Unmarshal(assertionsJSON, &tc.Assertions); err != nil {
+		return nil, fmt.Errorf("failed to parse assertions: %v", err)
+	}
+	return &tc, nil
+}
+
+func fetchTestCases(db *sql.DB, agentID string) ([]*TestCase, error) {
+	rows, err := db.Query(`SELECT `+testCaseColumns+` FROM agent_test_cases WHERE agent_id = $1 ORDER BY created_at, id`, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cases := []*TestCase{}
+	for rows.Next() {
+		tc, err := scanTestCase(rows)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, tc)
+	}
+	return cases, rows.Err()
+}
+
+// evalSummaryColumns leave out the per-case results.
+const evalSummaryColumns = `id, agent_id, agent_version, proposed, score, passed, total, comparison, author, created_at`
+
+func scanEvalSummary(row rowScanner) (*EvalReport, error) {
+	var r EvalReport
+	var comparisonJSON []byte
+	if err := row.Scan(&r.ID, &r.AgentID, &r.AgentVersion, &r.Proposed, &r.Score, &r.Passed, &r.Total, &comparisonJSON, &r.Author, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+	if comparisonJSON != nil {
+		if err := json.Unmarshal(comparisonJSON, &r.Comparison); err != nil {
+			return nil, fmt.Errorf("failed to parse comparison: %v", err)
+		}
+	}
+	return &r, nil
+}
+
+func fetchEvalReport(db *sql.DB, agentID, id string) (*EvalReport, error) {
+	var r EvalReport
+	var configJSON, resultsJSON, comparisonJSON []byte
+	err := db.QueryRow(`
+		SELECT id, agent_id, agent_version, proposed, narrative, config, score, passed, total, results, comparison, author, created_at
+		FROM agent_evaluations
+		WHERE id::text = $1 AND agent_id::text = $2`,
+		id, agentID,
+	).Scan(&r.ID, &r.AgentID, &r.AgentVersion, &r.Proposed, &r.Narrative, &configJSON, &r.Score, &r.Passed, &r.Total, &resultsJSON, &comparisonJSON, &r.Author, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(configJSON, &r.Config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %v", err)
+	}
+	if err := json.Unmarshal(resultsJSON, &r.Results); err != nil {
+		return nil, fmt.Errorf("failed to parse results: %v", err)
+	}
+	if comparisonJSON != nil {
+		if err := json.Unmarshal(comparisonJSON, &r.Comparison); err != nil {
+			return nil, fmt.Errorf("failed to parse comparison: %v", err)
+		}
+	}
+	return &r, nil
+}
+
+// baselineReport picks the report to compare a new one against: the latest
+// of an earlier saved version, or for a proposed change the latest of the
+// current version or before.
+func baselineReport(db *sql.DB, agentID string, version int, proposed bool) (*EvalReport, error) {
+	var id string
+	err := db.QueryRow(`
+		SELECT id FROM agent_evaluations
+		WHERE agent_id = $1 AND NOT proposed AND (agent_version < $2 OR ($3 AND agent_version = $2))
+		ORDER BY agent_version DESC, created_at DESC
+		LIMIT 1`,
+		agentID, version, proposed,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return fetchEvalReport(db, agentID, id)
+}
+
+func ListTestCases(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		cases, err := fetchTestCases(db, agent.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch test cases"})
+			return
+		}
+		c.JSON(http.StatusOK, cases)
+	}
+}
+
+func CreateTestCase(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		var in testCaseInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errs := in.check(); errs != nil {
+			respondFieldErrors(c, "test case", errs)
+			return
+		}
+		contextJSON, _ := json.Marshal(in.Context)
+		assertionsJSON, _ := json.Marshal(in.Assertions)
+
+		tc, err := scanTestCase(db.QueryRow(`
+			INSERT INTO agent_test_cases (agent_id, name, input, context, assertions)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING `+testCaseColumns,
+			agent.ID, in.Name, in.Input, contextJSON, assertionsJSON))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create test case"})
+			return
+		}
+		c.JSON(http.StatusCreated, tc)
+	}
+}
+
+func UpdateTestCase(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in testCaseInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errs := in.check(); errs != nil {
+			respondFieldErrors(c, "test case", errs)
+			return
+		}
+		contextJSON, _ := json.Marshal(in.Context)
+		assertionsJSON, _ := json.Marshal(in.Assertions)
+
+		tc, err := scanTestCase(db.QueryRow(`
+			UPDATE agent_test_cases SET name = $3, input = $4, context = $5, assertions = $6
+			WHERE id::text = $1 AND agent_id::text = $2
+			RETURNING `+testCaseColumns,
+			c.Param("tid"), c.Param("id"), in.Name, in.Input, contextJSON, assertionsJSON))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Test case not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update test case"})
+			return
+		}
+		c.JSON(http.StatusOK, tc)
+	}
+}
+
+func DeleteTestCase(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := db.Exec(`DELETE FROM agent_test_cases WHERE id::text = $1 AND agent_id::text = $2`, c.Param("tid"), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete test case"})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Test case not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Test case deleted", "id": c.Param("tid")})
+	}
+}
+
+// EvaluateAgent serves POST /agents/:id/evaluate. It runs the agent's test
+// cases, or those named in "tests", and stores the report. A "narrative" or
+// "config" (merged over the agent's config) evaluates a proposed change
+// without saving it. The report is compared with "baseline", a report ID,
+// or else the latest report of an earlier version.
+func EvaluateAgent(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		var req struct {
+			Narrative *string                `json:"narrative"`
+			Config    map[string]interface{} `json:"config"`
+			Tests     []string               `json:"tests"`
+			Baseline  string                 `json:"baseline"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		report := EvalReport{AgentID: agent.ID, AgentVersion: agent.Version, Results: []CaseResult{}, Author: currentUser(c)}
+		if req.Narrative != nil {
+			errs := FieldErrors{}
+			checkNarrative(*req.Narrative, errs)
+			if len(errs) > 0 {
+				respondFieldErrors(c, "narrative", errs)
+				return
+			}
+			agent.Narrative = *req.Narrative
+			report.Proposed = true
+		}
+		if req.Config != nil {
+			config := agent.Config.toMap()
+			for k, v := range req.Config {
+				config[k] = v
+			}
+			var errs FieldErrors
+			if agent.Config, errs = parseAgentConfig(agent.Type, config); errs != nil {
+				respondFieldErrors(c, "config", errs)
+				return
+			}
+			report.Proposed = true
+		}
+		report.Narrative, report.Config = agent.Narrative, &agent.Config
+
+		cases, err := fetchTestCases(db, agent.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch test cases"})
+			return
+		}
+		if len(req.Tests) > 0 {
+			wanted := make(map[string]bool, len(req.Tests))
+			for _, id := range req.Tests {
+				wanted[id] = true
+			}
+			selected := cases[:0]
+			for _, tc := range cases {
+				if wanted[tc.ID] {
+					selected = append(selected, tc)
+				}
+			}
+			cases = selected
+		}
+		if len(cases) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Agent has no test cases to run"})
+			return
+		}
+
+		for _, tc := range cases {
+			result := runTestCase(c.Request.Context(), db, llmClient, agent, tc)
+			report.Results = append(report.Results, result)
+			report.Score += result.Score
+			if result.Passed {
+				report.Passed++
+			}
+		}
+		report.Total = len(cases)
+		report.Score /= float64(report.Total)
+
+		var baseline *EvalReport
+		if req.Baseline != "" {
+			baseline, err = fetchEvalReport(db, agent.ID, req.Baseline)
+		} else {
+			baseline, err = baselineReport(db, agent.ID, agent.Version, report.Proposed)
+		}
+		if err == sql.ErrNoRows && req.Baseline != "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Baseline report not found"})
+			return
+		}
+		if err != nil && err != sql.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch baseline report"})
+			return
+		}
+		if baseline != nil {
+			report.Comparison = compareReports(baseline, &report)
+		}
+
+		configJSON, _ := json.Marshal(report.Config)
+		resultsJSON, _ := json.Marshal(report.Results)
+		var comparisonJSON []byte
+		if report.Comparison != nil {
+			comparisonJSON, _ = json.Marshal(report.Comparison)
+		}
+		err = db.QueryRow(`
+			INSERT INTO agent_evaluations (agent_id, agent_version, proposed, narrative, config, score, passed, total, results, comparison, author)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id, created_at`,
+			report.AgentID, report.AgentVersion, report.Proposed, report.Narrative, configJSON,
+			report.Score, report.Passed, report.Total, resultsJSON, comparisonJSON, report.Author,
+		).Scan(&report.ID, &report.CreatedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store evaluation report"})
+			return
+		}
+		c.JSON(http.StatusCreated, report)
+	}
+}
+
+var evalListSpec = listSpec{
+	table:       "agent_evaluations",
+	columns:     evalSummaryColumns,
+	sorts:       map[string]string{"created_at": "created_at", "score": "score"},
+	defaultSort: "-created_at",
+	filters:     map[string]string{"agent_id": "agent_id::text", "version": "agent_version::text", "proposed": "proposed::text"},
+}
+
+// ListEvaluations serves an agent's evaluation reports without their
+// per-case results, newest first, with ?version= and ?proposed= filters.
+func ListEvaluations(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		q, err := parseListQuery(c, evalListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		q.filters["agent_id"] = []string{agent.ID}
+
+		page, err := queryPage(db, evalListSpec, q, scanEvalSummary)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch evaluations"})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func GetEvaluation(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report, err := fetchEvalReport(db, c.Param("id"), c.Param("eid"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Evaluation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch evaluation"})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
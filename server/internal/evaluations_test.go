@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssertionEvaluate(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{
+		{System: "You grade", Match: "polite", Response: `{"score": 0.8, "reason": "Courteous."}`},
+		{System: "You grade", Response: `{"score": 0.2, "reason": "Curt."}`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	low := 0.1
+	tests := []struct {
+		name      string
+		assertion Assertion
+		reply     string
+		want      bool
+	}{
+		{"contains", Assertion{Type: AssertContains, Value: "Refund"}, "Refund approved", true},
+		{"contains is case sensitive", Assertion{Type: AssertContains, Value: "refund"}, "Refund approved", false},
+		{"contains ignoring case", Assertion{Type: AssertContains, Value: "refund", IgnoreCase: true}, "Refund approved", true},
+		{"regex", Assertion{Type: AssertRegex, Pattern: `\d+ days`}, "within 14 days", true},
+		{"regex mismatch", Assertion{Type: AssertRegex, Pattern: `^\d+$`}, "14 days", false},
+		{"json schema", Assertion{Type: AssertJSONSchema, Schema: map[string]interface{}{"type": "object", "required": []interface{}{"ok"}}}, `{"ok": true}`, true},
+		{"json in prose", Assertion{Type: AssertJSONSchema, Schema: map[string]interface{}{"type": "object"}}, "Here you go: {\"ok\": true}", true},
+		{"not json", Assertion{Type: AssertJSONSchema, Schema: map[string]interface{}{"type": "object"}}, "no", false},
+		{"similar", Assertion{Type: AssertSimilarity, Expected: "Refunds take 14 days"}, "Refunds take 14 days", true},
+		{"dissimilar", Assertion{Type: AssertSimilarity, Expected: "Refunds take 14 days"}, "The garage opens at seven", false},
+		{"judge", Assertion{Type: AssertJudge, Rubric: "Is the reply polite?"}, "Happy to help!", true},
+		{"judge below threshold", Assertion{Type: AssertJudge, Rubric: "Does it cite a source?"}, "No.", false},
+		{"judge with own threshold", Assertion{Type: AssertJudge, Rubric: "Does it cite a source?", Threshold: &low}, "No.", true},
+	}
+	for _, tt := range tests {
+		got := tt.assertion.evaluate(context.Background(), llm, "question", tt.reply)
+		if got.Passed != tt.want {
+			t.Errorf("%s: passed = %v (%s), want %v", tt.name, got.Passed, got.Detail, tt.want)
+		}
+		if tt.assertion.Type == AssertJudge && got.Detail == "" {
+			t.Errorf("%s: the judge's reason is missing", tt.name)
+		}
+	}
+}
+
+func TestTestCaseInputCheck(t *testing.T) {
+	in := testCaseInput{
+		Context: TestContext{
+			History:   []Message{{Role: "system", Content: "x"}},
+			Documents: []Document{{ID: "d1"}},
+		},
+		Assertions: []Assertion{
+			{Type: AssertRegex, Pattern: "("},
+			{Type: AssertSimilarity, Expected: "x", Threshold: bound(1.5)},
+			{Type: "vibes"},
+		},
+	}
+	errs := in.check()
+	for _, field := range []string{"input", "context.history[0].role", "context.documents[0]", "assertions[0].pattern", "assertions[1].threshold", "assertions[2].type"} {
+		if errs[field] == "" {
+			t.Errorf("errors = %v, want %s reported", errs, field)
+		}
+	}
+
+	in = testCaseInput{Input: "Where is my order?", Assertions: []Assertion{{Type: AssertContains, Value: "order"}}}
+	if errs := in.check(); errs != nil || in.Name != "Where is my order?" {
+		t.Errorf("errors = %v, name %q; want a valid case named after its input", errs, in.Name)
+	}
+}
+
+func TestRunTestCase(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{
+		{System: "Document: policy", Match: "refund", Response: "Refunds take 14 days."},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &Agent{Type: TypeLLM, Narrative: "You answer for {{company}}.", Config: AgentConfig{
+		UseRAG:    true,
+		Variables: []NarrativeVariable{{Name: "company", Required: true}},
+	}}
+	tc := &TestCase{
+		ID:    "t1",
+		Name:  "refunds",
+		Input: "How long does a refund take?",
+		Context: TestContext{
+			Variables: map[string]interface{}{"company": "Acme"},
+			Documents: []Document{{ID: "policy", Content: "Refunds take 14 days."}},
+		},
+		Assertions: []Assertion{
+			{Type: AssertContains, Value: "14 days"},
+			{Type: AssertContains, Value: "receipt"},
+		},
+	}
+
+	result := runTestCase(context.Background(), nil, llm, agent, tc)
+	if result.Error != "" {
+		t.Fatal(result.Error)
+	}
+	if result.Response != "Refunds take 14 days." || result.Passed || result.Score != 0.5 {
+		t.Errorf("result = %+v, want half the assertions passed", result)
+	}
+	if system := llm.Calls()[0][0].Content; !strings.HasPrefix(system, "You answer for Acme.") {
+		t.Errorf("system prompt = %q, want the narrative filled from the case's variables", system)
+	}
+
+	tc.Context.Variables = nil
+	if result := runTestCase(context.Background(), nil, llm, agent, tc); result.Error == "" || result.Passed {
+		t.Errorf("result = %+v, want a missing variable to fail the case", result)
+	}
+}
+
+func TestCompareReports(t *testing.T) {
+	baseline := &EvalReport{ID: "r1", AgentVersion: 3, Score: 0.5, Results: []CaseResult{
+		{TestID: "a", Passed: true, Score: 1},
+		{TestID: "b", Passed: false, Score: 0},
+		{TestID: "c", Passed: true, Score: 1},
+	}}
+	report := &EvalReport{Score: 0.75, Results: []CaseResult{
+		{TestID: "a", Name: "A", Passed: false, Score: 0.5},
+		{TestID: "b", Name: "B", Passed: true, Score: 1},
+		{TestID: "c", Passed: true, Score: 1},
+		{TestID: "new", Passed: false},
+	}}
+
+	got := compareReports(baseline, report)
+	want := &EvalComparison{
+		BaselineID:      "r1",
+		BaselineVersion: 3,
+		ScoreDelta:      0.25,
+		Regressions:     []CaseChange{{TestID: "a", Name: "A", Before: 1, After: 0.5}},
+		Fixed:           []CaseChange{{TestID: "b", Name: "B", Before: 0, After: 1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("comparison = %+v, want %+v", got, want)
+	}
+}
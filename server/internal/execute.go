@@ -1,62 +1,50 @@
 package internal
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-type ReactFlowEdge struct {
-	ID           string         `json:"id"`
-	Source       string         `json:"source"`
-	Target       string         `json:"target"`
-	Style        map[string]any `json:"style,omitempty"`
-	Animated     bool           `json:"animated,omitempty"`
-	Selected     bool           `json:"selected,omitempty"`
-	SourceHandle *string        `json:"sourceHandle"`
-	TargetHandle *string        `json:"targetHandle"`
-}
-
-type ReactFlowNode struct {
-	ID            string                 `json:"id"`
-	Type          string                 `json:"type"`
-	AgentID       string                 `json:"agentId"`
-	Position      map[string]float64     `json:"position"`
-	Configuration map[string]interface{} `json:"configuration"`
-	Inputs        []string               `json:"inputs"`
-	Outputs       []string               `json:"outputs"`
-}
-
-type ReactFlowDAG struct {
-	Nodes []ReactFlowNode `json:"nodes"`
-	Edges []ReactFlowEdge `json:"edges"`
-}
-
 type ExecutionRequest struct {
-	WorkflowID string       `json:"workflowId"`
-	DAG        ReactFlowDAG `json:"dag"`
+	WorkflowID string            `json:"workflowId"`
+	DAG        Dag               `json:"dag"`
+	Params     map[string]string `json:"params"`
 }
 
 type TaskDefinition struct {
-	ID         string     `json:"id"`
-	WorkflowID string     `json:"workflowId"`
-	Nodes      []TaskNode `json:"nodes"`
-	Edges      []TaskEdge `json:"edges"`
-	Status     string     `json:"status"`
-	Results    []Result   `json:"results"`
-	CreatedAt  time.Time  `json:"createdAt"`
-	UpdatedAt  time.Time  `json:"updatedAt"`
+	ID              string            `json:"id"`
+	WorkflowID      string            `json:"workflowId"`
+	WorkflowVersion *int              `json:"workflowVersion,omitempty"`
+	Params          map[string]string `json:"params"`
+	Nodes           []TaskNode        `json:"nodes"`
+	Edges           []TaskEdge        `json:"edges"`
+	Status          string            `json:"status"`
+	Results         []Result          `json:"results"`
+	CreatedAt       time.Time         `json:"createdAt"`
+	UpdatedAt       time.Time         `json:"updatedAt"`
 }
 
 type TaskNode struct {
-	ID       string            `json:"id"`
-	Type     string            `json:"type"` // "agent" or "human"
-	Config   map[string]string `json:"config"`
-	Status   string            `json:"status"`
-	Response string            `json:"response,omitempty"`
+	ID           string                 `json:"id"`
+	Type         string                 `json:"type"` // "agent" or "human"
+	Config       map[string]string      `json:"config"`
+	Cache        *NodeCachePolicy       `json:"cache,omitempty"`
+	OutputSchema map[string]interface{} `json:"outputSchema,omitempty"` // JSON Schema the response must match
+	Status       string                 `json:"status"`
+	Response     string                 `json:"response,omitempty"`
+	Cached       bool                   `json:"cached,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+	AgentVersion int                    `json:"agentVersion,omitempty"` // the agent version the node ran
 }
 
 type TaskEdge struct {
@@ -71,131 +59,287 @@ type Result struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
-func ExecuteTask(db *sql.DB) gin.HandlerFunc {
+func ExecuteTask(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req ExecutionRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		startExecution(c, db, llmClient, req)
+	}
+}
 
-		// Convert ReactFlow nodes to task nodes
-		taskNodes := make([]TaskNode, len(req.DAG.Nodes))
-		for i, node := range req.DAG.Nodes {
-			taskNodes[i] = TaskNode{
-				ID:   node.ID,
-				Type: node.Type,
-				Config: map[string]string{
-					"agentId": node.AgentID,
-				},
-				Status: "pending",
-			}
+// RunWorkflow executes the stored DAG of a workflow with the given run
+// parameters.
+func RunWorkflow(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Params map[string]string `json:"params"`
 		}
-
-		// Convert ReactFlow edges to task edges
-		taskEdges := make([]TaskEdge, len(req.DAG.Edges))
-		for i, edge := range req.DAG.Edges {
-			taskEdges[i] = TaskEdge{
-				Source: edge.Source,
-				Target: edge.Target,
-			}
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+		startExecution(c, db, llmClient, ExecutionRequest{
+			WorkflowID: c.Param("id"),
+			Params:     req.Params,
+		})
+	}
+}
 
-		task := &TaskDefinition{
-			WorkflowID: req.WorkflowID,
-			Nodes:      taskNodes,
-			Edges:      taskEdges,
-			Status:     "in_progress",
-			Results:    make([]Result, 0),
-		}
+// storedWorkflow is what a run needs from a saved workflow.
+type storedWorkflow struct {
+	version  int
+	dag      Dag
+	defaults map[string]string
+	archived bool
+}
+
+// loadStoredWorkflow reads the current version of a workflow. It returns
+// sql.ErrNoRows when the workflow does not exist.
+func loadStoredWorkflow(db *sql.DB, id string) (*storedWorkflow, error) {
+	var w storedWorkflow
+	var dagBytes, paramsBytes []byte
+	err := db.QueryRow(`
+		SELECT version, dag, parameters, archived_at IS NOT NULL
+		FROM workflows WHERE id = $1`,
+		id,
+	).Scan(&w.version, &dagBytes, &paramsBytes, &w.archived)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(paramsBytes, &w.defaults); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow parameters: %v", err)
+	}
+	if err := json.Unmarshal(dagBytes, &w.dag); err != nil {
+		return nil, fmt.Errorf("failed to parse DAG: %v", err)
+	}
+	return &w, nil
+}
+
+// loadWorkflowTask builds a pending run of a saved, unarchived workflow.
+func loadWorkflowTask(db *sql.DB, id string, params map[string]string) (*TaskDefinition, error) {
+	w, err := loadStoredWorkflow(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if w.archived {
+		return nil, fmt.Errorf("workflow is archived")
+	}
+	if err := w.dag.Validate(); err != nil {
+		return nil, err
+	}
+	return NewTask(id, &w.version, w.dag, mergeParams(w.defaults, params))
+}
 
-		// Store task in database
-		taskID, err := storeTask(db, task)
+// runStoredWorkflow fills in what a run of a saved workflow takes from it:
+// default parameters and, unless the request brings its own, the DAG. It
+// returns the workflow version the run executes, or nil for a submitted DAG
+// that no stored version describes.
+func (req *ExecutionRequest) runStoredWorkflow(w *storedWorkflow) *int {
+	req.Params = mergeParams(w.defaults, req.Params)
+	if len(req.DAG.Nodes) > 0 {
+		return nil
+	}
+	req.DAG = w.dag
+	version := w.version
+	return &version
+}
+
+func startExecution(c *gin.Context, db *sql.DB, llmClient LLMClient, req ExecutionRequest) {
+	var workflowVersion *int
+	if req.WorkflowID != "" {
+		w, err := loadStoredWorkflow(db, req.WorkflowID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store task"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow"})
 			return
 		}
-		task.ID = taskID
-
-		// Start task execution asynchronously
-		go executeTaskAsync(db, task)
+		if w.archived {
+			c.JSON(http.StatusConflict, gin.H{"error": "Workflow is archived; restore it before running"})
+			return
+		}
+		workflowVersion = req.runStoredWorkflow(w)
+	}
 
-		c.JSON(http.StatusAccepted, gin.H{
-			"message": "Task started",
-			"taskId":  task.ID,
-		})
+	if err := req.DAG.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-}
 
-func storeTask(db *sql.DB, task *TaskDefinition) (string, error) {
-	nodesJSON, err := json.Marshal(task.Nodes)
+	task, err := NewTask(req.WorkflowID, workflowVersion, req.DAG, req.Params)
 	if err != nil {
-		return "", err
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	edgesJSON, err := json.Marshal(task.Edges)
+	invoker := NewInvoker(llmClient, NewToolRegistry(db, llmClient))
+	taskID, err := NewExecutor(NewPostgresStore(db), invoker).Start(task)
 	if err != nil {
-		return "", err
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store task"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Task started",
+		"taskId":  taskID,
+	})
+}
+
+// NewTask converts a validated DAG into a pending execution.
+func NewTask(workflowID string, workflowVersion *int, dag Dag, params map[string]string) (*TaskDefinition, error) {
+	taskNodes := make([]TaskNode, len(dag.Nodes))
+	for i, node := range dag.Nodes {
+		cachePolicy, err := parseNodeCachePolicy(node.Configuration)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %v", node.ID, err)
+		}
+		outputSchema, err := parseNodeOutputSchema(node.Configuration)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %v", node.ID, err)
+		}
+
+		config := map[string]string{
+			"agentId": node.AgentID,
+		}
+		if prompt, ok := node.Configuration["prompt"].(string); ok {
+			config["prompt"] = prompt
+		}
+
+		taskNodes[i] = TaskNode{
+			ID:           node.ID,
+			Type:         string(node.Type),
+			Config:       config,
+			Cache:        cachePolicy,
+			OutputSchema: outputSchema,
+			Status:       "pending",
+		}
+	}
+
+	if params == nil {
+		params = map[string]string{}
+	}
+
+	return &TaskDefinition{
+		WorkflowID:      workflowID,
+		WorkflowVersion: workflowVersion,
+		Params:          params,
+		Nodes:           taskNodes,
+		Edges:           dag.taskEdges(),
+		Status:          "in_progress",
+		Results:         make([]Result, 0),
+	}, nil
+}
+
+// mergeParams layers run parameters over a workflow's defaults.
+func mergeParams(defaults, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(defaults)+len(overrides))
+	for k, v := range defaults {
+		merged[k] = v
 	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
 
-	resultsJSON, err := json.Marshal(task.Results)
+// Executor runs tasks against an ExecutionStore. The server uses the
+// Postgres store; the local runner keeps everything in memory.
+type Executor struct {
+	store   ExecutionStore
+	invoker *Invoker
+}
+
+func NewExecutor(store ExecutionStore, invoker *Invoker) *Executor {
+	return &Executor{store: store, invoker: invoker}
+}
+
+// Start stores the task and runs it in the background.
+func (e *Executor) Start(task *TaskDefinition) (string, error) {
+	taskID, err := e.store.CreateExecution(task)
 	if err != nil {
 		return "", err
 	}
+	task.ID = taskID
 
-	var taskID string
-	err = db.QueryRow(`
-		INSERT INTO executions (workflow_id, status, nodes, edges, results)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`,
-		task.WorkflowID, task.Status, nodesJSON, edgesJSON, resultsJSON,
-	).Scan(&taskID)
+	go e.Run(task)
+	return taskID, nil
+}
 
-	return taskID, err
+// Run executes the pending nodes of a stored task in topological order,
+// saving progress after every node. Nodes that already finished keep their
+// results, so a run waiting on a human node resumes where it stopped. The
+// run ends "failed" if a node failed, "waiting" while human nodes are
+// pending and "completed" once every node has run.
+func (e *Executor) Run(task *TaskDefinition) {
+	e.RunContext(context.Background(), task)
 }
 
-func updateTask(db *sql.DB, task *TaskDefinition) error {
-	nodesJSON, err := json.Marshal(task.Nodes)
-	if err != nil {
-		return err
+// RunContext is Run that stops once ctx is done: nodes that have not
+// started fail with the context's error and the nodes below them are
+// skipped.
+func (e *Executor) RunContext(ctx context.Context, task *TaskDefinition) {
+	if purged, err := e.store.PurgeExpiredCache(); err != nil {
+		log.Printf("Failed to purge expired node cache: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d expired node cache entries", purged)
 	}
 
-	edgesJSON, err := json.Marshal(task.Edges)
+	order, err := topologicalOrder(task.nodeIDs(), task.Edges)
 	if err != nil {
-		return err
+		task.Status = "failed"
+		e.store.UpdateExecution(task)
+		return
 	}
 
-	resultsJSON, err := json.Marshal(task.Results)
-	if err != nil {
-		return err
+	outputs := make(map[string]string, len(task.Nodes))
+	for _, node := range task.Nodes {
+		if node.Status == "completed" {
+			outputs[node.ID] = node.Response
+		}
 	}
 
-	_, err = db.Exec(`
-		UPDATE executions 
-		SET status = $1, nodes = $2, edges = $3, results = $4
-		WHERE id = $5`,
-		task.Status, nodesJSON, edgesJSON, resultsJSON, task.ID,
-	)
+	// Process nodes in topological order
+	for _, i := range order {
+		node := &task.Nodes[i]
+		if node.Status != "pending" {
+			continue
+		}
 
-	return err
-}
+		// Nodes run only once every upstream node has completed. Below a
+		// failed node they are skipped; below a pending human node they wait.
+		// Human nodes wait for a person to complete them.
+		blocker := task.unfinishedUpstream(node.ID)
+		switch {
+		case blocker != nil && blocker.Status == "pending":
+			continue
+		case blocker != nil:
+			node.Status = "skipped"
+			node.Error = fmt.Sprintf("upstream node %s %s", blocker.ID, blocker.Status)
+		case node.Type == "human":
+			continue
 
-func executeTaskAsync(db *sql.DB, task *TaskDefinition) {
-	// Process nodes in topological order
-	for _, node := range task.Nodes {
-		// Execute node based on type
-		switch node.Type {
-		case "agent":
-			response, err := executeAgentNode(node)
+		case ctx.Err() != nil:
+			node.Status = "failed"
+			node.Error = fmt.Sprintf("run stopped: %v", ctx.Err())
+
+		case node.Type == "agent":
+			response, cached, err := e.executeAgentNode(node, task.Params, upstreamOutputs(node.ID, task.Edges, outputs),
+				nodeVariables(task.Params, node.ID, task.Edges, outputs))
 			if err != nil {
+				log.Printf("Node %s of task %s failed: %v", node.ID, task.ID, err)
 				node.Status = "failed"
-				continue
+				node.Error = err.Error()
+				break
 			}
 			node.Response = response
+			node.Cached = cached
 			node.Status = "completed"
-
-		case "human":
-			node.Status = "pending"
+			outputs[node.ID] = response
 		}
 
 		// Store result
@@ -206,20 +350,230 @@ func executeTaskAsync(db *sql.DB, task *TaskDefinition) {
 		}
 		task.Results = append(task.Results, result)
 
-		// Update task in database
-		if err := updateTask(db, task); err != nil {
+		if err := e.store.UpdateExecution(task); err != nil {
 			// Log error but continue execution
 			// TODO: Add proper error handling/retry logic
+			log.Printf("Failed to update task %s: %v", task.ID, err)
 			continue
 		}
 	}
 
-	task.Status = "completed"
-	updateTask(db, task)
+	task.Status = task.outcome()
+	e.store.UpdateExecution(task)
+}
+
+// outcome is the status of a task whose runnable nodes have all run.
+func (t *TaskDefinition) outcome() string {
+	status := "completed"
+	for _, node := range t.Nodes {
+		switch node.Status {
+		case "failed":
+			return "failed"
+		case "pending":
+			status = "waiting"
+		}
+	}
+	return status
+}
+
+func (e *Executor) executeAgentNode(node *TaskNode, params map[string]string, inputs []string, vars map[string]interface{}) (string, bool, error) {
+	agent, err := e.store.GetAgent(node.Config["agentId"])
+	if err != nil {
+		return "", false, fmt.Errorf("failed to fetch agent: %v", err)
+	}
+	node.AgentVersion = agent.Version
+	if agent, err = withNarrative(agent, vars, e.store.Integrations); err != nil {
+		return "", false, err
+	}
+	if node.OutputSchema != nil {
+		agent.Config.OutputSchema = node.OutputSchema
+	}
+
+	prompt := buildNodePrompt(node.Config["prompt"], params, inputs)
+	messages := []Message{
+		{Role: "system", Content: agent.Narrative},
+		{Role: "user", Content: prompt},
+	}
+	modelParams := agent.Config.modelParams(e.invoker.llm.Defaults())
+
+	var cacheKey string
+	if node.Cache != nil && node.Cache.Enabled {
+		version, err := agentVersion(agent)
+		if err != nil {
+			return "", false, err
+		}
+		cacheKey, err = nodeCacheKey(agent.ID, version, messages, modelParams)
+		if err != nil {
+			return "", false, err
+		}
+
+		output, hit, err := e.store.LookupCache(cacheKey)
+		if err != nil {
+			log.Printf("Node cache lookup failed for %s: %v", node.ID, err)
+		} else if hit {
+			return output, true, nil
+		}
+	}
+
+	input := prompt
+	if agent.Type == TypeFunction {
+		input = functionNodeInput(node.Config["prompt"], params, inputs)
+	}
+	response, err := e.invoker.Invoke(agent, AgentRequest{Messages: messages, Input: input})
+	if err != nil {
+		return "", false, err
+	}
+
+	if cacheKey != "" {
+		if err := e.store.StoreCache(cacheKey, agent.ID, response, node.Cache.ttl()); err != nil {
+			log.Printf("Failed to store node cache for %s: %v", node.ID, err)
+		}
+	}
+	return response, false, nil
+}
+
+// functionNodeInput passes structured data to function agents unchanged: a
+// node without a prompt forwards its single upstream output, or the run
+// parameters as a JSON object when it has no inputs.
+func functionNodeInput(prompt string, params map[string]string, inputs []string) string {
+	if prompt == "" && len(inputs) == 1 {
+		return inputs[0]
+	}
+	if prompt == "" && len(inputs) == 0 && len(params) > 0 {
+		encoded, _ := json.Marshal(params)
+		return string(encoded)
+	}
+	return buildNodePrompt(prompt, params, inputs)
 }
 
-func executeAgentNode(node TaskNode) (string, error) {
-	// TODO: Implement actual agent execution logic
-	// This would make API calls to the agent service
-	return "Sample response", nil
+func buildNodePrompt(prompt string, params map[string]string, inputs []string) string {
+	var b strings.Builder
+	if prompt != "" {
+		b.WriteString(prompt)
+	}
+	if len(params) > 0 {
+		keys := make([]string, 0, len(params))
+		for key := range params {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString("Parameters:")
+		for _, key := range keys {
+			fmt.Fprintf(&b, "\n- %s: %s", key, params[key])
+		}
+	}
+	for _, input := range inputs {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(input)
+	}
+	if b.Len() == 0 {
+		return "Begin."
+	}
+	return b.String()
+}
+
+// upstreamOutputs returns the outputs of the nodes feeding into nodeID, in
+// edge order.
+func upstreamOutputs(nodeID string, edges []TaskEdge, outputs map[string]string) []string {
+	var inputs []string
+	for _, edge := range edges {
+		if edge.Target != nodeID {
+			continue
+		}
+		if output, ok := outputs[edge.Source]; ok {
+			inputs = append(inputs, output)
+		}
+	}
+	return inputs
+}
+
+// unfinishedUpstream returns a node feeding id that has not completed,
+// preferring one that failed or was skipped over one still pending, or nil
+// when all have completed.
+func (t *TaskDefinition) unfinishedUpstream(id string) *TaskNode {
+	var pending *TaskNode
+	for _, edge := range t.Edges {
+		if edge.Target != id {
+			continue
+		}
+		for i := range t.Nodes {
+			node := &t.Nodes[i]
+			switch {
+			case node.ID != edge.Source || node.Status == "completed":
+			case node.Status == "pending":
+				pending = node
+			default:
+				return node
+			}
+		}
+	}
+	return pending
+}
+
+func (t *TaskDefinition) nodeIDs() []string {
+	ids := make([]string, len(t.Nodes))
+	for i, node := range t.Nodes {
+		ids[i] = node.ID
+	}
+	return ids
+}
+
+// topologicalOrder returns node indexes so that every node comes after all
+// of its upstream nodes. It fails on cycles and on edges to unknown nodes.
+func topologicalOrder(nodes []string, edges []TaskEdge) ([]int, error) {
+	index := make(map[string]int, len(nodes))
+	for i, id := range nodes {
+		index[id] = i
+	}
+
+	inDegree := make([]int, len(nodes))
+	downstream := make([][]int, len(nodes))
+	for _, edge := range edges {
+		src, ok := index[edge.Source]
+		if !ok {
+			return nil, fmt.Errorf("edge references unknown node %q", edge.Source)
+		}
+		dst, ok := index[edge.Target]
+		if !ok {
+			return nil, fmt.Errorf("edge references unknown node %q", edge.Target)
+		}
+		downstream[src] = append(downstream[src], dst)
+		inDegree[dst]++
+	}
+
+	var queue, order []int
+	for i := range nodes {
+		if inDegree[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		order = append(order, i)
+		for _, j := range downstream[i] {
+			inDegree[j]--
+			if inDegree[j] == 0 {
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	if len(order) != len(nodes) {
+		return nil, fmt.Errorf("workflow graph contains a cycle")
+	}
+	return order, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
 }
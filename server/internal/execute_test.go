@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runTestTask runs dag on an in-memory store with one llm agent, "writer",
+// and returns the stored execution.
+func runTestTask(t *testing.T, store *MemoryStore, llm LLMClient, dag Dag) *TaskDefinition {
+	t.Helper()
+	task, err := NewTask("", nil, dag, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ID, err = store.CreateExecution(task); err != nil {
+		t.Fatal(err)
+	}
+	NewExecutor(store, NewInvoker(llm, NewToolRegistry(nil, llm))).Run(task)
+	stored, err := store.Execution(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func newTestStore() *MemoryStore {
+	store := NewMemoryStore()
+	store.AddAgent(&Agent{ID: "writer", Slug: "writer", Name: "Writer", Type: TypeLLM, Narrative: "Write.", Version: 1})
+	return store
+}
+
+func agentNode(id, prompt string) DagNode {
+	return DagNode{ID: id, Type: NodeTypeAgent, AgentID: "writer", Configuration: map[string]interface{}{"prompt": prompt}}
+}
+
+func testEdges(pairs ...string) []DagEdge {
+	var edges []DagEdge
+	for i := 0; i+1 < len(pairs); i += 2 {
+		edges = append(edges, DagEdge{ID: pairs[i] + "-" + pairs[i+1], Source: pairs[i], Target: pairs[i+1]})
+	}
+	return edges
+}
+
+func nodeStatuses(task *TaskDefinition) map[string]string {
+	statuses := map[string]string{}
+	for _, node := range task.Nodes {
+		statuses[node.ID] = node.Status
+	}
+	return statuses
+}
+
+func TestExecutorCachesNodeResults(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Default: "draft"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newTestStore()
+	node := agentNode("a", "write it")
+	node.Configuration["cache"] = map[string]interface{}{"enabled": true, "ttl": "1h"}
+	dag := Dag{Nodes: []DagNode{node}}
+
+	first := runTestTask(t, store, llm, dag)
+	second := runTestTask(t, store, llm, dag)
+
+	if first.Nodes[0].Cached || !second.Nodes[0].Cached {
+		t.Errorf("cached = %v then %v, want false then true", first.Nodes[0].Cached, second.Nodes[0].Cached)
+	}
+	if second.Nodes[0].Response != "draft" {
+		t.Errorf("cached response = %q, want %q", second.Nodes[0].Response, "draft")
+	}
+	if n := len(llm.Calls()); n != 1 {
+		t.Errorf("model called %d times, want 1", n)
+	}
+
+	// A different prompt is a different cache key.
+	dag.Nodes[0].Configuration["prompt"] = "write it again"
+	if third := runTestTask(t, store, llm, dag); third.Nodes[0].Cached {
+		t.Error("a changed prompt hit the cache")
+	}
+}
+
+func TestExecutorWaitsForHumanNodes(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newTestStore()
+	dag := Dag{
+		Nodes: []DagNode{
+			agentNode("draft", "draft a reply"),
+			{ID: "review", Type: NodeTypeHuman, Task: "Approve the reply"},
+			agentNode("send", "send it"),
+			agentNode("log", "log it"),
+		},
+		Edges: testEdges("draft", "review", "review", "send"),
+	}
+
+	task := runTestTask(t, store, llm, dag)
+	if task.Status != "waiting" {
+		t.Fatalf("status = %q, want waiting", task.Status)
+	}
+	want := map[string]string{"draft": "completed", "review": "pending", "send": "pending", "log": "completed"}
+	if got := nodeStatuses(task); !reflect.DeepEqual(got, want) {
+		t.Fatalf("nodes = %v, want %v", got, want)
+	}
+
+	if err := task.completeHumanNode("send", "x"); err == nil {
+		t.Error("completed an agent node")
+	}
+	if err := task.completeHumanNode("nope", "x"); err != errNodeNotFound {
+		t.Errorf("unknown node: got %v, want errNodeNotFound", err)
+	}
+	if err := task.completeHumanNode("review", "approved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := task.completeHumanNode("review", "again"); err == nil {
+		t.Error("completed a human node twice")
+	}
+
+	store.UpdateExecution(task)
+	NewExecutor(store, NewInvoker(llm, NewToolRegistry(nil, llm))).Run(task)
+	task, _ = store.Execution(task.ID)
+	if task.Status != "completed" {
+		t.Fatalf("status after review = %q, want completed", task.Status)
+	}
+	for _, node := range task.Nodes {
+		if node.ID == "send" && !strings.Contains(node.Response, "approved") {
+			t.Errorf("send did not get the review output: %q", node.Response)
+		}
+	}
+	// draft and log ran once; send ran after the review.
+	if n := len(llm.Calls()); n != 3 {
+		t.Errorf("model called %d times, want 3", n)
+	}
+}
+
+func TestExecutorSkipsBelowFailures(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{{Match: "boom", Error: "model down"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dag := Dag{
+		Nodes: []DagNode{
+			agentNode("a", "boom"),
+			{ID: "approve", Type: NodeTypeHuman},
+			agentNode("b", "after both"),
+			agentNode("c", "after b"),
+		},
+		Edges: testEdges("a", "b", "approve", "b", "b", "c"),
+	}
+
+	task := runTestTask(t, newTestStore(), llm, dag)
+	if task.Status != "failed" {
+		t.Errorf("status = %q, want failed", task.Status)
+	}
+	want := map[string]string{"a": "failed", "approve": "pending", "b": "skipped", "c": "skipped"}
+	if got := nodeStatuses(task); !reflect.DeepEqual(got, want) {
+		t.Errorf("nodes = %v, want %v", got, want)
+	}
+	if task.Nodes[2].Error != "upstream node a failed" {
+		t.Errorf("b error = %q", task.Nodes[2].Error)
+	}
+}
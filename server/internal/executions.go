@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// executionColumns is the column list read by scanExecution.
+const executionColumns = `id, COALESCE(workflow_id::text, ''), workflow_version, params, status, nodes, edges, results, created_at, updated_at`
+
+func scanExecution(row rowScanner) (*TaskDefinition, error) {
+	var task TaskDefinition
+	var version sql.NullInt64
+	var paramsJSON, nodesJSON, edgesJSON, resultsJSON []byte
+
+	err := row.Scan(&task.ID, &task.WorkflowID, &version, &paramsJSON, &task.Status,
+		&nodesJSON, &edgesJSON, &resultsJSON, &task.CreatedAt, &task.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if version.Valid {
+		v := int(version.Int64)
+		task.WorkflowVersion = &v
+	}
+	for _, field := range []struct {
+		data []byte
+		dest interface{}
+	}{
+		{paramsJSON, &task.Params},
+		{nodesJSON, &task.Nodes},
+		{edgesJSON, &task.Edges},
+		{resultsJSON, &task.Results},
+	} {
+		if err := json.Unmarshal(field.data, field.dest); err != nil {
+			return nil, fmt.Errorf("failed to parse execution %s: %v", task.ID, err)
+		}
+	}
+	return &task, nil
+}
+
+var executionListSpec = listSpec{
+	table:       "executions",
+	columns:     executionColumns,
+	sorts:       map[string]string{"created_at": "created_at", "updated_at": "updated_at"},
+	defaultSort: "-created_at",
+	filters:     map[string]string{"workflow_id": "workflow_id::text", "status": "status"},
+}
+
+// ListExecutions serves GET /executions, newest first, with pagination,
+// ?workflow_id= and ?status=.
+func ListExecutions(db *sql.DB) gin.HandlerFunc {
+	return listHandler(db, executionListSpec, scanExecution, "executions")
+}
+
+func GetExecution(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		task, err := scanExecution(db.QueryRow(`SELECT `+executionColumns+` FROM executions WHERE id = $1`, c.Param("id")))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch execution"})
+			return
+		}
+		c.JSON(http.StatusOK, task)
+	}
+}
+
+var errNodeNotFound = errors.New("node not found")
+
+// completeHumanNode records a person's output for a pending human node of
+// a waiting task and puts the task back in progress.
+func (t *TaskDefinition) completeHumanNode(id, output string) error {
+	if t.Status != "waiting" {
+		return fmt.Errorf("execution is %s, not waiting", t.Status)
+	}
+	for i := range t.Nodes {
+		node := &t.Nodes[i]
+		if node.ID != id {
+			continue
+		}
+		if node.Type != "human" || node.Status != "pending" {
+			return fmt.Errorf("node %s is not a pending human node", id)
+		}
+		if blocker := t.unfinishedUpstream(id); blocker != nil {
+			return fmt.Errorf("node %s waits for upstream node %s", id, blocker.ID)
+		}
+		node.Status = "completed"
+		node.Response = output
+		t.Results = append(t.Results, Result{NodeID: id, Output: output, Timestamp: time.Now()})
+		t.Status = "in_progress"
+		return nil
+	}
+	return errNodeNotFound
+}
+
+// CompleteHumanNode serves POST /executions/:id/nodes/:node/complete with
+// {"output": ...}: it completes a pending human node of a waiting run and
+// resumes the run in the background.
+func CompleteHumanNode(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Output string `json:"output"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		task, err := scanExecution(tx.QueryRow(`SELECT `+executionColumns+` FROM executions WHERE id = $1 FOR UPDATE`, c.Param("id")))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Execution not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch execution"})
+			return
+		}
+
+		if err := task.completeHumanNode(c.Param("node"), req.Output); errors.Is(err, errNodeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		nodesJSON, _ := json.Marshal(task.Nodes)
+		resultsJSON, _ := json.Marshal(task.Results)
+		if _, err := tx.Exec(`
+			UPDATE executions SET status = $1, nodes = $2, results = $3
+			WHERE id = $4`,
+			task.Status, nodesJSON, resultsJSON, task.ID,
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update execution"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		invoker := NewInvoker(llmClient, NewToolRegistry(db, llmClient))
+		go NewExecutor(NewPostgresStore(db), invoker).Run(task)
+		c.JSON(http.StatusAccepted, gin.H{"message": "Node completed", "taskId": task.ID})
+	}
+}
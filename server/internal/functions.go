@@ -0,0 +1,331 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultFunctionTimeout = 30 * time.Second
+	maxFunctionTimeout     = 300 * time.Second
+)
+
+// endpointClient calls function endpoints. It refuses to connect to
+// loopback, link-local and unspecified addresses, whatever the URL's host
+// resolves to and wherever redirects lead.
+var endpointClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || blockedEndpointIP(ip) {
+					return fmt.Errorf("endpoint address %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+func blockedEndpointIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// checkEndpointURL reports what is wrong with an endpoint URL, or "" if
+// nothing is. Hosts are checked as written; resolved addresses are checked
+// when the endpoint is called.
+func checkEndpointURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "must be an http or https URL"
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return "must not point at the server itself"
+	}
+	if ip := net.ParseIP(host); ip != nil && blockedEndpointIP(ip) {
+		return "must not be a loopback, link-local or unspecified address"
+	}
+	return ""
+}
+
+// headerEnv lists the environment variables endpoint headers may reference
+// as ${VAR}, from the comma-separated FUNCTION_HEADER_ENV.
+func headerEnv() map[string]bool {
+	allowed := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("FUNCTION_HEADER_ENV"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allowed[name] = true
+		}
+	}
+	return allowed
+}
+
+// expandHeader replaces ${VAR} and $VAR in a header value with allowed
+// environment variables. It fails on any other reference rather than leak
+// the server's environment.
+func expandHeader(value string, allowed map[string]bool) (string, error) {
+	var denied []string
+	expanded := os.Expand(value, func(name string) string {
+		if !allowed[name] {
+			denied = append(denied, name)
+			return ""
+		}
+		return os.Getenv(name)
+	})
+	if len(denied) > 0 {
+		return "", fmt.Errorf("not listed in FUNCTION_HEADER_ENV: %s", strings.Join(denied, ", "))
+	}
+	return expanded, nil
+}
+
+// AgentFunction is a Go function that function agents and pipeline steps
+// call by name. Schemas use the shapes encoding/json decodes to, so lists
+// are []interface{}.
+type AgentFunction struct {
+	Name         string                                                                    `json:"name"`
+	Description  string                                                                    `json:"description"`
+	InputSchema  map[string]interface{}                                                    `json:"input_schema,omitempty"`
+	OutputSchema map[string]interface{}                                                    `json:"output_schema,omitempty"`
+	Call         func(ctx context.Context, input json.RawMessage) (json.RawMessage, error) `json:"-"`
+}
+
+// FunctionEndpoint is an external HTTP function.
+type FunctionEndpoint struct {
+	URL            string            `json:"url"`
+	Method         string            `json:"method,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"`
+}
+
+var (
+	agentFunctionsMu sync.RWMutex
+	agentFunctions   = map[string]AgentFunction{}
+)
+
+// RegisterAgentFunction makes fn callable by name. It panics if the name is
+// taken, like database/sql.Register.
+func RegisterAgentFunction(fn AgentFunction) {
+	agentFunctionsMu.Lock()
+	defer agentFunctionsMu.Unlock()
+	if fn.Name == "" || fn.Call == nil {
+		panic("agent function needs a name and a Call")
+	}
+	if _, exists := agentFunctions[fn.Name]; exists {
+		panic(fmt.Sprintf("agent function %q registered twice", fn.Name))
+	}
+	agentFunctions[fn.Name] = fn
+}
+
+func lookupAgentFunction(name string) (AgentFunction, bool) {
+	agentFunctionsMu.RLock()
+	defer agentFunctionsMu.RUnlock()
+	fn, ok := agentFunctions[name]
+	return fn, ok
+}
+
+// ListAgentFunctions serves the registered functions and their schemas.
+func ListAgentFunctions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agentFunctionsMu.RLock()
+		functions := make([]AgentFunction, 0, len(agentFunctions))
+		for _, fn := range agentFunctions {
+			functions = append(functions, fn)
+		}
+		agentFunctionsMu.RUnlock()
+
+		sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+		c.JSON(http.StatusOK, functions)
+	}
+}
+
+func init() {
+	RegisterAgentFunction(AgentFunction{
+		Name:        "echo",
+		Description: "Returns its input unchanged",
+		Call: func(ctx context.Context, input json.RawMessage) (json.RawMessage, error) {
+			return input, nil
+		},
+	})
+	RegisterAgentFunction(AgentFunction{
+		Name:        "current_time",
+		Description: "Returns the current UTC time",
+		OutputSchema: map[string]interface{}{
+			"type":       "object",
+			"required":   []interface{}{"time"},
+			"properties": map[string]interface{}{"time": map[string]interface{}{"type": "string"}},
+		},
+		Call: func(ctx context.Context, input json.RawMessage) (json.RawMessage, error) {
+			return json.Marshal(map[string]string{"time": time.Now().UTC().Format(time.RFC3339)})
+		},
+	})
+}
+
+// callFunction runs a registered function or an HTTP endpoint on input.
+// Input that is valid JSON is passed as is, anything else as a JSON string.
+// The schemas, when given, override those of a registered function.
+func callFunction(name string, endpoint *FunctionEndpoint, input string, inputSchema, outputSchema map[string]interface{}) (string, error) {
+	payload := functionPayload(input)
+
+	var call func(ctx context.Context, input json.RawMessage) (json.RawMessage, error)
+	timeout := defaultFunctionTimeout
+	switch {
+	case name != "":
+		fn, ok := lookupAgentFunction(name)
+		if !ok {
+			return "", fmt.Errorf("unknown function %q", name)
+		}
+		call = fn.Call
+		if inputSchema == nil {
+			inputSchema = fn.InputSchema
+		}
+		if outputSchema == nil {
+			outputSchema = fn.OutputSchema
+		}
+	case endpoint != nil:
+		call = endpoint.call
+		if endpoint.TimeoutSeconds > 0 {
+			timeout = time.Duration(endpoint.TimeoutSeconds) * time.Second
+		}
+		if timeout > maxFunctionTimeout {
+			timeout = maxFunctionTimeout
+		}
+	default:
+		return "", fmt.Errorf("no function or endpoint configured")
+	}
+
+	if inputSchema != nil {
+		if err := validatePayload(inputSchema, payload); err != nil {
+			return "", fmt.Errorf("invalid input: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := call(ctx, payload)
+	if err != nil {
+		return "", err
+	}
+
+	if outputSchema != nil {
+		if !json.Valid(output) {
+			return "", fmt.Errorf("invalid output: not JSON")
+		}
+		if err := validatePayload(outputSchema, output); err != nil {
+			return "", fmt.Errorf("invalid output: %v", err)
+		}
+	}
+	return functionOutput(output), nil
+}
+
+func validatePayload(schema map[string]interface{}, payload json.RawMessage) error {
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return err
+	}
+	return validateJSONSchema(schema, value)
+}
+
+func functionPayload(input string) json.RawMessage {
+	trimmed := strings.TrimSpace(input)
+	if trimmed != "" && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	encoded, _ := json.Marshal(input)
+	return encoded
+}
+
+// functionOutput turns a function result into node output: JSON strings
+// are unquoted, other JSON is compacted and anything else is kept as text.
+func functionOutput(output []byte) string {
+	var s string
+	if err := json.Unmarshal(output, &s); err == nil {
+		return s
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, output); err == nil {
+		return compact.String()
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// call sends the payload as the JSON body, or as ?input= for GET.
+func (e *FunctionEndpoint) call(ctx context.Context, payload json.RawMessage) (json.RawMessage, error) {
+	if msg := checkEndpointURL(e.URL); msg != "" {
+		return nil, fmt.Errorf("endpoint url %s", msg)
+	}
+	method := e.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	var req *http.Request
+	var err error
+	if method == http.MethodGet {
+		u, perr := url.Parse(e.URL)
+		if perr != nil {
+			return nil, perr
+		}
+		query := u.Query()
+		query.Set("input", functionOutput(payload))
+		u.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, method, u.String(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, e.URL, bytes.NewReader(payload))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	allowed := headerEnv()
+	for key, value := range e.Headers {
+		expanded, err := expandHeader(value, allowed)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", key, err)
+		}
+		req.Header.Set(key, expanded)
+	}
+
+	resp, err := endpointClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read endpoint response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("endpoint returned %s: %s", resp.Status, truncateText(string(body), 200))
+	}
+	return body, nil
+}
+
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,511 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tmc/langchaingo/chains"
+)
+
+// Guardrail actions, taken on what a rule's detector finds.
+const (
+	GuardrailRedact       = "redact"       // replace with [NAME]
+	GuardrailPseudonymize = "pseudonymize" // replace with [NAME_1], restored in the reply
+	GuardrailBlock        = "block"        // refuse the call
+	GuardrailLog          = "log"          // log how many were found
+)
+
+// maxPseudonymLen bounds how much streamed text is held back while a
+// pseudonym may be split across chunks.
+const maxPseudonymLen = 32
+
+// GuardrailRule applies an action to the values a detector finds in the
+// prompt and the reply of every model call an agent makes.
+type GuardrailRule struct {
+	Detector string `json:"detector"`
+	Action   string `json:"action"`
+}
+
+// Detector finds sensitive values in text, returning the [start, end) byte
+// offsets of each.
+type Detector func(text string) [][]int
+
+// GuardrailError reports a model call refused by a block rule.
+type GuardrailError struct {
+	Detector string
+	Stage    string // "prompt", "reply" or "embedding input"
+}
+
+func (e *GuardrailError) Error() string {
+	return fmt.Sprintf("blocked by guardrail: the %s contains %s", e.Stage, e.Detector)
+}
+
+const datePattern = `(\d{4}-\d{2}-\d{2}|\d{1,2}/\d{1,2}/\d{2,4}|(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)[a-z]*\.? \d{1,2},? \d{4}|\d{1,2} (?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)[a-z]* \d{4})`
+
+var (
+	detectorsMu sync.RWMutex
+	detectors   = map[string]Detector{
+		// Names after a title, or labelled as in "Patient: ..." and the
+		// patient_name column of lookup rows.
+		"name": patternDetector(
+			`\b(?:Mr|Mrs|Ms|Miss|Dr|Prof)\b\.?\s+([A-Z][a-z'-]+(?:\s+[A-Z][a-z'-]+)*)`,
+			`(?i:\b(?:patient|(?:patient|member|first|last|full|given|family|sur)?[ _]?name))"?\s*[:=]\s*"?([A-Z][a-z'-]+(?:,?\s+[A-Z][a-z'-]+){0,3})`,
+		),
+		// Dates labelled as a date of birth.
+		"dob":   patternDetector(`(?i:\b(?:dob|d\.o\.b\.?|date[ _]of[ _]birth|birth[ _]?date|born(?: on)?))"?\s*[:=]?\s*"?` + datePattern),
+		"mrn":   patternDetector(`(?i)\b(?:mrn|medical[ _]record(?:[ _](?:number|no\.?))?)"?\s*(?:[:=#]\s*"?|is\s+)?((?:[a-z]{1,4}-?)?\d{4,}(?:-\d+)*)\b`),
+		"ssn":   patternDetector(`\b\d{3}-\d{2}-\d{4}\b`, `(?i:\b(?:ssn|social security(?: number)?))"?\s*[:=#]?\s*"?(\d{9})\b`),
+		"email": patternDetector(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+		"phone": patternDetector(`(?:\+1[-. ]?)?(?:\(\d{3}\)\s?|\b\d{3}[-. ])\d{3}[-. ]\d{4}\b`),
+	}
+)
+
+// RegisterDetector makes d usable in guardrail rules by name. It panics if
+// the name is taken, like RegisterAgentFunction.
+func RegisterDetector(name string, d Detector) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	if name == "" || d == nil {
+		panic("detector needs a name and a function")
+	}
+	if _, exists := detectors[name]; exists {
+		panic(fmt.Sprintf("detector %q registered twice", name))
+	}
+	detectors[name] = d
+}
+
+func lookupDetector(name string) (Detector, bool) {
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+	d, ok := detectors[name]
+	return d, ok
+}
+
+// patternDetector finds matches of any of patterns: the first group when
+// the pattern has one, the whole match otherwise.
+func patternDetector(patterns ...string) Detector {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		compiled[i] = regexp.MustCompile(p)
+	}
+	return func(text string) [][]int {
+		var spans [][]int
+		for _, re := range compiled {
+			for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+				if len(m) >= 4 && m[2] >= 0 {
+					spans = append(spans, m[2:4])
+				} else {
+					spans = append(spans, m[:2])
+				}
+			}
+		}
+		return spans
+	}
+}
+
+// checkGuardrails reports rules with unknown detectors or actions.
+func checkGuardrails(rules []GuardrailRule, errs FieldErrors) {
+	seen := map[string]bool{}
+	for i, rule := range rules {
+		path := fmt.Sprintf("config.guardrails[%d]", i)
+		if rule.Action == "" {
+			errs[path+".action"] = "is required"
+		}
+		switch _, ok := lookupDetector(rule.Detector); {
+		case rule.Detector == "":
+			errs[path+".detector"] = "is required"
+		case !ok:
+			errs[path+".detector"] = fmt.Sprintf("unknown detector %q", rule.Detector)
+		case seen[rule.Detector]:
+			errs[path+".detector"] = "has another rule"
+		}
+		seen[rule.Detector] = true
+	}
+}
+
+// GuardedLLMClient applies an agent's guardrails around every call to the
+// client it wraps: before the call the prompt is checked and rewritten,
+// after it the reply is. Embedding input is checked like a reply.
+type GuardedLLMClient struct {
+	LLMClient
+	agent string
+	rules []GuardrailRule
+}
+
+func NewGuardedLLMClient(llm LLMClient, agent string, rules []GuardrailRule) *GuardedLLMClient {
+	return &GuardedLLMClient{LLMClient: llm, agent: agent, rules: rules}
+}
+
+// guardLLM wraps llm in the agent's guardrails. A client guarded for
+// another agent is unwrapped first, so each agent runs under its own rules.
+func guardLLM(llm LLMClient, agent *Agent) LLMClient {
+	if guarded, ok := llm.(*GuardedLLMClient); ok {
+		llm = guarded.LLMClient
+	}
+	if len(agent.Config.Guardrails) == 0 {
+		return llm
+	}
+	return NewGuardedLLMClient(llm, agent.Slug, agent.Config.Guardrails)
+}
+
+func (g *GuardedLLMClient) Complete(messages []Message, model string, temperature float64, maxTokens *int) (string, Usage, error) {
+	scan := g.newScan()
+	messages, err := scan.prompt(messages)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	response, usage, err := g.LLMClient.Complete(messages, model, temperature, maxTokens)
+	if err != nil {
+		return "", usage, err
+	}
+	response, err = scan.reply(response)
+	return response, usage, err
+}
+
+// CompleteWithTools restores pseudonyms in tool call input too, so tools
+// see the real values.
+func (g *GuardedLLMClient) CompleteWithTools(messages []Message, tools []ToolSpec, model string, temperature float64, maxTokens *int) (Message, Usage, error) {
+	scan := g.newScan()
+	messages, err := scan.prompt(messages)
+	if err != nil {
+		return Message{}, Usage{}, err
+	}
+	reply, usage, err := g.LLMClient.CompleteWithTools(messages, tools, model, temperature, maxTokens)
+	if err != nil {
+		return Message{}, usage, err
+	}
+	if reply.Content, err = scan.reply(reply.Content); err != nil {
+		return Message{}, usage, err
+	}
+	for i, call := range reply.ToolCalls {
+		var input interface{}
+		if json.Unmarshal(call.Input, &input) == nil {
+			reply.ToolCalls[i].Input, _ = json.Marshal(scan.restoreValue(input))
+		}
+	}
+	return reply, usage, nil
+}
+
+// CompleteJSON applies the reply rules to each string in the JSON.
+func (g *GuardedLLMClient) CompleteJSON(messages []Message, schema map[string]interface{}, model string, temperature float64, maxTokens *int) (json.RawMessage, Usage, error) {
+	scan := g.newScan()
+	messages, err := scan.prompt(messages)
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	output, usage, err := g.LLMClient.CompleteJSON(messages, schema, model, temperature, maxTokens)
+	if err != nil {
+		return nil, usage, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(output, &value); err != nil {
+		return nil, usage, err
+	}
+	if value, err = scan.replyValue(value); err != nil {
+		return nil, usage, err
+	}
+	output, err = json.Marshal(value)
+	return output, usage, err
+}
+
+// Stream restores pseudonyms as chunks arrive. Replies that redact or block
+// rules apply to cannot be taken back once sent, so with those rules the
+// reply is checked whole and passed to onToken as one chunk.
+func (g *GuardedLLMClient) Stream(ctx context.Context, messages []Message, model string, temperature float64, maxTokens *int, onToken func(string) error) (string, Usage, error) {
+	if g.filtersReply() {
+		response, usage, err := g.Complete(messages, model, temperature, maxTokens)
+		if err != nil {
+			return "", usage, err
+		}
+		if err := onToken(response); err != nil {
+			return "", usage, err
+		}
+		return response, usage, nil
+	}
+
+	scan := g.newScan()
+	messages, err := scan.prompt(messages)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	var pending string
+	response, usage, err := g.LLMClient.Stream(ctx, messages, model, temperature, maxTokens, func(chunk string) error {
+		text := pending + chunk
+		cut := len(text)
+		if i := strings.LastIndex(text, "["); i >= 0 && !strings.Contains(text[i:], "]") && len(text)-i <= maxPseudonymLen {
+			cut = i
+		}
+		pending = text[cut:]
+		if cut == 0 {
+			return nil
+		}
+		return onToken(scan.restore(text[:cut]))
+	})
+	if err != nil {
+		return "", usage, err
+	}
+	if pending != "" {
+		if err := onToken(scan.restore(pending)); err != nil {
+			return "", usage, err
+		}
+	}
+	response, err = scan.reply(response)
+	return response, usage, err
+}
+
+// CreateEmbeddings applies the redact, block and log rules to texts before
+// they are embedded.
+func (g *GuardedLLMClient) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	scan := g.newScan()
+	checked := make([]string, len(texts))
+	for i, text := range texts {
+		var err error
+		if checked[i], err = scan.apply(text, "embedding input"); err != nil {
+			return nil, err
+		}
+	}
+	return g.LLMClient.CreateEmbeddings(ctx, checked)
+}
+
+func (g *GuardedLLMClient) GetChain(prompt string) (chains.Chain, error) {
+	return nil, fmt.Errorf("chain functionality not available with guardrails")
+}
+
+func (g *GuardedLLMClient) newScan() *guardrailScan {
+	return &guardrailScan{
+		agent:      g.agent,
+		rules:      g.rules,
+		pseudonyms: map[string]string{},
+		values:     map[string]string{},
+		counts:     map[string]int{},
+	}
+}
+
+// guardrailScan is the state of one guarded call: the pseudonyms given out
+// in the prompt, to be restored in the reply.
+type guardrailScan struct {
+	agent      string
+	rules      []GuardrailRule
+	pseudonyms map[string]string // pseudonym to value
+	values     map[string]string // detector and value to pseudonym
+	counts     map[string]int    // pseudonyms per detector
+}
+
+type guardrailFinding struct {
+	start, end int
+	rule       GuardrailRule
+}
+
+// find returns the non-overlapping findings of the rules with one of
+// actions, in order. Of overlapping findings the earliest, then longest,
+// is kept.
+func (s *guardrailScan) find(text string, actions ...string) ([]guardrailFinding, error) {
+	var findings []guardrailFinding
+	for _, rule := range s.rules {
+		if !containsString(actions, rule.Action) {
+			continue
+		}
+		detect, ok := lookupDetector(rule.Detector)
+		if !ok {
+			return nil, fmt.Errorf("guardrail uses unknown detector %q", rule.Detector)
+		}
+		for _, span := range detect(text) {
+			if span[0] < span[1] {
+				findings = append(findings, guardrailFinding{start: span[0], end: span[1], rule: rule})
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].start != findings[j].start {
+			return findings[i].start < findings[j].start
+		}
+		return findings[i].end > findings[j].end
+	})
+	kept := findings[:0]
+	for _, f := range findings {
+		if len(kept) == 0 || f.start >= kept[len(kept)-1].end {
+			kept = append(kept, f)
+		}
+	}
+	return kept, nil
+}
+
+// apply rewrites text at stage, which names it in logs and errors.
+// Pseudonymize rules only act on the prompt.
+func (s *guardrailScan) apply(text, stage string) (string, error) {
+	actions := []string{GuardrailRedact, GuardrailBlock, GuardrailLog}
+	if stage == "prompt" {
+		actions = append(actions, GuardrailPseudonymize)
+	}
+	findings, err := s.find(text, actions...)
+	if err != nil || len(findings) == 0 {
+		return text, err
+	}
+
+	var out strings.Builder
+	last := 0
+	logged := map[string]int{}
+	for _, f := range findings {
+		value := text[f.start:f.end]
+		replacement := value
+		switch f.rule.Action {
+		case GuardrailBlock:
+			log.Printf("Guardrail blocked a call of agent %s: the %s contains %s", s.agent, stage, f.rule.Detector)
+			return "", &GuardrailError{Detector: f.rule.Detector, Stage: stage}
+		case GuardrailRedact:
+			replacement = "[" + strings.ToUpper(f.rule.Detector) + "]"
+		case GuardrailPseudonymize:
+			replacement = s.pseudonym(f.rule.Detector, value)
+		case GuardrailLog:
+			logged[f.rule.Detector]++
+		}
+		out.WriteString(text[last:f.start])
+		out.WriteString(replacement)
+		last = f.end
+	}
+	out.WriteString(text[last:])
+	for detector, n := range logged {
+		log.Printf("Guardrail of agent %s found %d %s in the %s", s.agent, n, detector, stage)
+	}
+	return out.String(), nil
+}
+
+// pseudonym returns the stand-in for value, the same one each time it
+// appears in the call.
+func (s *guardrailScan) pseudonym(detector, value string) string {
+	key := detector + "\x00" + value
+	if p, ok := s.values[key]; ok {
+		return p
+	}
+	s.counts[detector]++
+	p := fmt.Sprintf("[%s_%d]", strings.ToUpper(detector), s.counts[detector])
+	s.values[key] = p
+	s.pseudonyms[p] = value
+	return p
+}
+
+// prompt returns a rewritten copy of messages, including tool call input.
+func (s *guardrailScan) prompt(messages []Message) ([]Message, error) {
+	out := make([]Message, len(messages))
+	for i, m := range messages {
+		var err error
+		if m.Content, err = s.apply(m.Content, "prompt"); err != nil {
+			return nil, err
+		}
+		if len(m.ToolCalls) > 0 {
+			m.ToolCalls = append([]ToolCall(nil), m.ToolCalls...)
+			for j, call := range m.ToolCalls {
+				var input interface{}
+				if json.Unmarshal(call.Input, &input) != nil {
+					continue
+				}
+				if input, err = s.applyValue(input, "prompt"); err != nil {
+					return nil, err
+				}
+				m.ToolCalls[j].Input, _ = json.Marshal(input)
+			}
+		}
+		out[i] = m
+	}
+	return out, nil
+}
+
+func (s *guardrailScan) reply(text string) (string, error) {
+	text, err := s.apply(text, "reply")
+	if err != nil {
+		return "", err
+	}
+	return s.restore(text), nil
+}
+
+func (s *guardrailScan) replyValue(value interface{}) (interface{}, error) {
+	value, err := s.applyValue(value, "reply")
+	if err != nil {
+		return nil, err
+	}
+	return s.restoreValue(value), nil
+}
+
+// restore puts the real values back in place of this call's pseudonyms.
+func (s *guardrailScan) restore(text string) string {
+	if len(s.pseudonyms) == 0 || !strings.Contains(text, "[") {
+		return text
+	}
+	for p, value := range s.pseudonyms {
+		text = strings.ReplaceAll(text, p, value)
+	}
+	return text
+}
+
+// applyValue applies the rules of stage to each string in a decoded JSON
+// value.
+func (s *guardrailScan) applyValue(value interface{}, stage string) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case string:
+		return s.apply(v, stage)
+	case []interface{}:
+		for i := range v {
+			if v[i], err = s.applyValue(v[i], stage); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for k := range v {
+			if v[k], err = s.applyValue(v[k], stage); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+func (s *guardrailScan) restoreValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return s.restore(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = s.restoreValue(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = s.restoreValue(v[k])
+		}
+	}
+	return value
+}
+
+// filtersReply reports whether rules can change or refuse a reply beyond
+// restoring pseudonyms.
+func (g *GuardedLLMClient) filtersReply() bool {
+	for _, rule := range g.rules {
+		if rule.Action == GuardrailRedact || rule.Action == GuardrailBlock {
+			return true
+		}
+	}
+	return false
+}
+
+// redactForStorage applies the agent's redact rules to text the agent keeps
+// or passes on outside a guarded model call: stored chat messages and
+// memories, and tool results. Redacted values are not kept.
+func redactForStorage(agent *Agent, message string) string {
+	var rules []GuardrailRule
+	for _, rule := range agent.Config.Guardrails {
+		if rule.Action == GuardrailRedact {
+			rules = append(rules, rule)
+		}
+	}
+	scan := &guardrailScan{agent: agent.Slug, rules: rules}
+	if redacted, err := scan.apply(message, "message"); err == nil {
+		return redacted
+	}
+	return message
+}
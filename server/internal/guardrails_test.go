@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func detected(t *testing.T, detector, text string) []string {
+	t.Helper()
+	detect, ok := lookupDetector(detector)
+	if !ok {
+		t.Fatalf("no detector %q", detector)
+	}
+	var values []string
+	for _, span := range detect(text) {
+		values = append(values, text[span[0]:span[1]])
+	}
+	return values
+}
+
+func TestDetectors(t *testing.T) {
+	tests := []struct {
+		detector string
+		text     string
+		want     []string
+	}{
+		{"name", "Seen by Dr. Jane Doe today", []string{"Jane Doe"}},
+		{"name", "Patient: John Smith, 54", []string{"John Smith"}},
+		{"name", `{"patient_name": "Ann Lee"}`, []string{"Ann Lee"}},
+		{"name", "the name of the game", nil},
+		{"dob", "DOB: 1970-01-31", []string{"1970-01-31"}},
+		{"dob", "born on 3/4/1981", []string{"3/4/1981"}},
+		{"dob", "date of birth Jan 5, 1990", []string{"Jan 5, 1990"}},
+		{"dob", "admitted 2024-02-01", nil},
+		{"mrn", "MRN: 00123456", []string{"00123456"}},
+		{"mrn", "medical record number is AB-123456", []string{"AB-123456"}},
+		{"mrn", "room 1234", nil},
+		{"ssn", "SSN 123-45-6789", []string{"123-45-6789"}},
+		{"ssn", "social security number: 123456789", []string{"123456789"}},
+		{"ssn", "order 123456789", nil},
+		{"email", "write to ada@example.org.", []string{"ada@example.org"}},
+		{"phone", "call (555) 123-4567 or +1 555.123.4567", []string{"(555) 123-4567", "+1 555.123.4567"}},
+		{"phone", "version 1.2.3", nil},
+	}
+	for _, tt := range tests {
+		if got := detected(t, tt.detector, tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s in %q = %q, want %q", tt.detector, tt.text, got, tt.want)
+		}
+	}
+}
+
+func newTestScan(rules ...GuardrailRule) *guardrailScan {
+	return (&GuardedLLMClient{agent: "test", rules: rules}).newScan()
+}
+
+func TestGuardrailActions(t *testing.T) {
+	text := "Reach Ada at ada@example.org, SSN 123-45-6789."
+
+	scan := newTestScan(GuardrailRule{Detector: "email", Action: GuardrailRedact}, GuardrailRule{Detector: "ssn", Action: GuardrailLog})
+	got, err := scan.apply(text, "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Reach Ada at [EMAIL], SSN 123-45-6789."; got != want {
+		t.Errorf("redact: got %q, want %q", got, want)
+	}
+
+	scan = newTestScan(GuardrailRule{Detector: "email", Action: GuardrailPseudonymize})
+	got, err = scan.apply("ada@example.org and bo@example.org, again ada@example.org", "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[EMAIL_1] and [EMAIL_2], again [EMAIL_1]"; got != want {
+		t.Errorf("pseudonymize: got %q, want %q", got, want)
+	}
+	reply, err := scan.reply("Wrote to [EMAIL_2].")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Wrote to bo@example.org."; reply != want {
+		t.Errorf("restore: got %q, want %q", reply, want)
+	}
+
+	scan = newTestScan(GuardrailRule{Detector: "ssn", Action: GuardrailBlock})
+	_, err = scan.apply(text, "reply")
+	var blocked *GuardrailError
+	if !errors.As(err, &blocked) || blocked.Detector != "ssn" || blocked.Stage != "reply" {
+		t.Errorf("block: got %v, want a GuardrailError for ssn in the reply", err)
+	}
+}
+
+func TestGuardedLLMClient(t *testing.T) {
+	script := LLMScript{Rules: []ScriptRule{{Match: `\[EMAIL_1\]`, Response: "Sent to [EMAIL_1]"}}}
+	llm, err := NewScriptedLLMClient(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &Agent{Slug: "mailer", Config: AgentConfig{Guardrails: []GuardrailRule{{Detector: "email", Action: GuardrailPseudonymize}}}}
+
+	reply, _, err := guardLLM(llm, agent).Complete([]Message{{Role: "user", Content: "Email ada@example.org"}}, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Sent to ada@example.org"; reply != want {
+		t.Errorf("reply = %q, want %q", reply, want)
+	}
+	if sent := llm.Calls()[0][0].Content; sent != "Email [EMAIL_1]" {
+		t.Errorf("model saw %q", sent)
+	}
+}
+
+func TestCheckGuardrails(t *testing.T) {
+	errs := FieldErrors{}
+	checkGuardrails([]GuardrailRule{
+		{Detector: "email", Action: GuardrailRedact},
+		{Detector: "email", Action: GuardrailLog},
+		{Detector: "iban", Action: GuardrailBlock},
+		{Detector: "ssn"},
+	}, errs)
+	want := FieldErrors{
+		"config.guardrails[1].detector": "has another rule",
+		"config.guardrails[2].detector": `unknown detector "iban"`,
+		"config.guardrails[3].action":   "is required",
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("got %v, want %v", errs, want)
+	}
+}
+
+// embeddingRecorder records the texts it is asked to embed.
+type embeddingRecorder struct {
+	*ScriptedLLMClient
+	texts []string
+}
+
+func (r *embeddingRecorder) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	r.texts = append(r.texts, texts...)
+	return r.ScriptedLLMClient.CreateEmbeddings(ctx, texts)
+}
+
+func TestGuardedEmbeddings(t *testing.T) {
+	scripted, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	llm := &embeddingRecorder{ScriptedLLMClient: scripted}
+	agent := &Agent{Slug: "indexer", Config: AgentConfig{Guardrails: []GuardrailRule{
+		{Detector: "email", Action: GuardrailRedact},
+		{Detector: "ssn", Action: GuardrailBlock},
+	}}}
+
+	if _, err := guardLLM(llm, agent).CreateEmbeddings(context.Background(), []string{"Ask ada@example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Ask [EMAIL]"}; !reflect.DeepEqual(llm.texts, want) {
+		t.Errorf("embedded %q, want %q", llm.texts, want)
+	}
+	_, err = guardLLM(llm, agent).CreateEmbeddings(context.Background(), []string{"SSN 123-45-6789"})
+	var blocked *GuardrailError
+	if !errors.As(err, &blocked) || blocked.Stage != "embedding input" || len(llm.texts) != 1 {
+		t.Errorf("err = %v, want the text blocked before it is embedded", err)
+	}
+}
+
+func TestFunctionAndToolOutputsAreRedacted(t *testing.T) {
+	rules := []GuardrailRule{{Detector: "email", Action: GuardrailRedact}}
+	inv, llm := newTestInvoker(t, LLMScript{Rules: []ScriptRule{
+		{Call: &ScriptToolCall{Tool: "test_upper", Input: map[string]interface{}{"input": "ada@example.org"}}},
+	}})
+
+	agent := &Agent{Type: TypeFunction, Config: AgentConfig{Function: "echo", Guardrails: rules}}
+	if got, err := inv.Invoke(agent, AgentRequest{Input: `"ada@example.org"`}); err != nil || got != "[EMAIL]" {
+		t.Errorf("function output = %q, %v; want the address redacted", got, err)
+	}
+
+	agent = &Agent{Type: TypeLLM, Config: AgentConfig{Tools: []string{"test_upper"}, Guardrails: rules}}
+	if _, err := inv.Invoke(agent, AgentRequest{Messages: []Message{{Role: "user", Content: "go"}}}); err != nil {
+		t.Fatal(err)
+	}
+	calls := llm.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("model called %d times, want 2", len(calls))
+	}
+	if result := calls[1][len(calls[1])-1]; result.Role != "tool" || !strings.Contains(result.Content, "[EMAIL]") {
+		t.Errorf("tool result = %+v, want the address redacted", result)
+	}
+}
+
+func TestExtractedMemoriesAreRedacted(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{
+		{System: "long-term memory", Response: `{"facts": ["The user's email is ada@example.org"]}`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &Agent{ID: "a1", Type: TypeLLM, Config: AgentConfig{UseMemory: true, Guardrails: []GuardrailRule{{Detector: "email", Action: GuardrailRedact}}}}
+	conv := &Conversation{ID: "c1", Owner: "alice"}
+
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT id, content, embedding FROM agent_memories`).
+		WithArgs("a1", "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "content", "embedding"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO agent_memories`).
+		WithArgs("a1", "alice", "The user's email is [EMAIL]", sqlmock.AnyArg(), "c1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("m1"))
+	mock.ExpectCommit()
+
+	if err := extractMemories(context.Background(), db, llm, agent, conv, "Mail me at ada@example.org", "Will do."); err != nil {
+		t.Fatal(err)
+	}
+}
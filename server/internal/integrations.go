@@ -15,6 +15,7 @@ type Integration struct {
 	Type        string                 `json:"type"`
 	Description string                 `json:"description"`
 	Config      map[string]interface{} `json:"config"`
+	Version     int                    `json:"version,omitempty"`
 }
 
 var AvailableIntegrations = []Integration{
@@ -40,34 +41,36 @@ func ListAvailableIntegrations(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-func ListIntegrations(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		rows, err := db.Query(`
-			SELECT id, name, provider, type, description, config
-			FROM integrations
-		`)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch integrations"})
-			return
-		}
-		defer rows.Close()
-
-		var integrations []Integration
-		for rows.Next() {
-			var integration Integration
-			var configJSON []byte
-			if err := rows.Scan(&integration.ID, &integration.Name, &integration.Provider, &integration.Type,
-				&integration.Description, &configJSON); err != nil {
-				continue
-			}
-			if err := json.Unmarshal(configJSON, &integration.Config); err != nil {
-				continue
-			}
-			integrations = append(integrations, integration)
-		}
+const integrationColumns = `id, name, provider, type, COALESCE(description, ''), config, version`
 
-		c.JSON(http.StatusOK, integrations)
+func scanIntegration(row rowScanner) (*Integration, error) {
+	var integration Integration
+	var configJSON []byte
+	if err := row.Scan(&integration.ID, &integration.Name, &integration.Provider, &integration.Type,
+		&integration.Description, &configJSON, &integration.Version); err != nil {
+		return nil, err
+	}
+	if len(configJSON) > 0 {
+		if err := json.Unmarshal(configJSON, &integration.Config); err != nil {
+			return nil, err
+		}
 	}
+	return &integration, nil
+}
+
+var integrationListSpec = listSpec{
+	table:       "integrations",
+	columns:     integrationColumns,
+	sorts:       map[string]string{"name": "name", "provider": "provider", "created_at": "created_at", "updated_at": "updated_at"},
+	defaultSort: "name",
+	filters:     map[string]string{"provider": "provider", "type": "type"},
+	search:      []string{"name", "description"},
+}
+
+// ListIntegrations serves GET /integrations with pagination, ?provider=,
+// ?type= and ?q= search.
+func ListIntegrations(db *sql.DB) gin.HandlerFunc {
+	return listHandler(db, integrationListSpec, scanIntegration, "integrations")
 }
 
 func GetIntegration(db *sql.DB) gin.HandlerFunc {
@@ -77,11 +80,11 @@ func GetIntegration(db *sql.DB) gin.HandlerFunc {
 		var configJSON []byte
 
 		err := db.QueryRow(`
-			SELECT id, name, provider, type, description, config
+			SELECT `+integrationColumns+`
 			FROM integrations WHERE id = $1`,
 			id,
 		).Scan(&integration.ID, &integration.Name, &integration.Provider, &integration.Type,
-			&integration.Description, &configJSON)
+			&integration.Description, &configJSON, &integration.Version)
 
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Integration not found"})
@@ -102,15 +105,17 @@ func GetIntegration(db *sql.DB) gin.HandlerFunc {
 			}
 			// Convert to map[string]interface{}
 			integration.Config = map[string]interface{}{
-				"account":   snowflakeConfig.Account,
-				"username":  snowflakeConfig.Username,
-				"password":  snowflakeConfig.Password,
-				"database":  snowflakeConfig.Database,
-				"schema":    snowflakeConfig.Schema,
-				"warehouse": snowflakeConfig.Warehouse,
+				"account":        snowflakeConfig.Account,
+				"username":       snowflakeConfig.Username,
+				"password":       snowflakeConfig.Password,
+				"database":       snowflakeConfig.Database,
+				"schema":         snowflakeConfig.Schema,
+				"warehouse":      snowflakeConfig.Warehouse,
+				"read_only_role": snowflakeConfig.ReadOnlyRole,
 			}
 		}
 
+		setETag(c, integration.Version)
 		c.JSON(http.StatusOK, integration)
 	}
 }
@@ -131,28 +136,40 @@ func UpdateIntegration(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		result, err := db.Exec(`
-			UPDATE integrations 	
-			SET name = $1, type = $2, provider = $3, description = $4, config = $5
-			WHERE id = $6`,
-			integration.Name, integration.Type, integration.Provider, integration.Description, configJSON, id,
-		)
+		expected, err := ifMatchVersion(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update integration"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		rows, err := result.RowsAffected()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rows affected"})
+		// The provider is fixed when the integration is created.
+		err = db.QueryRow(`
+			UPDATE integrations
+			SET name = $1, type = $2, description = $3, config = $4, version = version + 1
+			WHERE id = $5 AND ($6::int IS NULL OR version = $6)
+			RETURNING id, provider, version`,
+			integration.Name, integration.Type, integration.Description, configJSON, id, expected,
+		).Scan(&integration.ID, &integration.Provider, &integration.Version)
+		if err == sql.ErrNoRows {
+			// Either the integration is gone or If-Match named a stale version.
+			current, err := scanIntegration(db.QueryRow(`SELECT `+integrationColumns+` FROM integrations WHERE id = $1`, id))
+			if err == sql.ErrNoRows {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Integration not found"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch integration"})
+				return
+			}
+			respondVersionConflict(c, "Integration", current.Version, current)
 			return
 		}
-		if rows == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Integration not found"})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update integration"})
 			return
 		}
 
-		integration.Provider = id
+		setETag(c, integration.Version)
 		c.JSON(http.StatusOK, integration)
 	}
 }
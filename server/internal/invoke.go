@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Pipeline step types of custom agents.
+const (
+	StepPrompt   = "prompt"   // complete a prompt with the agent's narrative
+	StepFunction = "function" // call a registered function or endpoint
+)
+
+type PipelineStep struct {
+	Type     string            `json:"type"`
+	Prompt   string            `json:"prompt,omitempty"`
+	Function string            `json:"function,omitempty"`
+	Endpoint *FunctionEndpoint `json:"endpoint,omitempty"`
+}
+
+// AgentRequest is one turn addressed to an agent.
+type AgentRequest struct {
+	// Messages is the full prompt for LLM agents, system narrative first.
+	Messages []Message
+	// Input is the user turn on its own, which function and custom agents
+	// work from.
+	Input string
+}
+
+// Invoker runs a turn against an agent of any type. Chat and workflow
+// nodes both go through it.
+type Invoker struct {
+	llm   LLMClient
+	tools *ToolRegistry
+}
+
+func NewInvoker(llm LLMClient, tools *ToolRegistry) *Invoker {
+	return &Invoker{llm: llm, tools: tools}
+}
+
+// Invoke runs a turn. Agents with an output schema reply with JSON matching
+// it.
+func (inv *Invoker) Invoke(agent *Agent, req AgentRequest) (string, error) {
+	inv = inv.forAgent(agent)
+	switch agent.Type {
+	case TypeLLM:
+		params := agent.Config.modelParams(inv.llm.Defaults())
+		schema := agent.Config.OutputSchema
+		if len(agent.Config.Tools) > 0 && inv.tools != nil {
+			response, err := inv.completeWithTools(agent, req.Messages, params)
+			if err != nil || schema == nil {
+				return response, err
+			}
+			return structuredReply(inv.llm, req.Messages, response, schema, params)
+		}
+		if schema != nil {
+			response, _, err := inv.llm.CompleteJSON(req.Messages, schema, params.Model, params.Temperature, &params.MaxTokens)
+			return string(response), err
+		}
+		response, _, err := inv.llm.Complete(req.Messages, params.Model, params.Temperature, &params.MaxTokens)
+		return response, err
+	case TypeFunction:
+		cfg := agent.Config
+		output, err := callFunction(cfg.Function, cfg.Endpoint, req.Input, cfg.InputSchema, cfg.OutputSchema)
+		if err != nil {
+			return "", err
+		}
+		return redactForStorage(agent, output), nil
+	case TypeCustom:
+		return inv.runPipeline(agent, req.Input)
+	default:
+		return "", fmt.Errorf("unsupported agent type %q", agent.Type)
+	}
+}
+
+// Stream runs a turn like Invoke, passing the reply to onToken as it is
+// generated. Only plain LLM agents stream token by token; tool-using,
+// structured, function and custom agents deliver their reply as one chunk.
+func (inv *Invoker) Stream(ctx context.Context, agent *Agent, req AgentRequest, onToken func(string) error) (string, error) {
+	inv = inv.forAgent(agent)
+	if agent.Type == TypeLLM && (len(agent.Config.Tools) == 0 || inv.tools == nil) && agent.Config.OutputSchema == nil {
+		params := agent.Config.modelParams(inv.llm.Defaults())
+		response, _, err := inv.llm.Stream(ctx, req.Messages, params.Model, params.Temperature, &params.MaxTokens, onToken)
+		return response, err
+	}
+
+	response, err := inv.Invoke(agent, req)
+	if err != nil {
+		return "", err
+	}
+	if err := onToken(response); err != nil {
+		return "", err
+	}
+	return response, nil
+}
+
+// forAgent returns the invoker with the agent's guardrails around its model.
+func (inv *Invoker) forAgent(agent *Agent) *Invoker {
+	return &Invoker{llm: guardLLM(inv.llm, agent), tools: inv.tools}
+}
+
+// completeWithTools lets the model call the agent's allowed tools until it
+// answers without requesting any. Once the step limit is reached the model
+// is asked once more without tools, so it answers from what it has.
+func (inv *Invoker) completeWithTools(agent *Agent, messages []Message, params ModelParams) (string, error) {
+	tools := inv.tools.allowed(agent.Config.Tools)
+	specs := make([]ToolSpec, len(tools))
+	for i, tool := range tools {
+		specs[i] = tool.spec()
+	}
+	steps := agent.Config.MaxToolSteps
+	if steps <= 0 {
+		steps = defaultMaxToolSteps
+	}
+
+	messages = append([]Message(nil), messages...)
+	for step := 0; ; step++ {
+		offered := specs
+		if step >= steps {
+			offered = nil
+		}
+		reply, _, err := inv.llm.CompleteWithTools(messages, offered, params.Model, params.Temperature, &params.MaxTokens)
+		if err != nil {
+			return "", err
+		}
+		if len(reply.ToolCalls) == 0 || offered == nil {
+			return reply.Content, nil
+		}
+
+		for i := range reply.ToolCalls {
+			if len(reply.ToolCalls[i].Input) == 0 {
+				reply.ToolCalls[i].Input = json.RawMessage("{}")
+			}
+		}
+		messages = append(messages, reply)
+		for _, call := range reply.ToolCalls {
+			result := redactForStorage(agent, inv.tools.call(tools, call))
+			messages = append(messages, Message{Role: "tool", ToolCallID: call.ID, Content: result})
+		}
+	}
+}
+
+// runPipeline runs a custom agent's steps, feeding each output into the
+// next step. An agent without steps completes its input as one prompt. With
+// an output schema the last step must produce matching JSON: a prompt step
+// is completed as structured output, a function step's output is checked.
+func (inv *Invoker) runPipeline(agent *Agent, input string) (string, error) {
+	steps := agent.Config.Steps
+	if len(steps) == 0 {
+		steps = []PipelineStep{{Type: StepPrompt}}
+	}
+	params := agent.Config.modelParams(inv.llm.Defaults())
+	schema := agent.Config.OutputSchema
+
+	current := input
+	for i, step := range steps {
+		var output string
+		var err error
+		switch step.Type {
+		case StepPrompt:
+			messages := []Message{
+				{Role: "system", Content: agent.Narrative},
+				{Role: "user", Content: renderStepPrompt(step.Prompt, current, input)},
+			}
+			if schema != nil && i == len(steps)-1 {
+				var structured json.RawMessage
+				structured, _, err = inv.llm.CompleteJSON(messages, schema, params.Model, params.Temperature, &params.MaxTokens)
+				output = string(structured)
+			} else {
+				output, _, err = inv.llm.Complete(messages, params.Model, params.Temperature, &params.MaxTokens)
+			}
+		case StepFunction:
+			var outputSchema map[string]interface{}
+			if i == len(steps)-1 {
+				outputSchema = schema
+			}
+			if step.Function == "" {
+				output, err = callFunction("", step.Endpoint, current, nil, outputSchema)
+				break
+			}
+			if inv.tools == nil {
+				err = fmt.Errorf("tools are not available")
+				break
+			}
+			output, err = inv.tools.runStep(step.Function, current)
+			if err == nil && outputSchema != nil {
+				if verr := validatePayload(outputSchema, functionPayload(output)); verr != nil {
+					err = fmt.Errorf("invalid output: %v", verr)
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown step type %q", step.Type)
+		}
+		if err != nil {
+			return "", fmt.Errorf("step %d: %v", i+1, err)
+		}
+		if step.Type == StepFunction {
+			output = redactForStorage(agent, output)
+		}
+		current = output
+	}
+	return current, nil
+}
+
+// renderStepPrompt fills {{input}} with the previous step's output and
+// {{original}} with the agent's input, in one pass so neither value is
+// searched for placeholders. A prompt without {{input}} gets the previous
+// output appended.
+func renderStepPrompt(prompt, current, original string) string {
+	if prompt == "" {
+		return current
+	}
+	rendered := strings.NewReplacer("{{input}}", current, "{{original}}", original).Replace(prompt)
+	if !strings.Contains(prompt, "{{input}}") {
+		return rendered + "\n\n" + current
+	}
+	return rendered
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterAgentFunction(AgentFunction{
+		Name: "test_upper",
+		Call: func(ctx context.Context, input json.RawMessage) (json.RawMessage, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				return nil, fmt.Errorf("called without a deadline")
+			}
+			var s string
+			if err := json.Unmarshal(input, &s); err != nil {
+				return nil, err
+			}
+			return json.Marshal(strings.ToUpper(s))
+		},
+	})
+	RegisterAgentFunction(AgentFunction{
+		Name: "test_count",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"required":   []interface{}{"items"},
+			"properties": map[string]interface{}{"items": map[string]interface{}{"type": "array"}},
+		},
+		OutputSchema: map[string]interface{}{"type": "integer"},
+		Call: func(ctx context.Context, input json.RawMessage) (json.RawMessage, error) {
+			var args struct {
+				Items []interface{} `json:"items"`
+			}
+			json.Unmarshal(input, &args)
+			return json.Marshal(len(args.Items))
+		},
+	})
+}
+
+func newTestInvoker(t *testing.T, script LLMScript) (*Invoker, *ScriptedLLMClient) {
+	t.Helper()
+	llm, err := NewScriptedLLMClient(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewInvoker(llm, NewToolRegistry(nil, llm)), llm
+}
+
+func TestRenderStepPrompt(t *testing.T) {
+	tests := []struct {
+		name, prompt, current, original, want string
+	}{
+		{"no prompt", "", "prev", "orig", "prev"},
+		{"both placeholders", "{{original}}: {{input}}", "prev", "orig", "orig: prev"},
+		{"input appended", "Summarise {{original}}", "prev", "orig", "Summarise orig\n\nprev"},
+		{"values are not rescanned", "{{original}} / {{input}}", "{{original}}", "{{input}}", "{{input}} / {{original}}"},
+	}
+	for _, tt := range tests {
+		if got := renderStepPrompt(tt.prompt, tt.current, tt.original); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCustomAgentPipeline(t *testing.T) {
+	inv, llm := newTestInvoker(t, LLMScript{})
+	agent := &Agent{Slug: "digest", Type: TypeCustom, Narrative: "Digest.", Config: AgentConfig{Steps: []PipelineStep{
+		{Type: StepFunction, Function: "test_upper"},
+		{Type: StepPrompt, Prompt: "Shout back {{input}} for {{original}}"},
+	}}}
+
+	got, err := inv.Invoke(agent, AgentRequest{Input: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "echo: Shout back HELLO for hello"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if calls := llm.Calls(); len(calls) != 1 || calls[0][0].Content != "Digest." {
+		t.Errorf("calls = %v, want one prompt with the narrative", calls)
+	}
+}
+
+func TestCustomAgentStepsRunThroughRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    PipelineStep
+		input   string
+		schema  map[string]interface{}
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "object input",
+			step:  PipelineStep{Type: StepFunction, Function: "test_count"},
+			input: `{"items": [1, 2, 3]}`,
+			want:  "3",
+		},
+		{
+			name:    "input checked against the tool schema",
+			step:    PipelineStep{Type: StepFunction, Function: "test_count"},
+			input:   "not an object",
+			wantErr: "invalid input",
+		},
+		{
+			name:    "unavailable tools are refused",
+			step:    PipelineStep{Type: StepFunction, Function: "snowflake_query"},
+			input:   `{"query": "DELETE FROM orders"}`,
+			wantErr: `tool "snowflake_query" is not available`,
+		},
+		{
+			name:    "last step checked against the agent's output schema",
+			step:    PipelineStep{Type: StepFunction, Function: "test_upper"},
+			input:   "hello",
+			schema:  map[string]interface{}{"type": "object"},
+			wantErr: "invalid output",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, _ := newTestInvoker(t, LLMScript{})
+			agent := &Agent{Type: TypeCustom, Config: AgentConfig{Steps: []PipelineStep{tt.step}, OutputSchema: tt.schema}}
+
+			got, err := inv.Invoke(agent, AgentRequest{Input: tt.input})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFunctionAgent(t *testing.T) {
+	inv, _ := newTestInvoker(t, LLMScript{})
+
+	agent := &Agent{Type: TypeFunction, Config: AgentConfig{Function: "echo"}}
+	if got, err := inv.Invoke(agent, AgentRequest{Input: ` {"a": 1} `}); err != nil || got != `{"a":1}` {
+		t.Errorf("echo = %q, %v; want the JSON compacted", got, err)
+	}
+
+	agent.Config.InputSchema = map[string]interface{}{"type": "object"}
+	if _, err := inv.Invoke(agent, AgentRequest{Input: "plain text"}); err == nil || !strings.Contains(err.Error(), "invalid input") {
+		t.Errorf("err = %v, want the input schema enforced", err)
+	}
+
+	agent = &Agent{Type: TypeFunction, Config: AgentConfig{Endpoint: &FunctionEndpoint{URL: "http://127.0.0.1:9/fn"}}}
+	if _, err := inv.Invoke(agent, AgentRequest{Input: "x"}); err == nil || !strings.Contains(err.Error(), "endpoint url") {
+		t.Errorf("err = %v, want loopback endpoints refused", err)
+	}
+}
+
+func TestCheckCustomAgentSteps(t *testing.T) {
+	_, errs := parseAgentConfig(TypeCustom, map[string]interface{}{"steps": []interface{}{
+		map[string]interface{}{"type": "function", "function": "snowflake_query"},
+		map[string]interface{}{"type": "function", "function": "no_such_function"},
+		map[string]interface{}{"type": "prompt", "prompt": "{{input}}"},
+	}})
+	if len(errs) != 1 || errs["config.steps[1].function"] == "" {
+		t.Errorf("errors = %v, want only the unknown function reported", errs)
+	}
+}
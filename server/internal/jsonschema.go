@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// validateJSONSchema checks a value decoded from JSON against the subset of
+// JSON Schema that agent inputs and outputs use: type, enum, required,
+// properties, additionalProperties (true or false), items (one schema),
+// minimum and maximum. Schemas are checked with checkJSONSchema when saved,
+// so other keywords never reach it.
+func validateJSONSchema(schema map[string]interface{}, value interface{}) error {
+	return checkSchema(schema, value, "$")
+}
+
+// schemaKeywords are the keywords validateJSONSchema enforces, and the
+// annotations it can safely ignore.
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "required": true, "properties": true,
+	"additionalProperties": true, "items": true, "minimum": true, "maximum": true,
+	"title": true, "description": true, "default": true, "examples": true, "$schema": true,
+}
+
+var schemaTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true, "object": true, "array": true, "null": true,
+}
+
+// checkJSONSchema reports the first part of schema that validateJSONSchema
+// would not enforce, such as pattern, oneOf or $ref, so that saving it fails
+// instead of the keyword being ignored.
+func checkJSONSchema(schema map[string]interface{}) error {
+	return checkSchemaKeywords(schema, "")
+}
+
+func checkSchemaKeywords(schema map[string]interface{}, path string) error {
+	at := func(format string, args ...interface{}) error {
+		msg := fmt.Sprintf(format, args...)
+		if path == "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return fmt.Errorf("%s: %s", path, msg)
+	}
+
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !schemaKeywords[key] {
+			return at("unsupported keyword %q", key)
+		}
+	}
+
+	if t, ok := schema["type"]; ok {
+		names, isList := t.([]interface{})
+		if !isList {
+			names = []interface{}{t}
+		}
+		for _, name := range names {
+			if s, _ := name.(string); !schemaTypes[s] {
+				return at("unknown type %v", name)
+			}
+		}
+	}
+	if enum, ok := schema["enum"]; ok {
+		if _, ok := enum.([]interface{}); !ok {
+			return at("enum must be a list")
+		}
+	}
+	if required, ok := schema["required"]; ok {
+		names, ok := required.([]interface{})
+		if !ok {
+			return at("required must be a list of property names")
+		}
+		for _, name := range names {
+			if _, ok := name.(string); !ok {
+				return at("required must be a list of property names")
+			}
+		}
+	}
+	for _, key := range []string{"minimum", "maximum"} {
+		if v, ok := schema[key]; ok {
+			if _, ok := schemaNumber(v); !ok {
+				return at("%s must be a number", key)
+			}
+		}
+	}
+	if additional, ok := schema["additionalProperties"]; ok {
+		if _, ok := additional.(bool); !ok {
+			return at("additionalProperties must be true or false")
+		}
+	}
+
+	if raw, ok := schema["properties"]; ok {
+		properties, ok := raw.(map[string]interface{})
+		if !ok {
+			return at("properties must be an object")
+		}
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := properties[name].(map[string]interface{})
+			if !ok {
+				return at("properties.%s must be a schema object", name)
+			}
+			if err := checkSchemaKeywords(property, joinSchemaPath(path, "properties."+name)); err != nil {
+				return err
+			}
+		}
+	}
+	if raw, ok := schema["items"]; ok {
+		items, ok := raw.(map[string]interface{})
+		if !ok {
+			return at("items must be a single schema object")
+		}
+		if err := checkSchemaKeywords(items, joinSchemaPath(path, "items")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func checkSchema(schema map[string]interface{}, value interface{}, path string) error {
+	if t, ok := schema["type"]; ok && !matchesSchemaType(t, value) {
+		return fmt.Errorf("%s: must be of type %v", path, t)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: must be one of %v", path, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if min, ok := schemaNumber(schema["minimum"]); ok && v < min {
+			return fmt.Errorf("%s: must be at least %g", path, min)
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && v > max {
+			return fmt.Errorf("%s: must be at most %g", path, max)
+		}
+
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if key, _ := name.(string); key != "" {
+					if _, present := v[key]; !present {
+						return fmt.Errorf("%s.%s: is required", path, key)
+					}
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := properties[key].(map[string]interface{})
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s.%s: is not allowed", path, key)
+				}
+				continue
+			}
+			if err := checkSchema(property, v[key], path+"."+key); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := checkSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// schemaNumber reads a numeric keyword, which schemas written in Go may
+// give as an int.
+func schemaNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// matchesSchemaType reports whether value has the schema type t, which is a
+// type name or a list of them.
+func matchesSchemaType(t interface{}, value interface{}) bool {
+	if list, ok := t.([]interface{}); ok {
+		for _, name := range list {
+			if matchesSchemaType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	name, _ := t.(string)
+	switch strings.ToLower(name) {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return true
+	}
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const ticketSchema = `{
+	"type": "object",
+	"required": ["priority", "tags"],
+	"additionalProperties": false,
+	"properties": {
+		"priority": {"type": "string", "enum": ["low", "medium", "high"]},
+		"score": {"type": "number", "minimum": 0, "maximum": 1},
+		"count": {"type": "integer"},
+		"owner": {"type": ["string", "null"]},
+		"tags": {"type": "array", "items": {"type": "string"}}
+	}
+}`
+
+func decodeTestJSON(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := decodeTestJSON(t, ticketSchema)
+	tests := []struct {
+		value string
+		want  string // "" for valid, else part of the error
+	}{
+		{`{"priority": "high", "tags": []}`, ""},
+		{`{"priority": "low", "tags": ["a"], "score": 0.5, "count": 3, "owner": null}`, ""},
+		{`{"priority": "low", "tags": [], "owner": "ada"}`, ""},
+		{`{"tags": []}`, "$.priority: is required"},
+		{`{"priority": "urgent", "tags": []}`, "$.priority: must be one of"},
+		{`{"priority": "low", "tags": [], "score": 2}`, "$.score: must be at most 1"},
+		{`{"priority": "low", "tags": [], "score": -1}`, "$.score: must be at least 0"},
+		{`{"priority": "low", "tags": [], "count": 1.5}`, "$.count: must be of type integer"},
+		{`{"priority": "low", "tags": [1]}`, "$.tags[0]: must be of type string"},
+		{`{"priority": "low", "tags": [], "extra": true}`, "$.extra: is not allowed"},
+		{`{"priority": "low", "tags": [], "owner": 7}`, "$.owner: must be of type"},
+	}
+	for _, tt := range tests {
+		var value interface{}
+		if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+			t.Fatal(err)
+		}
+		err := validateJSONSchema(schema, value)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.value, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: got %v, want an error containing %q", tt.value, err, tt.want)
+		}
+	}
+
+	if err := validateJSONSchema(schema, []interface{}{}); err == nil {
+		t.Error("an array should not match an object schema")
+	}
+}
+
+func TestCheckJSONSchema(t *testing.T) {
+	if err := checkJSONSchema(decodeTestJSON(t, ticketSchema)); err != nil {
+		t.Errorf("supported schema rejected: %v", err)
+	}
+	if err := checkJSONSchema(decodeTestJSON(t, `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "T", "description": "d", "type": "string", "default": "x", "examples": ["y"]}`)); err != nil {
+		t.Errorf("annotations rejected: %v", err)
+	}
+
+	tests := []struct {
+		schema string
+		want   string
+	}{
+		{`{"type": "string", "minLength": 1}`, `unsupported keyword "minLength"`},
+		{`{"type": "string", "pattern": "^a"}`, `unsupported keyword "pattern"`},
+		{`{"type": "string", "format": "date"}`, `unsupported keyword "format"`},
+		{`{"oneOf": [{"type": "string"}]}`, `unsupported keyword "oneOf"`},
+		{`{"anyOf": [{"type": "string"}]}`, `unsupported keyword "anyOf"`},
+		{`{"$ref": "#/definitions/x"}`, `unsupported keyword "$ref"`},
+		{`{"properties": {"a": {"properties": {"b": {"pattern": "x"}}}}}`, `properties.a.properties.b: unsupported keyword "pattern"`},
+		{`{"items": {"format": "email"}}`, `items: unsupported keyword "format"`},
+		{`{"items": [{"type": "string"}]}`, "items must be a single schema object"},
+		{`{"additionalProperties": {"type": "string"}}`, "additionalProperties must be true or false"},
+		{`{"type": "text"}`, "unknown type text"},
+		{`{"required": "a"}`, "required must be a list"},
+		{`{"minimum": "1"}`, "minimum must be a number"},
+	}
+	for _, tt := range tests {
+		err := checkJSONSchema(decodeTestJSON(t, tt.schema))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.schema, err, tt.want)
+		}
+	}
+}
+
+func TestSchemaFieldErrors(t *testing.T) {
+	_, errs := parseAgentConfig(TypeFunction, map[string]interface{}{
+		"function":      "echo",
+		"input_schema":  map[string]interface{}{"type": "object"},
+		"output_schema": map[string]interface{}{"type": "string", "pattern": "^a"},
+	})
+	if want := `unsupported keyword "pattern"`; errs["config.output_schema"] != want {
+		t.Errorf("config.output_schema error = %q, want %q", errs["config.output_schema"], want)
+	}
+	if _, ok := errs["config.input_schema"]; ok {
+		t.Errorf("unexpected input_schema error %q", errs["config.input_schema"])
+	}
+
+	dag := Dag{Nodes: []DagNode{{ID: "a", Type: NodeTypeAgent, AgentID: "writer", Configuration: map[string]interface{}{
+		"outputSchema": map[string]interface{}{"anyOf": []interface{}{}},
+	}}}}
+	if err := dag.Validate(); err == nil || !strings.Contains(err.Error(), `outputSchema: unsupported keyword "anyOf"`) {
+		t.Errorf("Validate() = %v, want an outputSchema error", err)
+	}
+}
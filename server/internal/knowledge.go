@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Retrievers an agent's rag settings can name.
+const (
+	RetrieverPostgres  = "postgres"  // the agent_documents table, one store per agent
+	RetrieverSnowflake = "snowflake" // a table in the connected Snowflake warehouse
+)
+
+const (
+	defaultRAGTopK      = 4
+	defaultRAGThreshold = 0.3
+	maxRAGTopK          = 20
+)
+
+// RAGSettings control retrieval for agents with use_rag set.
+type RAGSettings struct {
+	Retriever string   `json:"retriever,omitempty"`
+	TopK      int      `json:"top_k,omitempty"`
+	Threshold *float64 `json:"threshold,omitempty"`
+	// Table is the Snowflake table searched by the snowflake retriever.
+	Table string `json:"table,omitempty"`
+}
+
+// resolved fills in the defaults of unset settings.
+func (s *RAGSettings) resolved() RAGSettings {
+	settings := RAGSettings{Retriever: RetrieverPostgres, TopK: defaultRAGTopK}
+	if s != nil {
+		settings = *s
+	}
+	if settings.Retriever == "" {
+		settings.Retriever = RetrieverPostgres
+	}
+	if settings.TopK <= 0 {
+		settings.TopK = defaultRAGTopK
+	}
+	if settings.Threshold == nil {
+		settings.Threshold = bound(defaultRAGThreshold)
+	}
+	return settings
+}
+
+var snowflakeTablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*){0,2}$`)
+
+// newRetriever opens the knowledge store of agent, embedding under the
+// agent's guardrails. Close releases any connection it holds.
+func newRetriever(db *sql.DB, llmClient LLMClient, agent *Agent) (retriever Retriever, close func(), err error) {
+	llmClient = guardLLM(llmClient, agent)
+	settings := agent.Config.RAG.resolved()
+	switch settings.Retriever {
+	case RetrieverPostgres:
+		if db == nil {
+			return nil, nil, fmt.Errorf("the postgres retriever needs a database")
+		}
+		return &PostgresRetriever{db: db, agentID: agent.ID, llmClient: llmClient}, func() {}, nil
+	case RetrieverSnowflake:
+		if db == nil {
+			return nil, nil, fmt.Errorf("Snowflake is not connected")
+		}
+		snowflakeDB, err := openSnowflake(db)
+		if err != nil {
+			return nil, nil, err
+		}
+		retriever := NewSnowflakeRetriever(snowflakeDB, settings.Table, "snowflake", llmClient, embeddingDim)
+		return retriever, func() { snowflakeDB.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown retriever %q", settings.Retriever)
+	}
+}
+
+// retrieveForAgent finds the documents relevant to query in the agent's
+// knowledge store.
+func retrieveForAgent(ctx context.Context, db *sql.DB, llmClient LLMClient, agent *Agent, query string) ([]Document, error) {
+	retriever, close, err := newRetriever(db, llmClient, agent)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+
+	settings := agent.Config.RAG.resolved()
+	docs, err := retriever.FindSimilar(ctx, query, settings.TopK, *settings.Threshold)
+	if err != nil {
+		return nil, fmt.Errorf("retrieval failed: %v", err)
+	}
+	return docs, nil
+}
+
+func documentIDs(docs []Document) []string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return ids
+}
+
+// PostgresRetriever keeps an agent's documents and their embeddings in
+// agent_documents and ranks them in process.
+type PostgresRetriever struct {
+	db        *sql.DB
+	agentID   string
+	llmClient LLMClient
+}
+
+func (r *PostgresRetriever) FindSimilar(ctx context.Context, query string, k int, threshold float64) ([]Document, error) {
+	queryEmbeddings, err := r.llmClient.CreateEmbeddings(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+	}
+	queryEmbedding := queryEmbeddings[0]
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, content, metadata, embedding FROM agent_documents
+		WHERE agent_id = $1`, r.agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []Document
+	for rows.Next() {
+		var doc Document
+		var metadataJSON []byte
+		var embedding pq.Float32Array
+		if err := rows.Scan(&doc.ID, &doc.Content, &metadataJSON, &embedding); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		// Embeddings are unit length, so the dot product is the cosine.
+		doc.Score = float64(dot(queryEmbedding, embedding))
+		if doc.Score < threshold {
+			continue
+		}
+		if err := json.Unmarshal(metadataJSON, &doc.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to parse metadata: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].Score > docs[j].Score })
+	if len(docs) > k {
+		docs = docs[:k]
+	}
+	return docs, nil
+}
+
+// IngestDocuments adds documents, replacing any with the same ID.
+func (r *PostgresRetriever) IngestDocuments(ctx context.Context, docs []Document, opts IngestOptions) error {
+	chunks := splitDocuments(docs, opts)
+	contents := make([]string, len(chunks))
+	for i, doc := range chunks {
+		contents[i] = doc.Content
+	}
+	embeddings, err := r.llmClient.CreateEmbeddings(ctx, contents)
+	if err != nil {
+		return fmt.Errorf("failed to generate embeddings: %w", err)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, doc := range chunks {
+		metadata := doc.Metadata
+		if metadata == nil {
+			metadata = map[string]interface{}{}
+		}
+		metadataJSON, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO agent_documents (agent_id, id, content, metadata, embedding)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (agent_id, id) DO UPDATE
+			SET content = EXCLUDED.content, metadata = EXCLUDED.metadata, embedding = EXCLUDED.embedding`,
+			r.agentID, doc.ID, doc.Content, metadataJSON, pq.Float32Array(embeddings[i]))
+		if err != nil {
+			return fmt.Errorf("failed to insert document: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// IngestAgentDocuments serves POST /agents/:id/documents, adding documents
+// to the agent's knowledge store.
+func IngestAgentDocuments(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		var req struct {
+			Documents []Document `json:"documents" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for i, doc := range req.Documents {
+			if doc.ID == "" || doc.Content == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("documents[%d]: id and content are required", i)})
+				return
+			}
+		}
+
+		retriever, close, err := newRetriever(db, llmClient, agent)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer close()
+		if err := retriever.IngestDocuments(c.Request.Context(), req.Documents, IngestOptions{}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ingested": len(req.Documents)})
+	}
+}
+
+// SearchAgentDocuments serves POST /agents/:id/documents/search with
+// {"query": ...}, returning what chat would retrieve for it.
+func SearchAgentDocuments(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		var req struct {
+			Query string `json:"query" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		docs, err := retrieveForAgent(c.Request.Context(), db, llmClient, agent, req.Query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if docs == nil {
+			docs = []Document{}
+		}
+		c.JSON(http.StatusOK, gin.H{"documents": docs})
+	}
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestRAGSettingsResolved(t *testing.T) {
+	var unset *RAGSettings
+	got := unset.resolved()
+	if got.Retriever != RetrieverPostgres || got.TopK != defaultRAGTopK || *got.Threshold != defaultRAGThreshold {
+		t.Errorf("defaults = %+v", got)
+	}
+
+	zero := 0.0
+	got = (&RAGSettings{Retriever: RetrieverSnowflake, TopK: 2, Threshold: &zero}).resolved()
+	if got.Retriever != RetrieverSnowflake || got.TopK != 2 || *got.Threshold != 0 {
+		t.Errorf("settings = %+v, want the ones given", got)
+	}
+}
+
+// embeddingValue is the stored form of text's embedding.
+func embeddingValue(t *testing.T, llm LLMClient, text string) string {
+	t.Helper()
+	embeddings, err := llm.CreateEmbeddings(context.Background(), []string{text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := pq.Float32Array(embeddings[0]).Value()
+	return value.(string)
+}
+
+func TestRetrieveForAgent(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refunds := "Refunds are issued within 14 days of a return"
+	returns := "Return a refund request within 14 days"
+	unrelated := "The clinic parking garage opens at seven"
+
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT id, content, metadata, embedding FROM agent_documents`).
+		WithArgs("a1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "content", "metadata", "embedding"}).
+			AddRow("parking", unrelated, []byte(`{}`), embeddingValue(t, llm, unrelated)).
+			AddRow("returns", returns, []byte(`{}`), embeddingValue(t, llm, returns)).
+			AddRow("refunds", refunds, []byte(`{"source": "policy.md"}`), embeddingValue(t, llm, refunds)))
+
+	agent := &Agent{ID: "a1", Type: TypeLLM, Config: AgentConfig{UseRAG: true, RAG: &RAGSettings{TopK: 1}}}
+	docs, err := retrieveForAgent(context.Background(), db, llm, agent, refunds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].ID != "refunds" || docs[0].Metadata["source"] != "policy.md" {
+		t.Errorf("docs = %+v, want only the closest document", docs)
+	}
+
+	agent.Config.RAG = &RAGSettings{Retriever: "vector-db"}
+	if _, err := retrieveForAgent(context.Background(), db, llm, agent, refunds); err == nil || !strings.Contains(err.Error(), "unknown retriever") {
+		t.Errorf("err = %v, want the unknown retriever reported", err)
+	}
+}
+
+func TestAgentTurnGroundsReplyInDocuments(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{
+		{System: `Document: refunds\nRefunds take 14 days`, Response: "About two weeks."},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &Agent{Type: TypeLLM, Narrative: "You answer billing questions.", Config: AgentConfig{UseRAG: true}}
+	turn := agentTurn{
+		Message:   "How long do refunds take?",
+		Documents: []Document{{ID: "refunds", Content: "Refunds take 14 days"}},
+	}
+
+	response, sources, err := turn.reply(context.Background(), nil, llm, agent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != "About two weeks." || len(sources) != 1 || sources[0] != "refunds" {
+		t.Errorf("reply = %q from %v, want the grounded answer and its source", response, sources)
+	}
+	if system := llm.Calls()[0][0]; !strings.HasPrefix(system.Content, "You answer billing questions.") {
+		t.Errorf("system prompt = %q, want the narrative first", system.Content)
+	}
+
+	agent.Config.UseRAG = false
+	if _, sources, _ := turn.reply(context.Background(), nil, llm, agent, nil); len(sources) != 0 {
+		t.Errorf("sources = %v, want none without use_rag", sources)
+	}
+}
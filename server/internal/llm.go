@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/bedrock"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 	"github.com/gin-gonic/gin"
 	"github.com/tmc/langchaingo/chains"
 	"github.com/tmc/langchaingo/llms"
@@ -19,8 +21,20 @@ import (
 
 type LLMClient interface {
 	Complete(messages []Message, model string, temperature float64, maxTokens *int) (string, Usage, error)
+	// CompleteWithTools runs one model turn that may request tool calls. The
+	// reply is an assistant message; its ToolCalls are empty when the model
+	// has finished.
+	CompleteWithTools(messages []Message, tools []ToolSpec, model string, temperature float64, maxTokens *int) (Message, Usage, error)
+	// CompleteJSON completes messages with a JSON value matching schema. An
+	// invalid reply is retried with its validation error shown to the model.
+	CompleteJSON(messages []Message, schema map[string]interface{}, model string, temperature float64, maxTokens *int) (json.RawMessage, Usage, error)
+	// Stream completes messages like Complete, passing each text chunk to
+	// onToken as it arrives. An error from onToken aborts the stream.
+	Stream(ctx context.Context, messages []Message, model string, temperature float64, maxTokens *int, onToken func(string) error) (string, Usage, error)
 	GetChain(prompt string) (chains.Chain, error)
 	CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error)
+	// Defaults are the model settings used where an agent sets none.
+	Defaults() ModelParams
 }
 
 type LLMProvider string
@@ -34,6 +48,11 @@ type LLMConfig struct {
 	AWSRegion   string      `json:"aws_region"`
 }
 
+// modelDefaults are the model settings agents inherit from the server.
+func (config LLMConfig) modelDefaults() ModelParams {
+	return ModelParams{Model: config.Model, Temperature: config.Temperature, MaxTokens: config.MaxTokens}
+}
+
 const (
 	Anthropic LLMProvider = "anthropic"
 	Bedrock   LLMProvider = "bedrock"
@@ -60,6 +79,23 @@ type Usage struct {
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the calls an assistant message requests.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID links a "tool" message to the call it answers.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// ToolSpec describes a tool to the model.
+type ToolSpec struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type ToolCall struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
 }
 
 func NewLLMClient(config LLMConfig) (LLMClient, error) {
@@ -122,28 +158,133 @@ func NewLLMClient(config LLMConfig) (LLMClient, error) {
 }
 
 func (c *AnthropicClient) Complete(messages []Message, model string, temperature float64, maxTokens *int) (string, Usage, error) {
+	reply, usage, err := c.CompleteWithTools(messages, nil, model, temperature, maxTokens)
+	return reply.Content, usage, err
+}
+
+func (c *AnthropicClient) CompleteWithTools(messages []Message, tools []ToolSpec, model string, temperature float64, maxTokens *int) (Message, Usage, error) {
 	ctx := context.Background()
 
-	// Convert our Message type to LangChain messages
-	lcMessages := make([]llms.MessageContent, len(messages))
-	for i, msg := range messages {
-		lcMessages[i] = llms.MessageContent{
-			Role:  llms.ChatMessageTypeAI,
-			Parts: []llms.ContentPart{llms.TextContent{Text: msg.Content}},
+	lcMessages := langchainMessages(messages)
+
+	options := []llms.CallOption{
+		llms.WithModel(model),
+		llms.WithTemperature(temperature),
+		llms.WithMaxTokens(*maxTokens),
+	}
+	if len(tools) > 0 {
+		lcTools := make([]llms.Tool, len(tools))
+		for i, tool := range tools {
+			lcTools[i] = llms.Tool{
+				Type:     "function",
+				Function: &llms.FunctionDefinition{Name: tool.Name, Description: tool.Description, Parameters: tool.InputSchema},
+			}
 		}
+		options = append(options, llms.WithTools(lcTools))
 	}
 
 	// Call LangChain
-	response, err := c.llm.GenerateContent(ctx, lcMessages,
+	response, err := c.llm.GenerateContent(ctx, lcMessages, options...)
+	if err != nil {
+		return Message{}, Usage{}, fmt.Errorf("LLM call failed: %v", err)
+	}
+
+	reply := Message{Role: "assistant"}
+	var usage Usage
+	var text []string
+	for _, choice := range response.Choices {
+		if choice.Content != "" {
+			text = append(text, choice.Content)
+		}
+		for _, call := range choice.ToolCalls {
+			reply.ToolCalls = append(reply.ToolCalls, ToolCall{
+				ID:    call.ID,
+				Name:  call.FunctionCall.Name,
+				Input: json.RawMessage(call.FunctionCall.Arguments),
+			})
+		}
+		usage.InputTokens, _ = choice.GenerationInfo["InputTokens"].(int)
+		usage.OutputTokens, _ = choice.GenerationInfo["OutputTokens"].(int)
+	}
+	reply.Content = strings.Join(text, "\n")
+	usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	return reply, usage, nil
+}
+
+// CompleteJSON offers the schema as the only tool. The langchaingo adapter
+// does not pass tool_choice, so the model is asked to call it rather than
+// forced to.
+func (c *AnthropicClient) CompleteJSON(messages []Message, schema map[string]interface{}, model string, temperature float64, maxTokens *int) (json.RawMessage, Usage, error) {
+	return completeStructured(messages, schema, func(messages []Message, tool ToolSpec) (Message, Usage, error) {
+		return c.CompleteWithTools(messages, []ToolSpec{tool}, model, temperature, maxTokens)
+	})
+}
+
+// langchainMessages converts messages for the Anthropic adapter, which reads
+// one part per message, so each tool call and result is its own message.
+func langchainMessages(messages []Message) []llms.MessageContent {
+	lcMessages := make([]llms.MessageContent, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			lcMessages = append(lcMessages, llms.TextParts(llms.ChatMessageTypeSystem, msg.Content))
+		case "assistant":
+			if msg.Content != "" {
+				lcMessages = append(lcMessages, llms.TextParts(llms.ChatMessageTypeAI, msg.Content))
+			}
+			for _, call := range msg.ToolCalls {
+				lcMessages = append(lcMessages, llms.MessageContent{
+					Role: llms.ChatMessageTypeAI,
+					Parts: []llms.ContentPart{llms.ToolCall{
+						ID:           call.ID,
+						Type:         "function",
+						FunctionCall: &llms.FunctionCall{Name: call.Name, Arguments: string(call.Input)},
+					}},
+				})
+			}
+		case "tool":
+			lcMessages = append(lcMessages, llms.MessageContent{
+				Role:  llms.ChatMessageTypeTool,
+				Parts: []llms.ContentPart{llms.ToolCallResponse{ToolCallID: msg.ToolCallID, Content: msg.Content}},
+			})
+		default:
+			if msg.Content != "" {
+				lcMessages = append(lcMessages, llms.TextParts(llms.ChatMessageTypeHuman, msg.Content))
+			}
+		}
+	}
+
+	return lcMessages
+}
+
+func (c *AnthropicClient) Stream(ctx context.Context, messages []Message, model string, temperature float64, maxTokens *int, onToken func(string) error) (string, Usage, error) {
+	response, err := c.llm.GenerateContent(ctx, langchainMessages(messages),
+		llms.WithModel(model),
 		llms.WithTemperature(temperature),
 		llms.WithMaxTokens(*maxTokens),
+		llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			return onToken(string(chunk))
+		}),
 	)
-
 	if err != nil {
 		return "", Usage{}, fmt.Errorf("LLM call failed: %v", err)
 	}
 
-	return response.Choices[0].Content, Usage{}, nil
+	var usage Usage
+	var text []string
+	for _, choice := range response.Choices {
+		if choice.Content != "" {
+			text = append(text, choice.Content)
+		}
+		usage.InputTokens, _ = choice.GenerationInfo["InputTokens"].(int)
+		usage.OutputTokens, _ = choice.GenerationInfo["OutputTokens"].(int)
+	}
+	usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	return strings.Join(text, "\n"), usage, nil
+}
+
+func (c *AnthropicClient) Defaults() ModelParams {
+	return c.config.modelDefaults()
 }
 
 func (c *AnthropicClient) GetChain(prompt string) (chains.Chain, error) {
@@ -164,20 +305,110 @@ func (c *AnthropicClient) CreateEmbeddings(ctx context.Context, texts []string)
 }
 
 func (c *BedrockClient) Complete(messages []Message, model string, temperature float64, maxTokens *int) (string, Usage, error) {
-	// Convert system message to user message
+	reply, usage, err := c.CompleteWithTools(messages, nil, model, temperature, maxTokens)
+	return reply.Content, usage, err
+}
+
+func (c *BedrockClient) CompleteWithTools(messages []Message, tools []ToolSpec, model string, temperature float64, maxTokens *int) (Message, Usage, error) {
+	return c.invoke(messages, tools, "", model, temperature, maxTokens)
+}
+
+// CompleteJSON forces a call to the schema's tool with tool_choice.
+func (c *BedrockClient) CompleteJSON(messages []Message, schema map[string]interface{}, model string, temperature float64, maxTokens *int) (json.RawMessage, Usage, error) {
+	return completeStructured(messages, schema, func(messages []Message, tool ToolSpec) (Message, Usage, error) {
+		return c.invoke(messages, []ToolSpec{tool}, tool.Name, model, temperature, maxTokens)
+	})
+}
+
+// invoke runs one model turn. A non-empty forceTool makes the model call
+// that tool.
+func (c *BedrockClient) invoke(messages []Message, tools []ToolSpec, forceTool string, model string, temperature float64, maxTokens *int) (Message, Usage, error) {
+	jsonBytes, err := bedrockRequestBody(messages, tools, forceTool, temperature, maxTokens)
+	if err != nil {
+		return Message{}, Usage{}, err
+	}
+
+	input := &bedrockruntime.InvokeModelInput{
+		ModelId: aws.String(model),
+		Body:    jsonBytes,
+	}
+
+	output, err := c.client.InvokeModel(context.Background(), input)
+	if err != nil {
+		return Message{}, Usage{}, fmt.Errorf("Bedrock call failed: %v", err)
+	}
+
+	var response struct {
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(output.Body, &response); err != nil {
+		return Message{}, Usage{}, fmt.Errorf("failed to parse Bedrock response: %v", err)
+	}
+
+	if len(response.Content) == 0 {
+		return Message{}, Usage{}, fmt.Errorf("empty response from Bedrock")
+	}
+
+	reply := Message{Role: "assistant"}
+	var text []string
+	for _, block := range response.Content {
+		switch block.Type {
+		case "tool_use":
+			reply.ToolCalls = append(reply.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Input: block.Input})
+		default:
+			text = append(text, block.Text)
+		}
+	}
+	reply.Content = strings.Join(text, "\n")
+	usage := Usage{
+		InputTokens:  response.Usage.InputTokens,
+		OutputTokens: response.Usage.OutputTokens,
+		TotalTokens:  response.Usage.InputTokens + response.Usage.OutputTokens,
+	}
+	return reply, usage, nil
+}
+
+// bedrockRequestBody builds an Anthropic messages request. Bedrock takes the
+// system prompt separately and needs user and assistant turns to alternate,
+// so consecutive blocks of one role are merged into a single message.
+func bedrockRequestBody(messages []Message, tools []ToolSpec, forceTool string, temperature float64, maxTokens *int) ([]byte, error) {
+	var system []string
 	var formattedMessages []map[string]interface{}
+	appendBlocks := func(role string, blocks ...map[string]interface{}) {
+		if n := len(formattedMessages); n > 0 && formattedMessages[n-1]["role"] == role {
+			formattedMessages[n-1]["content"] = append(formattedMessages[n-1]["content"].([]map[string]interface{}), blocks...)
+			return
+		}
+		formattedMessages = append(formattedMessages, map[string]interface{}{"role": role, "content": blocks})
+	}
 	for _, msg := range messages {
-		role := msg.Role
-		if role == "system" {
-			role = "user"
+		switch msg.Role {
+		case "system":
+			system = append(system, msg.Content)
+		case "assistant":
+			var blocks []map[string]interface{}
+			if msg.Content != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				blocks = append(blocks, map[string]interface{}{"type": "tool_use", "id": call.ID, "name": call.Name, "input": call.Input})
+			}
+			appendBlocks("assistant", blocks...)
+		case "tool":
+			appendBlocks("user", map[string]interface{}{"type": "tool_result", "tool_use_id": msg.ToolCallID, "content": msg.Content})
+		default:
+			appendBlocks("user", map[string]interface{}{"type": "text", "text": msg.Content})
 		}
-		formattedMessages = append(formattedMessages, map[string]interface{}{
-			"role": role,
-			"content": []map[string]string{{
-				"type": "text",
-				"text": msg.Content,
-			}},
-		})
 	}
 
 	requestBody := map[string]interface{}{
@@ -186,36 +417,90 @@ func (c *BedrockClient) Complete(messages []Message, model string, temperature f
 		"max_tokens":        maxTokens,
 		"temperature":       temperature,
 	}
+	if len(system) > 0 {
+		requestBody["system"] = strings.Join(system, "\n\n")
+	}
+	if len(tools) > 0 {
+		requestBody["tools"] = tools
+	}
+	if forceTool != "" {
+		requestBody["tool_choice"] = map[string]interface{}{"type": "tool", "name": forceTool}
+	}
 
 	jsonBytes, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", Usage{}, fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
 	}
+	return jsonBytes, nil
+}
 
-	input := &bedrockruntime.InvokeModelInput{
-		ModelId: aws.String(model),
-		Body:    jsonBytes,
+func (c *BedrockClient) Stream(ctx context.Context, messages []Message, model string, temperature float64, maxTokens *int, onToken func(string) error) (string, Usage, error) {
+	jsonBytes, err := bedrockRequestBody(messages, nil, "", temperature, maxTokens)
+	if err != nil {
+		return "", Usage{}, err
 	}
 
-	output, err := c.client.InvokeModel(context.Background(), input)
+	output, err := c.client.InvokeModelWithResponseStream(ctx, &bedrockruntime.InvokeModelWithResponseStreamInput{
+		ModelId: aws.String(model),
+		Body:    jsonBytes,
+	})
 	if err != nil {
 		return "", Usage{}, fmt.Errorf("Bedrock call failed: %v", err)
 	}
+	stream := output.GetStream()
+	defer stream.Close()
+
+	// Each chunk is one Anthropic streaming event.
+	var text strings.Builder
+	var usage Usage
+	for event := range stream.Events() {
+		chunk, ok := event.(*types.ResponseStreamMemberChunk)
+		if !ok {
+			continue
+		}
+		var payload struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Message struct {
+				Usage struct {
+					InputTokens int `json:"input_tokens"`
+				} `json:"usage"`
+			} `json:"message"`
+			Usage struct {
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(chunk.Value.Bytes, &payload); err != nil {
+			return "", Usage{}, fmt.Errorf("failed to parse Bedrock stream event: %v", err)
+		}
 
-	var response struct {
-		Content []struct {
-			Text string `json:"text"`
-		} `json:"content"`
-	}
-	if err := json.Unmarshal(output.Body, &response); err != nil {
-		return "", Usage{}, fmt.Errorf("failed to parse Bedrock response: %v", err)
+		switch payload.Type {
+		case "message_start":
+			usage.InputTokens = payload.Message.Usage.InputTokens
+		case "content_block_delta":
+			if payload.Delta.Type != "text_delta" || payload.Delta.Text == "" {
+				continue
+			}
+			text.WriteString(payload.Delta.Text)
+			if err := onToken(payload.Delta.Text); err != nil {
+				return "", Usage{}, err
+			}
+		case "message_delta":
+			usage.OutputTokens = payload.Usage.OutputTokens
+		}
 	}
-
-	if len(response.Content) == 0 {
-		return "", Usage{}, fmt.Errorf("empty response from Bedrock")
+	if err := stream.Err(); err != nil {
+		return "", Usage{}, fmt.Errorf("Bedrock stream failed: %v", err)
 	}
+	usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	return text.String(), usage, nil
+}
 
-	return response.Content[0].Text, Usage{}, nil
+func (c *BedrockClient) Defaults() ModelParams {
+	return c.config.modelDefaults()
 }
 
 func (c *BedrockClient) GetChain(prompt string) (chains.Chain, error) {
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/tmc/langchaingo/chains"
+	"gopkg.in/yaml.v3"
+)
+
+// LLMScript drives a ScriptedLLMClient. The first rule whose patterns match
+// the request answers it; unmatched requests get Default, or an echo of the
+// prompt when no default is set.
+//
+//	default: "ok"
+//	rules:
+//	  - system: "triage"          # regexp on the system prompt
+//	    match: "ticket: 12\\d\\d" # regexp on the user prompt
+//	    response: "priority: high"
+//	  - match: "timeout"
+//	    error: "upstream timed out"
+//	  - match: "revenue"
+//	    call: {tool: snowflake_query, input: {query: "SELECT 1"}}
+//
+// A rule with a call requests that tool when it is offered. Once a tool
+// result is the latest message, call rules are skipped so the next matching
+// rule, or the default, answers.
+type LLMScript struct {
+	Default string       `yaml:"default" json:"default"`
+	Rules   []ScriptRule `yaml:"rules" json:"rules"`
+}
+
+type ScriptRule struct {
+	System   string          `yaml:"system,omitempty" json:"system,omitempty"`
+	Match    string          `yaml:"match,omitempty" json:"match,omitempty"`
+	Response string          `yaml:"response,omitempty" json:"response,omitempty"`
+	Error    string          `yaml:"error,omitempty" json:"error,omitempty"`
+	Call     *ScriptToolCall `yaml:"call,omitempty" json:"call,omitempty"`
+
+	system *regexp.Regexp
+	match  *regexp.Regexp
+}
+
+type ScriptToolCall struct {
+	Tool  string                 `yaml:"tool" json:"tool"`
+	Input map[string]interface{} `yaml:"input,omitempty" json:"input,omitempty"`
+}
+
+// ScriptedLLMClient is an offline LLMClient that answers from an LLMScript.
+// It records every request so tests can assert on the prompts a workflow
+// produced.
+type ScriptedLLMClient struct {
+	script   LLMScript
+	embedder *LocalEmbedder
+
+	mu    sync.Mutex
+	calls [][]Message
+}
+
+func NewScriptedLLMClient(script LLMScript) (*ScriptedLLMClient, error) {
+	for i := range script.Rules {
+		rule := &script.Rules[i]
+		var err error
+		if rule.system, err = compileScriptPattern(rule.System); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid system pattern: %v", i, err)
+		}
+		if rule.match, err = compileScriptPattern(rule.Match); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid match pattern: %v", i, err)
+		}
+	}
+
+	embedder, err := NewLocalEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	return &ScriptedLLMClient{script: script, embedder: embedder}, nil
+}
+
+// LoadLLMScript reads a YAML or JSON script file.
+func LoadLLMScript(path string) (LLMScript, error) {
+	var script LLMScript
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return script, err
+	}
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return script, fmt.Errorf("%s: %v", path, err)
+	}
+	return script, nil
+}
+
+func compileScriptPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+func (c *ScriptedLLMClient) Complete(messages []Message, model string, temperature float64, maxTokens *int) (string, Usage, error) {
+	reply, usage, err := c.CompleteWithTools(messages, nil, model, temperature, maxTokens)
+	return reply.Content, usage, err
+}
+
+// Stream passes the scripted response to onToken word by word.
+func (c *ScriptedLLMClient) Stream(ctx context.Context, messages []Message, model string, temperature float64, maxTokens *int, onToken func(string) error) (string, Usage, error) {
+	response, usage, err := c.Complete(messages, model, temperature, maxTokens)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	for _, token := range strings.SplitAfter(response, " ") {
+		if err := ctx.Err(); err != nil {
+			return "", Usage{}, err
+		}
+		if err := onToken(token); err != nil {
+			return "", Usage{}, err
+		}
+	}
+	return response, usage, nil
+}
+
+func (c *ScriptedLLMClient) CompleteWithTools(messages []Message, tools []ToolSpec, model string, temperature float64, maxTokens *int) (Message, Usage, error) {
+	c.mu.Lock()
+	c.calls = append(c.calls, append([]Message(nil), messages...))
+	callID := fmt.Sprintf("call-%d", len(c.calls))
+	c.mu.Unlock()
+
+	offered := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		offered[tool.Name] = true
+	}
+	afterTool := len(messages) > 0 && messages[len(messages)-1].Role == "tool"
+
+	var system, prompt []string
+	for _, m := range messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+		} else {
+			prompt = append(prompt, m.Content)
+		}
+	}
+	systemText, promptText := strings.Join(system, "\n"), strings.Join(prompt, "\n")
+
+	reply := Message{Role: "assistant", Content: c.script.Default}
+	if reply.Content == "" {
+		reply.Content = "echo: " + promptText
+	}
+	for _, rule := range c.script.Rules {
+		if rule.system != nil && !rule.system.MatchString(systemText) {
+			continue
+		}
+		if rule.match != nil && !rule.match.MatchString(promptText) {
+			continue
+		}
+		if rule.Call != nil && (afterTool || !offered[rule.Call.Tool]) {
+			continue
+		}
+		if rule.Error != "" {
+			return Message{}, Usage{}, fmt.Errorf("%s", rule.Error)
+		}
+		reply.Content = rule.Response
+		if rule.Call != nil {
+			input, err := json.Marshal(rule.Call.Input)
+			if err != nil {
+				return Message{}, Usage{}, err
+			}
+			if rule.Call.Input == nil {
+				input = []byte("{}")
+			}
+			reply.ToolCalls = []ToolCall{{ID: callID, Name: rule.Call.Tool, Input: input}}
+		}
+		break
+	}
+
+	inputTokens := len(strings.Fields(systemText)) + len(strings.Fields(promptText))
+	outputTokens := len(strings.Fields(reply.Content))
+	return reply, Usage{
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		TotalTokens:  inputTokens + outputTokens,
+	}, nil
+}
+
+// CompleteJSON offers the schema's tool, so rules can answer with a call to
+// respond_with_json or with the JSON as text.
+func (c *ScriptedLLMClient) CompleteJSON(messages []Message, schema map[string]interface{}, model string, temperature float64, maxTokens *int) (json.RawMessage, Usage, error) {
+	return completeStructured(messages, schema, func(messages []Message, tool ToolSpec) (Message, Usage, error) {
+		return c.CompleteWithTools(messages, []ToolSpec{tool}, model, temperature, maxTokens)
+	})
+}
+
+// Calls returns the messages of every request made so far.
+func (c *ScriptedLLMClient) Calls() [][]Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]Message(nil), c.calls...)
+}
+
+// Defaults leaves the model empty so scripts never depend on one.
+func (c *ScriptedLLMClient) Defaults() ModelParams {
+	return ModelParams{Temperature: 0.7, MaxTokens: 1024}
+}
+
+func (c *ScriptedLLMClient) GetChain(prompt string) (chains.Chain, error) {
+	return nil, fmt.Errorf("chain functionality not implemented for the scripted client")
+}
+
+func (c *ScriptedLLMClient) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return c.embedder.CreateEmbeddings(ctx, texts)
+}
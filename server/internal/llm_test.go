@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms/anthropic"
+)
+
+// fakeAnthropic answers Messages API requests with "Hello" and records the
+// model each request asked for.
+type fakeAnthropic struct {
+	models []string
+}
+
+func (f *fakeAnthropic) Do(req *http.Request) (*http.Response, error) {
+	var payload struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	f.models = append(f.models, payload.Model)
+
+	body := `{"id": "msg_1", "type": "message", "role": "assistant", "model": "` + payload.Model + `",
+		"content": [{"type": "text", "text": "Hello"}], "stop_reason": "end_turn",
+		"usage": {"input_tokens": 3, "output_tokens": 1}}`
+	if payload.Stream {
+		body = strings.Join([]string{
+			`data: {"type": "message_start", "message": {"id": "msg_1", "type": "message", "role": "assistant", "model": "` + payload.Model + `", "usage": {"input_tokens": 3}}}`,
+			`data: {"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`,
+			`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}`,
+			`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "lo"}}`,
+			`data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 1}}`,
+			`data: {"type": "message_stop"}`,
+		}, "\n\n")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func newFakeAnthropicClient(t *testing.T) (*AnthropicClient, *fakeAnthropic) {
+	t.Helper()
+	fake := &fakeAnthropic{}
+	llm, err := anthropic.New(
+		anthropic.WithToken("test"),
+		anthropic.WithModel("server-default"),
+		anthropic.WithHTTPClient(fake),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AnthropicClient{llm: llm}, fake
+}
+
+func TestAnthropicClientUsesRequestedModel(t *testing.T) {
+	client, fake := newFakeAnthropicClient(t)
+	maxTokens := 100
+	messages := []Message{{Role: "user", Content: "Hi"}}
+
+	reply, _, err := client.CompleteWithTools(messages, nil, "agent-model", 0.2, &maxTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "Hello" {
+		t.Errorf("reply = %q, want Hello", reply.Content)
+	}
+
+	var tokens []string
+	response, _, err := client.Stream(context.Background(), messages, "other-model", 0.2, &maxTokens, func(token string) error {
+		tokens = append(tokens, token)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != "Hello" || strings.Join(tokens, "|") != "Hel|lo" {
+		t.Errorf("stream = %q from tokens %q", response, tokens)
+	}
+
+	if want := []string{"agent-model", "other-model"}; strings.Join(fake.models, ",") != strings.Join(want, ",") {
+		t.Errorf("models = %v, want %v", fake.models, want)
+	}
+}
@@ -0,0 +1,72 @@
+package internal
+
+import "fmt"
+
+// RunLocal executes a workflow from a definition bundle in-process, using
+// the same executor as the server with an in-memory store. Agents are
+// addressed by slug and each is at version 1. It blocks until the run
+// finishes.
+func RunLocal(bundle *DefinitionBundle, workflowSlug string, params map[string]string, llmClient LLMClient) (*TaskDefinition, error) {
+	if err := bundle.normalize(); err != nil {
+		return nil, err
+	}
+
+	def, err := bundle.workflow(workflowSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	store := NewMemoryStore()
+	agentIDs := make(map[string]string, len(bundle.Agents))
+	for _, a := range bundle.Agents {
+		// normalize has already validated the config.
+		config, _ := parseAgentConfig(a.Type, a.Config)
+		store.AddAgent(&Agent{
+			ID:          a.Slug,
+			Slug:        a.Slug,
+			Name:        a.Name,
+			Description: a.Description,
+			Narrative:   a.Narrative,
+			Type:        a.Type,
+			Config:      config,
+			Version:     1,
+		})
+		agentIDs[a.Slug] = a.Slug
+	}
+
+	dag, err := resolveDagDefinition(nil, def.Dag, agentIDs)
+	if err != nil {
+		return nil, fmt.Errorf("workflow %s: %v", def.Slug, err)
+	}
+	if err := dag.Validate(); err != nil {
+		return nil, fmt.Errorf("workflow %s: %v", def.Slug, err)
+	}
+
+	task, err := NewTask("", nil, dag, mergeParams(def.Parameters, params))
+	if err != nil {
+		return nil, fmt.Errorf("workflow %s: %v", def.Slug, err)
+	}
+	task.ID, err = store.CreateExecution(task)
+	if err != nil {
+		return nil, err
+	}
+
+	NewExecutor(store, NewInvoker(llmClient, NewToolRegistry(nil, llmClient))).Run(task)
+	return store.Execution(task.ID)
+}
+
+// workflow picks a workflow by slug, or the only one when slug is empty.
+func (b *DefinitionBundle) workflow(slug string) (*WorkflowDefinition, error) {
+	if slug == "" {
+		if len(b.Workflows) != 1 {
+			return nil, fmt.Errorf("definitions contain %d workflows, name the one to run", len(b.Workflows))
+		}
+		return &b.Workflows[0], nil
+	}
+	for i := range b.Workflows {
+		if b.Workflows[i].Slug == slug {
+			return &b.Workflows[i], nil
+		}
+	}
+	return nil, fmt.Errorf("workflow %q not found in definitions", slug)
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const localTestDefinitions = `
+agents:
+  - name: Triage
+    narrative: "You triage tickets for {{team}}."
+    config:
+      variables:
+        - {name: team, default: support}
+  - name: Writer
+    narrative: Write a short report.
+workflows:
+  - name: Tickets
+    parameters: {ticket: "1234"}
+    dag:
+      nodes:
+        - {id: triage, agent: triage, configuration: {prompt: "Triage this ticket"}}
+        - {id: report, agent: writer}
+        - {id: lookup, agent: triage, configuration: {prompt: "boom"}}
+        - {id: followup, agent: writer}
+      edges:
+        - {source: triage, target: report}
+        - {source: lookup, target: followup}
+`
+
+const localTestScript = `
+rules:
+  - match: boom
+    error: upstream timed out
+  - system: "triage tickets for support"
+    match: "ticket: 1234"
+    response: "priority: high"
+  - system: "report"
+    match: "priority: high"
+    response: "Urgent ticket"
+`
+
+func TestRunLocal(t *testing.T) {
+	bundle, err := parseDefinitionBundle([]byte(localTestDefinitions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var script LLMScript
+	if err := yaml.Unmarshal([]byte(localTestScript), &script); err != nil {
+		t.Fatal(err)
+	}
+	llm, err := NewScriptedLLMClient(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := RunLocal(bundle, "tickets", nil, llm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]struct{ status, response, err string }{
+		"triage":   {status: "completed", response: "priority: high"},
+		"report":   {status: "completed", response: "Urgent ticket"},
+		"lookup":   {status: "failed", err: "upstream timed out"},
+		"followup": {status: "skipped", err: "upstream node lookup failed"},
+	}
+	for _, node := range task.Nodes {
+		w, ok := want[node.ID]
+		if !ok {
+			t.Errorf("unexpected node %q", node.ID)
+			continue
+		}
+		if node.Status != w.status {
+			t.Errorf("node %s: status = %q, want %q", node.ID, node.Status, w.status)
+		}
+		if node.Response != w.response {
+			t.Errorf("node %s: response = %q, want %q", node.ID, node.Response, w.response)
+		}
+		if !strings.Contains(node.Error, w.err) {
+			t.Errorf("node %s: error = %q, want it to contain %q", node.ID, node.Error, w.err)
+		}
+	}
+
+	// The followup node is skipped, so the model saw three requests.
+	calls := llm.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("got %d model calls, want 3", len(calls))
+	}
+	for _, call := range calls {
+		if strings.Contains(call[1].Content, "Triage this ticket") {
+			if want := "Triage this ticket\n\nParameters:\n- ticket: 1234"; call[1].Content != want {
+				t.Errorf("triage prompt = %q, want %q", call[1].Content, want)
+			}
+		}
+	}
+}
+
+func TestRunLocalParams(t *testing.T) {
+	bundle, err := parseDefinitionBundle([]byte(localTestDefinitions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	llm, err := NewScriptedLLMClient(LLMScript{Default: "ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := RunLocal(bundle, "tickets", map[string]string{"ticket": "99"}, llm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range llm.Calls() {
+		if strings.Contains(call[1].Content, "1234") {
+			t.Errorf("prompt %q still has the default ticket", call[1].Content)
+		}
+	}
+	for _, node := range task.Nodes {
+		if node.Status != "completed" {
+			t.Errorf("node %s: status = %q, want completed", node.ID, node.Status)
+		}
+	}
+}
+
+func TestRunLocalUnknownWorkflow(t *testing.T) {
+	bundle, err := parseDefinitionBundle([]byte(localTestDefinitions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunLocal(bundle, "missing", nil, llm); err == nil {
+		t.Fatal("expected an error for an unknown workflow")
+	}
+}
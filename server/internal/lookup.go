@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Field types a lookup can extract.
+const (
+	LookupString  = "string"
+	LookupInteger = "integer"
+	LookupNumber  = "number"
+	LookupBoolean = "boolean"
+	LookupDate    = "date" // YYYY-MM-DD
+)
+
+const (
+	defaultLookupRows = 100
+	maxLookupRows     = 1000
+	lookupTimeout     = time.Minute
+)
+
+// LookupSettings let an agent answer from a parameterized query. Chat
+// extracts Fields from the user's message, binds them to the :name
+// parameters of Query and grounds the reply in the rows it returns.
+type LookupSettings struct {
+	Integration string        `json:"integration"`
+	Fields      []LookupField `json:"fields"`
+	Query       string        `json:"query"`
+	MaxRows     int           `json:"max_rows,omitempty"`
+}
+
+type LookupField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// lookupTarget runs bound queries against one integration.
+type lookupTarget struct {
+	open func(db *sql.DB) (*sql.DB, error)
+	// placeholder renders the nth (from 1) bind parameter.
+	placeholder func(n int) string
+}
+
+var lookupTargets = map[string]lookupTarget{
+	"snowflake": {open: openSnowflakeReadOnly, placeholder: func(int) string { return "?" }},
+}
+
+var lookupFieldName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// check reports problems with the settings under path.
+func (l *LookupSettings) check(path string, errs FieldErrors) {
+	if _, ok := lookupTargets[l.Integration]; !ok {
+		errs[path+".integration"] = "must be one of " + strings.Join(lookupIntegrations(), ", ")
+	}
+
+	declared := map[string]bool{}
+	for i, f := range l.Fields {
+		fieldPath := fmt.Sprintf("%s.fields[%d]", path, i)
+		switch {
+		case !lookupFieldName.MatchString(f.Name):
+			errs[fieldPath+".name"] = "use letters, digits and underscores"
+		case declared[f.Name]:
+			errs[fieldPath+".name"] = fmt.Sprintf("duplicate field %q", f.Name)
+		}
+		declared[f.Name] = true
+	}
+	if len(l.Fields) == 0 {
+		errs[path+".fields"] = "is required"
+	}
+
+	_, params, err := bindLookupQuery(l.Query, func(int) string { return "?" })
+	if err != nil {
+		errs[path+".query"] = err.Error()
+		return
+	}
+	for _, name := range params {
+		if !declared[name] {
+			errs[path+".query"] = fmt.Sprintf("parameter :%s is not a declared field", name)
+			return
+		}
+	}
+}
+
+func lookupIntegrations() []string {
+	names := make([]string, 0, len(lookupTargets))
+	for name := range lookupTargets {
+		names = append(names, name)
+	}
+	return names
+}
+
+// bindLookupQuery replaces the :name parameters of a single read-only
+// statement with driver placeholders and returns the names in order. Quoted
+// strings and identifiers and :: casts are left alone.
+func bindLookupQuery(query string, placeholder func(n int) string) (string, []string, error) {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if !readOnlyStatement.MatchString(query) {
+		return "", nil, fmt.Errorf("must be a single read-only statement")
+	}
+
+	var out strings.Builder
+	var params []string
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'' || ch == '"':
+			end := strings.IndexByte(query[i+1:], ch)
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			out.WriteString(query[i : i+end+2])
+			i += end + 1
+		case ch == ';':
+			return "", nil, fmt.Errorf("must be a single read-only statement")
+		case ch == ':' && i+1 < len(query) && query[i+1] == ':':
+			out.WriteString("::")
+			i++
+		case ch == ':' && i+1 < len(query) && (query[i+1] == '_' || isASCIILetter(query[i+1])):
+			end := i + 1
+			for end < len(query) && (query[end] == '_' || isASCIILetter(query[end]) || (query[end] >= '0' && query[end] <= '9')) {
+				end++
+			}
+			params = append(params, query[i+1:end])
+			out.WriteString(placeholder(len(params)))
+			i = end - 1
+		default:
+			out.WriteByte(ch)
+		}
+	}
+	return out.String(), params, nil
+}
+
+func isASCIILetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// schema is the JSON Schema extracted values must satisfy. Dates are
+// strings here and checked separately.
+func (l *LookupSettings) schema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, f := range l.Fields {
+		kind := f.Type
+		if kind == LookupDate {
+			kind = LookupString
+		}
+		property := map[string]interface{}{"type": []interface{}{kind, "null"}}
+		if f.Description != "" {
+			property["description"] = f.Description
+		}
+		properties[f.Name] = property
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// extractLookupFields asks the model for the lookup fields mentioned in
+// message. It returns the values found and the required fields missing.
+func extractLookupFields(llmClient LLMClient, agent *Agent, lookup *LookupSettings, message string) (map[string]interface{}, []string, error) {
+	prompt := []Message{
+		{Role: "system", Content: `You extract values from a user's message. Use null for any value the message does not state. Write dates as YYYY-MM-DD.`},
+		{Role: "user", Content: message},
+	}
+
+	params := agent.Config.modelParams(llmClient.Defaults())
+	response, _, err := llmClient.CompleteJSON(prompt, lookup.schema(), params.Model, 0, &params.MaxTokens)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to extract lookup fields: %v", err)
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(response, &values); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse extracted fields: %v", err)
+	}
+
+	var missing []string
+	for _, f := range lookup.Fields {
+		value := values[f.Name]
+		if s, ok := value.(string); ok && f.Type == LookupDate {
+			if _, err := time.Parse("2006-01-02", s); err != nil {
+				value = nil
+			}
+		}
+		if s, ok := value.(string); ok && strings.TrimSpace(s) == "" {
+			value = nil
+		}
+		if value == nil {
+			delete(values, f.Name)
+			if f.Required {
+				missing = append(missing, f.Name)
+			}
+			continue
+		}
+		if n, ok := value.(float64); ok && f.Type == LookupInteger {
+			value = int64(n)
+		}
+		values[f.Name] = value
+	}
+	return values, missing, nil
+}
+
+// runLookup binds values to the lookup query and returns at most MaxRows
+// rows, reading no further. Parameters without a value bind NULL.
+func runLookup(ctx context.Context, db *sql.DB, lookup *LookupSettings, values map[string]interface{}) ([]map[string]interface{}, bool, error) {
+	target, ok := lookupTargets[lookup.Integration]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown integration %q", lookup.Integration)
+	}
+	query, params, err := bindLookupQuery(lookup.Query, target.placeholder)
+	if err != nil {
+		return nil, false, err
+	}
+	args := make([]interface{}, len(params))
+	for i, name := range params {
+		args[i] = values[name]
+	}
+
+	conn, err := target.open(db)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("lookup query failed: %v", err)
+	}
+	defer rows.Close()
+
+	limit := lookup.MaxRows
+	if limit <= 0 {
+		limit = defaultLookupRows
+	}
+	return processQueryRows(rows, limit)
+}
+
+// lookupPrompt extracts the lookup fields from message, runs the query and
+// returns the context that grounds the agent's reply.
+func lookupPrompt(ctx context.Context, db *sql.DB, llmClient LLMClient, agent *Agent, message string) (string, error) {
+	lookup := agent.Config.Lookup
+	if lookup == nil {
+		return "", fmt.Errorf("use_direct_query is set but the agent has no lookup")
+	}
+
+	values, missing, err := extractLookupFields(llmClient, agent, lookup, message)
+	if err != nil {
+		return "", err
+	}
+	if len(missing) > 0 {
+		return fmt.Sprintf("Before you can look up records, the user must provide: %s. Ask for them and do not guess.", strings.Join(missing, ", ")), nil
+	}
+
+	rows, truncated, err := runLookup(ctx, db, lookup, values)
+	if err != nil {
+		return "", err
+	}
+	criteria, _ := json.Marshal(values)
+	data, _ := json.MarshalIndent(rows, "", "  ")
+	note := ""
+	if truncated {
+		note = fmt.Sprintf(" Only the first %d rows are shown.", len(rows))
+	}
+	return fmt.Sprintf(`A lookup for %s returned %d rows.%s
+
+%s
+
+Answer from these rows only. If there are none, say that no matching records were found.`, criteria, len(rows), note, data), nil
+}
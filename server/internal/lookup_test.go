@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBindLookupQuery(t *testing.T) {
+	numbered := func(n int) string { return fmt.Sprintf("$%d", n) }
+	tests := []struct {
+		name   string
+		query  string
+		want   string
+		params []string
+	}{
+		{
+			name:   "parameters in order",
+			query:  "SELECT * FROM obs WHERE last_name = :last_name AND birth_date = :dob",
+			want:   "SELECT * FROM obs WHERE last_name = $1 AND birth_date = $2",
+			params: []string{"last_name", "dob"},
+		},
+		{
+			name:   "repeated parameter",
+			query:  "SELECT :id, :id",
+			want:   "SELECT $1, $2",
+			params: []string{"id", "id"},
+		},
+		{
+			name:   "casts are kept",
+			query:  "SELECT created_at::date FROM obs WHERE id = :id::int",
+			want:   "SELECT created_at::date FROM obs WHERE id = $1::int",
+			params: []string{"id"},
+		},
+		{
+			name:   "quoted strings are kept",
+			query:  "SELECT ':not_a_param' AS label FROM obs WHERE note = 'a;b' AND code = :code",
+			want:   "SELECT ':not_a_param' AS label FROM obs WHERE note = 'a;b' AND code = $1",
+			params: []string{"code"},
+		},
+		{
+			name:   "quoted identifiers are kept",
+			query:  `SELECT "Weird:Name" FROM obs WHERE x = :x`,
+			want:   `SELECT "Weird:Name" FROM obs WHERE x = $1`,
+			params: []string{"x"},
+		},
+		{
+			name:  "trailing semicolon",
+			query: "  SELECT 1;  ",
+			want:  "SELECT 1",
+		},
+		{
+			name:  "colon before a digit",
+			query: "SELECT '12:30', 1 :2",
+			want:  "SELECT '12:30', 1 :2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, params, err := bindLookupQuery(tt.query, numbered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("query = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(params, tt.params) {
+				t.Errorf("params = %v, want %v", params, tt.params)
+			}
+		})
+	}
+}
+
+func TestBindLookupQueryRejects(t *testing.T) {
+	for _, query := range []string{
+		"DELETE FROM obs WHERE id = :id",
+		"SELECT 1; DROP TABLE obs",
+		"SELECT 'unterminated FROM obs",
+		`SELECT "unterminated FROM obs`,
+	} {
+		if _, _, err := bindLookupQuery(query, func(int) string { return "?" }); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	// memoryRecallTopK memories at most are recalled into a turn.
+	memoryRecallTopK      = 5
+	memoryRecallThreshold = 0.2
+	// A new fact this close to a remembered one replaces it.
+	memoryDuplicateSimilarity = 0.85
+	maxFactsPerTurn           = 5
+)
+
+// Memory is a fact an agent remembers about a user, written after chat
+// turns of agents with use_memory set.
+type Memory struct {
+	ID      string `json:"id"`
+	AgentID string `json:"agent_id"`
+	Owner   string `json:"owner"`
+	Content string `json:"content"`
+	// ConversationID is where the fact was learned, unless edited since.
+	ConversationID *string   `json:"conversation_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+const memoryColumns = `id, agent_id, owner, content, conversation_id, created_at, updated_at`
+
+func scanMemory(row rowScanner) (*Memory, error) {
+	var m Memory
+	if err := row.Scan(&m.ID, &m.AgentID, &m.Owner, &m.Content, &m.ConversationID, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+var memoryListSpec = listSpec{
+	table:       "agent_memories",
+	columns:     memoryColumns,
+	sorts:       map[string]string{"updated_at": "updated_at", "created_at": "created_at"},
+	defaultSort: "-updated_at",
+	filters:     map[string]string{"agent_id": "agent_id::text", "owner": "owner"},
+	search:      []string{"content"},
+}
+
+type scoredMemory struct {
+	id        string
+	content   string
+	embedding []float32
+	score     float64
+}
+
+// loadMemories reads the user's memories of the agent with their
+// embeddings.
+func loadMemories(ctx context.Context, db *sql.DB, agentID, owner string) ([]scoredMemory, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, content, embedding FROM agent_memories
+		WHERE agent_id = $1 AND owner = $2`, agentID, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memories []scoredMemory
+	for rows.Next() {
+		var m scoredMemory
+		var embedding pq.Float32Array
+		if err := rows.Scan(&m.id, &m.content, &embedding); err != nil {
+			return nil, err
+		}
+		m.embedding = embedding
+		memories = append(memories, m)
+	}
+	return memories, rows.Err()
+}
+
+// recallMemories returns what the agent remembers about owner that is
+// relevant to message, most relevant first.
+func recallMemories(ctx context.Context, db *sql.DB, llmClient LLMClient, agentID, owner, message string) ([]string, error) {
+	memories, err := loadMemories(ctx, db, agentID, owner)
+	if err != nil || len(memories) == 0 {
+		return nil, err
+	}
+	embeddings, err := llmClient.CreateEmbeddings(ctx, []string{message})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+	}
+
+	var relevant []scoredMemory
+	for _, m := range memories {
+		// Embeddings are unit length, so the dot product is the cosine.
+		m.score = float64(dot(embeddings[0], m.embedding))
+		if m.score >= memoryRecallThreshold {
+			relevant = append(relevant, m)
+		}
+	}
+	sort.SliceStable(relevant, func(i, j int) bool { return relevant[i].score > relevant[j].score })
+	if len(relevant) > memoryRecallTopK {
+		relevant = relevant[:memoryRecallTopK]
+	}
+	facts := make([]string, len(relevant))
+	for i, m := range relevant {
+		facts[i] = m.content
+	}
+	return facts, nil
+}
+
+func memoryPrompt(facts []string) string {
+	return "What you remember about the user from earlier conversations:\n- " + strings.Join(facts, "\n- ")
+}
+
+// rememberTurn extracts facts from a finished chat turn in the background,
+// so the reply is not held up. Failures are only logged.
+func rememberTurn(db *sql.DB, llmClient LLMClient, agent *Agent, conv *Conversation, message, response string) {
+	if agent.Type != TypeLLM || !agent.Config.UseMemory {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := extractMemories(ctx, db, llmClient, agent, conv, message, response); err != nil {
+			log.Printf("Failed to update memories of agent %s for %s: %v", agent.ID, conv.Owner, err)
+		}
+	}()
+}
+
+var factsSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []interface{}{"facts"},
+	"properties": map[string]interface{}{
+		"facts": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+	},
+}
+
+// extractMemories asks the model for durable facts about the user in one
+// exchange and stores them. A fact close to one already remembered
+// replaces it, so changed preferences overwrite old ones.
+func extractMemories(ctx context.Context, db *sql.DB, llmClient LLMClient, agent *Agent, conv *Conversation, message, response string) error {
+	memories, err := loadMemories(ctx, db, agent.ID, conv.Owner)
+	if err != nil {
+		return err
+	}
+
+	var exchange strings.Builder
+	if len(memories) > 0 {
+		exchange.WriteString("Already remembered:\n")
+		for _, m := range memories {
+			exchange.WriteString("- " + m.content + "\n")
+		}
+		exchange.WriteString("\n")
+	}
+	fmt.Fprintf(&exchange, "user: %s\nassistant: %s\n", message, response)
+
+	prompt := []Message{
+		{Role: "system", Content: `You keep an assistant's long-term memory of a user. From the exchange below, list durable facts about the user and their preferences that will matter in later conversations: who they are, what they work on, how they like to be answered. Skip anything temporary, only about this conversation, or already remembered unless it changed. Write each fact as a short sentence about "the user", and give an empty list if there is nothing to remember.`},
+		{Role: "user", Content: exchange.String()},
+	}
+	params := agent.Config.modelParams(llmClient.Defaults())
+	reply, _, err := llmClient.CompleteJSON(prompt, factsSchema, params.Model, 0, &params.MaxTokens)
+	if err != nil {
+		return err
+	}
+	var extracted struct {
+		Facts []string `json:"facts"`
+	}
+	if err := json.Unmarshal(reply, &extracted); err != nil {
+		return fmt.Errorf("failed to parse extracted facts: %v", err)
+	}
+
+	var facts []string
+	for _, fact := range extracted.Facts {
+		fact = redactForStorage(agent, strings.TrimSpace(fact))
+		if fact != "" && len(facts) < maxFactsPerTurn {
+			facts = append(facts, fact)
+		}
+	}
+	if len(facts) == 0 {
+		return nil
+	}
+	embeddings, err := llmClient.CreateEmbeddings(ctx, facts)
+	if err != nil {
+		return fmt.Errorf("failed to generate embeddings: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, fact := range facts {
+		closest, similarity := -1, 0.0
+		for j, m := range memories {
+			if s := float64(dot(embeddings[i], m.embedding)); s > similarity {
+				closest, similarity = j, s
+			}
+		}
+		if similarity >= memoryDuplicateSimilarity {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE agent_memories SET content = $2, embedding = $3, conversation_id = $4
+				WHERE id = $1`,
+				memories[closest].id, fact, pq.Float32Array(embeddings[i]), conv.ID)
+			memories[closest].content, memories[closest].embedding = fact, embeddings[i]
+		} else {
+			var id string
+			err = tx.QueryRowContext(ctx, `
+				INSERT INTO agent_memories (agent_id, owner, content, embedding, conversation_id)
+				VALUES ($1, $2, $3, $4, $5)
+				RETURNING id`,
+				agent.ID, conv.Owner, fact, pq.Float32Array(embeddings[i]), conv.ID).Scan(&id)
+			memories = append(memories, scoredMemory{id: id, content: fact, embedding: embeddings[i]})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store memory: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListMemories serves what the agent remembers about the requesting user,
+// most recently updated first, with ?q= searching the facts.
+func ListMemories(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		q, err := parseListQuery(c, memoryListSpec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		q.filters["agent_id"] = []string{agent.ID}
+		q.filters["owner"] = []string{currentUser(c)}
+
+		page, err := queryPage(db, memoryListSpec, q, scanMemory)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch memories"})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// UpdateMemory serves PUT with {"content": ...}, rewriting a fact under the
+// agent's guardrails.
+func UpdateMemory(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		agent := requestAgent(c, db)
+		if agent == nil {
+			return
+		}
+		var req struct {
+			Content string `json:"content" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		content := redactForStorage(agent, strings.TrimSpace(req.Content))
+		if content == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Content must not be blank"})
+			return
+		}
+		embeddings, err := guardLLM(llmClient, agent).CreateEmbeddings(c.Request.Context(), []string{content})
+		var blocked *GuardrailError
+		if errors.As(err, &blocked) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to generate embedding: %v", err)})
+			return
+		}
+
+		memory, err := scanMemory(db.QueryRow(`
+			UPDATE agent_memories SET content = $4, embedding = $5, conversation_id = NULL
+			WHERE id::text = $1 AND agent_id::text = $2 AND owner = $3
+			RETURNING `+memoryColumns,
+			c.Param("mid"), agent.ID, currentUser(c), content, pq.Float32Array(embeddings[0])))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Memory not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update memory"})
+			return
+		}
+		c.JSON(http.StatusOK, memory)
+	}
+}
+
+func DeleteMemory(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := db.Exec(`
+			DELETE FROM agent_memories
+			WHERE id::text = $1 AND agent_id::text = $2 AND owner = $3`,
+			c.Param("mid"), c.Param("id"), currentUser(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete memory"})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Memory not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Memory deleted", "id": c.Param("mid")})
+	}
+}
+
+// ForgetMemories deletes everything the agent remembers about the
+// requesting user.
+func ForgetMemories(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := db.Exec(`
+			DELETE FROM agent_memories
+			WHERE agent_id::text = $1 AND owner = $2`,
+			c.Param("id"), currentUser(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete memories"})
+			return
+		}
+		n, _ := result.RowsAffected()
+		c.JSON(http.StatusOK, gin.H{"message": "Memories deleted", "deleted": n})
+	}
+}
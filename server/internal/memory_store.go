@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process ExecutionStore for running workflows without
+// a database. Executions are copied on the way in and out so callers can
+// read them while the executor is still writing.
+type MemoryStore struct {
+	mu         sync.Mutex
+	agents     map[string]*Agent
+	executions map[string][]byte
+	cache      map[string]memoryCacheEntry
+	nextID     int
+}
+
+type memoryCacheEntry struct {
+	output    string
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		agents:     make(map[string]*Agent),
+		executions: make(map[string][]byte),
+		cache:      make(map[string]memoryCacheEntry),
+	}
+}
+
+// AddAgent registers an agent under its ID.
+func (s *MemoryStore) AddAgent(agent *Agent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agents[agent.ID] = agent
+}
+
+// Integrations is empty: no integrations are connected without a database.
+func (s *MemoryStore) Integrations() (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+// GetAgent resolves an agent reference. Only the registered version of each
+// agent is available, so a reference pinning any other fails.
+func (s *MemoryStore) GetAgent(ref string) (*Agent, error) {
+	id, version, err := parseAgentRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agent, ok := s.agents[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if version != 0 && version != agent.Version {
+		return nil, fmt.Errorf("agent %s has no version %d", id, version)
+	}
+	return agent, nil
+}
+
+func (s *MemoryStore) CreateExecution(task *TaskDefinition) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	id := fmt.Sprintf("local-%d", s.nextID)
+	now := time.Now()
+	task.CreatedAt, task.UpdatedAt = now, now
+
+	data, err := json.Marshal(task)
+	if err != nil {
+		return "", err
+	}
+	s.executions[id] = data
+	return id, nil
+}
+
+func (s *MemoryStore) UpdateExecution(task *TaskDefinition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.executions[task.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	task.UpdatedAt = time.Now()
+
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	s.executions[task.ID] = data
+	return nil
+}
+
+// Execution returns a snapshot of a stored execution.
+func (s *MemoryStore) Execution(id string) (*TaskDefinition, error) {
+	s.mu.Lock()
+	data, ok := s.executions[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	var task TaskDefinition
+	if err := json.Unmarshal(data, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (s *MemoryStore) LookupCache(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.cache[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return "", false, nil
+	}
+	return entry.output, true, nil
+}
+
+func (s *MemoryStore) StoreCache(key, agentID, output string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[key] = memoryCacheEntry{output: output, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) PurgeExpiredCache() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	now := time.Now()
+	for key, entry := range s.cache {
+		if !now.Before(entry.expiresAt) {
+			delete(s.cache, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func memoryEmbeddingRows(t *testing.T, llm LLMClient, facts map[string]string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "content", "embedding"})
+	for id, fact := range facts {
+		rows.AddRow(id, fact, embeddingValue(t, llm, fact))
+	}
+	return rows
+}
+
+func TestRecallMemories(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT id, content, embedding FROM agent_memories\s+WHERE agent_id = \$1 AND owner = \$2`).
+		WithArgs("a1", "alice").
+		WillReturnRows(memoryEmbeddingRows(t, llm, map[string]string{
+			"m1": "The user manages the Denver clinic",
+			"m2": "Gardening on weekends relaxes them",
+		}))
+
+	facts, err := recallMemories(context.Background(), db, llm, "a1", "alice", "Which clinic does the user manage in Denver?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(facts, []string{"The user manages the Denver clinic"}) {
+		t.Errorf("facts = %v, want only the relevant one", facts)
+	}
+}
+
+func TestExtractMemories(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{
+		{System: "long-term memory", Response: `{"facts": ["The user wants answers as bullet points", "  ", "The user manages the Boulder clinic"]}`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &Agent{ID: "a1", Type: TypeLLM, Config: AgentConfig{UseMemory: true}}
+	conv := &Conversation{ID: "c1", Owner: "alice"}
+
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT id, content, embedding FROM agent_memories`).
+		WithArgs("a1", "alice").
+		WillReturnRows(memoryEmbeddingRows(t, llm, map[string]string{"m1": "The user wants answers as bullet points"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE agent_memories SET content = \$2, embedding = \$3, conversation_id = \$4`).
+		WithArgs("m1", "The user wants answers as bullet points", sqlmock.AnyArg(), "c1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO agent_memories`).
+		WithArgs("a1", "alice", "The user manages the Boulder clinic", sqlmock.AnyArg(), "c1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("m2"))
+	mock.ExpectCommit()
+
+	if err := extractMemories(context.Background(), db, llm, agent, conv, "I moved to Boulder, bullets please", "Noted."); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := llm.Calls()[0][1].Content; !strings.HasPrefix(prompt, "Already remembered:\n- The user wants answers as bullet points") {
+		t.Errorf("prompt = %q, want the remembered facts listed", prompt)
+	}
+}
+
+func TestMemoriesAreScopedToOwner(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, mock := newTestMock(t)
+	expectAgent := func() {
+		mock.ExpectQuery(`SELECT .* FROM agents WHERE id = \$1`).WithArgs("a1").
+			WillReturnRows(agentRows().AddRow("a1", "Helper", "helper", TypeLLM, "", "", []byte(`{}`), 1, false, "{}", "", "", nil))
+	}
+	expectAgent()
+	mock.ExpectQuery(`UPDATE agent_memories SET content = \$4, embedding = \$5, conversation_id = NULL\s+WHERE id::text = \$1 AND agent_id::text = \$2 AND owner = \$3`).
+		WithArgs("m1", "a1", "bob", "The user likes tea", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(strings.Split(memoryColumns, ", ")))
+	expectAgent()
+	mock.ExpectQuery(`UPDATE agent_memories`).
+		WithArgs("m1", "a1", "alice", "The user likes tea", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(strings.Split(memoryColumns, ", ")).
+			AddRow("m1", "a1", "alice", "The user likes tea", nil, time.Now(), time.Now()))
+	mock.ExpectExec(`DELETE FROM agent_memories\s+WHERE agent_id::text = \$1 AND owner = \$2`).
+		WithArgs("a1", "alice").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	route, target, body := "/agents/:id/memories/:mid", "/agents/a1/memories/m1", `{"content": " The user likes tea "}`
+	if w := serveTestRequest(http.MethodPut, route, target, body, asTestUser("bob"), UpdateMemory(db, llm)); w.Code != http.StatusNotFound {
+		t.Errorf("another user's memory: status = %d, want 404", w.Code)
+	}
+	w := serveTestRequest(http.MethodPut, route, target, body, asTestUser("alice"), UpdateMemory(db, llm))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"content":"The user likes tea"`) {
+		t.Errorf("owner: status = %d: %s", w.Code, w.Body)
+	}
+	w = serveTestRequest(http.MethodDelete, "/agents/:id/memories", "/agents/a1/memories", "", asTestUser("alice"), ForgetMemories(db))
+	if !strings.Contains(w.Body.String(), `"deleted":3`) {
+		t.Errorf("forget: %s", w.Body)
+	}
+}
+
+func TestAgentTurnIncludesMemories(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	turn := agentTurn{Message: "Hi", Memories: []string{"The user manages the Denver clinic"}}
+	if _, _, err := turn.reply(context.Background(), nil, llm, &Agent{Type: TypeLLM, Narrative: "You help clinics."}, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "You help clinics.\n\nWhat you remember about the user from earlier conversations:\n- The user manages the Denver clinic"
+	if system := llm.Calls()[0][0].Content; system != want {
+		t.Errorf("system prompt = %q, want %q", system, want)
+	}
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metadata organizes agents and workflows for discovery: free-form tags, a
+// slash-separated folder path and the owning user or team.
+type Metadata struct {
+	Tags   []string `json:"tags" yaml:"tags,omitempty"`
+	Folder string   `json:"folder" yaml:"folder,omitempty"`
+	Owner  string   `json:"owner" yaml:"owner,omitempty"`
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]*$`)
+
+// normalize lowercases, dedupes and sorts tags and cleans up the folder
+// path, so equal metadata always compares and indexes the same way.
+func (m *Metadata) normalize() error {
+	seen := make(map[string]bool, len(m.Tags))
+	tags := []string{}
+	for _, tag := range m.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if !tagPattern.MatchString(tag) || len(tag) > 64 {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	m.Tags = tags
+
+	var parts []string
+	for _, part := range strings.Split(m.Folder, "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	m.Folder = strings.Join(parts, "/")
+	m.Owner = strings.TrimSpace(m.Owner)
+	return nil
+}
+
+// defaultOwner makes the requesting user the owner of a new resource that
+// names none.
+func (m *Metadata) defaultOwner(c *gin.Context) {
+	if user := currentUser(c); m.Owner == "" && user != anonymousUser {
+		m.Owner = user
+	}
+}
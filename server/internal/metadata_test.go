@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetadataNormalize(t *testing.T) {
+	m := Metadata{
+		Tags:   []string{" Support ", "billing", "support", "", "team:ops"},
+		Folder: "/ Customers //EU/ ",
+		Owner:  " alice ",
+	}
+	if err := m.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{Tags: []string{"billing", "support", "team:ops"}, Folder: "Customers/EU", Owner: "alice"}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("normalized = %+v, want %+v", m, want)
+	}
+
+	for _, tag := range []string{"has space", "-leading", "ünïcode"} {
+		m := Metadata{Tags: []string{tag}}
+		if err := m.normalize(); err == nil {
+			t.Errorf("tag %q was accepted", tag)
+		}
+	}
+}
+
+func TestDefaultOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(userContextKey, "alice")
+
+	m := Metadata{}
+	m.defaultOwner(c)
+	if m.Owner != "alice" {
+		t.Errorf("owner = %q, want the caller", m.Owner)
+	}
+
+	m = Metadata{Owner: "team-billing"}
+	m.defaultOwner(c)
+	if m.Owner != "team-billing" {
+		t.Errorf("owner = %q, want the one given", m.Owner)
+	}
+}
+
+func TestUpdateAgentKeepsOwnerWhenOmitted(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`owner = COALESCE\(NULLIF\(\$12, ''\), owner\)`).
+		WithArgs("Writer", "", TypeLLM, "", "Write.", sqlmock.AnyArg(), "a1", nil, false, sqlmock.AnyArg(), "drafts", "").
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "owner", "version"}).AddRow("writer", "bob", 4))
+	mock.ExpectExec(`INSERT INTO agent_versions`).
+		WithArgs("a1", 4, "Writer", TypeLLM, "", "Write.", sqlmock.AnyArg(), "alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := `{"name": "Writer", "type": "llm", "narrative": "Write.", "tags": ["Draft"], "folder": "drafts/"}`
+	w := serveTestRequest(http.MethodPut, "/agents/:id", "/agents/a1", body, asTestUser("alice"), UpdateAgent(db))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var agent Agent
+	json.Unmarshal(w.Body.Bytes(), &agent)
+	if agent.Owner != "bob" || agent.Slug != "writer" || !reflect.DeepEqual(agent.Tags, []string{"draft"}) {
+		t.Errorf("agent = %+v, want the stored owner and normalized tags", agent)
+	}
+}
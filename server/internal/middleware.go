@@ -1,10 +1,70 @@
 package internal
 
-import "github.com/gin-gonic/gin"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
 
-func AuthMiddleware() gin.HandlerFunc {
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	userContextKey = "userID"
+	anonymousUser  = "anonymous"
+)
+
+// AuthMiddleware identifies the caller from a bearer token signed with
+// secret (see SignUserToken). Requests without a valid token are rejected.
+func AuthMiddleware(secret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// TODO: Implement proper authentication
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing bearer token"})
+			return
+		}
+		userID, err := verifyUserToken(secret, strings.TrimSpace(token))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(userContextKey, userID)
 		c.Next()
 	}
 }
+
+// SignUserToken returns a token that identifies userID to servers sharing
+// secret: the encoded user ID and its HMAC-SHA256, joined by a dot.
+func SignUserToken(secret, userID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(userID)) + "." +
+		base64.RawURLEncoding.EncodeToString(userTokenMAC(secret, userID))
+}
+
+func verifyUserToken(secret, token string) (string, error) {
+	encodedUser, encodedMAC, ok := strings.Cut(token, ".")
+	user, userErr := base64.RawURLEncoding.DecodeString(encodedUser)
+	mac, macErr := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if !ok || userErr != nil || macErr != nil || len(user) == 0 {
+		return "", fmt.Errorf("malformed bearer token")
+	}
+	if !hmac.Equal(mac, userTokenMAC(secret, string(user))) {
+		return "", fmt.Errorf("invalid bearer token")
+	}
+	return string(user), nil
+}
+
+func userTokenMAC(secret, userID string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(userID))
+	return h.Sum(nil)
+}
+
+// currentUser returns the ID of the user making the request.
+func currentUser(c *gin.Context) string {
+	if userID := c.GetString(userContextKey); userID != "" {
+		return userID
+	}
+	return anonymousUser
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/me", AuthMiddleware("secret"), func(c *gin.Context) {
+		c.String(http.StatusOK, currentUser(c))
+	})
+
+	valid := SignUserToken("secret", "alice")
+	_, aliceMAC, _ := strings.Cut(valid, ".")
+	bob, _, _ := strings.Cut(SignUserToken("secret", "bob"), ".")
+	tests := []struct {
+		name, authorization string
+		wantStatus          int
+		wantBody            string
+	}{
+		{"valid token", "Bearer " + valid, http.StatusOK, "alice"},
+		{"no token", "", http.StatusUnauthorized, ""},
+		{"bare user ID", "alice", http.StatusUnauthorized, ""},
+		{"other secret", "Bearer " + SignUserToken("other", "alice"), http.StatusUnauthorized, ""},
+		{"user swapped", "Bearer " + bob + "." + aliceMAC, http.StatusUnauthorized, ""},
+		{"malformed", "Bearer not-a-token", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+		if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+			t.Errorf("%s: user = %q, want %q", tt.name, w.Body, tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Narratives are templates with a small Handlebars-like syntax:
+//
+//	{{name}}                                   a variable; dots reach into objects
+//	{{#if name}}...{{else}}...{{/if}}           name is set and not empty, false or 0
+//	{{#each items}}{{@index}}: {{this}}{{/each}} a list; an item's keys are variables too
+//
+// Variables come from the agent's declared defaults, chat request
+// variables, workflow run parameters, upstream outputs (nodes.<id> and the
+// inputs list) and integrations.<provider>. Anything else in braces is left
+// as written.
+
+// NarrativeVariable declares a variable of an agent's narrative.
+type NarrativeVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Default     string `json:"default,omitempty"`
+}
+
+// MissingVariablesError names required variables that have no value.
+type MissingVariablesError []string
+
+func (e MissingVariablesError) Error() string {
+	return "missing required variables: " + strings.Join(e, ", ")
+}
+
+type templateNodeKind int
+
+const (
+	templateText templateNodeKind = iota
+	templateVar
+	templateIf
+	templateEach
+)
+
+type templateNode struct {
+	kind templateNodeKind
+	text string // templateText
+	path string // the variable, condition or list
+	body []templateNode
+	// orElse is the {{else}} branch of an if.
+	orElse []templateNode
+}
+
+var (
+	templateTag      = regexp.MustCompile(`\{\{\s*([#/]?)\s*([^{}]*?)\s*\}\}`)
+	templatePath     = regexp.MustCompile(`^(@index|[A-Za-z_][A-Za-z0-9_]*)(\.[A-Za-z0-9_]+)*$`)
+	variableNamePart = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Reserved variables filled by the server rather than by callers.
+var reservedVariables = map[string]bool{"this": true, "nodes": true, "inputs": true, "integrations": true}
+
+// parseNarrative parses a narrative template.
+func parseNarrative(src string) ([]templateNode, error) {
+	type frame struct {
+		node     templateNode
+		inElse   bool
+		children []templateNode
+		elseKids []templateNode
+	}
+	root := &frame{}
+	stack := []*frame{root}
+	appendNode := func(n templateNode) {
+		top := stack[len(stack)-1]
+		if top.inElse {
+			top.elseKids = append(top.elseKids, n)
+		} else {
+			top.children = append(top.children, n)
+		}
+	}
+
+	last := 0
+	for _, m := range templateTag.FindAllStringSubmatchIndex(src, -1) {
+		sigil, body := src[m[2]:m[3]], src[m[4]:m[5]]
+		var node *templateNode
+		switch {
+		case sigil == "#":
+			keyword, path, _ := strings.Cut(body, " ")
+			path = strings.TrimSpace(path)
+			if keyword != "if" && keyword != "each" {
+				return nil, fmt.Errorf("unknown block {{#%s}}", keyword)
+			}
+			if !templatePath.MatchString(path) {
+				return nil, fmt.Errorf("{{#%s}} needs a variable, got %q", keyword, path)
+			}
+			kind := templateIf
+			if keyword == "each" {
+				kind = templateEach
+			}
+			node = &templateNode{kind: kind, path: path}
+		case sigil == "/":
+			if body != "if" && body != "each" {
+				return nil, fmt.Errorf("unknown closing tag {{/%s}}", body)
+			}
+		case body == "else":
+		case templatePath.MatchString(body):
+			node = &templateNode{kind: templateVar, path: body}
+		default:
+			continue // not a tag; kept as text
+		}
+
+		start, end := m[0], m[1]
+		if node == nil || node.kind != templateVar {
+			start, end = standaloneLine(src, start, end)
+		}
+		if start > last {
+			appendNode(templateNode{kind: templateText, text: src[last:start]})
+		}
+		last = end
+
+		switch {
+		case node != nil && node.kind == templateVar:
+			appendNode(*node)
+		case node != nil:
+			stack = append(stack, &frame{node: *node})
+		case sigil == "/":
+			top := stack[len(stack)-1]
+			if len(stack) == 1 || (top.node.kind == templateIf) != (body == "if") {
+				return nil, fmt.Errorf("unexpected {{/%s}}", body)
+			}
+			stack = stack[:len(stack)-1]
+			top.node.body, top.node.orElse = top.children, top.elseKids
+			appendNode(top.node)
+		default: // else
+			top := stack[len(stack)-1]
+			if len(stack) == 1 || top.node.kind != templateIf || top.inElse {
+				return nil, fmt.Errorf("unexpected {{else}}")
+			}
+			top.inElse = true
+		}
+	}
+	if len(stack) > 1 {
+		top := stack[len(stack)-1]
+		keyword := "if"
+		if top.node.kind == templateEach {
+			keyword = "each"
+		}
+		return nil, fmt.Errorf("{{#%s %s}} is not closed", keyword, top.node.path)
+	}
+	if last < len(src) {
+		root.children = append(root.children, templateNode{kind: templateText, text: src[last:]})
+	}
+	return root.children, nil
+}
+
+// standaloneLine widens a block tag alone on its line to the whole line, so
+// that blocks leave no blank lines behind.
+func standaloneLine(src string, start, end int) (int, int) {
+	lineStart := strings.LastIndexByte(src[:start], '\n') + 1
+	lineEnd := len(src)
+	if i := strings.IndexByte(src[end:], '\n'); i >= 0 {
+		lineEnd = end + i + 1
+	}
+	if strings.TrimSpace(src[lineStart:start]) != "" || strings.TrimSpace(src[end:lineEnd]) != "" {
+		return start, end
+	}
+	return lineStart, lineEnd
+}
+
+// templateScope resolves variables, innermost {{#each}} item first.
+type templateScope struct {
+	vars   map[string]interface{}
+	item   interface{}
+	index  int
+	parent *templateScope
+}
+
+func (s *templateScope) lookup(path string) interface{} {
+	parts := strings.Split(path, ".")
+	var value interface{}
+	switch {
+	case parts[0] == "@index":
+		return s.index
+	case parts[0] == "this":
+		value = s.item
+	default:
+		found := false
+		for scope := s; scope != nil && !found; scope = scope.parent {
+			if m, ok := scope.item.(map[string]interface{}); ok {
+				value, found = m[parts[0]]
+			}
+			if !found && scope.parent == nil {
+				value, found = scope.vars[parts[0]]
+			}
+		}
+	}
+	for _, key := range parts[1:] {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+func renderTemplate(nodes []templateNode, scope *templateScope, out *strings.Builder) {
+	for _, n := range nodes {
+		switch n.kind {
+		case templateText:
+			out.WriteString(n.text)
+		case templateVar:
+			out.WriteString(templateString(scope.lookup(n.path)))
+		case templateIf:
+			if truthy(scope.lookup(n.path)) {
+				renderTemplate(n.body, scope, out)
+			} else {
+				renderTemplate(n.orElse, scope, out)
+			}
+		case templateEach:
+			items, _ := scope.lookup(n.path).([]interface{})
+			for i, item := range items {
+				renderTemplate(n.body, &templateScope{item: item, index: i, parent: scope}, out)
+			}
+		}
+	}
+}
+
+func templateString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map {
+		return rv.Len() > 0
+	}
+	return true
+}
+
+// templateRoots lists the top-level variables the template refers to
+// outside {{#each}} bodies.
+func templateRoots(nodes []templateNode) map[string]bool {
+	roots := map[string]bool{}
+	var walk func([]templateNode)
+	walk = func(nodes []templateNode) {
+		for _, n := range nodes {
+			if n.kind == templateText {
+				continue
+			}
+			roots[strings.Split(n.path, ".")[0]] = true
+			walk(n.orElse)
+			if n.kind != templateEach {
+				walk(n.body)
+			}
+		}
+	}
+	walk(nodes)
+	return roots
+}
+
+// checkNarrative reports a narrative that is not a valid template.
+func checkNarrative(narrative string, errs FieldErrors) {
+	if _, err := parseNarrative(narrative); err != nil {
+		errs["narrative"] = err.Error()
+	}
+}
+
+func checkVariables(variables []NarrativeVariable, errs FieldErrors) {
+	seen := map[string]bool{}
+	for i, v := range variables {
+		path := fmt.Sprintf("config.variables[%d].name", i)
+		switch {
+		case !variableNamePart.MatchString(v.Name):
+			errs[path] = "use letters, digits and underscores"
+		case reservedVariables[v.Name]:
+			errs[path] = fmt.Sprintf("%q is filled by the server", v.Name)
+		case seen[v.Name]:
+			errs[path] = fmt.Sprintf("duplicate variable %q", v.Name)
+		}
+		seen[v.Name] = true
+	}
+}
+
+// missingVariables lists the required variables vars leaves empty.
+func (cfg AgentConfig) missingVariables(vars map[string]interface{}) MissingVariablesError {
+	var missing MissingVariablesError
+	for _, v := range cfg.Variables {
+		if v.Required && v.Default == "" && !truthyOrFalse(vars[v.Name]) {
+			missing = append(missing, v.Name)
+		}
+	}
+	return missing
+}
+
+// truthyOrFalse reports whether value counts as given: false and 0 are
+// values, empty strings are not.
+func truthyOrFalse(value interface{}) bool {
+	switch v := value.(type) {
+	case bool, float64, int:
+		return true
+	case string:
+		return strings.TrimSpace(v) != ""
+	default:
+		return truthy(v)
+	}
+}
+
+// renderNarrative fills the agent's narrative from vars over its declared
+// defaults. Integration metadata is loaded only if the narrative uses it.
+func renderNarrative(agent *Agent, vars map[string]interface{}, integrations func() (map[string]interface{}, error)) (string, error) {
+	if !strings.Contains(agent.Narrative, "{{") {
+		if missing := agent.Config.missingVariables(vars); missing != nil {
+			return "", missing
+		}
+		return agent.Narrative, nil
+	}
+	nodes, err := parseNarrative(agent.Narrative)
+	if err != nil {
+		return "", fmt.Errorf("invalid narrative: %v", err)
+	}
+
+	values := make(map[string]interface{}, len(vars)+len(agent.Config.Variables))
+	for _, v := range agent.Config.Variables {
+		if v.Default != "" {
+			values[v.Name] = v.Default
+		}
+	}
+	for name, value := range vars {
+		if !truthyOrFalse(value) && values[name] != nil {
+			continue // an empty value keeps the default
+		}
+		values[name] = value
+	}
+	if missing := agent.Config.missingVariables(values); missing != nil {
+		return "", missing
+	}
+	if templateRoots(nodes)["integrations"] && integrations != nil {
+		if values["integrations"], err = integrations(); err != nil {
+			return "", fmt.Errorf("failed to load integrations: %v", err)
+		}
+	}
+
+	var out strings.Builder
+	renderTemplate(nodes, &templateScope{vars: values}, &out)
+	return out.String(), nil
+}
+
+// withNarrative returns a copy of agent with its narrative rendered.
+func withNarrative(agent *Agent, vars map[string]interface{}, integrations func() (map[string]interface{}, error)) (*Agent, error) {
+	narrative, err := renderNarrative(agent, vars, integrations)
+	if err != nil {
+		return nil, err
+	}
+	rendered := *agent
+	rendered.Narrative = narrative
+	return &rendered, nil
+}
+
+// integrationMetadataKeys are the config keys of each provider that
+// narratives may read. Credentials are never exposed.
+var integrationMetadataKeys = map[string][]string{
+	"snowflake": {"account", "database", "schema", "warehouse"},
+}
+
+// integrationMetadata describes the connected integrations by provider.
+func integrationMetadata(db *sql.DB) (map[string]interface{}, error) {
+	rows, err := db.Query(`SELECT ` + integrationColumns + ` FROM integrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := map[string]interface{}{}
+	for rows.Next() {
+		integration, err := scanIntegration(rows)
+		if err != nil {
+			return nil, err
+		}
+		entry := map[string]interface{}{
+			"name":        integration.Name,
+			"type":        integration.Type,
+			"description": integration.Description,
+		}
+		for _, key := range integrationMetadataKeys[integration.Provider] {
+			if value, ok := integration.Config[key]; ok {
+				entry[key] = value
+			}
+		}
+		metadata[integration.Provider] = entry
+	}
+	return metadata, rows.Err()
+}
+
+// nodeVariables are the narrative variables of a workflow node: the run
+// parameters, nodes.<id> for each upstream output and inputs listing them.
+// Outputs that are JSON objects or arrays are decoded so paths reach into
+// them.
+func nodeVariables(params map[string]string, nodeID string, edges []TaskEdge, outputs map[string]string) map[string]interface{} {
+	vars := make(map[string]interface{}, len(params)+2)
+	for k, v := range params {
+		vars[k] = v
+	}
+	nodes := map[string]interface{}{}
+	inputs := []interface{}{}
+	for _, edge := range edges {
+		output, ok := outputs[edge.Source]
+		if edge.Target != nodeID || !ok {
+			continue
+		}
+		var value interface{} = output
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(output), &decoded); err == nil {
+			switch decoded.(type) {
+			case map[string]interface{}, []interface{}:
+				value = decoded
+			}
+		}
+		nodes[edge.Source] = value
+		inputs = append(inputs, value)
+	}
+	vars["nodes"] = nodes
+	vars["inputs"] = inputs
+	return vars
+}
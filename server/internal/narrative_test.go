@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func renderTestNarrative(t *testing.T, src string, vars map[string]interface{}) string {
+	t.Helper()
+	nodes, err := parseNarrative(src)
+	if err != nil {
+		t.Fatalf("parseNarrative(%q): %v", src, err)
+	}
+	var out strings.Builder
+	renderTemplate(nodes, &templateScope{vars: vars}, &out)
+	return out.String()
+}
+
+func TestParseNarrative(t *testing.T) {
+	vars := map[string]interface{}{
+		"name":    "Ada",
+		"patient": map[string]interface{}{"mrn": "A-1"},
+		"urgent":  true,
+		"quiet":   false,
+		"items":   []interface{}{"x", "y"},
+		"people":  []interface{}{map[string]interface{}{"name": "Bo"}, map[string]interface{}{"name": "Cy"}},
+	}
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"text", "Be brief.", "Be brief."},
+		{"variable", "Hello {{name}}!", "Hello Ada!"},
+		{"spaces in tag", "Hello {{ name }}!", "Hello Ada!"},
+		{"dotted path", "MRN {{patient.mrn}}", "MRN A-1"},
+		{"missing variable", "[{{nope}}]", "[]"},
+		{"if", "{{#if urgent}}now{{/if}}", "now"},
+		{"if false with else", "{{#if quiet}}shh{{else}}loud{{/if}}", "loud"},
+		{"each", "{{#each items}}{{@index}}={{this}} {{/each}}", "0=x 1=y "},
+		{"each item keys", "{{#each people}}{{name}};{{/each}}", "Bo;Cy;"},
+		{"outer variable in each", "{{#each items}}{{name}}{{/each}}", "AdaAda"},
+		{"standalone block lines", "a\n{{#if urgent}}\nb\n{{/if}}\nc", "a\nb\nc"},
+		{"not a tag", "JSON: {{ \"a\": 1 }}", "JSON: {{ \"a\": 1 }}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderTestNarrative(t, tt.src, vars); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNarrativeErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"{{#if urgent}}open", "{{#if urgent}} is not closed"},
+		{"{{/if}}", "unexpected {{/if}}"},
+		{"{{#if a}}{{/each}}", "unexpected {{/each}}"},
+		{"{{else}}", "unexpected {{else}}"},
+		{"{{#if a}}{{else}}{{else}}{{/if}}", "unexpected {{else}}"},
+		{"{{#with a}}{{/with}}", "unknown block {{#with}}"},
+		{"{{#each}}{{/each}}", "{{#each}} needs a variable"},
+	}
+	for _, tt := range tests {
+		_, err := parseNarrative(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseNarrative(%q) = %v, want an error containing %q", tt.src, err, tt.want)
+		}
+	}
+}
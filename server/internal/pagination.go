@@ -0,0 +1,275 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Page is the envelope returned by every list endpoint. NextCursor is set
+// when more rows follow; pass it back as ?cursor= to fetch them.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// listSpec describes what a list endpoint can be sorted, filtered and
+// searched by. Keys are query parameter values; values are SQL expressions.
+type listSpec struct {
+	table       string
+	columns     string
+	sorts       map[string]string
+	defaultSort string
+	filters     map[string]string
+	search      []string
+	// archivable tables hide rows with archived_at set unless ?archived=
+	// is true (only archived rows) or all.
+	archivable bool
+	// tagged tables accept ?tag=a,b (rows having all tags) and ?folder=
+	// (the folder and everything below it).
+	tagged bool
+}
+
+// listQuery is a parsed list request:
+//
+//	?limit=50&cursor=...&sort=-created_at&q=support&status=active,draft
+type listQuery struct {
+	limit    int
+	sortKey  string
+	desc     bool
+	cursor   *pageCursor
+	search   string
+	filters  map[string][]string
+	archived string
+	tags     []string
+	folder   string
+}
+
+// pageCursor is the keyset position after the last row of a page. The sort
+// key is recorded so a cursor can't be replayed against a different order.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func encodeCursor(cur pageCursor) string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cur pageCursor
+	if err := json.Unmarshal(data, &cur); err != nil || cur.ID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cur, nil
+}
+
+func parseListQuery(c *gin.Context, spec listSpec) (*listQuery, error) {
+	q := &listQuery{limit: defaultPageSize, filters: map[string][]string{}}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.limit = limit
+	}
+
+	sort := c.DefaultQuery("sort", spec.defaultSort)
+	q.desc = strings.HasPrefix(sort, "-")
+	q.sortKey = strings.TrimPrefix(sort, "-")
+	if _, ok := spec.sorts[q.sortKey]; !ok {
+		return nil, fmt.Errorf("cannot sort by %q", q.sortKey)
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decodeCursor(raw)
+		if err != nil {
+			return nil, err
+		}
+		if cur.Sort != sort {
+			return nil, fmt.Errorf("cursor does not match sort %q", sort)
+		}
+		q.cursor = cur
+	}
+
+	if spec.archivable {
+		q.archived = c.DefaultQuery("archived", "false")
+		if q.archived != "false" && q.archived != "true" && q.archived != "all" {
+			return nil, fmt.Errorf("archived must be true, false or all")
+		}
+	}
+
+	if spec.tagged {
+		meta := Metadata{Folder: c.Query("folder")}
+		if raw := c.Query("tag"); raw != "" {
+			meta.Tags = strings.Split(raw, ",")
+		}
+		if err := meta.normalize(); err != nil {
+			return nil, err
+		}
+		q.tags, q.folder = meta.Tags, meta.Folder
+	}
+
+	if len(spec.search) > 0 {
+		q.search = strings.TrimSpace(c.Query("q"))
+	}
+
+	for param := range spec.filters {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				q.filters[param] = append(q.filters[param], value)
+			}
+		}
+	}
+	return q, nil
+}
+
+// sql builds the page query. Two extra columns, the row's sort value and
+// id as text, are selected after spec.columns to build the next cursor.
+func (q *listQuery) sql(spec listSpec) (string, []interface{}) {
+	sortExpr := spec.sorts[q.sortKey]
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch q.archived {
+	case "false":
+		where = append(where, "archived_at IS NULL")
+	case "true":
+		where = append(where, "archived_at IS NOT NULL")
+	}
+
+	for param, values := range q.filters {
+		where = append(where, fmt.Sprintf("%s = ANY(%s)", spec.filters[param], arg(pq.Array(values))))
+	}
+
+	if len(q.tags) > 0 {
+		where = append(where, fmt.Sprintf("tags @> %s", arg(pq.Array(q.tags))))
+	}
+	if q.folder != "" {
+		where = append(where, fmt.Sprintf("(folder = %s OR folder LIKE %s)", arg(q.folder), arg(escapeLike(q.folder)+"/%")))
+	}
+
+	if q.search != "" {
+		pattern := arg("%" + escapeLike(q.search) + "%")
+		var clauses []string
+		for _, column := range spec.search {
+			clauses = append(clauses, fmt.Sprintf("COALESCE(%s, '') ILIKE %s", column, pattern))
+		}
+		where = append(where, "("+strings.Join(clauses, " OR ")+")")
+	}
+
+	direction, op := "ASC", ">"
+	if q.desc {
+		direction, op = "DESC", "<"
+	}
+	if q.cursor != nil {
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", sortExpr, op, arg(q.cursor.Value), arg(q.cursor.ID)))
+	}
+
+	query := fmt.Sprintf(`SELECT %s, (%s)::text, id::text FROM %s`, spec.columns, sortExpr, spec.table)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %d", sortExpr, direction, direction, q.limit+1)
+	return query, args
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// cursorScanner appends the sort value and id columns to every Scan so the
+// resource scanners can be reused unchanged.
+type cursorScanner struct {
+	row       rowScanner
+	sortValue *string
+	id        *string
+}
+
+func (s cursorScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.sortValue, s.id)...)
+}
+
+// queryPage runs a list query and scans one page of results.
+func queryPage[T any](db *sql.DB, spec listSpec, q *listQuery, scan func(rowScanner) (*T, error)) (*Page[T], error) {
+	query, args := q.sql(spec)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &Page[T]{Items: []T{}}
+	var last pageCursor
+	for rows.Next() {
+		if len(page.Items) == q.limit {
+			page.HasMore = true
+			break
+		}
+		var sortValue, id string
+		item, err := scan(cursorScanner{row: rows, sortValue: &sortValue, id: &id})
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, *item)
+		last = pageCursor{Value: sortValue, ID: id}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if page.HasMore {
+		last.Sort = q.sortKey
+		if q.desc {
+			last.Sort = "-" + q.sortKey
+		}
+		page.NextCursor = encodeCursor(last)
+	}
+	return page, nil
+}
+
+// listHandler serves a paginated list endpoint for spec.
+func listHandler[T any](db *sql.DB, spec listSpec, scan func(rowScanner) (*T, error), resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, err := parseListQuery(c, spec)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		page, err := queryPage(db, spec, q, scan)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch " + resource})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+type testItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+var testListSpec = listSpec{
+	table:       "items",
+	columns:     "id, name",
+	sorts:       map[string]string{"name": "name", "created_at": "created_at"},
+	defaultSort: "name",
+	filters:     map[string]string{"status": "status"},
+	search:      []string{"name"},
+	archivable:  true,
+	tagged:      true,
+}
+
+func scanTestItem(row rowScanner) (*testItem, error) {
+	var item testItem
+	return &item, row.Scan(&item.ID, &item.Name)
+}
+
+func parseTestListQuery(t *testing.T, rawQuery string) (*listQuery, error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/items?"+rawQuery, nil)
+	return parseListQuery(c, testListSpec)
+}
+
+func TestParseListQueryRejects(t *testing.T) {
+	otherSort := encodeCursor(pageCursor{Sort: "-name", Value: "b", ID: "2"})
+	for _, rawQuery := range []string{
+		"limit=0",
+		"limit=201",
+		"limit=ten",
+		"sort=owner",
+		"cursor=not-base64!",
+		"cursor=" + encodeCursor(pageCursor{Sort: "name"}),
+		"cursor=" + otherSort,
+		"archived=maybe",
+		"tag=has%20space",
+	} {
+		if _, err := parseTestListQuery(t, rawQuery); err == nil {
+			t.Errorf("?%s was accepted", rawQuery)
+		}
+	}
+}
+
+func TestListQuerySQL(t *testing.T) {
+	cursor := encodeCursor(pageCursor{Sort: "-created_at", Value: "2024-01-02", ID: "7"})
+	q, err := parseTestListQuery(t, "limit=10&sort=-created_at&cursor="+cursor+"&q=50%25_off&status=active,draft&tag=B,a&folder=/ops/&archived=all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, args := q.sql(testListSpec)
+	want := `SELECT id, name, (created_at)::text, id::text FROM items` +
+		` WHERE status = ANY($1) AND tags @> $2 AND (folder = $3 OR folder LIKE $4)` +
+		` AND (COALESCE(name, '') ILIKE $5) AND (created_at, id) < ($6, $7)` +
+		` ORDER BY created_at DESC, id DESC LIMIT 11`
+	if query != want {
+		t.Errorf("query =\n%s\nwant\n%s", query, want)
+	}
+	wantArgs := []interface{}{
+		pq.Array([]string{"active", "draft"}),
+		pq.Array([]string{"a", "b"}),
+		"ops", "ops/%",
+		`%50\%\_off%`,
+		"2024-01-02", "7",
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestListQueryHidesArchivedRowsByDefault(t *testing.T) {
+	q, err := parseTestListQuery(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, args := q.sql(testListSpec)
+	want := `SELECT id, name, (name)::text, id::text FROM items WHERE archived_at IS NULL ORDER BY name ASC, id ASC LIMIT 51`
+	if query != want || len(args) != 0 {
+		t.Errorf("query = %s %v, want %s", query, args, want)
+	}
+}
+
+func TestListHandlerPagesWithCursors(t *testing.T) {
+	db, mock := newTestMock(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/items", listHandler(db, testListSpec, scanTestItem, "items"))
+
+	get := func(rawQuery string) Page[testItem] {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items?"+rawQuery, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET ?%s = %d: %s", rawQuery, w.Code, w.Body)
+		}
+		var page Page[testItem]
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+	columns := []string{"id", "name", "sort", "id"}
+
+	// One row more than the limit is fetched to learn whether more follow.
+	mock.ExpectQuery(regexp.QuoteMeta(`ORDER BY name ASC, id ASC LIMIT 3`)).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("1", "alpha", "alpha", "1").
+			AddRow("2", "beta", "beta", "2").
+			AddRow("3", "gamma", "gamma", "3"))
+	first := get("limit=2")
+	if len(first.Items) != 2 || !first.HasMore || first.NextCursor == "" {
+		t.Fatalf("first page = %+v", first)
+	}
+	if cur, _ := decodeCursor(first.NextCursor); *cur != (pageCursor{Sort: "name", Value: "beta", ID: "2"}) {
+		t.Errorf("cursor = %+v", cur)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`(name, id) > ($1, $2) ORDER BY name ASC, id ASC LIMIT 3`)).
+		WithArgs("beta", "2").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("3", "gamma", "gamma", "3"))
+	second := get("limit=2&cursor=" + first.NextCursor)
+	if len(second.Items) != 1 || second.Items[0].Name != "gamma" || second.HasMore || second.NextCursor != "" {
+		t.Errorf("second page = %+v", second)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 type SnowflakeRetriever struct {
@@ -17,10 +19,20 @@ type SnowflakeRetriever struct {
 }
 
 type Document struct {
-	ID        string
-	Content   string
-	Metadata  map[string]interface{}
-	Embedding []float32
+	ID        string                 `json:"id"`
+	Content   string                 `json:"content"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Embedding []float32              `json:"-"`
+	// Score is the cosine similarity to the query a document was found by.
+	Score float64 `json:"score,omitempty"`
+}
+
+// Retriever is a knowledge store agents can ground their replies in.
+type Retriever interface {
+	// FindSimilar returns up to k documents scoring at least threshold
+	// against query, best first.
+	FindSimilar(ctx context.Context, query string, k int, threshold float64) ([]Document, error)
+	IngestDocuments(ctx context.Context, docs []Document, opts IngestOptions) error
 }
 
 type IngestOptions struct {
@@ -38,49 +50,38 @@ func NewSnowflakeRetriever(db *sql.DB, tableName string, provider string, llmCli
 	}
 }
 
-func (r *SnowflakeRetriever) FindSimilar(ctx context.Context, query string, k int) ([]Document, error) {
+func (r *SnowflakeRetriever) FindSimilar(ctx context.Context, query string, k int, threshold float64) ([]Document, error) {
 	queryEmbeddings, err := r.llmClient.CreateEmbeddings(ctx, []string{query})
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
 	}
 	queryEmbedding := queryEmbeddings[0]
 
-	// Convert []float32 to []float64 for database compatibility
-	queryEmbedding64 := make([]float64, len(queryEmbedding))
-	for i, v := range queryEmbedding {
-		queryEmbedding64[i] = float64(v)
-	}
-
 	// Convert embedding to string format: [1.0, 2.0, 3.0, ...]
-	embStr := "["
-	for i, v := range queryEmbedding64 {
-		if i > 0 {
-			embStr += ","
-		}
-		embStr += fmt.Sprintf("%f", v)
+	parts := make([]string, len(queryEmbedding))
+	for i, v := range queryEmbedding {
+		parts[i] = strconv.FormatFloat(float64(v), 'f', -1, 32)
 	}
-	embStr += "]"
+	embStr := "[" + strings.Join(parts, ",") + "]"
 
 	sqlQuery := fmt.Sprintf(`
 		WITH similarity_scores AS (
-			SELECT 
+			SELECT
 				id,
 				content,
 				metadata,
-				embedding,
-				vector_cosine_similarity(embedding, parse_json('%s')::vector(float, %d)) as score
-			FROM %s
-			ORDER BY score DESC
-			LIMIT %d
+				vector_cosine_similarity(embedding::vector(float, %[2]d), parse_json('%[1]s')::vector(float, %[2]d)) as score
+			FROM %[3]s
 		)
-		SELECT id, content, metadata, embedding, score
+		SELECT id, content, metadata, score
 		FROM similarity_scores
-		WHERE score > 0.7  -- Minimum similarity threshold
-	`, embStr, len(queryEmbedding), r.tableName, k)
+		WHERE score >= %[4]f
+		ORDER BY score DESC
+		LIMIT %[5]d
+	`, embStr, len(queryEmbedding), r.tableName, threshold, k)
 
-	rows, err := r.db.QueryContext(ctx, sqlQuery, k)
+	rows, err := r.db.QueryContext(ctx, sqlQuery)
 	if err != nil {
-		fmt.Println("Error querying similar documents:", err)
 		return nil, fmt.Errorf("failed to query similar documents: %w", err)
 	}
 	defer rows.Close()
@@ -88,15 +89,16 @@ func (r *SnowflakeRetriever) FindSimilar(ctx context.Context, query string, k in
 	var docs []Document
 	for rows.Next() {
 		var doc Document
-		var metadataJSON string
-		var score float64
+		var metadataJSON sql.NullString
 
-		if err := rows.Scan(&doc.ID, &doc.Content, &metadataJSON, &doc.Embedding, &score); err != nil {
+		if err := rows.Scan(&doc.ID, &doc.Content, &metadataJSON, &doc.Score); err != nil {
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 
-		if err := json.Unmarshal([]byte(metadataJSON), &doc.Metadata); err != nil {
-			return nil, fmt.Errorf("failed to parse metadata: %w", err)
+		if metadataJSON.Valid && metadataJSON.String != "" {
+			if err := json.Unmarshal([]byte(metadataJSON.String), &doc.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to parse metadata: %w", err)
+			}
 		}
 
 		docs = append(docs, doc)
@@ -136,7 +138,7 @@ func (r *SnowflakeRetriever) IngestDocuments(ctx context.Context, docs []Documen
 	// Insert into Snowflake
 	query := fmt.Sprintf(`
 		INSERT INTO %s (id, content, metadata, embedding)
-		VALUES (?, ?, PARSE_JSON(?), ?)
+		SELECT ?, ?, PARSE_JSON(?), PARSE_JSON(?)
 	`, r.tableName)
 
 	for i, doc := range chunks {
@@ -145,11 +147,15 @@ func (r *SnowflakeRetriever) IngestDocuments(ctx context.Context, docs []Documen
 			return fmt.Errorf("failed to marshal metadata: %w", err)
 		}
 
+		embedding, err := json.Marshal(embeddings[i])
+		if err != nil {
+			return fmt.Errorf("failed to marshal embedding: %w", err)
+		}
 		_, err = r.db.ExecContext(ctx, query,
 			doc.ID,
 			doc.Content,
 			string(metadata),
-			embeddings[i],
+			string(embedding),
 		)
 		if err != nil {
 			return fmt.Errorf("failed to insert document: %w", err)
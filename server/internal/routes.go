@@ -6,11 +6,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, db *sql.DB, llmClient LLMClient) {
-	r.Use(AuthMiddleware())
+func SetupRoutes(r *gin.Engine, db *sql.DB, llmClient LLMClient, authSecret string) {
+	// Google redirects here without a token; the OAuth state is checked instead.
+	r.GET("/api/v1/integrations/google-drive/callback", HandleGoogleDriveCallback(db))
 
 	// API v1 group
-	v1 := r.Group("/api/v1")
+	v1 := r.Group("/api/v1", AuthMiddleware(authSecret))
 	{
 		// Workflow routes
 		workflows := v1.Group("/workflows")
@@ -20,6 +21,14 @@ func SetupRoutes(r *gin.Engine, db *sql.DB, llmClient LLMClient) {
 			workflows.GET("/:id", GetWorkflow(db))
 			workflows.PUT("/:id", UpdateWorkflow(db))
 			workflows.DELETE("/:id", DeleteWorkflow(db))
+			workflows.POST("/:id/restore", RestoreWorkflow(db))
+			workflows.POST("/:id/clone", CloneWorkflow(db))
+			workflows.DELETE("/:id/purge", PurgeWorkflow(db))
+			workflows.GET("/:id/versions", ListWorkflowVersions(db))
+			workflows.GET("/:id/versions/:version", GetWorkflowVersion(db))
+			workflows.GET("/:id/diff", DiffWorkflowVersions(db))
+			workflows.POST("/:id/rollback", RollbackWorkflow(db))
+			workflows.POST("/:id/run", RunWorkflow(db, llmClient))
 		}
 
 		// Agent routes
@@ -27,10 +36,47 @@ func SetupRoutes(r *gin.Engine, db *sql.DB, llmClient LLMClient) {
 		{
 			agents.GET("/", ListAgents(db))
 			agents.POST("/", CreateAgent(db))
+			agents.GET("/schema", GetAgentConfigSchema(llmClient))
+			agents.GET("/functions", ListAgentFunctions())
 			agents.GET("/:id", GetAgent(db))
 			agents.PUT("/:id", UpdateAgent(db))
 			agents.DELETE("/:id", DeleteAgent(db))
+			agents.POST("/:id/restore", RestoreAgent(db))
+			agents.POST("/:id/clone", CloneAgent(db))
+			agents.DELETE("/:id/purge", PurgeAgent(db))
+			agents.GET("/:id/versions", ListAgentVersions(db))
+			agents.GET("/:id/versions/:version", GetAgentVersion(db))
+			agents.GET("/:id/diff", DiffAgentVersions(db))
+			agents.GET("/:id/tests", ListTestCases(db))
+			agents.POST("/:id/tests", CreateTestCase(db))
+			agents.PUT("/:id/tests/:tid", UpdateTestCase(db))
+			agents.DELETE("/:id/tests/:tid", DeleteTestCase(db))
+			agents.POST("/:id/evaluate", EvaluateAgent(db, llmClient))
+			agents.GET("/:id/evaluations", ListEvaluations(db))
+			agents.GET("/:id/evaluations/:eid", GetEvaluation(db))
 			agents.POST("/:id/chat", ChatWithAgent(db, llmClient))
+			agents.POST("/:id/documents", IngestAgentDocuments(db, llmClient))
+			agents.POST("/:id/documents/search", SearchAgentDocuments(db, llmClient))
+			agents.POST("/:id/chat/stream", StreamChatWithAgent(db, llmClient))
+			agents.GET("/:id/conversations", ListConversations(db))
+			agents.POST("/:id/conversations", CreateConversation(db))
+			agents.GET("/:id/conversations/:cid", GetConversation(db))
+			agents.PUT("/:id/conversations/:cid", RenameConversation(db))
+			agents.DELETE("/:id/conversations/:cid", DeleteConversation(db))
+			agents.POST("/:id/conversations/:cid/messages", PostConversationMessage(db, llmClient))
+			agents.POST("/:id/conversations/:cid/messages/stream", StreamConversationMessage(db, llmClient))
+			agents.GET("/:id/memories", ListMemories(db))
+			agents.DELETE("/:id/memories", ForgetMemories(db))
+			agents.PUT("/:id/memories/:mid", UpdateMemory(db, llmClient))
+			agents.DELETE("/:id/memories/:mid", DeleteMemory(db))
+		}
+
+		// Declarative definition routes
+		definitions := v1.Group("/definitions")
+		{
+			definitions.POST("/plan", PlanDefinitionsHandler(db))
+			definitions.POST("/apply", ApplyDefinitionsHandler(db))
+			definitions.GET("/export", ExportDefinitionsHandler(db))
 		}
 
 		// LLM routes
@@ -39,8 +85,14 @@ func SetupRoutes(r *gin.Engine, db *sql.DB, llmClient LLMClient) {
 			llm.POST("/complete", HandleLLMComplete(llmClient))
 		}
 
+		// Tools agents can call
+		v1.GET("/tools", ListTools(db, llmClient))
+
 		// Execute routes
-		v1.POST("/execute", ExecuteTask(db))
+		v1.POST("/execute", ExecuteTask(db, llmClient))
+		v1.GET("/executions", ListExecutions(db))
+		v1.GET("/executions/:id", GetExecution(db))
+		v1.POST("/executions/:id/nodes/:node/complete", CompleteHumanNode(db, llmClient))
 
 		// Integration routes
 		integrationRoutes := v1.Group("/integrations")
@@ -55,7 +107,6 @@ func SetupRoutes(r *gin.Engine, db *sql.DB, llmClient LLMClient) {
 			googleDrive := integrationRoutes.Group("/google-drive")
 			{
 				googleDrive.GET("/auth", InitiateGoogleDriveAuth(db))
-				googleDrive.GET("/callback", HandleGoogleDriveCallback(db))
 				googleDrive.POST("/disconnect", DisconnectGoogleDrive(db))
 				googleDrive.GET("/status", GetIntegrationStatus(db))
 			}
@@ -18,12 +18,11 @@ type SnowflakeConfig struct {
 	Database  string `json:"database"`
 	Schema    string `json:"schema"`
 	Warehouse string `json:"warehouse"`
-}
+	// ReadOnlyRole is the role agent tools and lookups query as. Grant it
+	// SELECT only; queries from agents are refused without it.
+	ReadOnlyRole string `json:"read_only_role,omitempty"`
 
-type PatientQuery struct {
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
-	DOB       string `json:"dob"`
+	role string
 }
 
 func ConnectSnowflake(db *sql.DB) gin.HandlerFunc {
@@ -147,70 +146,36 @@ func TestSnowflake(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-func QueryPatientData(db *sql.DB, params PatientQuery) (string, error) {
-	// First get Snowflake config
+// openSnowflake connects to the configured Snowflake integration. Callers
+// close the connection.
+func openSnowflake(db *sql.DB) (*sql.DB, error) {
 	config, err := getSnowflakeConfig(db)
 	if err != nil {
-		return "", fmt.Errorf("failed to get Snowflake config: %v", err)
+		return nil, fmt.Errorf("Snowflake is not connected")
 	}
-
-	// Connect to Snowflake
 	snowflakeDB, err := connectToSnowflake(config)
 	if err != nil {
-		return "", fmt.Errorf("failed to connect to Snowflake: %v", err)
+		return nil, fmt.Errorf("failed to connect to Snowflake: %v", err)
 	}
-	defer snowflakeDB.Close()
-
-	query := `
-		SELECT 
-			id,
-			created_at, 
-			code_display,
-			category,
-			value_quantity_value,
-			value_quantity_unit,
-			reference_range_display
-		FROM data_platform_dev.scotty_dev.observation
-		LIMIT 1000  -- Limit results to prevent token overflow
-	`
-
-	rows, err := snowflakeDB.Query(query)
-	fmt.Println(params.FirstName, params.LastName, params.DOB)
+	return snowflakeDB, nil
+}
+
+// openSnowflakeReadOnly connects to the Snowflake integration as its
+// read-only role, for queries written by agents and their authors.
+func openSnowflakeReadOnly(db *sql.DB) (*sql.DB, error) {
+	config, err := getSnowflakeConfig(db)
 	if err != nil {
-		return "", fmt.Errorf("failed to query patient data: %v", err)
+		return nil, fmt.Errorf("Snowflake is not connected")
 	}
-	defer rows.Close()
-
-	var result strings.Builder
-	result.WriteString("Recent Observations:\n\n")
-
-	for rows.Next() {
-		var (
-			id, createdAt, codeDisplay                                             string
-			category, valueQuantityValue, valueQuantityUnit, referenceRangeDisplay sql.NullString
-		)
-
-		if err := rows.Scan(&id, &createdAt, &codeDisplay, &category, &valueQuantityValue, &valueQuantityUnit, &referenceRangeDisplay); err != nil {
-			return "", fmt.Errorf("failed to scan row: %v", err)
-		}
-
-		// Handle NULL values
-		value := valueQuantityValue.String
-		unit := valueQuantityUnit.String
-		refRange := referenceRangeDisplay.String
-
-		result.WriteString(fmt.Sprintf("ID: %s\nDate: %s\nCode: %s\nCategory: %s\nValue: %s %s\nReference Range: %s\n\n",
-			id,
-			createdAt,
-			codeDisplay,
-			category.String,
-			value,
-			unit,
-			refRange,
-		))
+	if config.ReadOnlyRole == "" {
+		return nil, fmt.Errorf("the Snowflake integration has no read_only_role for agent queries")
 	}
-
-	return result.String(), nil
+	config.role = config.ReadOnlyRole
+	snowflakeDB, err := connectToSnowflake(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Snowflake: %v", err)
+	}
+	return snowflakeDB, nil
 }
 
 func connectToSnowflake(config SnowflakeConfig) (*sql.DB, error) {
@@ -221,6 +186,7 @@ func connectToSnowflake(config SnowflakeConfig) (*sql.DB, error) {
 		Database:  config.Database,
 		Schema:    config.Schema,
 		Warehouse: config.Warehouse,
+		Role:      config.role,
 	}
 	connString, err := gosnowflake.DSN(&snowflakeConfig)
 	if err != nil {
@@ -282,13 +248,23 @@ func removeSnowflakeConfig(db *sql.DB) error {
 }
 
 func processQueryResults(rows *sql.Rows) ([]map[string]interface{}, error) {
+	results, _, err := processQueryRows(rows, 0)
+	return results, err
+}
+
+// processQueryRows reads at most limit rows, or all of them when limit is 0,
+// and reports whether more were left unread.
+func processQueryRows(rows *sql.Rows, limit int) ([]map[string]interface{}, bool, error) {
 	columns, err := rows.Columns()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	var results []map[string]interface{}
 	for rows.Next() {
+		if limit > 0 && len(results) == limit {
+			return results, true, nil
+		}
 		values := make([]interface{}, len(columns))
 		valuePointers := make([]interface{}, len(columns))
 		for i := range values {
@@ -296,7 +272,7 @@ func processQueryResults(rows *sql.Rows) ([]map[string]interface{}, error) {
 		}
 
 		if err := rows.Scan(valuePointers...); err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
 		row := make(map[string]interface{})
@@ -306,7 +282,7 @@ func processQueryResults(rows *sql.Rows) ([]map[string]interface{}, error) {
 		results = append(results, row)
 	}
 
-	return results, rows.Err()
+	return results, false, rows.Err()
 }
 
 func parseAccountIdentifier(fullAccount string) string {
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ExecutionStore is the state the executor reads and writes while running a
+// task: agents, execution progress and the node result cache.
+type ExecutionStore interface {
+	// GetAgent resolves a node's agent reference, "id" or "id@version".
+	GetAgent(ref string) (*Agent, error)
+	CreateExecution(task *TaskDefinition) (string, error)
+	UpdateExecution(task *TaskDefinition) error
+	LookupCache(key string) (string, bool, error)
+	StoreCache(key, agentID, output string, ttl time.Duration) error
+	PurgeExpiredCache() (int64, error)
+	// Integrations describes the connected integrations for narratives.
+	Integrations() (map[string]interface{}, error)
+}
+
+// PostgresStore is the ExecutionStore used by the server.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) GetAgent(ref string) (*Agent, error) {
+	return fetchAgentRef(s.db, ref)
+}
+
+func (s *PostgresStore) Integrations() (map[string]interface{}, error) {
+	return integrationMetadata(s.db)
+}
+
+func (s *PostgresStore) CreateExecution(task *TaskDefinition) (string, error) {
+	nodesJSON, err := json.Marshal(task.Nodes)
+	if err != nil {
+		return "", err
+	}
+
+	edgesJSON, err := json.Marshal(task.Edges)
+	if err != nil {
+		return "", err
+	}
+
+	resultsJSON, err := json.Marshal(task.Results)
+	if err != nil {
+		return "", err
+	}
+
+	paramsJSON, err := json.Marshal(task.Params)
+	if err != nil {
+		return "", err
+	}
+
+	var taskID string
+	err = s.db.QueryRow(`
+		INSERT INTO executions (workflow_id, workflow_version, params, status, nodes, edges, results)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		nullIfEmpty(task.WorkflowID), task.WorkflowVersion, paramsJSON, task.Status, nodesJSON, edgesJSON, resultsJSON,
+	).Scan(&taskID)
+
+	return taskID, err
+}
+
+func (s *PostgresStore) UpdateExecution(task *TaskDefinition) error {
+	nodesJSON, err := json.Marshal(task.Nodes)
+	if err != nil {
+		return err
+	}
+
+	edgesJSON, err := json.Marshal(task.Edges)
+	if err != nil {
+		return err
+	}
+
+	resultsJSON, err := json.Marshal(task.Results)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE executions
+		SET status = $1, nodes = $2, edges = $3, results = $4
+		WHERE id = $5`,
+		task.Status, nodesJSON, edgesJSON, resultsJSON, task.ID,
+	)
+
+	return err
+}
+
+func (s *PostgresStore) LookupCache(key string) (string, bool, error) {
+	return lookupNodeCache(s.db, key)
+}
+
+func (s *PostgresStore) StoreCache(key, agentID, output string, ttl time.Duration) error {
+	return storeNodeCache(s.db, key, agentID, output, ttl)
+}
+
+func (s *PostgresStore) PurgeExpiredCache() (int64, error) {
+	return purgeExpiredNodeCache(s.db)
+}
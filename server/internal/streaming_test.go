@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectStreamedConversation(mock sqlmock.Sqlmock) {
+	now := time.Now()
+	mock.ExpectQuery(`SELECT .* FROM agents WHERE id = \$1`).
+		WithArgs("a1").
+		WillReturnRows(agentRows().AddRow("a1", "Support", "support", TypeLLM, "", "You help customers.", []byte(`{}`), 1, false, "{}", "", "", nil))
+	mock.ExpectQuery(`FROM conversations\s+WHERE id::text = \$1 AND agent_id::text = \$2 AND owner = \$3`).
+		WithArgs("c1", "a1", "alice").
+		WillReturnRows(conversationRows().AddRow("c1", "a1", "alice", "", "", 0, []byte(`{}`), now, now))
+	mock.ExpectQuery(`FROM conversation_messages`).
+		WithArgs("c1", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role", "content", "sources", "created_at"}))
+}
+
+func TestStreamConversationMessage(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{{Match: "hours", Response: "We open at nine."}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, mock := newTestMock(t)
+	expectStreamedConversation(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO conversation_messages`).
+		WithArgs("c1", "user", "What are your hours?", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`INSERT INTO conversation_messages`).
+		WithArgs("c1", "assistant", "We open at nine.", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role", "content", "sources", "created_at"}).
+			AddRow(2, "assistant", "We open at nine.", "{}", time.Now()))
+	mock.ExpectExec(`UPDATE conversations`).
+		WithArgs("c1", "What are your hours?", []byte(`{}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serveTestRequest(http.MethodPost, "/agents/:id/conversations/:cid/messages/stream", "/agents/a1/conversations/c1/messages/stream",
+		`{"message": "What are your hours?"}`, asTestUser("alice"), StreamConversationMessage(db, llm))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"event:start\ndata:{\"conversation_id\":\"c1\"}",
+		"event:token\ndata:{\"text\":\"We \"}",
+		"event:token\ndata:{\"text\":\"nine.\"}",
+		"event:done\ndata:{",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream is missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Index(body, "event:done") < strings.LastIndex(body, "event:token") {
+		t.Error("done was sent before the last token")
+	}
+}
+
+func TestStreamConversationMessageReportsErrors(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{{Error: "model overloaded"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, mock := newTestMock(t)
+	expectStreamedConversation(mock)
+
+	w := serveTestRequest(http.MethodPost, "/agents/:id/conversations/:cid/messages/stream", "/agents/a1/conversations/c1/messages/stream",
+		`{"message": "Hello"}`, asTestUser("alice"), StreamConversationMessage(db, llm))
+	if body := w.Body.String(); !strings.Contains(body, "event:error") || !strings.Contains(body, "model overloaded") || strings.Contains(body, "event:done") {
+		t.Errorf("stream = %s, want an error event and nothing stored", body)
+	}
+}
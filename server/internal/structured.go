@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// structuredOutputTool is the tool a model answers through when asked
+	// for structured output.
+	structuredOutputTool = "respond_with_json"
+	// maxStructuredAttempts bounds the model turns spent on one structured
+	// reply, the first included.
+	maxStructuredAttempts = 3
+)
+
+// structuredTurn runs one model turn offering tool, the single tool the
+// reply should call.
+type structuredTurn func(messages []Message, tool ToolSpec) (Message, Usage, error)
+
+// completeStructured asks for JSON matching schema until a reply validates,
+// feeding the validation error of each failed attempt back to the model.
+// The schema is offered as a tool's input; tool input must be an object, so
+// other schemas are wrapped in {"value": ...}. A reply in text rather than a
+// tool call is accepted too, as long as it holds the JSON.
+func completeStructured(messages []Message, schema map[string]interface{}, turn structuredTurn) (json.RawMessage, Usage, error) {
+	toolSchema, wrapped := schema, schema["type"] != "object"
+	if wrapped {
+		toolSchema = map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"value": schema},
+			"required":   []interface{}{"value"},
+		}
+	}
+	tool := ToolSpec{
+		Name:        structuredOutputTool,
+		Description: "Give your final answer. The input is the answer itself.",
+		InputSchema: toolSchema,
+	}
+	schemaJSON, _ := json.MarshalIndent(schema, "", "  ")
+	messages = withSystemNote(messages, fmt.Sprintf("Answer by calling the %s tool. The answer must match this JSON Schema:\n%s\nIf you cannot call the tool, respond with ONLY the JSON, with no other text.", structuredOutputTool, schemaJSON))
+
+	var total Usage
+	var lastErr error
+	for attempt := 0; attempt < maxStructuredAttempts; attempt++ {
+		reply, usage, err := turn(messages, tool)
+		total.InputTokens += usage.InputTokens
+		total.OutputTokens += usage.OutputTokens
+		total.TotalTokens += usage.TotalTokens
+		if err != nil {
+			return nil, total, err
+		}
+
+		output, fromTool := reply.Content, false
+		for _, call := range reply.ToolCalls {
+			if call.Name == structuredOutputTool {
+				output, fromTool = string(call.Input), true
+			}
+		}
+		value, err := decodeStructured(output, fromTool && wrapped)
+		if err == nil {
+			err = validateJSONSchema(schema, value)
+		}
+		if err == nil {
+			data, _ := json.Marshal(value)
+			return data, total, nil
+		}
+
+		lastErr = err
+		messages = append(messages,
+			Message{Role: "assistant", Content: output},
+			Message{Role: "user", Content: fmt.Sprintf("That answer is invalid: %v. Answer again with corrected JSON matching the schema.", err)},
+		)
+	}
+	return nil, total, fmt.Errorf("no valid structured output after %d attempts: %v", maxStructuredAttempts, lastErr)
+}
+
+// decodeStructured reads the JSON value of a reply. Text replies may wrap
+// it in prose or a code fence. Tool input of a wrapped schema is unwrapped.
+func decodeStructured(output string, unwrap bool) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(jsonValueIn(output)), &value); err != nil {
+		return nil, fmt.Errorf("not JSON: %v", err)
+	}
+	if unwrap {
+		object, _ := value.(map[string]interface{})
+		return object["value"], nil
+	}
+	return value, nil
+}
+
+// jsonValueIn returns the outermost JSON object or array in a model's
+// response, which may be wrapped in prose or a code fence.
+func jsonValueIn(response string) string {
+	start := strings.IndexAny(response, "{[")
+	if start < 0 {
+		return strings.TrimSpace(response)
+	}
+	closer := "}"
+	if response[start] == '[' {
+		closer = "]"
+	}
+	end := strings.LastIndex(response, closer)
+	if end < start {
+		return strings.TrimSpace(response)
+	}
+	return response[start : end+1]
+}
+
+// withSystemNote appends note to the system prompt, adding one if there is
+// none, without changing messages.
+func withSystemNote(messages []Message, note string) []Message {
+	out := append([]Message(nil), messages...)
+	if len(out) > 0 && out[0].Role == "system" {
+		out[0].Content = strings.TrimSpace(out[0].Content + "\n\n" + note)
+		return out
+	}
+	return append([]Message{{Role: "system", Content: note}}, out...)
+}
+
+// structuredReply makes an agent's reply conform to its output schema. A
+// reply that already does is returned as compact JSON; otherwise the model
+// is asked to restate it.
+func structuredReply(llm LLMClient, messages []Message, reply string, schema map[string]interface{}, params ModelParams) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(jsonValueIn(reply)), &value); err == nil && validateJSONSchema(schema, value) == nil {
+		data, _ := json.Marshal(value)
+		return string(data), nil
+	}
+	restate := append(append([]Message(nil), messages...),
+		Message{Role: "assistant", Content: reply},
+		Message{Role: "user", Content: "Restate your answer as JSON."},
+	)
+	output, _, err := llm.CompleteJSON(restate, schema, params.Model, params.Temperature, &params.MaxTokens)
+	return string(output), err
+}
+
+// parseNodeOutputSchema reads a node's "outputSchema" configuration, the
+// JSON Schema its result must match. It overrides the agent's own.
+func parseNodeOutputSchema(configuration map[string]interface{}) (map[string]interface{}, error) {
+	raw, ok := configuration["outputSchema"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	schema, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("outputSchema must be a JSON Schema object")
+	}
+	if err := checkJSONSchema(schema); err != nil {
+		return nil, fmt.Errorf("outputSchema: %v", err)
+	}
+	return schema, nil
+}
@@ -0,0 +1,345 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Agent sharing modes when cloning a workflow.
+const (
+	CloneShareAgents = "share" // the clone's nodes use the same agents
+	CloneCopyAgents  = "clone" // every referenced agent is copied too
+)
+
+type CloneAgentRequest struct {
+	Name        string  `json:"name"`
+	Slug        string  `json:"slug"`
+	Description *string `json:"description"`
+	Narrative   *string `json:"narrative"`
+	// Config keys are merged over the source agent's config.
+	Config   map[string]interface{} `json:"config"`
+	Template bool                   `json:"template"`
+	// Folder and Owner default to the source's folder and the caller.
+	Folder *string `json:"folder"`
+	Owner  *string `json:"owner"`
+}
+
+type CloneWorkflowRequest struct {
+	Name        string  `json:"name"`
+	Slug        string  `json:"slug"`
+	Description *string `json:"description"`
+	// Parameters are merged over the source workflow's defaults.
+	Parameters map[string]string `json:"parameters"`
+	Agents     string            `json:"agents"`
+	Template   bool              `json:"template"`
+	Folder     *string           `json:"folder"`
+	Owner      *string           `json:"owner"`
+}
+
+type CloneWorkflowResult struct {
+	Workflow *Workflow `json:"workflow"`
+	// Agents lists the agent copies made with agents=clone.
+	Agents []Agent `json:"agents"`
+}
+
+// uniqueSlug returns base, or base with the first free numeric suffix.
+func uniqueSlug(tx *sql.Tx, table, base string) (string, error) {
+	candidate := base
+	for i := 2; ; i++ {
+		var taken bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM `+table+` WHERE slug = $1)`, candidate).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func cloneAgent(tx *sql.Tx, source *Agent, req CloneAgentRequest, author string) (*Agent, error) {
+	clone := *source
+	clone.ID = ""
+	clone.ArchivedAt = nil
+	clone.IsTemplate = req.Template
+	clone.Name = req.Name
+	if clone.Name == "" {
+		clone.Name = source.Name + " (copy)"
+	}
+	if req.Description != nil {
+		clone.Description = *req.Description
+	}
+	if req.Narrative != nil {
+		clone.Narrative = *req.Narrative
+		if _, err := parseNarrative(clone.Narrative); err != nil {
+			return nil, FieldErrors{"narrative": err.Error()}
+		}
+	}
+	clone.Tags = append([]string(nil), source.Tags...)
+	if req.Folder != nil {
+		clone.Folder = *req.Folder
+	}
+	if req.Owner != nil {
+		clone.Owner = *req.Owner
+	}
+	if err := clone.Metadata.normalize(); err != nil {
+		return nil, err
+	}
+
+	config := source.Config.toMap()
+	for k, v := range req.Config {
+		config[k] = v
+	}
+	var errs FieldErrors
+	if clone.Config, errs = parseAgentConfig(clone.Type, config); errs != nil {
+		return nil, errs
+	}
+	configJSON, err := json.Marshal(clone.Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	if req.Slug != "" {
+		clone.Slug = req.Slug
+	} else if clone.Slug, err = uniqueSlug(tx, "agents", slugify(clone.Name)); err != nil {
+		return nil, err
+	}
+	if !validSlug(clone.Slug) {
+		return nil, fmt.Errorf("invalid slug %q", clone.Slug)
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO agents (name, slug, type, description, narrative, config, is_template, tags, folder, owner)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, version`,
+		clone.Name, clone.Slug, clone.Type, clone.Description, clone.Narrative, configJSON, clone.IsTemplate,
+		pq.Array(clone.Tags), clone.Folder, clone.Owner,
+	).Scan(&clone.ID, &clone.Version)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertAgentVersion(tx, &clone, configJSON, author); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
+
+// cloneOwner is the owner of a clone: the one requested, else the caller,
+// else the source's owner.
+func cloneOwner(c *gin.Context, requested *string) *string {
+	if requested != nil {
+		return requested
+	}
+	if user := currentUser(c); user != anonymousUser {
+		return &user
+	}
+	return nil
+}
+
+// CloneAgent creates a new agent from an existing one, typically a template.
+func CloneAgent(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CloneAgentRequest
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Owner = cloneOwner(c, req.Owner)
+
+		source, err := fetchAgent(db, c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		clone, err := cloneAgent(tx, source, req, currentUser(c))
+		if errs, ok := err.(FieldErrors); ok {
+			respondFieldErrors(c, "agent", errs)
+			return
+		}
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("An agent with slug %q already exists", req.Slug)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to clone agent: %v", err)})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		setETag(c, clone.Version)
+		c.JSON(http.StatusCreated, clone)
+	}
+}
+
+// CloneWorkflow creates a new workflow from an existing one. With
+// agents=clone every agent the DAG uses is copied as well, so the new
+// workflow can be tuned without affecting the original.
+func CloneWorkflow(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CloneWorkflowRequest
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Agents == "" {
+			req.Agents = CloneShareAgents
+		}
+		req.Owner = cloneOwner(c, req.Owner)
+		if req.Agents != CloneShareAgents && req.Agents != CloneCopyAgents {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "agents must be share or clone"})
+			return
+		}
+
+		source, err := fetchWorkflow(db, c.Param("id"))
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow"})
+			return
+		}
+
+		clone := *source
+		clone.ArchivedAt = nil
+		clone.IsTemplate = req.Template
+		clone.Status = StatusInactive
+		clone.Name = req.Name
+		if clone.Name == "" {
+			clone.Name = source.Name + " (copy)"
+		}
+		if req.Description != nil {
+			clone.Description = *req.Description
+		}
+		clone.Parameters = mergeParams(source.Parameters, req.Parameters)
+		clone.Tags = append([]string(nil), source.Tags...)
+		if req.Folder != nil {
+			clone.Folder = *req.Folder
+		}
+		if req.Owner != nil {
+			clone.Owner = *req.Owner
+		}
+		if err := clone.Metadata.normalize(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		clone.Dag.Nodes = append([]DagNode(nil), source.Dag.Nodes...)
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		result := CloneWorkflowResult{Workflow: &clone, Agents: []Agent{}}
+		if req.Agents == CloneCopyAgents {
+			copies := make(map[string]string)
+			for i, node := range clone.Dag.Nodes {
+				if node.Type != NodeTypeAgent {
+					continue
+				}
+				if id, ok := copies[node.AgentID]; ok {
+					clone.Dag.Nodes[i].AgentID = id
+					continue
+				}
+
+				// A pinned node gets a copy of the version it pins.
+				agent, err := fetchAgentRef(tx, node.AgentID)
+				if err == sql.ErrNoRows {
+					c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Node %s uses an agent that no longer exists", node.ID)})
+					return
+				}
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
+					return
+				}
+
+				copied, err := cloneAgent(tx, agent, CloneAgentRequest{
+					Name:   fmt.Sprintf("%s (%s)", agent.Name, clone.Name),
+					Folder: &clone.Folder,
+					Owner:  &clone.Owner,
+				}, currentUser(c))
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to clone agent %s: %v", agent.Slug, err)})
+					return
+				}
+				copies[node.AgentID] = copied.ID
+				clone.Dag.Nodes[i].AgentID = copied.ID
+				result.Agents = append(result.Agents, *copied)
+			}
+		}
+
+		if req.Slug != "" {
+			clone.Slug = req.Slug
+		} else if clone.Slug, err = uniqueSlug(tx, "workflows", slugify(clone.Name)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate slug"})
+			return
+		}
+		if !validSlug(clone.Slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug: use lowercase letters, digits and dashes"})
+			return
+		}
+
+		dagJSON, err := json.Marshal(clone.Dag)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode DAG"})
+			return
+		}
+		paramsJSON, err := json.Marshal(clone.Parameters)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parameters"})
+			return
+		}
+
+		err = tx.QueryRow(`
+			INSERT INTO workflows (name, slug, description, status, dag, schedule, is_template, parameters, tags, folder, owner)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id, version, created_at, updated_at`,
+			clone.Name, clone.Slug, clone.Description, clone.Status, dagJSON, clone.Schedule, clone.IsTemplate, paramsJSON,
+			pq.Array(clone.Tags), clone.Folder, clone.Owner,
+		).Scan(&clone.ID, &clone.Version, &clone.CreatedAt, &clone.UpdatedAt)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A workflow with slug %q already exists", clone.Slug)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+			return
+		}
+
+		if err := insertWorkflowVersion(tx, &clone, dagJSON, currentUser(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record workflow version"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		setETag(c, clone.Version)
+		c.JSON(http.StatusCreated, result)
+	}
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// asTestUser stands in for AuthMiddleware with an already verified user.
+func asTestUser(id string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(userContextKey, id)
+	}
+}
+
+// argFunc matches a query argument with a predicate.
+type argFunc func(driver.Value) bool
+
+func (f argFunc) Match(v driver.Value) bool { return f(v) }
+
+func TestCloneAgent(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT .* FROM agents WHERE id = \$1`).
+		WithArgs("a1").
+		WillReturnRows(agentRows().AddRow("a1", "Writer", "writer", TypeLLM, "Drafts posts.", "Write.",
+			[]byte(`{"model": "m", "temperature": 0.2}`), 3, true, "{docs}", "team/a", "bob", nil))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM agents WHERE slug = \$1\)`).
+		WithArgs("writer-copy").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM agents WHERE slug = \$1\)`).
+		WithArgs("writer-copy-2").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`INSERT INTO agents`).
+		WithArgs("Writer (copy)", "writer-copy-2", TypeLLM, "Drafts posts.", "Write.", sqlmock.AnyArg(), false, sqlmock.AnyArg(), "team/a", "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow("a2", 1))
+	mock.ExpectExec(`INSERT INTO agent_versions`).
+		WithArgs("a2", 1, "Writer (copy)", TypeLLM, "Drafts posts.", "Write.", sqlmock.AnyArg(), "alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serveTestRequest(http.MethodPost, "/agents/:id/clone", "/agents/a1/clone", `{"config": {"temperature": 0.9}}`,
+		asTestUser("alice"), CloneAgent(db))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var clone struct {
+		ID         string                 `json:"id"`
+		Slug       string                 `json:"slug"`
+		IsTemplate bool                   `json:"is_template"`
+		Tags       []string               `json:"tags"`
+		Owner      string                 `json:"owner"`
+		Config     map[string]interface{} `json:"config"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &clone)
+	if clone.ID != "a2" || clone.Slug != "writer-copy-2" || clone.Owner != "alice" {
+		t.Errorf("clone = %+v", clone)
+	}
+	if len(clone.Tags) != 1 || clone.Tags[0] != "docs" {
+		t.Errorf("tags = %v, want the source's", clone.Tags)
+	}
+	if clone.Config["model"] != "m" || clone.Config["temperature"] != 0.9 {
+		t.Errorf("config = %v, want the override merged over the source", clone.Config)
+	}
+}
+
+func TestCloneAgentRejectsInvalidConfig(t *testing.T) {
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT .* FROM agents WHERE id = \$1`).
+		WillReturnRows(agentRows().AddRow("a1", "Writer", "writer", TypeLLM, "", "Write.", []byte(`{}`), 1, false, "{}", "", "", nil))
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	w := serveTestRequest(http.MethodPost, "/agents/:id/clone", "/agents/a1/clone", `{"config": {"temperature": 7}}`,
+		asTestUser("alice"), CloneAgent(db))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400: %s", w.Code, w.Body)
+	}
+}
+
+func TestCloneWorkflowCopiesAgents(t *testing.T) {
+	dagJSON, _ := json.Marshal(Dag{
+		Nodes: []DagNode{
+			{ID: "draft", Type: NodeTypeAgent, AgentID: "a1"},
+			{ID: "polish", Type: NodeTypeAgent, AgentID: "a1"},
+			{ID: "approve", Type: NodeTypeHuman, UserID: "carol"},
+		},
+		Edges: testEdges("draft", "polish", "polish", "approve"),
+	})
+	now := time.Now()
+	copiedAgents := argFunc(func(v driver.Value) bool {
+		var dag Dag
+		if err := json.Unmarshal(v.([]byte), &dag); err != nil {
+			return false
+		}
+		return dag.Nodes[0].AgentID == "a2" && dag.Nodes[1].AgentID == "a2" && dag.Nodes[2].UserID == "carol"
+	})
+
+	db, mock := newTestMock(t)
+	mock.ExpectQuery(`SELECT .* FROM workflows WHERE id = \$1`).
+		WithArgs("w1").
+		WillReturnRows(workflowRows().AddRow("w1", "Nightly", "nightly", "", StatusActive, dagJSON, "@daily", 4, now, now, true,
+			[]byte(`{"region": "eu", "limit": "10"}`), "{reports}", "ops", "bob", nil))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .* FROM agents WHERE id = \$1`).
+		WithArgs("a1").
+		WillReturnRows(agentRows().AddRow("a1", "Writer", "writer", TypeLLM, "", "Write.", []byte(`{}`), 3, false, "{}", "", "bob", nil))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM agents WHERE slug = \$1\)`).
+		WithArgs("writer-nightly-eu").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`INSERT INTO agents`).
+		WithArgs("Writer (Nightly EU)", "writer-nightly-eu", TypeLLM, "", "Write.", sqlmock.AnyArg(), false, sqlmock.AnyArg(), "ops", "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow("a2", 1))
+	mock.ExpectExec(`INSERT INTO agent_versions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM workflows WHERE slug = \$1\)`).
+		WithArgs("nightly-eu").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`INSERT INTO workflows`).
+		WithArgs("Nightly EU", "nightly-eu", "", StatusInactive, copiedAgents, "@daily", false,
+			[]byte(`{"limit":"10","region":"us"}`), sqlmock.AnyArg(), "ops", "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at", "updated_at"}).AddRow("w2", 1, now, now))
+	mock.ExpectExec(`INSERT INTO workflow_versions`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := `{"name": "Nightly EU", "agents": "clone", "parameters": {"region": "us"}}`
+	w := serveTestRequest(http.MethodPost, "/workflows/:id/clone", "/workflows/w1/clone", body, asTestUser("alice"), CloneWorkflow(db))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var result CloneWorkflowResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Workflow.ID != "w2" || result.Workflow.IsTemplate || result.Workflow.Status != StatusInactive {
+		t.Errorf("workflow = %+v", result.Workflow)
+	}
+	if len(result.Agents) != 1 || result.Agents[0].ID != "a2" {
+		t.Errorf("agents = %+v, want one copy shared by both nodes", result.Agents)
+	}
+}
+
+func TestCloneWorkflowRejectsUnknownSharing(t *testing.T) {
+	db, _ := newTestMock(t)
+	w := serveTestRequest(http.MethodPost, "/workflows/:id/clone", "/workflows/w1/clone", `{"agents": "link"}`, CloneWorkflow(db))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
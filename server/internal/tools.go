@@ -0,0 +1,417 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+)
+
+const (
+	defaultMaxToolSteps = 5
+	maxToolSteps        = 20
+	toolTimeout         = 2 * time.Minute
+	maxToolResultBytes  = 20000
+	maxToolRows         = 200
+)
+
+// Tool is something an LLM agent can call while it answers. Built-in tools
+// wrap integrations; registered agent functions are tools too.
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+	// Integration is the integration the tool needs to be connected.
+	Integration string `json:"integration,omitempty"`
+
+	run func(ctx context.Context, r *ToolRegistry, input json.RawMessage) (string, error)
+	// wrapsInput is set for functions whose input is not an object, which
+	// take it as {"input": ...}.
+	wrapsInput bool
+}
+
+func (t Tool) spec() ToolSpec {
+	return ToolSpec{Name: t.Name, Description: t.Description, InputSchema: t.InputSchema}
+}
+
+// builtinTools is a function rather than a variable because run_workflow
+// reaches back into the executor, which calls tools.
+func builtinTools() []Tool {
+	return []Tool{
+		{
+			Name:        "snowflake_query",
+			Description: "Run a read-only SQL query (SELECT, WITH, SHOW, DESCRIBE or EXPLAIN) against the connected Snowflake warehouse. Returns at most 200 rows as JSON.",
+			Integration: "snowflake",
+			InputSchema: map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"query"},
+				"properties": map[string]interface{}{
+					"query": map[string]interface{}{"type": "string", "description": "A single SQL statement"},
+				},
+			},
+			run: runSnowflakeQueryTool,
+		},
+		{
+			Name:        "drive_search",
+			Description: "Search the connected Google Drive by full text. Returns matching file names, types and links.",
+			Integration: "google_drive",
+			InputSchema: map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"query"},
+				"properties": map[string]interface{}{
+					"query": map[string]interface{}{"type": "string"},
+					"limit": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 50},
+				},
+			},
+			run: runDriveSearchTool,
+		},
+		{
+			Name:        "run_workflow",
+			Description: "Run a saved workflow by slug or ID and wait for it to finish. Returns the status and the output of its final nodes.",
+			InputSchema: map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"workflow"},
+				"properties": map[string]interface{}{
+					"workflow": map[string]interface{}{"type": "string", "description": "Workflow slug or ID"},
+					"params": map[string]interface{}{
+						"type":                 "object",
+						"description":          "Run parameters",
+						"additionalProperties": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+			run: runWorkflowTool,
+		},
+	}
+}
+
+// ToolRegistry resolves the tools an agent may call. Integration tools are
+// only offered while their integration is connected.
+type ToolRegistry struct {
+	db  *sql.DB // nil when running locally
+	llm LLMClient
+	// nested is set inside run_workflow so a workflow cannot start itself
+	// through its own agents.
+	nested bool
+}
+
+func NewToolRegistry(db *sql.DB, llm LLMClient) *ToolRegistry {
+	return &ToolRegistry{db: db, llm: llm}
+}
+
+// knownTool reports whether name is a built-in tool or a registered
+// function, whether or not it is currently usable.
+func knownTool(name string) bool {
+	for _, tool := range builtinTools() {
+		if tool.Name == name {
+			return true
+		}
+	}
+	_, ok := lookupAgentFunction(name)
+	return ok
+}
+
+// all returns every tool with whether it can be used right now.
+func (r *ToolRegistry) all() ([]Tool, map[string]bool) {
+	tools := builtinTools()
+
+	agentFunctionsMu.RLock()
+	for _, fn := range agentFunctions {
+		tools = append(tools, functionTool(fn))
+	}
+	agentFunctionsMu.RUnlock()
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+
+	available := make(map[string]bool, len(tools))
+	connected := map[string]bool{}
+	for _, tool := range tools {
+		switch {
+		case tool.Integration != "":
+			if _, checked := connected[tool.Integration]; !checked {
+				connected[tool.Integration] = r.integrationConnected(tool.Integration)
+			}
+			available[tool.Name] = connected[tool.Integration]
+		case tool.Name == "run_workflow":
+			available[tool.Name] = r.db != nil && !r.nested
+		default:
+			available[tool.Name] = true
+		}
+	}
+	return tools, available
+}
+
+// allowed returns the usable tools among names, in the order given.
+func (r *ToolRegistry) allowed(names []string) []Tool {
+	tools, available := r.all()
+	byName := make(map[string]Tool, len(tools))
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+	}
+
+	var allowed []Tool
+	for _, name := range names {
+		if tool, ok := byName[name]; ok && available[name] {
+			allowed = append(allowed, tool)
+		}
+	}
+	return allowed
+}
+
+func (r *ToolRegistry) integrationConnected(provider string) bool {
+	if r.db == nil {
+		return false
+	}
+	var exists bool
+	var err error
+	switch provider {
+	case "snowflake":
+		err = r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM integrations WHERE provider = $1)`, provider).Scan(&exists)
+	default:
+		err = r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM integration_tokens WHERE provider = $1)`, provider).Scan(&exists)
+	}
+	return err == nil && exists
+}
+
+// call runs one tool call. Failures are returned as text so the model can
+// see them and recover.
+func (r *ToolRegistry) call(tools []Tool, call ToolCall) string {
+	for _, tool := range tools {
+		if tool.Name != call.Name {
+			continue
+		}
+		result, err := r.run(tool, call.Input)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return truncateText(result, maxToolResultBytes)
+	}
+	return fmt.Sprintf("error: tool %q is not available to this agent", call.Name)
+}
+
+// runStep runs the named tool as a step of a custom agent's pipeline, on the
+// previous step's output. Steps get the same availability, input checks
+// and timeout as a call from the model.
+func (r *ToolRegistry) runStep(name, input string) (string, error) {
+	tools := r.allowed([]string{name})
+	if len(tools) == 0 {
+		return "", fmt.Errorf("tool %q is not available", name)
+	}
+	tool := tools[0]
+
+	payload := functionPayload(input)
+	if tool.wrapsInput {
+		payload, _ = json.Marshal(map[string]json.RawMessage{"input": payload})
+	}
+	return r.run(tool, payload)
+}
+
+// run checks input against the tool's schema and runs the tool with a
+// deadline.
+func (r *ToolRegistry) run(tool Tool, input json.RawMessage) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(input, &value); err != nil {
+		return "", fmt.Errorf("input is not valid JSON")
+	}
+	if err := validateJSONSchema(tool.InputSchema, value); err != nil {
+		return "", fmt.Errorf("invalid input: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), toolTimeout)
+	defer cancel()
+	return tool.run(ctx, r, input)
+}
+
+// functionTool exposes a registered agent function. Tool inputs must be
+// objects, so other inputs are wrapped in {"input": ...}.
+func functionTool(fn AgentFunction) Tool {
+	tool := Tool{Name: fn.Name, Description: fn.Description, InputSchema: fn.InputSchema}
+	wrapped := fn.InputSchema == nil || fn.InputSchema["type"] != "object"
+	tool.wrapsInput = wrapped
+	if wrapped {
+		input := fn.InputSchema
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		tool.InputSchema = map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"input": input},
+		}
+	}
+
+	tool.run = func(ctx context.Context, r *ToolRegistry, input json.RawMessage) (string, error) {
+		if wrapped {
+			var args struct {
+				Input json.RawMessage `json:"input"`
+			}
+			json.Unmarshal(input, &args)
+			input = args.Input
+			if len(input) == 0 {
+				input = json.RawMessage("null")
+			}
+		}
+		output, err := fn.Call(ctx, input)
+		if err != nil {
+			return "", err
+		}
+		if fn.OutputSchema != nil {
+			if err := validatePayload(fn.OutputSchema, output); err != nil {
+				return "", fmt.Errorf("invalid output: %v", err)
+			}
+		}
+		return functionOutput(output), nil
+	}
+	return tool
+}
+
+var readOnlyStatement = regexp.MustCompile(`(?is)^\s*(select|with|show|describe|desc|explain)\b`)
+
+func runSnowflakeQueryTool(ctx context.Context, r *ToolRegistry, input json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return "", err
+	}
+	query := strings.TrimSuffix(strings.TrimSpace(args.Query), ";")
+	if !readOnlyStatement.MatchString(query) || strings.Contains(query, ";") {
+		return "", fmt.Errorf("only a single read-only statement is allowed")
+	}
+
+	snowflakeDB, err := openSnowflakeReadOnly(r.db)
+	if err != nil {
+		return "", err
+	}
+	defer snowflakeDB.Close()
+
+	rows, err := snowflakeDB.QueryContext(ctx, query)
+	if err != nil {
+		return "", fmt.Errorf("query failed: %v", err)
+	}
+	defer rows.Close()
+
+	results, truncated, err := processQueryRows(rows, maxToolRows)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(gin.H{"rows": results, "truncated": truncated})
+	return string(encoded), err
+}
+
+func runDriveSearchTool(ctx context.Context, r *ToolRegistry, input json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+		Limit int64  `json:"limit"`
+	}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return "", err
+	}
+	if args.Limit <= 0 {
+		args.Limit = 10
+	}
+
+	var tokenJSON []byte
+	if err := r.db.QueryRow(`SELECT token_data FROM integration_tokens WHERE provider = $1`, "google_drive").Scan(&tokenJSON); err != nil {
+		return "", fmt.Errorf("Google Drive is not connected")
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(tokenJSON, &token); err != nil {
+		return "", fmt.Errorf("failed to read Google Drive token: %v", err)
+	}
+
+	service, err := drive.NewService(ctx, option.WithTokenSource(googleOAuthConfig.TokenSource(ctx, &token)))
+	if err != nil {
+		return "", fmt.Errorf("failed to create Drive client: %v", err)
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(args.Query)
+	list, err := service.Files.List().
+		Q(fmt.Sprintf("fullText contains '%s' and trashed = false", escaped)).
+		Fields("files(id, name, mimeType, webViewLink, modifiedTime)").
+		PageSize(args.Limit).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return "", fmt.Errorf("Drive search failed: %v", err)
+	}
+	encoded, err := json.Marshal(list.Files)
+	return string(encoded), err
+}
+
+func runWorkflowTool(ctx context.Context, r *ToolRegistry, input json.RawMessage) (string, error) {
+	var args struct {
+		Workflow string            `json:"workflow"`
+		Params   map[string]string `json:"params"`
+	}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return "", err
+	}
+	if r.db == nil || r.nested {
+		return "", fmt.Errorf("workflows cannot be run from here")
+	}
+
+	var id string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id FROM workflows
+		WHERE (slug = $1 OR id::text = $1) AND archived_at IS NULL`,
+		args.Workflow,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("workflow %q not found", args.Workflow)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	task, err := loadWorkflowTask(r.db, id, args.Params)
+	if err != nil {
+		return "", err
+	}
+	store := NewPostgresStore(r.db)
+	if task.ID, err = store.CreateExecution(task); err != nil {
+		return "", err
+	}
+	nested := &ToolRegistry{db: r.db, llm: r.llm, nested: true}
+	NewExecutor(store, NewInvoker(r.llm, nested)).RunContext(ctx, task)
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("workflow %q stopped: %v", args.Workflow, err)
+	}
+
+	// Report the nodes nothing else consumes: the workflow's results.
+	consumed := map[string]bool{}
+	for _, edge := range task.Edges {
+		consumed[edge.Source] = true
+	}
+	outputs := map[string]string{}
+	for _, node := range task.Nodes {
+		if !consumed[node.ID] {
+			outputs[node.ID] = node.Response
+		}
+	}
+	encoded, err := json.Marshal(gin.H{"executionId": task.ID, "status": task.Status, "outputs": outputs})
+	return string(encoded), err
+}
+
+// ListTools serves every tool with whether it is usable right now.
+func ListTools(db *sql.DB, llmClient LLMClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tools, available := NewToolRegistry(db, llmClient).all()
+		type toolInfo struct {
+			Tool
+			Available bool `json:"available"`
+		}
+		infos := make([]toolInfo, len(tools))
+		for i, tool := range tools {
+			infos[i] = toolInfo{tool, available[tool.Name]}
+		}
+		c.JSON(http.StatusOK, infos)
+	}
+}
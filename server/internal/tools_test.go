@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// toolHungryLLM requests the first tool it is offered on every turn and
+// answers only when it is offered none.
+type toolHungryLLM struct {
+	*ScriptedLLMClient
+	offered []int
+}
+
+func (l *toolHungryLLM) CompleteWithTools(messages []Message, tools []ToolSpec, model string, temperature float64, maxTokens *int) (Message, Usage, error) {
+	l.offered = append(l.offered, len(tools))
+	if len(tools) == 0 {
+		return Message{Role: "assistant", Content: fmt.Sprintf("answer after %d messages", len(messages))}, Usage{}, nil
+	}
+	call := ToolCall{ID: fmt.Sprintf("call-%d", len(l.offered)), Name: tools[0].Name, Input: json.RawMessage(`{"input": "x"}`)}
+	return Message{Role: "assistant", ToolCalls: []ToolCall{call}}, Usage{}, nil
+}
+
+func TestCompleteWithToolsAnswersAtStepLimit(t *testing.T) {
+	scripted, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	llm := &toolHungryLLM{ScriptedLLMClient: scripted}
+	inv := NewInvoker(llm, NewToolRegistry(nil, llm))
+	agent := &Agent{Slug: "looper", Type: TypeLLM, Config: AgentConfig{Tools: []string{"test_upper"}, MaxToolSteps: 2}}
+
+	got, err := inv.Invoke(agent, AgentRequest{Messages: []Message{{Role: "user", Content: "go"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two rounds of a call and its result follow the prompt.
+	if want := "answer after 5 messages"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if want := []int{1, 1, 0}; !reflect.DeepEqual(llm.offered, want) {
+		t.Errorf("tools offered per turn = %v, want %v", llm.offered, want)
+	}
+}
+
+func TestCompleteWithToolsReturnsToolResults(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{Rules: []ScriptRule{
+		{Call: &ScriptToolCall{Tool: "test_upper", Input: map[string]interface{}{"input": "quiet"}}},
+		{Match: `QUIET`, Response: "done"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := NewInvoker(llm, NewToolRegistry(nil, llm))
+	agent := &Agent{Type: TypeLLM, Config: AgentConfig{Tools: []string{"test_upper"}}}
+
+	got, err := inv.Invoke(agent, AgentRequest{Messages: []Message{{Role: "user", Content: "go"}}})
+	if err != nil || got != "done" {
+		t.Errorf("output = %q, %v; want the tool result seen by the model", got, err)
+	}
+	if calls := llm.Calls(); len(calls) != 2 {
+		t.Errorf("model called %d times, want 2", len(calls))
+	}
+}
+
+func TestRunContextStopsWhenCancelled(t *testing.T) {
+	llm, err := NewScriptedLLMClient(LLMScript{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newTestStore()
+	task, err := NewTask("", nil, Dag{
+		Nodes: []DagNode{agentNode("draft", "Draft"), agentNode("polish", "Polish")},
+		Edges: testEdges("draft", "polish"),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ID, err = store.CreateExecution(task); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NewExecutor(store, NewInvoker(llm, NewToolRegistry(nil, llm))).RunContext(ctx, task)
+
+	if task.Status != "failed" {
+		t.Errorf("status = %q, want failed", task.Status)
+	}
+	if got := nodeStatuses(task); got["draft"] != "failed" || got["polish"] != "skipped" {
+		t.Errorf("nodes = %v, want the first failed and the rest skipped", got)
+	}
+	if len(llm.Calls()) != 0 {
+		t.Error("a node ran after the context was cancelled")
+	}
+}
+
+func TestWorkflowToolStopsWhenCancelled(t *testing.T) {
+	db, _ := newTestMock(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	registry := NewToolRegistry(db, nil)
+	if _, err := runWorkflowTool(ctx, registry, json.RawMessage(`{"workflow": "nightly"}`)); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want the lookup cancelled", err)
+	}
+}
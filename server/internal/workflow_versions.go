@@ -0,0 +1,416 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkflowVersion is an immutable snapshot of a workflow, written on every
+// save.
+type WorkflowVersion struct {
+	WorkflowID  string         `json:"workflow_id"`
+	Version     int            `json:"version"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Status      WorkflowStatus `json:"status"`
+	Dag         *Dag           `json:"dag,omitempty"`
+	Schedule    string         `json:"schedule"`
+	// Parameters are nil in versions saved before they were recorded.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Author     string            `json:"author"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+type WorkflowDiff struct {
+	From          int          `json:"from"`
+	To            int          `json:"to"`
+	FieldsChanged []string     `json:"fields_changed"`
+	NodesAdded    []DagNode    `json:"nodes_added"`
+	NodesRemoved  []DagNode    `json:"nodes_removed"`
+	NodesChanged  []NodeChange `json:"nodes_changed"`
+	EdgesAdded    []DagEdge    `json:"edges_added"`
+	EdgesRemoved  []DagEdge    `json:"edges_removed"`
+	EdgesChanged  []EdgeChange `json:"edges_changed"`
+}
+
+type NodeChange struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+	Before DagNode  `json:"before"`
+	After  DagNode  `json:"after"`
+}
+
+type EdgeChange struct {
+	Key    string   `json:"key"`
+	Fields []string `json:"fields"`
+	Before DagEdge  `json:"before"`
+	After  DagEdge  `json:"after"`
+}
+
+func insertWorkflowVersion(tx *sql.Tx, workflow *Workflow, dagJSON []byte, author string) error {
+	params := workflow.Parameters
+	if params == nil {
+		params = map[string]string{}
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO workflow_versions (workflow_id, version, name, description, status, dag, schedule, parameters, author)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		workflow.ID, workflow.Version, workflow.Name, workflow.Description, workflow.Status, dagJSON, workflow.Schedule, paramsJSON, author,
+	)
+	return err
+}
+
+func fetchWorkflowVersion(db *sql.DB, workflowID string, version int) (*WorkflowVersion, error) {
+	v := &WorkflowVersion{Dag: &Dag{}}
+	var dagBytes, paramsBytes []byte
+
+	err := db.QueryRow(`
+		SELECT workflow_id, version, name, description, status, dag, schedule, parameters, author, created_at
+		FROM workflow_versions
+		WHERE workflow_id = $1 AND version = $2`,
+		workflowID, version,
+	).Scan(&v.WorkflowID, &v.Version, &v.Name, &v.Description, &v.Status, &dagBytes, &v.Schedule, &paramsBytes, &v.Author, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(dagBytes, v.Dag); err != nil {
+		return nil, fmt.Errorf("failed to parse DAG: %v", err)
+	}
+	if paramsBytes != nil {
+		if err := json.Unmarshal(paramsBytes, &v.Parameters); err != nil {
+			return nil, fmt.Errorf("failed to parse parameters: %v", err)
+		}
+	}
+	return v, nil
+}
+
+func ListWorkflowVersions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		rows, err := db.Query(`
+			SELECT workflow_id, version, name, description, status, schedule, author, created_at
+			FROM workflow_versions
+			WHERE workflow_id = $1
+			ORDER BY version DESC`,
+			id,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow versions"})
+			return
+		}
+		defer rows.Close()
+
+		versions := []WorkflowVersion{}
+		for rows.Next() {
+			var v WorkflowVersion
+			if err := rows.Scan(&v.WorkflowID, &v.Version, &v.Name, &v.Description, &v.Status, &v.Schedule, &v.Author, &v.CreatedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan workflow version"})
+				return
+			}
+			versions = append(versions, v)
+		}
+
+		if len(versions) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			return
+		}
+		c.JSON(http.StatusOK, versions)
+	}
+}
+
+func GetWorkflowVersion(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+			return
+		}
+
+		v, err := fetchWorkflowVersion(db, c.Param("id"), version)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow version not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow version"})
+			return
+		}
+		c.JSON(http.StatusOK, v)
+	}
+}
+
+func DiffWorkflowVersions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		from, errFrom := strconv.Atoi(c.Query("from"))
+		to, errTo := strconv.Atoi(c.Query("to"))
+		if errFrom != nil || errTo != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameters 'from' and 'to' must be version numbers"})
+			return
+		}
+
+		before, err := fetchWorkflowVersion(db, id, from)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Workflow version %d not found", from)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow version"})
+			return
+		}
+
+		after, err := fetchWorkflowVersion(db, id, to)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Workflow version %d not found", to)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow version"})
+			return
+		}
+
+		c.JSON(http.StatusOK, diffWorkflowVersions(before, after))
+	}
+}
+
+func RollbackWorkflow(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var req struct {
+			Version int `json:"version" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		expected, err := ifMatchVersion(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		current, err := fetchWorkflow(db, id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow"})
+			return
+		}
+		if current.ArchivedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Restore the workflow before rolling it back"})
+			return
+		}
+
+		target, err := fetchWorkflowVersion(db, id, req.Version)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow version not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow version"})
+			return
+		}
+
+		// Versions saved before parameters were recorded keep the current ones.
+		workflow := Workflow{
+			Name:        target.Name,
+			Description: target.Description,
+			Status:      target.Status,
+			Dag:         *target.Dag,
+			Schedule:    target.Schedule,
+			Parameters:  target.Parameters,
+		}
+		if workflow.Parameters == nil {
+			workflow.Parameters = current.Parameters
+		}
+		if err := workflow.Dag.Validate(); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Version %d has an invalid DAG: %v", req.Version, err)})
+			return
+		}
+		unavailable, err := unavailableAgents(db, workflow.Dag)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch agent"})
+			return
+		}
+		if len(unavailable) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  fmt.Sprintf("Version %d uses agents that are archived or purged; restore them first", req.Version),
+				"agents": unavailable,
+			})
+			return
+		}
+		workflow.Dag.SchemaVersion = CurrentDagSchemaVersion
+		paramsJSON, err := json.Marshal(workflow.Parameters)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode parameters"})
+			return
+		}
+
+		dagJSON, err := json.Marshal(workflow.Dag)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode DAG"})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// A rollback is a new save, so history stays append-only.
+		err = tx.QueryRow(`
+			UPDATE workflows
+			SET name = $1, description = $2, status = $3, dag = $4, schedule = $5, parameters = $8, version = version + 1
+			WHERE id = $6 AND ($7::int IS NULL OR version = $7) AND archived_at IS NULL
+			RETURNING id, slug, version, created_at, updated_at`,
+			workflow.Name, workflow.Description, workflow.Status, dagJSON, workflow.Schedule, id, expected, paramsJSON,
+		).Scan(&workflow.ID, &workflow.Slug, &workflow.Version, &workflow.CreatedAt, &workflow.UpdatedAt)
+		if err == sql.ErrNoRows {
+			respondWorkflowNotUpdated(c, db, id)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back workflow"})
+			return
+		}
+
+		if err := insertWorkflowVersion(tx, &workflow, dagJSON, currentUser(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record workflow version"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		setETag(c, workflow.Version)
+		c.JSON(http.StatusOK, workflow)
+	}
+}
+
+func diffWorkflowVersions(before, after *WorkflowVersion) WorkflowDiff {
+	diff := WorkflowDiff{
+		From:          before.Version,
+		To:            after.Version,
+		FieldsChanged: []string{},
+		NodesAdded:    []DagNode{},
+		NodesRemoved:  []DagNode{},
+		NodesChanged:  []NodeChange{},
+		EdgesAdded:    []DagEdge{},
+		EdgesRemoved:  []DagEdge{},
+		EdgesChanged:  []EdgeChange{},
+	}
+
+	if before.Name != after.Name {
+		diff.FieldsChanged = append(diff.FieldsChanged, "name")
+	}
+	if before.Description != after.Description {
+		diff.FieldsChanged = append(diff.FieldsChanged, "description")
+	}
+	if before.Status != after.Status {
+		diff.FieldsChanged = append(diff.FieldsChanged, "status")
+	}
+	if before.Schedule != after.Schedule {
+		diff.FieldsChanged = append(diff.FieldsChanged, "schedule")
+	}
+	if before.Parameters != nil && after.Parameters != nil && !reflect.DeepEqual(before.Parameters, after.Parameters) {
+		diff.FieldsChanged = append(diff.FieldsChanged, "parameters")
+	}
+
+	oldNodes := make(map[string]DagNode, len(before.Dag.Nodes))
+	for _, node := range before.Dag.Nodes {
+		oldNodes[node.ID] = node
+	}
+	newNodes := make(map[string]bool, len(after.Dag.Nodes))
+	for _, node := range after.Dag.Nodes {
+		newNodes[node.ID] = true
+		old, ok := oldNodes[node.ID]
+		if !ok {
+			diff.NodesAdded = append(diff.NodesAdded, node)
+			continue
+		}
+		if fields := changedFields(old, node); len(fields) > 0 {
+			diff.NodesChanged = append(diff.NodesChanged, NodeChange{ID: node.ID, Fields: fields, Before: old, After: node})
+		}
+	}
+	for _, node := range before.Dag.Nodes {
+		if !newNodes[node.ID] {
+			diff.NodesRemoved = append(diff.NodesRemoved, node)
+		}
+	}
+
+	oldEdges := make(map[string]DagEdge, len(before.Dag.Edges))
+	for _, edge := range before.Dag.Edges {
+		oldEdges[edgeKey(edge)] = edge
+	}
+	newEdges := make(map[string]bool, len(after.Dag.Edges))
+	for _, edge := range after.Dag.Edges {
+		key := edgeKey(edge)
+		newEdges[key] = true
+		old, ok := oldEdges[key]
+		if !ok {
+			diff.EdgesAdded = append(diff.EdgesAdded, edge)
+			continue
+		}
+		if fields := changedFields(old, edge); len(fields) > 0 {
+			diff.EdgesChanged = append(diff.EdgesChanged, EdgeChange{Key: key, Fields: fields, Before: old, After: edge})
+		}
+	}
+	for _, edge := range before.Dag.Edges {
+		if !newEdges[edgeKey(edge)] {
+			diff.EdgesRemoved = append(diff.EdgesRemoved, edge)
+		}
+	}
+
+	return diff
+}
+
+// edgeKey identifies an edge across versions. React Flow edge IDs are not
+// always stable, so edges are matched on their endpoints.
+func edgeKey(edge DagEdge) string {
+	return edge.Source + "->" + edge.Target
+}
+
+// changedFields compares two values by their JSON representation and returns
+// the top-level JSON fields that differ.
+func changedFields(before, after interface{}) []string {
+	var a, b map[string]interface{}
+	beforeJSON, _ := json.Marshal(before)
+	afterJSON, _ := json.Marshal(after)
+	json.Unmarshal(beforeJSON, &a)
+	json.Unmarshal(afterJSON, &b)
+
+	var fields []string
+	for key, value := range b {
+		if !reflect.DeepEqual(a[key], value) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunStoredWorkflow(t *testing.T) {
+	stored := &storedWorkflow{
+		version:  3,
+		dag:      Dag{Nodes: []DagNode{agentNode("a", "stored")}},
+		defaults: map[string]string{"region": "eu", "limit": "10"},
+	}
+
+	t.Run("stored DAG", func(t *testing.T) {
+		req := &ExecutionRequest{Params: map[string]string{"limit": "5"}}
+		version := req.runStoredWorkflow(stored)
+		if version == nil || *version != 3 {
+			t.Fatalf("version = %v, want 3", version)
+		}
+		if len(req.DAG.Nodes) != 1 || req.DAG.Nodes[0].ID != "a" {
+			t.Errorf("DAG = %+v, want the stored DAG", req.DAG)
+		}
+		want := map[string]string{"region": "eu", "limit": "5"}
+		if !reflect.DeepEqual(req.Params, want) {
+			t.Errorf("params = %v, want %v", req.Params, want)
+		}
+	})
+
+	t.Run("submitted DAG", func(t *testing.T) {
+		req := &ExecutionRequest{DAG: Dag{Nodes: []DagNode{agentNode("b", "draft")}}}
+		if version := req.runStoredWorkflow(stored); version != nil {
+			t.Errorf("version = %d, want none for a submitted DAG", *version)
+		}
+		if len(req.DAG.Nodes) != 1 || req.DAG.Nodes[0].ID != "b" {
+			t.Errorf("DAG = %+v, want the submitted DAG", req.DAG)
+		}
+		want := map[string]string{"region": "eu", "limit": "10"}
+		if !reflect.DeepEqual(req.Params, want) {
+			t.Errorf("params = %v, want %v", req.Params, want)
+		}
+	})
+}
+
+func TestDiffWorkflowVersions(t *testing.T) {
+	before := &WorkflowVersion{
+		Version:    1,
+		Name:       "report",
+		Schedule:   "@daily",
+		Parameters: map[string]string{"region": "eu"},
+		Dag: &Dag{
+			Nodes: []DagNode{agentNode("a", "draft"), agentNode("b", "review"), agentNode("c", "publish")},
+			Edges: testEdges("a", "b", "b", "c"),
+		},
+	}
+	changed := agentNode("b", "review")
+	changed.AgentID = "editor"
+	after := &WorkflowVersion{
+		Version:    2,
+		Name:       "report",
+		Schedule:   "@hourly",
+		Parameters: map[string]string{"region": "us"},
+		Dag: &Dag{
+			Nodes: []DagNode{agentNode("a", "draft"), changed, agentNode("d", "notify")},
+			Edges: testEdges("a", "b", "b", "d"),
+		},
+	}
+
+	diff := diffWorkflowVersions(before, after)
+	if diff.From != 1 || diff.To != 2 {
+		t.Errorf("from/to = %d/%d, want 1/2", diff.From, diff.To)
+	}
+	if want := []string{"schedule", "parameters"}; !reflect.DeepEqual(diff.FieldsChanged, want) {
+		t.Errorf("fields changed = %v, want %v", diff.FieldsChanged, want)
+	}
+	if len(diff.NodesAdded) != 1 || diff.NodesAdded[0].ID != "d" {
+		t.Errorf("nodes added = %+v, want d", diff.NodesAdded)
+	}
+	if len(diff.NodesRemoved) != 1 || diff.NodesRemoved[0].ID != "c" {
+		t.Errorf("nodes removed = %+v, want c", diff.NodesRemoved)
+	}
+	if len(diff.NodesChanged) != 1 || diff.NodesChanged[0].ID != "b" || !reflect.DeepEqual(diff.NodesChanged[0].Fields, []string{"agentId"}) {
+		t.Errorf("nodes changed = %+v, want b with agentId", diff.NodesChanged)
+	}
+	if len(diff.EdgesAdded) != 1 || edgeKey(diff.EdgesAdded[0]) != "b->d" {
+		t.Errorf("edges added = %+v, want b->d", diff.EdgesAdded)
+	}
+	if len(diff.EdgesRemoved) != 1 || edgeKey(diff.EdgesRemoved[0]) != "b->c" {
+		t.Errorf("edges removed = %+v, want b->c", diff.EdgesRemoved)
+	}
+}
+
+func TestDiffWorkflowVersionsIgnoresUnrecordedParameters(t *testing.T) {
+	before := &WorkflowVersion{Version: 1, Dag: &Dag{}}
+	after := &WorkflowVersion{Version: 2, Dag: &Dag{}, Parameters: map[string]string{"region": "eu"}}
+	if diff := diffWorkflowVersions(before, after); len(diff.FieldsChanged) != 0 {
+		t.Errorf("fields changed = %v, want none", diff.FieldsChanged)
+	}
+}
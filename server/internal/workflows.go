@@ -4,10 +4,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 const (
@@ -20,62 +22,93 @@ type WorkflowStatus string
 type Workflow struct {
 	ID          string         `json:"id"`
 	Name        string         `json:"name"`
+	Slug        string         `json:"slug"`
 	Description string         `json:"description"`
 	Status      WorkflowStatus `json:"status"`
 	Dag         Dag            `json:"dag"`
 	Schedule    string         `json:"schedule"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	Version     int            `json:"version"`
+	IsTemplate  bool           `json:"is_template"`
+	// Parameters are the default run parameters; run requests override them.
+	Parameters map[string]string `json:"parameters"`
+	Metadata
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 }
 
-type Dag struct {
-	Nodes []Node `json:"nodes"`
-	Edges []Edge `json:"edges"`
+// workflowColumns is the column list read by scanWorkflow.
+const workflowColumns = `id, name, slug, description, status, dag, schedule, version, created_at, updated_at, is_template, parameters, tags, folder, owner, archived_at`
+
+func scanWorkflow(row rowScanner) (*Workflow, error) {
+	var w Workflow
+	var dagBytes, paramsBytes []byte
+	err := row.Scan(
+		&w.ID,
+		&w.Name,
+		&w.Slug,
+		&w.Description,
+		&w.Status,
+		&dagBytes,
+		&w.Schedule,
+		&w.Version,
+		&w.CreatedAt,
+		&w.UpdatedAt,
+		&w.IsTemplate,
+		&paramsBytes,
+		pq.Array(&w.Tags),
+		&w.Folder,
+		&w.Owner,
+		&w.ArchivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(dagBytes, &w.Dag); err != nil {
+		return nil, fmt.Errorf("failed to parse DAG: %v", err)
+	}
+	if err := json.Unmarshal(paramsBytes, &w.Parameters); err != nil {
+		return nil, fmt.Errorf("failed to parse parameters: %v", err)
+	}
+	return &w, nil
 }
 
-type Node interface{}
-
-type Edge interface{}
+// fetchWorkflow loads a single workflow by ID. It returns sql.ErrNoRows when
+// the workflow does not exist.
+func fetchWorkflow(db *sql.DB, id string) (*Workflow, error) {
+	return scanWorkflow(db.QueryRow(`SELECT `+workflowColumns+` FROM workflows WHERE id = $1`, id))
+}
 
-func ListWorkflows(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		query := `
-			SELECT id, name, description, status, dag, schedule, created_at, updated_at 
-			FROM workflows`
+var workflowListSpec = listSpec{
+	table:       "workflows",
+	columns:     workflowColumns,
+	sorts:       map[string]string{"name": "name", "created_at": "created_at", "updated_at": "updated_at"},
+	defaultSort: "name",
+	filters:     map[string]string{"status": "status", "template": "is_template::text", "owner": "owner"},
+	search:      []string{"name", "description"},
+	archivable:  true,
+	tagged:      true,
+}
 
-		rows, err := db.Query(query)
-		if err != nil {
-			c.JSON(http.StatusOK, []Workflow{})
-			return
-		}
-		defer rows.Close()
-
-		var workflows []Workflow
-		for rows.Next() {
-			var w Workflow
-			var dagBytes []byte
-			err := rows.Scan(
-				&w.ID,
-				&w.Name,
-				&w.Description,
-				&w.Status,
-				&dagBytes,
-				&w.Schedule,
-				&w.CreatedAt,
-				&w.UpdatedAt,
-			)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan workflow"})
-				return
-			}
-			if err := json.Unmarshal(dagBytes, &w.Dag); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse DAG"})
-				return
-			}
-			workflows = append(workflows, w)
-		}
-		c.JSON(http.StatusOK, workflows)
+// respondWorkflowNotUpdated explains why a conditional workflow update
+// matched no row: the workflow is gone, or If-Match named a stale version.
+func respondWorkflowNotUpdated(c *gin.Context, db *sql.DB, id string) {
+	current, err := fetchWorkflow(db, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow"})
+		return
+	}
+	respondVersionConflict(c, "Workflow", current.Version, current)
+}
+
+// ListWorkflows serves GET /workflows with pagination, ?q= search and the
+// ?status=, ?template=, ?owner=, ?tag=, ?folder= and ?archived= filters.
+func ListWorkflows(db *sql.DB) gin.HandlerFunc {
+	return listHandler(db, workflowListSpec, scanWorkflow, "workflows")
 }
 
 func CreateWorkflow(db *sql.DB) gin.HandlerFunc {
@@ -86,6 +119,21 @@ func CreateWorkflow(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		if err := workflow.Dag.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid DAG: %v", err)})
+			return
+		}
+		workflow.Dag.SchemaVersion = CurrentDagSchemaVersion
+		workflow.defaultOwner(c)
+
+		if workflow.Slug == "" {
+			workflow.Slug = slugify(workflow.Name)
+		}
+		if !validSlug(workflow.Slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug: use lowercase letters, digits and dashes"})
+			return
+		}
+
 		// Convert DAG to JSON
 		dagJSON, err := json.Marshal(workflow.Dag)
 		if err != nil {
@@ -93,6 +141,19 @@ func CreateWorkflow(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		if workflow.Parameters == nil {
+			workflow.Parameters = map[string]string{}
+		}
+		if err := workflow.Metadata.normalize(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		paramsJSON, err := json.Marshal(workflow.Parameters)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parameters"})
+			return
+		}
+
 		tx, err := db.Begin()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
@@ -101,44 +162,40 @@ func CreateWorkflow(db *sql.DB) gin.HandlerFunc {
 		defer tx.Rollback()
 
 		err = tx.QueryRow(`
-			INSERT INTO workflows (name, description, status, dag, schedule)
-			VALUES ($1, $2, $3, $4, $5)
-			RETURNING id, created_at, updated_at`,
-			workflow.Name, workflow.Description, workflow.Status, dagJSON, workflow.Schedule,
-		).Scan(&workflow.ID, &workflow.CreatedAt, &workflow.UpdatedAt)
-
+			INSERT INTO workflows (name, slug, description, status, dag, schedule, is_template, parameters, tags, folder, owner)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			RETURNING id, version, created_at, updated_at`,
+			workflow.Name, workflow.Slug, workflow.Description, workflow.Status, dagJSON, workflow.Schedule, workflow.IsTemplate, paramsJSON,
+			pq.Array(workflow.Tags), workflow.Folder, workflow.Owner,
+		).Scan(&workflow.ID, &workflow.Version, &workflow.CreatedAt, &workflow.UpdatedAt)
+
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A workflow with slug %q already exists", workflow.Slug)})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
 			return
 		}
 
-		tx.Commit()
+		if err := insertWorkflowVersion(tx, &workflow, dagJSON, currentUser(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record workflow version"})
+			return
+		}
 
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		setETag(c, workflow.Version)
 		c.JSON(http.StatusCreated, workflow)
 	}
 }
 
 func GetWorkflow(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
-		workflow := &Workflow{}
-		var dagBytes []byte
-
-		err := db.QueryRow(`
-			SELECT id, name, description, status, dag, schedule, created_at, updated_at 
-			FROM workflows WHERE id = $1`,
-			id,
-		).Scan(
-			&workflow.ID,
-			&workflow.Name,
-			&workflow.Description,
-			&workflow.Status,
-			&dagBytes,
-			&workflow.Schedule,
-			&workflow.CreatedAt,
-			&workflow.UpdatedAt,
-		)
-
+		workflow, err := fetchWorkflow(db, c.Param("id"))
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
 			return
@@ -149,11 +206,7 @@ func GetWorkflow(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		if err := json.Unmarshal(dagBytes, &workflow.Dag); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse DAG"})
-			return
-		}
-
+		setETag(c, workflow.Version)
 		c.JSON(http.StatusOK, workflow)
 	}
 }
@@ -167,14 +220,32 @@ func UpdateWorkflow(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		if err := workflow.Dag.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid DAG: %v", err)})
+			return
+		}
+		workflow.Dag.SchemaVersion = CurrentDagSchemaVersion
+
 		// Convert DAG to JSON
 		dagJSON, err := json.Marshal(workflow.Dag)
-		fmt.Println(string(dagJSON))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode DAG"})
 			return
 		}
 
+		if workflow.Parameters == nil {
+			workflow.Parameters = map[string]string{}
+		}
+		if err := workflow.Metadata.normalize(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		paramsJSON, err := json.Marshal(workflow.Parameters)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parameters"})
+			return
+		}
+
 		tx, err := db.Begin()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
@@ -182,25 +253,42 @@ func UpdateWorkflow(db *sql.DB) gin.HandlerFunc {
 		}
 		defer tx.Rollback()
 
-		result, err := tx.Exec(`
-			UPDATE workflows 
-			SET name = $1, description = $2, status = $3, dag = $4, schedule = $5	
-			WHERE id = $6`,
-			workflow.Name, workflow.Description, workflow.Status, dagJSON, workflow.Schedule, id,
-		)
+		if workflow.Slug != "" && !validSlug(workflow.Slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slug: use lowercase letters, digits and dashes"})
+			return
+		}
 
+		expected, err := ifMatchVersion(c)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workflow"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		rows, err := result.RowsAffected()
+		err = tx.QueryRow(`
+			UPDATE workflows
+			SET name = $1, slug = COALESCE(NULLIF($2, ''), slug), description = $3, status = $4, dag = $5, schedule = $6, is_template = $9, parameters = $10,
+				tags = $11, folder = $12, owner = COALESCE(NULLIF($13, ''), owner), version = version + 1
+			WHERE id = $7 AND ($8::int IS NULL OR version = $8)
+			RETURNING id, slug, owner, version, created_at, updated_at`,
+			workflow.Name, workflow.Slug, workflow.Description, workflow.Status, dagJSON, workflow.Schedule, id, expected,
+			workflow.IsTemplate, paramsJSON, pq.Array(workflow.Tags), workflow.Folder, workflow.Owner,
+		).Scan(&workflow.ID, &workflow.Slug, &workflow.Owner, &workflow.Version, &workflow.CreatedAt, &workflow.UpdatedAt)
+
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A workflow with slug %q already exists", workflow.Slug)})
+			return
+		}
+		if err == sql.ErrNoRows {
+			respondWorkflowNotUpdated(c, db, id)
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rows affected"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workflow"})
 			return
 		}
-		if rows == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+
+		if err := insertWorkflowVersion(tx, &workflow, dagJSON, currentUser(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record workflow version"})
 			return
 		}
 
@@ -209,31 +297,60 @@ func UpdateWorkflow(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		workflow.ID = id
+		setETag(c, workflow.Version)
 		c.JSON(http.StatusOK, workflow)
 	}
 }
 
-func DeleteWorkflow(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		id := c.Param("id")
+// UpgradeStoredWorkflows rewrites every stored DAG that predates the current
+// schema version, so reads no longer need to upgrade it on the fly.
+func UpgradeStoredWorkflows(db *sql.DB) error {
+	rows, err := db.Query(`
+		SELECT id, dag FROM workflows
+		WHERE COALESCE((dag->>'schemaVersion')::int, 0) < $1`,
+		CurrentDagSchemaVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to fetch workflows: %v", err)
+	}
 
-		result, err := db.Exec(`DELETE FROM workflows WHERE id = $1`, id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete workflow"})
-			return
+	upgrades := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var dagBytes []byte
+		if err := rows.Scan(&id, &dagBytes); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan workflow: %v", err)
 		}
 
-		rows, err := result.RowsAffected()
+		dag, upgraded, err := decodeDag(dagBytes)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rows affected"})
-			return
+			rows.Close()
+			return fmt.Errorf("workflow %s: %v", id, err)
 		}
-		if rows == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
-			return
+		if !upgraded {
+			continue
+		}
+
+		dagJSON, err := json.Marshal(dag)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("workflow %s: failed to encode DAG: %v", id, err)
 		}
+		upgrades[id] = dagJSON
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Workflow deleted", "id": id})
+	for id, dagJSON := range upgrades {
+		if _, err := db.Exec(`UPDATE workflows SET dag = $1 WHERE id = $2`, dagJSON, id); err != nil {
+			return fmt.Errorf("failed to upgrade workflow %s: %v", id, err)
+		}
+	}
+	if len(upgrades) > 0 {
+		log.Printf("Upgraded %d workflow DAGs to schema version %d", len(upgrades), CurrentDagSchemaVersion)
 	}
+	return nil
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"strings"
@@ -21,6 +23,12 @@ func init() {
 }
 
 func main() {
+	applyDir := flag.String("apply", "", "apply agent and workflow definitions from this path at startup")
+	planOnly := flag.Bool("plan", false, "with -apply, print the changes that would be made and exit")
+	exportDir := flag.String("export", "", "export agent and workflow definitions to this directory and exit")
+	tokenUser := flag.String("token", "", "print an API token for this user ID and exit")
+	flag.Parse()
+
 	// Set required env vars
 	os.Setenv("SNOWFLAKE_OCSP", "INSECURE_SKIP_VERIFY")
 
@@ -30,6 +38,11 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
+	if *tokenUser != "" {
+		fmt.Println(internal.SignUserToken(config.Auth.Secret, *tokenUser))
+		return
+	}
+
 	// Initialize database connection
 	db, err := internal.NewDBConnection(config.DB)
 	if err != nil {
@@ -42,6 +55,43 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	// Upgrade stored workflow DAGs to the current schema version
+	if err := internal.UpgradeStoredWorkflows(db); err != nil {
+		log.Fatal("Failed to upgrade workflows:", err)
+	}
+
+	// Rewrite agent configs into their typed shape
+	if err := internal.MigrateAgentConfigs(db); err != nil {
+		log.Fatal("Failed to migrate agent configs:", err)
+	}
+
+	// Apply or export declarative definitions
+	if *exportDir != "" {
+		bundle, err := internal.ExportDefinitions(db)
+		if err != nil {
+			log.Fatal("Failed to export definitions:", err)
+		}
+		if err := internal.WriteDefinitions(*exportDir, bundle); err != nil {
+			log.Fatal("Failed to write definitions:", err)
+		}
+		log.Printf("Exported %d agents and %d workflows to %s", len(bundle.Agents), len(bundle.Workflows), *exportDir)
+		return
+	}
+	if *applyDir != "" {
+		bundle, err := internal.LoadDefinitions(*applyDir)
+		if err != nil {
+			log.Fatal("Failed to load definitions:", err)
+		}
+		result, err := internal.ApplyDefinitions(db, bundle, "system", *planOnly)
+		if err != nil {
+			log.Fatal("Failed to apply definitions:", err)
+		}
+		fmt.Print(result)
+		if *planOnly {
+			return
+		}
+	}
+
 	// Initialize LLM client
 	llmClient, err := internal.NewLLMClient(config.LLM)
 	if err != nil {
@@ -73,14 +123,16 @@ func main() {
 			"Accept",
 			"Accept-Encoding",
 			"Accept-Language",
+			"Authorization",
+			"If-Match",
 		},
-		ExposeHeaders:    []string{"Content-Length", "Content-Type"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Type", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	// Setup routes with dependencies
-	internal.SetupRoutes(r, db, llmClient)
+	internal.SetupRoutes(r, db, llmClient, config.Auth.Secret)
 
 	// Start the server
 	if err := r.Run(":8080"); err != nil {
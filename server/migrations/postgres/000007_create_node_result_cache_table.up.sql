@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS node_result_cache (
+    cache_key VARCHAR(64) PRIMARY KEY,
+    agent_id UUID NOT NULL,
+    output TEXT NOT NULL,
+    hits INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Add indexes
+CREATE INDEX idx_node_result_cache_agent_id ON node_result_cache(agent_id);
+CREATE INDEX idx_node_result_cache_expires_at ON node_result_cache(expires_at);
+
+-- Add trigger for updated_at
+CREATE TRIGGER update_node_result_cache_updated_at
+    BEFORE UPDATE ON node_result_cache
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
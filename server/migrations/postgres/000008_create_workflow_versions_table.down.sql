@@ -0,0 +1,3 @@
+ALTER TABLE executions DROP COLUMN IF EXISTS workflow_version;
+DROP TABLE IF EXISTS workflow_versions;
+ALTER TABLE workflows DROP COLUMN IF EXISTS version;
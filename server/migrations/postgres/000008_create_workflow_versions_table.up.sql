@@ -0,0 +1,26 @@
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS workflow_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    workflow_id UUID NOT NULL REFERENCES workflows(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    status VARCHAR(50) NOT NULL,
+    dag JSONB NOT NULL,
+    schedule VARCHAR(50) NOT NULL,
+    author VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(workflow_id, version)
+);
+
+CREATE INDEX idx_workflow_versions_workflow_id ON workflow_versions(workflow_id);
+
+-- Existing workflows become version 1
+INSERT INTO workflow_versions (workflow_id, version, name, description, status, dag, schedule, author, created_at)
+SELECT id, version, name, description, status, dag, schedule, 'system', updated_at
+FROM workflows
+ON CONFLICT (workflow_id, version) DO NOTHING;
+
+-- Record which workflow version an execution ran
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS workflow_version INTEGER;
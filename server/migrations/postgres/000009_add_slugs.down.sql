@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_workflows_slug;
+DROP INDEX IF EXISTS idx_agents_slug;
+ALTER TABLE workflows DROP COLUMN IF EXISTS slug;
+ALTER TABLE agents DROP COLUMN IF EXISTS slug;
@@ -0,0 +1,35 @@
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS slug VARCHAR(255);
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS slug VARCHAR(255);
+
+-- Backfill slugs from names, suffixing duplicates
+UPDATE agents a
+SET slug = CASE WHEN s.n = 1 THEN s.base ELSE s.base || '-' || s.n END
+FROM (
+    SELECT id,
+           COALESCE(NULLIF(trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), ''), 'agent') AS base,
+           row_number() OVER (
+               PARTITION BY COALESCE(NULLIF(trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), ''), 'agent')
+               ORDER BY created_at, id
+           ) AS n
+    FROM agents
+) s
+WHERE a.id = s.id AND a.slug IS NULL;
+
+UPDATE workflows w
+SET slug = CASE WHEN s.n = 1 THEN s.base ELSE s.base || '-' || s.n END
+FROM (
+    SELECT id,
+           COALESCE(NULLIF(trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), ''), 'workflow') AS base,
+           row_number() OVER (
+               PARTITION BY COALESCE(NULLIF(trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')), ''), 'workflow')
+               ORDER BY created_at, id
+           ) AS n
+    FROM workflows
+) s
+WHERE w.id = s.id AND w.slug IS NULL;
+
+ALTER TABLE agents ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE workflows ALTER COLUMN slug SET NOT NULL;
+
+CREATE UNIQUE INDEX idx_agents_slug ON agents(slug);
+CREATE UNIQUE INDEX idx_workflows_slug ON workflows(slug);
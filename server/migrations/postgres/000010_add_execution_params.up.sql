@@ -0,0 +1 @@
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS params JSONB NOT NULL DEFAULT '{}';
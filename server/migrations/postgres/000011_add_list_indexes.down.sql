@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_executions_created_at_id;
+DROP INDEX IF EXISTS idx_integrations_provider;
+DROP INDEX IF EXISTS idx_integrations_name_id;
+DROP INDEX IF EXISTS idx_workflows_created_at_id;
+DROP INDEX IF EXISTS idx_workflows_name_id;
+DROP INDEX IF EXISTS idx_agents_type;
+DROP INDEX IF EXISTS idx_agents_created_at_id;
+DROP INDEX IF EXISTS idx_agents_name_id;
+
+ALTER TABLE executions ALTER COLUMN created_at DROP NOT NULL, ALTER COLUMN updated_at DROP NOT NULL;
+ALTER TABLE integrations ALTER COLUMN created_at DROP NOT NULL, ALTER COLUMN updated_at DROP NOT NULL;
+ALTER TABLE workflows ALTER COLUMN created_at DROP NOT NULL, ALTER COLUMN updated_at DROP NOT NULL;
+ALTER TABLE agents ALTER COLUMN created_at DROP NOT NULL, ALTER COLUMN updated_at DROP NOT NULL;
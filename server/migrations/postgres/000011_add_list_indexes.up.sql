@@ -0,0 +1,23 @@
+-- Keyset pagination orders by (sort column, id); sort columns must be non-null.
+UPDATE agents SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+UPDATE agents SET updated_at = created_at WHERE updated_at IS NULL;
+UPDATE workflows SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+UPDATE workflows SET updated_at = created_at WHERE updated_at IS NULL;
+UPDATE integrations SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+UPDATE integrations SET updated_at = created_at WHERE updated_at IS NULL;
+UPDATE executions SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+UPDATE executions SET updated_at = created_at WHERE updated_at IS NULL;
+
+ALTER TABLE agents ALTER COLUMN created_at SET NOT NULL, ALTER COLUMN updated_at SET NOT NULL;
+ALTER TABLE workflows ALTER COLUMN created_at SET NOT NULL, ALTER COLUMN updated_at SET NOT NULL;
+ALTER TABLE integrations ALTER COLUMN created_at SET NOT NULL, ALTER COLUMN updated_at SET NOT NULL;
+ALTER TABLE executions ALTER COLUMN created_at SET NOT NULL, ALTER COLUMN updated_at SET NOT NULL;
+
+CREATE INDEX idx_agents_name_id ON agents(name, id);
+CREATE INDEX idx_agents_created_at_id ON agents(created_at, id);
+CREATE INDEX idx_agents_type ON agents(type);
+CREATE INDEX idx_workflows_name_id ON workflows(name, id);
+CREATE INDEX idx_workflows_created_at_id ON workflows(created_at, id);
+CREATE INDEX idx_integrations_name_id ON integrations(name, id);
+CREATE INDEX idx_integrations_provider ON integrations(provider);
+CREATE INDEX idx_executions_created_at_id ON executions(created_at, id);
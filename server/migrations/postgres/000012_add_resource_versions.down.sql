@@ -0,0 +1,2 @@
+ALTER TABLE integrations DROP COLUMN IF EXISTS version;
+ALTER TABLE agents DROP COLUMN IF EXISTS version;
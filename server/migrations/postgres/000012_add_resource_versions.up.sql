@@ -0,0 +1,4 @@
+-- Row versions for optimistic concurrency (ETag / If-Match). Workflows
+-- already carry a version from 000008.
+ALTER TABLE agents ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE integrations ADD COLUMN version INT NOT NULL DEFAULT 1;
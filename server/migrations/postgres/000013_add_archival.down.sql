@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_agents_archived_at;
+DROP INDEX IF EXISTS idx_workflows_archived_at;
+ALTER TABLE agents DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE workflows DROP COLUMN IF EXISTS archived_at;
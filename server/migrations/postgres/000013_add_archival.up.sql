@@ -0,0 +1,6 @@
+-- Soft delete: archived rows are hidden from lists but keep their history.
+ALTER TABLE workflows ADD COLUMN archived_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE agents ADD COLUMN archived_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_workflows_archived_at ON workflows(archived_at);
+CREATE INDEX idx_agents_archived_at ON agents(archived_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_workflows_is_template;
+DROP INDEX IF EXISTS idx_agents_is_template;
+ALTER TABLE workflows DROP COLUMN IF EXISTS parameters;
+ALTER TABLE workflows DROP COLUMN IF EXISTS is_template;
+ALTER TABLE agents DROP COLUMN IF EXISTS is_template;
@@ -0,0 +1,8 @@
+ALTER TABLE agents ADD COLUMN is_template BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE workflows ADD COLUMN is_template BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Default run parameters; run requests and clones override them.
+ALTER TABLE workflows ADD COLUMN parameters JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_agents_is_template ON agents(is_template) WHERE is_template;
+CREATE INDEX idx_workflows_is_template ON workflows(is_template) WHERE is_template;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_workflows_folder;
+DROP INDEX IF EXISTS idx_workflows_owner;
+DROP INDEX IF EXISTS idx_workflows_tags;
+DROP INDEX IF EXISTS idx_agents_folder;
+DROP INDEX IF EXISTS idx_agents_owner;
+DROP INDEX IF EXISTS idx_agents_tags;
+
+ALTER TABLE workflows DROP COLUMN IF EXISTS owner, DROP COLUMN IF EXISTS folder, DROP COLUMN IF EXISTS tags;
+ALTER TABLE agents DROP COLUMN IF EXISTS owner, DROP COLUMN IF EXISTS folder, DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,16 @@
+ALTER TABLE agents
+    ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN folder TEXT NOT NULL DEFAULT '',
+    ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE workflows
+    ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN folder TEXT NOT NULL DEFAULT '',
+    ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_agents_tags ON agents USING GIN (tags);
+CREATE INDEX idx_agents_owner ON agents(owner);
+CREATE INDEX idx_agents_folder ON agents(folder text_pattern_ops);
+CREATE INDEX idx_workflows_tags ON workflows USING GIN (tags);
+CREATE INDEX idx_workflows_owner ON workflows(owner);
+CREATE INDEX idx_workflows_folder ON workflows(folder text_pattern_ops);
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS conversations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    owner TEXT NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_conversations_agent_owner ON conversations(agent_id, owner, updated_at DESC);
+
+CREATE TABLE IF NOT EXISTS conversation_messages (
+    id BIGSERIAL PRIMARY KEY,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_conversation_messages_conversation ON conversation_messages(conversation_id, id);
+
+-- Carry each (agent, user) history over as one conversation. Chat history
+-- was stored for the placeholder user "current_user"; its conversations go
+-- to "anonymous", the user requests without an identity resolved to.
+CREATE TEMPORARY TABLE chat_history_conversations AS
+SELECT h.agent_id,
+    CASE h.user_id WHEN 'current_user' THEN 'anonymous' ELSE h.user_id END AS user_id,
+    h.messages, h.created_at, h.updated_at, gen_random_uuid() AS conversation_id
+FROM chat_history h
+JOIN agents a ON a.id::text = h.agent_id;
+
+INSERT INTO conversations (id, agent_id, owner, title, created_at, updated_at)
+SELECT conversation_id, agent_id::uuid, user_id, 'Chat history', created_at, updated_at
+FROM chat_history_conversations;
+
+INSERT INTO conversation_messages (conversation_id, role, content, created_at)
+SELECT h.conversation_id, m.value->>'role', COALESCE(m.value->>'content', ''), h.updated_at
+FROM chat_history_conversations h
+CROSS JOIN LATERAL jsonb_array_elements(h.messages) WITH ORDINALITY AS m(value, position)
+ORDER BY h.conversation_id, m.position;
+
+DROP TABLE chat_history_conversations;
@@ -0,0 +1,2 @@
+ALTER TABLE conversation_messages DROP COLUMN IF EXISTS sources;
+DROP TABLE IF EXISTS agent_documents;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS agent_documents (
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    id TEXT NOT NULL,
+    content TEXT NOT NULL,
+    metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+    embedding REAL[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (agent_id, id)
+);
+
+ALTER TABLE conversation_messages ADD COLUMN sources TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+ALTER TABLE conversations
+    DROP COLUMN IF EXISTS summary,
+    DROP COLUMN IF EXISTS summarized_through;
@@ -0,0 +1,3 @@
+ALTER TABLE conversations
+    ADD COLUMN summary TEXT NOT NULL DEFAULT '',
+    ADD COLUMN summarized_through BIGINT NOT NULL DEFAULT 0;
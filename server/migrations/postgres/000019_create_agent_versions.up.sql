@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS agent_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    description TEXT,
+    narrative TEXT,
+    config JSONB NOT NULL,
+    author VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(agent_id, version)
+);
+
+CREATE INDEX idx_agent_versions_agent_id ON agent_versions(agent_id);
+
+-- Each agent's current state becomes its first recorded version
+INSERT INTO agent_versions (agent_id, version, name, type, description, narrative, config, author, created_at)
+SELECT id, version, name, type, description, narrative, config, 'system', updated_at
+FROM agents
+ON CONFLICT (agent_id, version) DO NOTHING;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS agent_evaluations;
+DROP TABLE IF EXISTS agent_test_cases;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS agent_test_cases (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    input TEXT NOT NULL,
+    context JSONB NOT NULL DEFAULT '{}'::jsonb,
+    assertions JSONB NOT NULL DEFAULT '[]'::jsonb,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_agent_test_cases_agent_id ON agent_test_cases(agent_id, created_at);
+
+CREATE TRIGGER update_agent_test_cases_updated_at
+    BEFORE UPDATE ON agent_test_cases
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- One row per evaluation run. Proposed runs evaluated an unsaved narrative
+-- or config on top of agent_version.
+CREATE TABLE IF NOT EXISTS agent_evaluations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    agent_version INTEGER NOT NULL,
+    proposed BOOLEAN NOT NULL DEFAULT false,
+    narrative TEXT NOT NULL DEFAULT '',
+    config JSONB NOT NULL DEFAULT '{}'::jsonb,
+    score DOUBLE PRECISION NOT NULL,
+    passed INTEGER NOT NULL,
+    total INTEGER NOT NULL,
+    results JSONB NOT NULL DEFAULT '[]'::jsonb,
+    comparison JSONB,
+    author VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_agent_evaluations_agent ON agent_evaluations(agent_id, agent_version, created_at DESC);
@@ -0,0 +1,2 @@
+-- Narrative variables given when chatting, reused on every turn
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS variables JSONB NOT NULL DEFAULT '{}';
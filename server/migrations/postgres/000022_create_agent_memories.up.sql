@@ -0,0 +1,18 @@
+-- Facts an agent remembers about each user across conversations
+CREATE TABLE IF NOT EXISTS agent_memories (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    owner VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    embedding REAL[] NOT NULL,
+    conversation_id UUID REFERENCES conversations(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_agent_memories_owner ON agent_memories(agent_id, owner);
+
+CREATE TRIGGER update_agent_memories_updated_at
+    BEFORE UPDATE ON agent_memories
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1 @@
+ALTER TABLE workflow_versions DROP COLUMN IF EXISTS parameters;
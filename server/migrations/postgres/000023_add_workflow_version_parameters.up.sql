@@ -0,0 +1,8 @@
+-- Default run parameters at each version, so rollback restores them. Older
+-- versions have none recorded except the current one.
+ALTER TABLE workflow_versions ADD COLUMN IF NOT EXISTS parameters JSONB;
+
+UPDATE workflow_versions v
+SET parameters = w.parameters
+FROM workflows w
+WHERE v.workflow_id = w.id AND v.version = w.version;
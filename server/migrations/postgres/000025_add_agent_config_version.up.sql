@@ -0,0 +1,5 @@
+-- The config shape each agent was last written in. Existing agents start at
+-- 0 so the server upgrades them once; new agents are written in the current
+-- shape.
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS config_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE agents ALTER COLUMN config_version SET DEFAULT 1;
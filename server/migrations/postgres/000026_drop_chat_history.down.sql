@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS chat_history (
+    agent_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    messages JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (agent_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_history_agent_id ON chat_history(agent_id);
+
+-- Keep each user's most recent conversation with an agent.
+INSERT INTO chat_history (agent_id, user_id, messages, created_at, updated_at)
+SELECT DISTINCT ON (c.agent_id, c.owner)
+    c.agent_id::text, CASE c.owner WHEN 'anonymous' THEN 'current_user' ELSE c.owner END,
+    COALESCE((
+        SELECT jsonb_agg(jsonb_build_object('role', m.role, 'content', m.content) ORDER BY m.id)
+        FROM conversation_messages m
+        WHERE m.conversation_id = c.id
+    ), '[]'::jsonb),
+    c.created_at, c.updated_at
+FROM conversations c
+ORDER BY c.agent_id, c.owner, c.updated_at DESC;
@@ -0,0 +1,2 @@
+-- Chat history was copied into conversations by 000016.
+DROP TABLE IF EXISTS chat_history;
@@ -137,6 +137,18 @@ const SnowflakeConfig: React.FC<SnowflakeConfigProps> = ({ formData, setFormData
           })}
         />
       </div>
+      <div className="form-group">
+        <label>Read-only role</label>
+        <input
+          type="text"
+          placeholder="Role used for agent queries"
+          value={formData.config?.read_only_role || ''}
+          onChange={e => setFormData({
+            ...formData,
+            config: { ...formData.config, read_only_role: e.target.value }
+          })}
+        />
+      </div>
       <div className="integration-form-buttons">
         <button
           type="button"
@@ -1,4 +1,5 @@
 import React, { useState } from 'react';
+import { authHeaders } from '../../services/api';
 
 const SnowflakeQuery: React.FC = () => {
   const [query, setQuery] = useState('');
@@ -12,6 +13,7 @@ const SnowflakeQuery: React.FC = () => {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
+          ...authHeaders(),
         },
         body: JSON.stringify({ query }),
       });
@@ -19,7 +19,7 @@ import { AgentNodeModel, HumanNodeModel, NodeData, DAG } from '../../types/job';
 import { Workflow } from '../../types/workflow';
 import { updateWorkflow } from '../../store/workflowSlice';
 import { addAgent } from '../../store/agentSlice';
-import { api } from '../../services/api';
+import { api, ConflictError } from '../../services/api';
 import 'reactflow/dist/style.css';
 import './WorkflowEditor.css';
 import NodeEditModal from '../nodes/NodeEditModal';
@@ -29,6 +29,9 @@ interface WorkflowEditorProps {
   workflow: Workflow;
 }
 
+// Value of the agent picker option that loads the next page of agents.
+const MORE_AGENTS = '__more__';
+
 const nodeTypes = {
   agent: AgentNode,
   human: HumanNode,
@@ -122,22 +125,46 @@ const WorkflowEditor: React.FC<WorkflowEditorProps> = ({ workflowId, workflow: i
     }
   }, [initialized, initialWorkflow.dag, setNodes, setEdges, agents]);
 
-  // Fetch agents when component mounts
+  // Loads the first page of agents, or the page after cursor.
+  const [agentsCursor, setAgentsCursor] = useState<string | undefined>();
+  const [agentsLoaded, setAgentsLoaded] = useState(false);
+  const loadAgents = useCallback(async (cursor?: string) => {
+    try {
+      const page = await api.listAgents({ cursor });
+      if (!cursor) {
+        dispatch({ type: 'agent/clearAgents' });
+      }
+      page.items.forEach(agent => dispatch(addAgent(agent)));
+      setAgentsCursor(page.nextCursor);
+      setAgentsLoaded(true);
+    } catch (error) {
+      console.error('Failed to fetch agents:', error);
+    }
+  }, [dispatch]);
+
   useEffect(() => {
-    const fetchAgents = async () => {
+    loadAgents();
+  }, [loadAgents]);
+
+  // Nodes may use agents beyond the first page; fetch those individually.
+  const [fetchedNodeAgents, setFetchedNodeAgents] = useState(false);
+  useEffect(() => {
+    if (fetchedNodeAgents || !agentsLoaded || !initialWorkflow.dag) return;
+    setFetchedNodeAgents(true);
+    const known = new Set(agents.map(agent => agent.id));
+    const missing = new Set(
+      initialWorkflow.dag.nodes
+        .map(node => (node.type === 'agent' ? node.agentId.split('@')[0] : undefined))
+        .filter((id): id is string => !!id && !known.has(id))
+    );
+    missing.forEach(async id => {
       try {
-        const agents = await api.listAgents();
-        dispatch({ type: 'agent/clearAgents' });
-        agents.forEach(agent => dispatch(addAgent(agent)));
+        dispatch(addAgent(await api.getAgent(id)));
       } catch (error) {
-        console.error('Failed to fetch agents:', error);
+        console.error(`Failed to fetch agent ${id}:`, error);
       }
-    };
-    
-    if (agents.length === 0) {
-      fetchAgents();
-    }
-  }, [dispatch, agents.length]);
+    });
+  }, [fetchedNodeAgents, agentsLoaded, agents, initialWorkflow.dag, dispatch]);
 
   const onConnect = useCallback(
     (connection: Connection) => {
@@ -160,19 +187,38 @@ const WorkflowEditor: React.FC<WorkflowEditorProps> = ({ workflowId, workflow: i
       updatedAt: new Date().toISOString()
     };
 
-    const saveWorkflow = async () => {
+    const saveWorkflow = async (workflow: Workflow) => {
       try {
-        const savedWorkflow = await api.updateWorkflow(updatedWorkflow);
+        const savedWorkflow = await api.updateWorkflow(workflow);
         dispatch(updateWorkflow(savedWorkflow));
         alert('Workflow saved successfully');
       } catch (error) {
+        if (error instanceof ConflictError) {
+          const overwrite = window.confirm(
+            `Someone else saved this workflow (now version ${error.currentVersion}). ` +
+            'Press OK to overwrite their changes with yours, or Cancel to load their version.'
+          );
+          if (overwrite) {
+            await saveWorkflow({ ...workflow, version: error.currentVersion });
+            return;
+          }
+          const current = error.current as Workflow;
+          setNodes(current.dag.nodes.map(node => ({
+            ...convertToReactFlowNode(node),
+            targetPosition: Position.Top,
+            sourcePosition: Position.Bottom,
+          })));
+          setEdges(current.dag.edges);
+          dispatch(updateWorkflow({ ...initialWorkflow, ...current }));
+          return;
+        }
         console.error('Failed to save workflow:', error);
         alert('Failed to save workflow. Please try again.');
       }
     };
     
-    saveWorkflow();
-  }, [initialWorkflow, nodes, edges, dispatch]);
+    saveWorkflow(updatedWorkflow);
+  }, [initialWorkflow, nodes, edges, dispatch, setNodes, setEdges]);
 
   const addAgentNode = useCallback((agentId: string) => {
     const agent = agents.find(a => a.id === agentId);
@@ -235,7 +281,7 @@ const WorkflowEditor: React.FC<WorkflowEditorProps> = ({ workflowId, workflow: i
         <Panel position="top-right" className="workflow-editor-panel">
           <button onClick={onSave} className="save-button">Save Changes</button>
           <select 
-            onChange={(e) => addAgentNode(e.target.value)}
+            onChange={(e) => e.target.value === MORE_AGENTS ? loadAgents(agentsCursor) : addAgentNode(e.target.value)}
             value=""
             className="agent-select"
           >
@@ -245,6 +291,7 @@ const WorkflowEditor: React.FC<WorkflowEditorProps> = ({ workflowId, workflow: i
                 {agent.name}
               </option>
             ))}
+            {agentsCursor && <option value={MORE_AGENTS}>More agents…</option>}
           </select>
         </Panel>
       </ReactFlow>
@@ -1,4 +1,4 @@
-import React, { useState, useRef, useEffect } from 'react';
+import React, { useState, useRef, useEffect, useCallback } from 'react';
 import { useAppDispatch, useAppSelector } from '../store/hooks';
 import { addAgent, updateAgent, removeAgent } from '../store/agentSlice';
 import { Agent, AvatarType, Config } from '../types/agent';
@@ -230,7 +230,7 @@ const AgentForm: React.FC<{
           Enable Direct Query
         </label>
         <div className="form-help-text">
-          When enabled, the agent can directly query patient data from configured data sources
+          When enabled, the agent answers from the rows of its lookup query, which must be set in the agent config
         </div>
       </div>
       <div className="modal-actions">
@@ -256,20 +256,26 @@ const Agents: React.FC = () => {
   const [isEditing, setIsEditing] = useState<string | null>(null);
   const [chatWithAgent, setChatWithAgent] = useState<string | null>(null);
   const [editingAgent, setEditingAgent] = useState<Agent | null>(null);
+  const [nextCursor, setNextCursor] = useState<string | undefined>();
 
-  useEffect(() => {
-    const fetchAgents = async () => {
-      try {
-        const agents = await api.listAgents();
+  // Loads the first page, or the page after cursor onto the list.
+  const loadAgents = useCallback(async (cursor?: string) => {
+    try {
+      const page = await api.listAgents({ cursor });
+      if (!cursor) {
         dispatch({ type: 'agent/clearAgents' });
-        agents.forEach(agent => dispatch(addAgent(agent)));
-      } catch (error) {
-        console.error('Failed to fetch agents:', error);
       }
-    };
-    fetchAgents();
+      page.items.forEach(agent => dispatch(addAgent(agent)));
+      setNextCursor(page.nextCursor);
+    } catch (error) {
+      console.error('Failed to fetch agents:', error);
+    }
   }, [dispatch]);
 
+  useEffect(() => {
+    loadAgents();
+  }, [loadAgents]);
+
   const handleCreateAgent = async (formData: AgentFormData) => {
     try {
       const newAgent = await api.createAgent(formData);
@@ -299,10 +305,10 @@ const Agents: React.FC = () => {
       };
 
       // Add API call to update the agent in the database
-      await api.updateAgent(updatedAgent);
+      const saved = await api.updateAgent(updatedAgent);
       
       // Then update the store
-      dispatch(updateAgent(updatedAgent));
+      dispatch(updateAgent({ ...updatedAgent, version: saved.version }));
       setEditingAgent(null);
     } catch (error) {
       console.error('Failed to update agent:', error);
@@ -425,6 +431,12 @@ const Agents: React.FC = () => {
         ))}
       </div>
 
+      {nextCursor && (
+        <button className="load-more-button" onClick={() => loadAgents(nextCursor)}>
+          Load more
+        </button>
+      )}
+
       {chatWithAgent && (
         <ChatInterface
           agent={agents.find(a => a.id === chatWithAgent)!}
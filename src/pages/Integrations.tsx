@@ -18,8 +18,9 @@ const Integrations: React.FC = () => {
   const loadIntegrations = async () => {
     try {
       setIsLoading(true);
-      const configured = await api.listIntegrations();
-      setConfiguredIntegrations(configured || []);
+      // A handful per provider, so one page holds them all.
+      const configured = await api.listIntegrations({ limit: 200 });
+      setConfiguredIntegrations(configured.items || []);
     } catch (error) {
       console.error('Failed to load integrations:', error);
       setConfiguredIntegrations([]);
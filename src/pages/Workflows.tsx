@@ -26,6 +26,7 @@ const Workflows: React.FC = () => {
     schedule: 'daily'
   });
   const [isEditing, setIsEditing] = useState<string | null>(null);
+  const [nextCursor, setNextCursor] = useState<string | undefined>();
 
   const scheduleOptions: WorkflowSchedule[] = ['daily', 'weekly', 'monthly', 'custom'];
 
@@ -33,10 +34,11 @@ const Workflows: React.FC = () => {
     let mounted = true;
     const fetchWorkflows = async () => {
       try {
-        const workflows = await api.listWorkflows();
+        const page = await api.listWorkflows();
         if (mounted) {
           dispatch({ type: 'workflow/clearWorkflows' });
-          dispatch(setWorkflows(workflows));
+          dispatch(setWorkflows(page.items));
+          setNextCursor(page.nextCursor);
         }
       } catch (error) {
         console.error('Failed to fetch workflows:', error);
@@ -51,6 +53,12 @@ const Workflows: React.FC = () => {
     };
   }, []);
 
+  const handleLoadMore = async () => {
+    const page = await api.listWorkflows({ cursor: nextCursor });
+    page.items.forEach(workflow => dispatch(addWorkflow(workflow)));
+    setNextCursor(page.nextCursor);
+  };
+
   const handleCreateWorkflow = async () => {
     try {
       const newWorkflow = {
@@ -111,8 +119,8 @@ const Workflows: React.FC = () => {
         status: workflow.status === 'active' ? 'inactive' : 'active' as 'inactive' | 'active',
         updatedAt: new Date().toISOString()
       };
-      await api.updateWorkflow(updatedWorkflow);
-      dispatch(updateWorkflow(updatedWorkflow));
+      const saved = await api.updateWorkflow(updatedWorkflow);
+      dispatch(updateWorkflow({ ...updatedWorkflow, version: saved.version }));
     } catch (error) {
       console.error('Failed to update workflow status:', error);
       alert('Failed to update workflow status. Please try again.');
@@ -126,8 +134,8 @@ const Workflows: React.FC = () => {
         schedule: newSchedule,
         updatedAt: new Date().toISOString()
       };
-      await api.updateWorkflow(updatedWorkflow);
-      dispatch(updateWorkflow(updatedWorkflow));
+      const saved = await api.updateWorkflow(updatedWorkflow);
+      dispatch(updateWorkflow({ ...updatedWorkflow, version: saved.version }));
     } catch (error) {
       console.error('Failed to update workflow schedule:', error);
       alert('Failed to update schedule. Please try again.');
@@ -154,8 +162,8 @@ const Workflows: React.FC = () => {
         schedule: formData.schedule,
         updatedAt: new Date().toISOString()
       };
-      await api.updateWorkflow(updatedWorkflow);
-      dispatch(updateWorkflow(updatedWorkflow));
+      const saved = await api.updateWorkflow(updatedWorkflow);
+      dispatch(updateWorkflow({ ...updatedWorkflow, version: saved.version }));
       setEditingWorkflow(null);
     } catch (error) {
       console.error('Failed to update workflow:', error);
@@ -259,6 +267,12 @@ const Workflows: React.FC = () => {
         )}
       </div>
 
+      {nextCursor && (
+        <button className="load-more-button" onClick={handleLoadMore}>
+          Load more
+        </button>
+      )}
+
       {isCreating && (
         <Modal 
           isOpen={true}
@@ -1,22 +1,132 @@
-import { Agent, AgentFormData } from '../types/agent';
-import { Workflow } from '../types/workflow';
+import { Agent, AgentDiff, AgentFormData, AgentVersion, Config, EvalReport, Memory, TestCase } from '../types/agent';
+import { CloneWorkflowOptions, Workflow } from '../types/workflow';
 import { Integration, IntegrationConfig, SnowflakeConfig } from '../types/integration';
 
 export const API_BASE_URL = 'http://localhost:8080/api/v1';
 
+// ConflictError is thrown when a write carried a stale If-Match version.
+// `current` is the resource as it is now stored, for merging or reloading.
+export class ConflictError<T = any> extends Error {
+  constructor(message: string, public currentVersion: number, public current: T) {
+    super(message);
+    this.name = 'ConflictError';
+  }
+}
+
+// ValidationError carries per-field messages, keyed by paths such as
+// "config.temperature", for showing next to form inputs.
+export class ValidationError extends Error {
+  constructor(message: string, public fields: Record<string, string>) {
+    super(message);
+    this.name = 'ValidationError';
+  }
+}
+
+// authHeaders carries the API token, issued with `server -token <user>`.
+export const authHeaders = (): Record<string, string> => {
+  const token = localStorage.getItem('abtToken') || process.env.REACT_APP_API_TOKEN;
+  return token ? { Authorization: `Bearer ${token}` } : {};
+};
+
+const ifMatch = (version?: number): Record<string, string> =>
+  version ? { 'If-Match': `"${version}"` } : {};
+
 const fetchApi = async (endpoint: string, options?: RequestInit) => {
   const response = await fetch(`${API_BASE_URL}${endpoint}`, {
+    ...options,
     headers: {
       'Content-Type': 'application/json',
+      ...authHeaders(),
       ...(options?.headers || {})
-    },
-    ...options
+    }
   });
+  if (response.status === 409) {
+    const body = await response.json().catch(() => ({}));
+    if (body.currentVersion) {
+      throw new ConflictError(body.error, body.currentVersion, body.current);
+    }
+    throw new Error(body.error || `API error: ${response.statusText}`);
+  }
+  if (response.status === 400) {
+    const body = await response.json().catch(() => ({}));
+    if (body.fields) {
+      throw new ValidationError(body.error, body.fields);
+    }
+    throw new Error(body.error || `API error: ${response.statusText}`);
+  }
   if (!response.ok) throw new Error(`API error: ${response.statusText}`);
   const text = await response.text();
   return text ? JSON.parse(text) : null;
 };
 
+// streamApi POSTs body and calls onEvent for each server-sent event until
+// the stream ends.
+const streamApi = async (
+  endpoint: string,
+  body: unknown,
+  onEvent: (event: string, data: any) => void
+) => {
+  const response = await fetch(`${API_BASE_URL}${endpoint}`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json', Accept: 'text/event-stream', ...authHeaders() },
+    body: JSON.stringify(body)
+  });
+  if (!response.ok || !response.body) {
+    const error = await response.json().catch(() => ({}));
+    throw new Error(error.error || `API error: ${response.statusText}`);
+  }
+
+  const reader = response.body.getReader();
+  const decoder = new TextDecoder();
+  let buffer = '';
+  for (;;) {
+    const { done, value } = await reader.read();
+    if (done) break;
+    buffer += decoder.decode(value, { stream: true });
+    let end;
+    while ((end = buffer.indexOf('\n\n')) >= 0) {
+      const block = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
+      let event = 'message';
+      const data: string[] = [];
+      for (const line of block.split('\n')) {
+        if (line.startsWith('event:')) event = line.slice(6).trim();
+        else if (line.startsWith('data:')) data.push(line.slice(5));
+      }
+      onEvent(event, data.length ? JSON.parse(data.join('\n')) : null);
+    }
+  }
+};
+
+export interface Page<T> {
+  items: T[];
+  nextCursor?: string;
+  hasMore: boolean;
+}
+
+export interface ListParams {
+  limit?: number;
+  cursor?: string;
+  sort?: string;
+  q?: string;
+  [filter: string]: string | number | undefined;
+}
+
+const listQuery = (params: ListParams = {}) => {
+  const query = new URLSearchParams();
+  Object.entries(params).forEach(([key, value]) => {
+    if (value !== undefined && value !== '') query.set(key, String(value));
+  });
+  const encoded = query.toString();
+  return encoded ? `?${encoded}` : '';
+};
+
+const PAGE_SIZE = 50;
+
+// Reads one page; pass its nextCursor as params.cursor for the next one.
+const fetchPage = async <T>(endpoint: string, params: ListParams = {}): Promise<Page<T>> =>
+  fetchApi(`${endpoint}${listQuery({ limit: PAGE_SIZE, ...params })}`);
+
 export interface Message {
   role: 'system' | 'user' | 'assistant';
   content: string;
@@ -29,32 +139,61 @@ export interface ChatRequest {
 
 export interface ChatResponse {
   response: string;
+  sources?: string[];
+  conversation_id?: string;
+}
+
+export interface ConversationMessage {
+  id: number;
+  role: 'user' | 'assistant';
+  content: string;
+  sources?: string[];
+  created_at: string;
+}
+
+export interface Conversation {
+  id: string;
+  agent_id: string;
+  owner: string;
+  title: string;
+  summary?: string;
+  variables: Record<string, any>;
+  created_at: string;
+  updated_at: string;
+  messages?: ConversationMessage[];
 }
 
 export type { Integration, IntegrationConfig, SnowflakeConfig };
 
+const toAgent = (agent: any): Agent => ({
+  ...agent,
+  avatar: agent.config.avatar || {
+    type: 'emoji',
+    value: '🤖'  // Default avatar
+  },
+  narrative: agent.narrative,
+  config: {
+    model: agent.config.model,
+    temperature: agent.config.temperature,
+    max_tokens: agent.config.max_tokens,
+    use_rag: agent.config.use_rag || false,
+    use_direct_query: agent.config.use_direct_query || false
+  },
+  status: agent.status || 'idle',
+  capabilities: agent.capabilities || [],
+  createdAt: new Date(agent.created_at || Date.now()).toISOString(),
+  updatedAt: new Date(agent.updated_at || Date.now()).toISOString()
+});
+
 export const api = {
-  async listAgents(): Promise<Agent[]> {
-    const response = await fetchApi('/agents/');
-    return response.map((agent: any) => ({
-      ...agent,
-      avatar: agent.config.avatar || {
-        type: 'emoji',
-        value: '🤖'  // Default avatar
-      },
-      narrative: agent.narrative,
-      config: {
-        model: agent.config.model,
-        temperature: agent.config.temperature,
-        max_tokens: agent.config.max_tokens,
-        use_rag: agent.config.use_rag || false,
-        use_direct_query: agent.config.use_direct_query || false
-      },
-      status: agent.status || 'idle',
-      capabilities: agent.capabilities || [],
-      createdAt: new Date(agent.created_at || Date.now()).toISOString(),
-      updatedAt: new Date(agent.updated_at || Date.now()).toISOString()
-    }));
+  async listAgents(params?: ListParams): Promise<Page<Agent>> {
+    const page = await fetchPage<any>('/agents/', params);
+    return { ...page, items: page.items.map(toAgent) };
+  },
+
+  // An agent by ID; a trailing "@version" pin is ignored.
+  async getAgent(id: string): Promise<Agent> {
+    return toAgent(await fetchApi(`/agents/${id.split('@')[0]}`));
   },
 
   async chat(agent: Agent, message: string): Promise<string> {
@@ -74,6 +213,64 @@ export const api = {
     }
   },
 
+  // The requesting user's conversations with an agent, most recent first.
+  async listConversations(agentId: string, params?: ListParams): Promise<Page<Conversation>> {
+    return fetchPage<Conversation>(`/agents/${agentId}/conversations`, params);
+  },
+
+  async createConversation(agentId: string, title = '', variables?: Record<string, any>): Promise<Conversation> {
+    return fetchApi(`/agents/${agentId}/conversations`, {
+      method: 'POST',
+      body: JSON.stringify({ title, variables })
+    });
+  },
+
+  async getConversation(agentId: string, id: string): Promise<Conversation> {
+    return fetchApi(`/agents/${agentId}/conversations/${id}`);
+  },
+
+  async renameConversation(agentId: string, id: string, title: string): Promise<Conversation> {
+    return fetchApi(`/agents/${agentId}/conversations/${id}`, {
+      method: 'PUT',
+      body: JSON.stringify({ title })
+    });
+  },
+
+  async deleteConversation(agentId: string, id: string): Promise<void> {
+    await fetchApi(`/agents/${agentId}/conversations/${id}`, {
+      method: 'DELETE'
+    });
+  },
+
+  // Variables fill the agent's narrative and are kept for later messages.
+  async sendMessage(agentId: string, conversationId: string, message: string, variables?: Record<string, any>): Promise<string> {
+    const response: ChatResponse = await fetchApi(`/agents/${agentId}/conversations/${conversationId}/messages`, {
+      method: 'POST',
+      body: JSON.stringify({ message, variables })
+    });
+    return response.response;
+  },
+
+  // Sends a message and calls onToken with each chunk of the reply as it is
+  // generated. Resolves with the full reply once it has been stored.
+  async streamMessage(
+    agentId: string,
+    conversationId: string,
+    message: string,
+    onToken: (text: string) => void,
+    variables?: Record<string, any>
+  ): Promise<string> {
+    let reply = '';
+    let failure: string | undefined;
+    await streamApi(`/agents/${agentId}/conversations/${conversationId}/messages/stream`, { message, variables }, (event, data) => {
+      if (event === 'token') onToken(data.text);
+      else if (event === 'done') reply = data.response;
+      else if (event === 'error') failure = data.error;
+    });
+    if (failure) throw new Error(failure);
+    return reply;
+  },
+
   async getModels(): Promise<string[]> {
     try {
       const response = await fetchApi('/llm/models');
@@ -88,6 +285,11 @@ export const api = {
     }
   },
 
+  // JSON Schema of the config accepted by an agent type, with server defaults.
+  async getAgentConfigSchema(type: string = 'llm'): Promise<Record<string, any>> {
+    return fetchApi(`/agents/schema?type=${encodeURIComponent(type)}`);
+  },
+
   async createAgent(formData: AgentFormData): Promise<Agent> {
     const agent = {
       name: formData.name,
@@ -130,20 +332,20 @@ export const api = {
     };
   },
 
-  async listWorkflows(): Promise<Workflow[]> {
+  async listWorkflows(params?: ListParams): Promise<Page<Workflow>> {
     try {
-      const response = await fetchApi('/workflows/');
-      return response.map((workflow: any) => ({
+      const page = await fetchPage<any>('/workflows/', params);
+      return { ...page, items: page.items.map((workflow: any) => ({
         ...workflow,
         createdAt: new Date(workflow.created_at).toISOString(),
         updatedAt: new Date(workflow.updated_at).toISOString(),
         status: workflow.status || 'inactive',
         schedule: workflow.schedule || 'daily',
         dag: workflow.dag || { nodes: [], edges: [] }
-      }));
+      })) };
     } catch (error) {
       console.error('Error fetching workflows:', error);
-      return [];
+      return { items: [], hasMore: false };
     }
   },
 
@@ -178,6 +380,7 @@ export const api = {
   async updateWorkflow(workflow: Workflow): Promise<Workflow> {
     const response = await fetchApi(`/workflows/${workflow.id}`, {
       method: 'PUT',
+      headers: ifMatch(workflow.version),
       body: JSON.stringify(workflow)
     });
     return {
@@ -188,6 +391,20 @@ export const api = {
     };
   },
 
+  async cloneWorkflow(id: string, options: CloneWorkflowOptions = {}): Promise<Workflow> {
+    const response = await fetchApi(`/workflows/${id}/clone`, {
+      method: 'POST',
+      body: JSON.stringify(options)
+    });
+    const workflow = response.workflow;
+    return {
+      ...workflow,
+      createdAt: new Date(workflow.created_at).toISOString(),
+      updatedAt: new Date(workflow.updated_at).toISOString(),
+      dag: workflow.dag || { nodes: [], edges: [] }
+    };
+  },
+
   async getWorkflow(id: string): Promise<Workflow> {
     const response = await fetchApi(`/workflows/${id}`);
     if (!response) {
@@ -202,13 +419,89 @@ export const api = {
   },
 
   updateAgent: async (agent: Agent): Promise<Agent> => {
-    const response = await fetch(`${API_BASE_URL}/agents/${agent.id}`, {
+    const response = await fetchApi(`/agents/${agent.id}`, {
       method: 'PUT',
-      headers: { 'Content-Type': 'application/json' },
+      headers: ifMatch(agent.version),
       body: JSON.stringify(agent)
     });
     console.log("Agent updated with following data: ", agent);
-    return response.json();
+    return response;
+  },
+
+  async listAgentVersions(id: string): Promise<AgentVersion[]> {
+    return fetchApi(`/agents/${id}/versions`);
+  },
+
+  async diffAgentVersions(id: string, from: number, to: number): Promise<AgentDiff> {
+    return fetchApi(`/agents/${id}/diff?from=${from}&to=${to}`);
+  },
+
+  async listTestCases(agentId: string): Promise<TestCase[]> {
+    return fetchApi(`/agents/${agentId}/tests`);
+  },
+
+  async createTestCase(agentId: string, test: Pick<TestCase, 'name' | 'input' | 'context' | 'assertions'>): Promise<TestCase> {
+    return fetchApi(`/agents/${agentId}/tests`, {
+      method: 'POST',
+      body: JSON.stringify(test)
+    });
+  },
+
+  async updateTestCase(test: TestCase): Promise<TestCase> {
+    return fetchApi(`/agents/${test.agent_id}/tests/${test.id}`, {
+      method: 'PUT',
+      body: JSON.stringify(test)
+    });
+  },
+
+  async deleteTestCase(agentId: string, id: string): Promise<void> {
+    await fetchApi(`/agents/${agentId}/tests/${id}`, {
+      method: 'DELETE'
+    });
+  },
+
+  // Runs the agent's test cases. A narrative or config evaluates a proposed
+  // change without saving it.
+  async evaluateAgent(
+    agentId: string,
+    options: { narrative?: string; config?: Partial<Config>; tests?: string[]; baseline?: string } = {}
+  ): Promise<EvalReport> {
+    return fetchApi(`/agents/${agentId}/evaluate`, {
+      method: 'POST',
+      body: JSON.stringify(options)
+    });
+  },
+
+  async listEvaluations(agentId: string, params?: ListParams): Promise<Page<EvalReport>> {
+    return fetchPage<EvalReport>(`/agents/${agentId}/evaluations`, params);
+  },
+
+  async getEvaluation(agentId: string, id: string): Promise<EvalReport> {
+    return fetchApi(`/agents/${agentId}/evaluations/${id}`);
+  },
+
+  // What the agent remembers about the requesting user.
+  async listMemories(agentId: string, params?: ListParams): Promise<Page<Memory>> {
+    return fetchPage<Memory>(`/agents/${agentId}/memories`, params);
+  },
+
+  async updateMemory(agentId: string, id: string, content: string): Promise<Memory> {
+    return fetchApi(`/agents/${agentId}/memories/${id}`, {
+      method: 'PUT',
+      body: JSON.stringify({ content })
+    });
+  },
+
+  async deleteMemory(agentId: string, id: string): Promise<void> {
+    await fetchApi(`/agents/${agentId}/memories/${id}`, {
+      method: 'DELETE'
+    });
+  },
+
+  async forgetMemories(agentId: string): Promise<void> {
+    await fetchApi(`/agents/${agentId}/memories`, {
+      method: 'DELETE'
+    });
   },
 
   async initiateGoogleDriveAuth(): Promise<string> {
@@ -227,9 +520,8 @@ export const api = {
     });
   },
 
-  async listIntegrations(): Promise<Integration[]> {
-    const response = await fetchApi('/integrations/');
-    return response;
+  async listIntegrations(params?: ListParams): Promise<Page<Integration>> {
+    return fetchPage<Integration>('/integrations/', params);
   },
 
   async createIntegration(config: IntegrationConfig): Promise<Integration> {
@@ -253,7 +545,7 @@ export const api = {
   updateIntegration: async (id: string, integration: IntegrationConfig): Promise<Integration> => {
     const response = await fetch(`${API_BASE_URL}/integrations/${id}`, {
       method: 'PUT',
-      headers: { 'Content-Type': 'application/json' },
+      headers: { 'Content-Type': 'application/json', ...authHeaders() },
       body: JSON.stringify(integration)
     });
     return response.json();
@@ -268,7 +560,8 @@ export const api = {
       const response = await fetch(`${API_BASE_URL}/integrations/${config.endpoint}`, {
         method: 'POST',
         headers: {
-          'Content-Type': 'application/json'
+          'Content-Type': 'application/json',
+          ...authHeaders()
         },
         body: JSON.stringify(config.config)
       });
@@ -11,6 +11,25 @@ export interface Config {
   max_tokens?: number;
   use_rag?: boolean;
   use_direct_query?: boolean;
+  use_memory?: boolean;
+  tools?: string[];
+  context_budget?: number;
+  lookup?: {
+    integration: 'snowflake';
+    fields: { name: string; type: 'string' | 'integer' | 'number' | 'boolean' | 'date'; required?: boolean; description?: string }[];
+    query: string;
+    max_rows?: number;
+  };
+  rag?: {
+    retriever?: 'postgres' | 'snowflake';
+    top_k?: number;
+    threshold?: number;
+    table?: string;
+  };
+  max_tool_steps?: number;
+  variables?: { name: string; description?: string; required?: boolean; default?: string }[];
+  output_schema?: Record<string, any>;
+  guardrails?: { detector: string; action: 'redact' | 'pseudonymize' | 'block' | 'log' }[];
 }
 
 export interface Agent {
@@ -28,6 +47,106 @@ export interface Agent {
   capabilities: string[];
   createdAt: string;
   updatedAt: string;
+  version?: number;
+  tags?: string[];
+  folder?: string;
+  owner?: string;
+}
+
+export interface AgentDiff {
+  from: number;
+  to: number;
+  fields_changed: string[];
+  narrative: { op: '+' | '-' | ' '; text: string }[];
+  config: { key: string; before?: any; after?: any }[];
+}
+
+export interface AgentVersion {
+  agent_id: string;
+  version: number;
+  name: string;
+  type: string;
+  description: string;
+  narrative: string;
+  config: Config;
+  author: string;
+  created_at: string;
+  changes?: AgentDiff;
+}
+
+export interface Assertion {
+  type: 'contains' | 'regex' | 'json_schema' | 'similarity' | 'judge';
+  value?: string;
+  ignore_case?: boolean;
+  pattern?: string;
+  schema?: Record<string, any>;
+  expected?: string;
+  rubric?: string;
+  threshold?: number;
+}
+
+export interface TestCase {
+  id: string;
+  agent_id: string;
+  name: string;
+  input: string;
+  context: {
+    history?: { role: 'user' | 'assistant'; content: string }[];
+    summary?: string;
+    documents?: { id: string; content: string; metadata?: Record<string, any> }[];
+    variables?: Record<string, any>;
+    memories?: string[];
+  };
+  assertions: Assertion[];
+  created_at: string;
+  updated_at: string;
+}
+
+export interface CaseChange {
+  test_id: string;
+  name: string;
+  before: number;
+  after: number;
+}
+
+export interface EvalReport {
+  id: string;
+  agent_id: string;
+  agent_version: number;
+  proposed: boolean;
+  narrative?: string;
+  config?: Config;
+  score: number;
+  passed: number;
+  total: number;
+  results?: {
+    test_id: string;
+    name: string;
+    response: string;
+    error?: string;
+    passed: boolean;
+    score: number;
+    assertions: { type: Assertion['type']; passed: boolean; score: number; detail?: string }[];
+  }[];
+  comparison?: {
+    baseline_id: string;
+    baseline_version: number;
+    score_delta: number;
+    regressions: CaseChange[];
+    fixed: CaseChange[];
+  };
+  author: string;
+  created_at: string;
+}
+
+export interface Memory {
+  id: string;
+  agent_id: string;
+  owner: string;
+  content: string;
+  conversation_id?: string;
+  created_at: string;
+  updated_at: string;
 }
 
 export interface AgentFormData {
@@ -15,6 +15,7 @@ export interface SnowflakeConfig {
   database: string;
   schema: string;
   warehouse: string;
+  read_only_role?: string;
 }
 
 export interface IntegrationConfig {
@@ -23,7 +23,7 @@ export interface BaseNodeModel {
 
 export interface AgentNodeModel extends BaseNodeModel {
   type: 'agent';
-  agentId: string;
+  agentId: string; // "id@3" pins version 3 of the agent
   configuration: Record<string, any>;
 }
 
@@ -44,7 +44,7 @@ export interface Job {
   id: string;
   name: string;
   description: string;
-  status: 'pending' | 'in_progress' | 'completed' | 'failed';
+  status: 'pending' | 'in_progress' | 'waiting' | 'completed' | 'failed';
   createdAt: Date;
   updatedAt: Date;
   dag: DAG;
@@ -2,6 +2,7 @@ import { Edge } from 'reactflow';
 import { AgentNodeModel, HumanNodeModel } from './job';
 
 export interface DAG {
+  schemaVersion?: number;
   nodes: (AgentNodeModel | HumanNodeModel)[];
   edges: Edge[];
 }
@@ -17,4 +18,19 @@ export interface Workflow {
   updatedAt: string;
   status: 'inactive' | 'active';
   schedule: WorkflowSchedule;
+  version?: number;
+  is_template?: boolean;
+  tags?: string[];
+  folder?: string;
+  owner?: string;
+  parameters?: Record<string, string>;
+}
+
+export interface CloneWorkflowOptions {
+  name?: string;
+  slug?: string;
+  description?: string;
+  parameters?: Record<string, string>;
+  agents?: 'share' | 'clone';
+  template?: boolean;
 } 
\ No newline at end of file